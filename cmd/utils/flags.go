@@ -340,6 +340,32 @@ var (
 		Name:  "miner.noverify",
 		Usage: "Disable remote sealing verification",
 	}
+	MinerEmptyBlockDelayFlag = cli.DurationFlag{
+		Name:  "miner.emptyblockdelay",
+		Usage: "Time without new transactions before an empty block is produced (0 = no delay)",
+	}
+	MinerStateBatchFlag = cli.IntFlag{
+		Name:  "miner.statebatch",
+		Usage: "Number of sealed blocks whose states are flushed to disk together on archive nodes (1 = every block)",
+		Value: eth.DefaultConfig.MinerStateBatchSize,
+	}
+	MinerPendingFetchLimitFlag = cli.IntFlag{
+		Name:  "miner.pendingfetchlimit",
+		Usage: "Maximum number of pending transactions fetched from the pool for each block (0 = pool limit)",
+	}
+	MinerTxBatchFlag = cli.IntFlag{
+		Name:  "miner.txbatch",
+		Usage: "Number of transactions whose accounts are loaded together before executing them (1 = no batching, max 256)",
+		Value: eth.DefaultConfig.MinerTxBatchSize,
+	}
+	MinerMaxTxsPerAccountFlag = cli.IntFlag{
+		Name:  "miner.maxtxsperaccount",
+		Usage: "Maximum number of transactions of a single account included in a block (0 = unlimited)",
+	}
+	MinerWitnessFlag = cli.BoolFlag{
+		Name:  "miner.witness",
+		Usage: "Record the state witnesses of new blocks for stateless clients",
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -451,6 +477,24 @@ var (
 		Usage: "Maximum number of pending connection attempts (defaults used if set to 0)",
 		Value: 0,
 	}
+	HandshakeTimeoutFlag = cli.DurationFlag{
+		Name:  "handshake.timeout",
+		Usage: "Time allowed for the status exchange with new peers",
+		Value: 5 * time.Second,
+	}
+	HandshakeRetryFlag = cli.BoolFlag{
+		Name:  "handshake.retry",
+		Usage: "Send the status once more to peers not answering within the handshake timeout",
+	}
+	MaxPeersPerIPFlag = cli.IntFlag{
+		Name:  "maxpeersperip",
+		Usage: "Maximum number of peers sharing a remote IP (0 for no limit)",
+		Value: 0,
+	}
+	KnownTxsBloomFlag = cli.BoolFlag{
+		Name:  "knowntxs.bloom",
+		Usage: "Track the transactions known by peers in bloom filters (less memory, rare redundant sends skipped)",
+	}
 	ListenPortFlag = cli.IntFlag{
 		Name:  "port",
 		Usage: "Network listening port",
@@ -1029,6 +1073,18 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
 	}
+	if ctx.GlobalIsSet(HandshakeTimeoutFlag.Name) {
+		cfg.HandshakeTimeout = ctx.GlobalDuration(HandshakeTimeoutFlag.Name)
+	}
+	if ctx.GlobalIsSet(HandshakeRetryFlag.Name) {
+		cfg.HandshakeRetry = ctx.GlobalBool(HandshakeRetryFlag.Name)
+	}
+	if ctx.GlobalIsSet(MaxPeersPerIPFlag.Name) {
+		cfg.MaxPeersPerIP = ctx.GlobalInt(MaxPeersPerIPFlag.Name)
+	}
+	if ctx.GlobalIsSet(KnownTxsBloomFlag.Name) {
+		cfg.BloomKnownTxs = ctx.GlobalBool(KnownTxsBloomFlag.Name)
+	}
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheDatabaseFlag.Name) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
@@ -1076,6 +1132,24 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	if ctx.GlobalIsSet(MinerNoVerfiyFlag.Name) {
 		cfg.MinerNoverify = ctx.Bool(MinerNoVerfiyFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerEmptyBlockDelayFlag.Name) {
+		cfg.MinerEmptyBlockDelay = ctx.Duration(MinerEmptyBlockDelayFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerStateBatchFlag.Name) {
+		cfg.MinerStateBatchSize = ctx.Int(MinerStateBatchFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerPendingFetchLimitFlag.Name) {
+		cfg.MinerPendingFetchLimit = ctx.Int(MinerPendingFetchLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerTxBatchFlag.Name) {
+		cfg.MinerTxBatchSize = ctx.Int(MinerTxBatchFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerMaxTxsPerAccountFlag.Name) {
+		cfg.MinerMaxTxsPerAccount = ctx.Int(MinerMaxTxsPerAccountFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerWitnessFlag.Name) {
+		cfg.MinerWitness = ctx.Bool(MinerWitnessFlag.Name)
+	}
 	if ctx.GlobalIsSet(VMEnableDebugFlag.Name) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
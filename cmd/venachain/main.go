@@ -90,6 +90,10 @@ var (
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
 		utils.MaxPendingPeersFlag,
+		utils.HandshakeTimeoutFlag,
+		utils.HandshakeRetryFlag,
+		utils.MaxPeersPerIPFlag,
+		utils.KnownTxsBloomFlag,
 		utils.MiningEnabledFlag,
 		utils.MinerThreadsFlag,
 		utils.MinerLegacyThreadsFlag,
@@ -105,6 +109,12 @@ var (
 		utils.MinerLegacyExtraDataFlag,
 		utils.MinerRecommitIntervalFlag,
 		utils.MinerNoVerfiyFlag,
+		utils.MinerEmptyBlockDelayFlag,
+		utils.MinerStateBatchFlag,
+		utils.MinerPendingFetchLimitFlag,
+		utils.MinerTxBatchFlag,
+		utils.MinerMaxTxsPerAccountFlag,
+		utils.MinerWitnessFlag,
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
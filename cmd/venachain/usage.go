@@ -155,6 +155,10 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.ListenPortFlag,
 			utils.MaxPeersFlag,
 			utils.MaxPendingPeersFlag,
+			utils.HandshakeTimeoutFlag,
+			utils.HandshakeRetryFlag,
+			utils.MaxPeersPerIPFlag,
+			utils.KnownTxsBloomFlag,
 			utils.NATFlag,
 			utils.NoDiscoverFlag,
 			utils.DiscoveryV5Flag,
@@ -176,6 +180,12 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.MinerExtraDataFlag,
 			utils.MinerRecommitIntervalFlag,
 			utils.MinerNoVerfiyFlag,
+			utils.MinerEmptyBlockDelayFlag,
+			utils.MinerStateBatchFlag,
+			utils.MinerPendingFetchLimitFlag,
+			utils.MinerTxBatchFlag,
+			utils.MinerMaxTxsPerAccountFlag,
+			utils.MinerWitnessFlag,
 		},
 	},
 	{
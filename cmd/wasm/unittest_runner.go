@@ -495,6 +495,11 @@ func (s *stateDB) GetState(key []byte) []byte {
 	return nil
 }
 
+func (s *stateDB) DebugEnabled() bool {
+	return false
+}
+func (s *stateDB) DebugLog(level uint32, msg []byte) {
+}
 func (s *stateDB) GetCallerNonce() int64 {
 	return s.state.CallerNonce
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/core/state"
 	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/p2p"
 	"github.com/Venachain/Venachain/params"
 	"github.com/Venachain/Venachain/rpc"
@@ -44,6 +45,10 @@ type ChainReader interface {
 	// GetHeaderByHash retrieves a block header from the database by its hash.
 	GetHeaderByHash(hash common.Hash) *types.Header
 
+	// GetHeaderRangeByNumber retrieves the canonical headers numbered from to to,
+	// both inclusive, in a single batch, cut at the first missing one.
+	GetHeaderRangeByNumber(from, to uint64) []*types.Header
+
 	// GetBlock retrieves a block from the database by hash and number.
 	GetBlock(hash common.Hash, number uint64) *types.Block
 }
@@ -98,6 +103,23 @@ type Engine interface {
 	Close() error
 }
 
+// ReorgEvent is posted when a new chain head does not extend the previous one,
+// together with the proposers of both heads.
+type ReorgEvent struct {
+	OldHead     *types.Block
+	NewHead     *types.Block
+	OldProposer common.Address
+	NewProposer common.Address
+}
+
+// ValidatorSetEvent is posted when the block at Number changes the active
+// validator set, together with the new set.
+type ValidatorSetEvent struct {
+	Number     uint64
+	Validators []common.Address
+	Local      bool // Whether the local node is one of the validators
+}
+
 // Handler should be implemented is the consensus needs to handle and send peer's message
 type Handler interface {
 	// NewChainHead handles a new head block comes
@@ -121,4 +143,16 @@ type Istanbul interface {
 
 	// Stop stops the engine
 	Stop() error
+
+	// SubscribeReorgEvents registers a subscription of ReorgEvent
+	SubscribeReorgEvents(ch chan<- ReorgEvent) event.Subscription
+
+	// SubscribeValidatorSetEvents registers a subscription of ValidatorSetEvent
+	SubscribeValidatorSetEvents(ch chan<- ValidatorSetEvent) event.Subscription
+
+	// ValidatorsAt retrieves the validators of the canonical block at number
+	ValidatorsAt(chain ChainReader, number uint64) ([]common.Address, error)
+
+	// GetPendingRound returns the consensus round currently in progress
+	GetPendingRound() (round uint64, err error)
 }
@@ -17,20 +17,52 @@
 package backend
 
 import (
+	"fmt"
+
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/consensus"
+	istanbulCore "github.com/Venachain/Venachain/consensus/istanbul/core"
 	"github.com/Venachain/Venachain/core/types"
 	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/p2p/discover"
 	"github.com/Venachain/Venachain/rpc"
 )
 
+// maxValidatorChangesRange is the maximum number of blocks GetValidatorChanges
+// walks in a single request.
+const maxValidatorChangesRange = 1024
+
+// maxParticipationRange is the maximum number of blocks ValidatorParticipation
+// scans in a single request.
+const maxParticipationRange = 4096
+
+// ValidatorChange describes the validator set update introduced by a block.
+type ValidatorChange struct {
+	BlockNumber uint64           `json:"blockNumber"`
+	Added       []common.Address `json:"added"`
+	Removed     []common.Address `json:"removed"`
+}
+
 // API is a user facing RPC API to dump Istanbul state
 type API struct {
 	chain    consensus.ChainReader
 	istanbul *backend
 }
 
+// PrivateAPI is the collection of Istanbul APIs exposed over the private
+// endpoints only, as they act on the node.
+type PrivateAPI struct {
+	istanbul *backend
+}
+
+// PurgeSnapshotCache drops the in-memory snapshots and recovered signers,
+// which are rebuilt on their next access. It is meant for when the cached
+// snapshots are suspected to have diverged from the chain, e.g. after a deep
+// reorg, and causes a temporary CPU spike while the snapshots are rebuilt.
+func (api *PrivateAPI) PurgeSnapshotCache() {
+	api.istanbul.PurgeSnapshotCache()
+}
+
 // GetSnapshot retrieves the state snapshot at a given block.
 func (api *API) GetSnapshot(number *rpc.BlockNumber) (*Snapshot, error) {
 	// Retrieve the requested block number (or current if none requested)
@@ -76,6 +108,21 @@ func (api *API) GetValidators(number *rpc.BlockNumber) ([]common.Address, error)
 	return snap.validators(), nil
 }
 
+// CommittedSealCount returns the number of committed seals of the given block
+// (or the current one if none requested).
+func (api *API) CommittedSealCount(number *rpc.BlockNumber) (int, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return 0, errUnknownBlock
+	}
+	return api.istanbul.CommittedSealCount(header)
+}
+
 // GetValidatorsAtHash retrieves the state snapshot at a given block.
 func (api *API) GetValidatorsAtHash(hash common.Hash) ([]common.Address, error) {
 	header := api.chain.GetHeaderByHash(hash)
@@ -89,6 +136,95 @@ func (api *API) GetValidatorsAtHash(hash common.Hash) ([]common.Address, error)
 	return snap.validators(), nil
 }
 
+// GetProposerForBlock retrieves the validator expected to propose the given
+// block in its first round, as selected by the validator set of its parent.
+func (api *API) GetProposerForBlock(blockNumber uint64) (common.Address, error) {
+	if blockNumber > api.chain.CurrentHeader().Number.Uint64() {
+		return common.Address{}, consensus.ErrFutureBlock
+	}
+	if blockNumber == 0 {
+		// The genesis block is not proposed by anyone
+		return common.Address{}, errUnknownBlock
+	}
+	parent := api.chain.GetHeaderByNumber(blockNumber - 1)
+	if parent == nil {
+		return common.Address{}, fmt.Errorf("no snapshot at block %d: missing header %d", blockNumber-1, blockNumber-1)
+	}
+	snap, err := api.istanbul.snapshot(api.chain, parent.Number.Uint64(), parent.Hash(), nil)
+	if err == consensus.ErrUnknownAncestor {
+		return common.Address{}, fmt.Errorf("no snapshot at block %d: missing header %d: %w", parent.Number, api.firstMissingHeader(parent), err)
+	}
+	if err != nil {
+		return common.Address{}, err
+	}
+	// Calculating the proposer rotates the set, leave the cached snapshot alone
+	valSet := snap.ValSet.Copy()
+	api.istanbul.weighValidators(valSet, parent)
+	var lastProposer common.Address
+	if parent.Number.Sign() > 0 {
+		if lastProposer, err = api.istanbul.Author(parent); err != nil {
+			return common.Address{}, err
+		}
+	}
+	valSet.CalcProposer(lastProposer, 0)
+	return valSet.GetProposer().Address(), nil
+}
+
+// firstMissingHeader walks back the ancestors of header the way snapshot does
+// and returns the number of the first one missing from the chain.
+func (api *API) firstMissingHeader(header *types.Header) uint64 {
+	for header.Number.Sign() > 0 {
+		number := header.Number.Uint64() - 1
+		if header = api.chain.GetHeader(header.ParentHash, number); header == nil {
+			return number
+		}
+	}
+	return 0
+}
+
+// GetValidatorChanges retrieves the validator set changes of every block in the
+// range (fromBlock, toBlock]. Blocks that leave the validator set untouched are
+// omitted from the result.
+func (api *API) GetValidatorChanges(fromBlock, toBlock uint64) ([]*ValidatorChange, error) {
+	if fromBlock > toBlock || toBlock-fromBlock > maxValidatorChangesRange {
+		return nil, errInvalidBlockRange
+	}
+	header := api.chain.GetHeaderByNumber(fromBlock)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	prev, err := api.istanbul.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*ValidatorChange, 0)
+	for number := fromBlock + 1; number <= toBlock; number++ {
+		header := api.chain.GetHeaderByNumber(number)
+		if header == nil {
+			return nil, errUnknownBlock
+		}
+		snap, err := api.istanbul.snapshot(api.chain, number, header.Hash(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if added, removed := prev.Diff(snap); len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, &ValidatorChange{
+				BlockNumber: number,
+				Added:       added,
+				Removed:     removed,
+			})
+		}
+		prev = snap
+	}
+	return changes, nil
+}
+
+// ValidatorParticipation returns how many blocks in the range [fromBlock, toBlock]
+// each validator signed a committed seal for.
+func (api *API) ValidatorParticipation(fromBlock, toBlock uint64) (map[common.Address]int, error) {
+	return api.istanbul.ValidatorParticipation(api.chain, fromBlock, toBlock)
+}
+
 // Candidates returns the current candidates the node tries to uphold and vote on.
 func (api *API) Candidates(number *rpc.BlockNumber) ([]common.Address, error) {
 	// Retrieve the requested block number (or current if none requested)
@@ -98,7 +234,10 @@ func (api *API) Candidates(number *rpc.BlockNumber) ([]common.Address, error) {
 	} else {
 		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
 	}
-	nodes := getCandidateNodesAtNumber(api.chain, api.istanbul, header.Number.Uint64())
+	nodes, err := getCandidateNodesAtNumber(api.chain, api.istanbul, header.Number.Uint64())
+	if err != nil {
+		return nil, err
+	}
 	addrs := make([]common.Address, 0)
 	for _, node := range nodes {
 		pubHex, err := discover.HexID(node.PublicKey)
@@ -132,3 +271,14 @@ func (api *API) Discard(address common.Address) {
 
 	delete(api.istanbul.candidates, address)
 }
+
+// GetPendingRound returns the consensus round currently in progress.
+func (api *API) GetPendingRound() (uint64, error) {
+	return api.istanbul.GetPendingRound()
+}
+
+// RoundChangeStats returns the recent heights that needed round changes to
+// reach consensus, along with their number of round changes.
+func (api *API) RoundChangeStats() []istanbulCore.RoundChangeStat {
+	return api.istanbul.core.RoundChangeStats()
+}
@@ -60,10 +60,20 @@ func New(config *params.IstanbulConfig, privateKey *ecdsa.PrivateKey, db ethdb.D
 	} else {
 		address = crypto.PubkeyToAddress(privateKey.PublicKey)
 	}
+	checkpoint := config.CheckpointInterval
+	if checkpoint == 0 {
+		checkpoint = defaultCheckpointInterval
+	}
+	if checkpoint < minCheckpointInterval {
+		log.Warn("Sanitizing checkpoint interval", "provided", checkpoint, "updated", minCheckpointInterval)
+		checkpoint = minCheckpointInterval
+	}
 	backend := &backend{
 		config:           config,
 		istanbulEventMux: new(event.TypeMux),
 		msgFeed:          new(event.Feed),
+		reorgFeed:        new(event.Feed),
+		validatorSetFeed: new(event.Feed),
 		privateKey:       privateKey,
 		address:          address,
 		logger:           log.New(),
@@ -74,6 +84,9 @@ func New(config *params.IstanbulConfig, privateKey *ecdsa.PrivateKey, db ethdb.D
 		coreStarted:      false,
 		recentMessages:   recentMessages,
 		knownMessages:    knownMessages,
+
+		checkpointInterval: checkpoint,
+		proposerPolicy:     config.ProposerPolicy,
 	}
 	backend.core = istanbulCore.New(backend, backend.config)
 	return backend
@@ -126,6 +139,19 @@ type backend struct {
 
 	recentMessages *lru.ARCCache // the cache of peer's messages
 	knownMessages  *lru.ARCCache // the cache of self messages
+
+	checkpointInterval       uint64 // Number of blocks after which to save the vote snapshot
+	legacyCheckpointInterval uint64 // Interval used before a config change, 0 if unchanged
+
+	proposerPolicy params.ProposerPolicy // Policy of the validator sets of the next epochs
+	policyMu       sync.RWMutex          // Protects proposerPolicy
+
+	reorgFeed *event.Feed  // Feed of chain reorganizations seen by NewChainHead
+	lastHead  *types.Block // Head seen by the previous NewChainHead call
+	headMu    sync.Mutex   // Protects lastHead and valSetChange
+
+	validatorSetFeed *event.Feed // Feed of validator set changes reaching the chain head
+	valSetChange     uint64      // Number of the latest finalized block changing the validator set, 0 if none
 }
 
 // Address implements istanbul.Backend.Address
@@ -133,6 +159,33 @@ func (sb *backend) Address() common.Address {
 	return sb.address
 }
 
+// SetProposerPolicy changes the proposer selection policy of the validator sets.
+// The change takes effect at the next epoch boundary, the validator set of the
+// current epoch keeps its policy. Snapshots already persisted keep the policy
+// they were stored with, so the new policy only applies to snapshots built from
+// headers crossing a boundary. All validators have to switch to the same policy
+// within the same epoch or they will disagree on the proposers.
+func (sb *backend) SetProposerPolicy(policy istanbul.ProposerPolicy) error {
+	switch params.ProposerPolicy(policy) {
+	case istanbul.RoundRobin, istanbul.Sticky, istanbul.WeightedRoundRobin:
+	default:
+		return errInvalidProposerPolicy
+	}
+	sb.policyMu.Lock()
+	defer sb.policyMu.Unlock()
+
+	sb.proposerPolicy = params.ProposerPolicy(policy)
+	return nil
+}
+
+// nextProposerPolicy returns the policy of the validator sets of the next epochs.
+func (sb *backend) nextProposerPolicy() params.ProposerPolicy {
+	sb.policyMu.RLock()
+	defer sb.policyMu.RUnlock()
+
+	return sb.proposerPolicy
+}
+
 // Validators implements istanbul.Backend.Validators
 func (sb *backend) Validators(proposal istanbul.Proposal) istanbul.ValidatorSet {
 	return sb.getValidators(proposal.Number().Uint64(), proposal.Hash())
@@ -249,6 +302,11 @@ func (sb *backend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	}
 
 	h := block.Header()
+	// Only keep a quorum of seals if configured so
+	if max := sb.config.MaxStoredCommittedSeals; max > 0 {
+		valSet := sb.getValidators(h.Number.Uint64()-1, h.ParentHash)
+		seals = truncateCommittedSeals(seals, max, valSet.Size()-valSet.F())
+	}
 	// Append seals into extra-data
 	err := writeCommittedSeals(h, seals)
 	if err != nil {
@@ -256,6 +314,9 @@ func (sb *backend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	}
 	// update block's header
 	block = block.WithSeal(h)
+	if sb.config.PersistProposedBlocks {
+		sb.deleteProposedBlock(block.Hash())
+	}
 	isEmpty := block.Transactions().Len() == 0
 	isProduceEmptyBlock := common.SysCfg.IsProduceEmptyBlock()
 
@@ -268,8 +329,14 @@ func (sb *backend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	// -- if success, the ChainHeadEvent event will be broadcasted, try to build
 	//    the next block and the previous Seal() will be stopped.
 	// -- otherwise, a error will be returned and a round change event will be fired.
-	if sb.proposedBlockHash == block.Hash() {
+	sb.sealMu.Lock()
+	proposed := sb.proposedBlockHash == block.Hash()
+	if proposed {
 		sb.proposedBlockHash = common.Hash{}
+	}
+	sb.sealMu.Unlock()
+
+	if proposed {
 		if err := sb.CheckFirstNodeCommitAtWrongTime(); err != nil {
 			sb.commitCh <- nil
 			return istanbulCore.ErrFirstCommitAtWrongTime
@@ -318,6 +385,37 @@ func (sb *backend) MsgFeed() *event.Feed {
 	return sb.msgFeed
 }
 
+// SubscribeReorgEvents registers a subscription of consensus.ReorgEvent.
+func (sb *backend) SubscribeReorgEvents(ch chan<- consensus.ReorgEvent) event.Subscription {
+	return sb.reorgFeed.Subscribe(ch)
+}
+
+// SubscribeValidatorSetEvents registers a subscription of
+// consensus.ValidatorSetEvent.
+func (sb *backend) SubscribeValidatorSetEvents(ch chan<- consensus.ValidatorSetEvent) event.Subscription {
+	return sb.validatorSetFeed.Subscribe(ch)
+}
+
+// ValidatorsAt implements consensus.Istanbul.ValidatorsAt, returning the
+// validators of the snapshot at the canonical block with the given number.
+func (sb *backend) ValidatorsAt(chain consensus.ChainReader, number uint64) ([]common.Address, error) {
+	header := chain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	snap, err := sb.snapshot(chain, number, header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return snap.validators(), nil
+}
+
+// GetPendingRound implements consensus.Istanbul.GetPendingRound, returning the
+// round of the view the Istanbul core is currently in.
+func (sb *backend) GetPendingRound() (uint64, error) {
+	return sb.core.PendingRound()
+}
+
 // makeCurrent creates a new environment for the current cycle.
 func (sb *backend) makeCurrent(parentRoot common.Hash, header *types.Header) error {
 	var (
@@ -507,7 +605,24 @@ func (sb *backend) getValidators(number uint64, hash common.Hash) istanbul.Valid
 	if err != nil {
 		return validator.NewSet(nil, sb.config.ProposerPolicy)
 	}
-	return snap.ValSet
+	valSet := snap.ValSet.Copy()
+	if header := sb.chain.GetHeader(hash, number); header != nil {
+		sb.weighValidators(valSet, header)
+	}
+	return valSet
+}
+
+// weighValidators sets up the weighted proposer selection of a validator set
+// following header: the stake weights come from the chain config and the VRF
+// nonce of the header seeds the pick. Sets of other policies are left alone.
+func (sb *backend) weighValidators(valSet istanbul.ValidatorSet, header *types.Header) {
+	if valSet.Policy() != istanbul.WeightedRoundRobin {
+		return
+	}
+	for addr, weight := range sb.config.ValidatorWeights {
+		valSet.SetWeight(addr, new(big.Int).SetUint64(weight))
+	}
+	valSet.SetSeed(header.Nonce[:])
 }
 
 func (sb *backend) LastProposal() (istanbul.Proposal, common.Address) {
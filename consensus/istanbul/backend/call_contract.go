@@ -2,6 +2,7 @@ package backend
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/big"
 	"reflect"
 
@@ -36,12 +37,18 @@ func (cc *ChainContext) Engine() consensus.Engine {
 // new a dpos and miner a new block
 func getConsensusNodesList(chain consensus.ChainReader, sb *backend, number uint64) ([]discover.NodeID, error) {
 	var tmp []common.NodeInfo
-	tmpVrfParam := getVRFParamsAtNumber(chain, sb, number)
-	if tmpVrfParam.ElectionEpoch != 0 {
+	tmpVrfParam, err := getVRFParamsAtNumber(chain, sb, number)
+	if err != nil {
+		return nil, err
+	}
+	if tmpVrfParam != nil && tmpVrfParam.ElectionEpoch != 0 {
 		// vrf feature is active
-		tmp = getVrfConsensusNodesAtNumber(chain, sb, number)
+		tmp, err = getVrfConsensusNodesAtNumber(chain, sb, number)
 	} else {
-		tmp = getCandidateNodesAtNumber(chain, sb, number)
+		tmp, err = getCandidateNodesAtNumber(chain, sb, number)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	nodeIDs := make([]discover.NodeID, 0, len(tmp))
@@ -56,41 +63,50 @@ func getConsensusNodesList(chain consensus.ChainReader, sb *backend, number uint
 	return nodeIDs, nil
 }
 
-func getVRFParamsAtNumber(chain consensus.ChainReader, sb *backend, number uint64) *common.VRFParams {
+func getVRFParamsAtNumber(chain consensus.ChainReader, sb *backend, number uint64) (*common.VRFParams, error) {
 	isOldBlock := number < chain.CurrentHeader().Number.Uint64()
 	if !isOldBlock {
-		return &common.SysCfg.SysParam.VRF
+		return &common.SysCfg.SysParam.VRF, nil
 	}
 
-	resVRF := CallSystemContractAtBlockNumber(chain, sb, number, syscontracts.ParameterManagementAddress, "getVRFParams", []interface{}{})
+	resVRF, err := callSystemContract(chain, sb, number, syscontracts.ParameterManagementAddress, "getVRFParams", []interface{}{})
+	if err != nil {
+		return nil, err
+	}
 	vrf := ParseResultToExtractType(resVRF, common.VRFParams{})
 	if vrf != nil {
-		return vrf.(*common.VRFParams)
+		return vrf.(*common.VRFParams), nil
 	}
-	return nil
+	return nil, nil
 }
 
-func getVrfConsensusNodesAtNumber(chain consensus.ChainReader, sb *backend, number uint64) []common.NodeInfo {
-	resVrfConsensusNodes := CallSystemContractAtBlockNumber(chain, sb, number, syscontracts.NodeManagementAddress, "getVrfConsensusNodes", []interface{}{})
+func getVrfConsensusNodesAtNumber(chain consensus.ChainReader, sb *backend, number uint64) ([]common.NodeInfo, error) {
+	resVrfConsensusNodes, err := callSystemContract(chain, sb, number, syscontracts.NodeManagementAddress, "getVrfConsensusNodes", []interface{}{})
+	if err != nil {
+		return nil, err
+	}
 	nodes := ParseResultToExtractType(resVrfConsensusNodes, common.CommonResult{})
 	if nodes != nil {
-		return nodes.(*common.CommonResult).Data
+		return nodes.(*common.CommonResult).Data, nil
 	}
-	return []common.NodeInfo{}
+	return []common.NodeInfo{}, nil
 }
 
-func getCandidateNodesAtNumber(chain consensus.ChainReader, sb *backend, number uint64) []common.NodeInfo {
+func getCandidateNodesAtNumber(chain consensus.ChainReader, sb *backend, number uint64) ([]common.NodeInfo, error) {
 	isOldBlock := number < chain.CurrentHeader().Number.Uint64()
 	nodes := make([]common.NodeInfo, 0)
 	if isOldBlock {
-		resNodes := CallSystemContractAtBlockNumber(chain, sb, number, syscontracts.NodeManagementAddress, "getAllNodes", []interface{}{})
+		resNodes, err := callSystemContract(chain, sb, number, syscontracts.NodeManagementAddress, "getAllNodes", []interface{}{})
+		if err != nil {
+			return nil, err
+		}
 		tmp := ParseResultToExtractType(resNodes, common.CommonResult{})
 		if tmp != nil {
 			nodes = tmp.(*common.CommonResult).Data
 		}
 	}
 
-	return common.SysCfg.GetConsensusNodesFilterDelay(number, nodes, isOldBlock)
+	return common.SysCfg.GetConsensusNodesFilterDelay(number, nodes, isOldBlock), nil
 }
 
 func ParseResultToExtractType(res []byte, v interface{}) interface{} {
@@ -112,19 +128,43 @@ func CallSystemContractAtBlockNumber(
 	sysFuncName string,
 	sysFuncParams []interface{},
 ) []byte {
-	_state, _ := state.New(chain.GetHeaderByNumber(number).Root, state.NewDatabase(sb.db))
-	if _state == nil {
-		log.Warn("load state fail at block number", "number", number)
+	res, err := callSystemContract(chain, sb, number, sysContractAddr, sysFuncName, sysFuncParams)
+	if err != nil {
+		log.Warn("load state fail at block number", "number", number, "err", err)
 		return nil
 	}
+	return res
+}
+
+// callSystemContract is like CallSystemContractAtBlockNumber, but fails if the
+// state at the given block is not available, e.g. because it was pruned.
+func callSystemContract(
+	chain consensus.ChainReader,
+	sb *backend,
+	number uint64,
+	sysContractAddr common.Address,
+	sysFuncName string,
+	sysFuncParams []interface{},
+) ([]byte, error) {
+	header := chain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	_state, err := state.New(header.Root, state.NewDatabase(sb.db))
+	if err != nil {
+		return nil, fmt.Errorf("missing state at block %d: %v", number, err)
+	}
 	msg := types.NewMessage(common.Address{}, nil, 1, big.NewInt(1), 0x1, big.NewInt(1), nil, false)
 	cc := ChainContext{&chain, sb}
 	context := core.NewEVMContext(msg, chain.CurrentHeader(), &cc, nil)
 	evm := vm.NewEVM(context, _state, chain.Config(), vm.Config{})
 	callData := common.GenCallData(sysFuncName, sysFuncParams)
 	res, _, err := evm.Call(vm.AccountRef(common.Address{}), sysContractAddr, callData, uint64(0xffffffffff), big.NewInt(0))
+	if dbErr := _state.Error(); dbErr != nil {
+		return nil, fmt.Errorf("missing state at block %d: %v", number, dbErr)
+	}
 	if err != nil {
-		return nil
+		return nil, nil
 	}
-	return res
+	return res, nil
 }
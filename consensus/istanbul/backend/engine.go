@@ -22,6 +22,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"runtime"
 	"time"
 
 	"github.com/Venachain/Venachain/crypto"
@@ -43,10 +44,12 @@ import (
 )
 
 const (
-	checkpointInterval = 1024 // Number of blocks after which to save the vote snapshot to the database
-	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
-	inmemoryPeers      = 40
-	inmemoryMessages   = 1024
+	defaultCheckpointInterval = 1024 // Default number of blocks after which to save the vote snapshot to the database
+	minCheckpointInterval     = 64   // Minimum checkpoint interval allowed to avoid flooding the database
+	inmemorySnapshots         = 128  // Number of recent vote snapshots to keep in memory
+	inmemoryPeers             = 40
+	inmemoryMessages          = 1024
+	snapshotBatchThreshold    = 10 // Number of headers above which a snapshot walk reads them in a single batch
 )
 
 var (
@@ -86,6 +89,10 @@ var (
 	errEmptyCommittedSeals = errors.New("zero committed seals")
 	// errMismatchTxhashes is returned if the TxHash in header is mismatch.
 	errMismatchTxhashes = errors.New("mismatch transcations hashes")
+	// errInvalidBlockRange is returned if a requested block range is reversed or too wide.
+	errInvalidBlockRange = errors.New("invalid block range")
+	// errInvalidProposerPolicy is returned if a proposer policy is unknown.
+	errInvalidProposerPolicy = errors.New("invalid proposer policy")
 )
 var (
 	//nilUncleHash      = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
@@ -191,16 +198,79 @@ func (sb *backend) verifyCascadingFields(chain consensus.ChainReader, header *ty
 // a results channel to retrieve the async verifications (the order is that of
 // the input slice).
 func (sb *backend) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return sb.verifyHeaders(chain, headers, runtime.NumCPU())
+}
+
+// VerifyHeaderRange verifies a contiguous segment of headers sequentially, each
+// one against the headers before it. It returns the index and error of the first
+// header failing verification, or (-1, nil) if the whole segment is valid.
+func (sb *backend) VerifyHeaderRange(chain consensus.ChainReader, headers []*types.Header) (int, error) {
+	for i, header := range headers {
+		if err := sb.verifyHeader(chain, header, headers[:i]); err != nil {
+			return i, err
+		}
+	}
+	return -1, nil
+}
+
+// verifyHeaders verifies a batch of headers with the given number of workers.
+// Results are delivered in input order. Once the first error has been delivered
+// no further headers are handed out, so the remaining workers exit early. The
+// abort channel is owned by the caller and is never closed here.
+func (sb *backend) verifyHeaders(chain consensus.ChainReader, headers []*types.Header, workers int) (chan<- struct{}, <-chan error) {
 	abort := make(chan struct{})
 	results := make(chan error, len(headers))
+	if len(headers) == 0 {
+		return abort, results
+	}
+	if workers > len(headers) {
+		workers = len(headers)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		inputs = make(chan int)
+		done   = make(chan int, len(headers))
+		errs   = make([]error, len(headers))
+	)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for index := range inputs {
+				errs[index] = sb.verifyHeader(chain, headers[index], headers[:index])
+				done <- index
+			}
+		}()
+	}
+
 	go func() {
-		for i, header := range headers {
-			err := sb.verifyHeader(chain, header, headers[:i])
+		defer close(inputs)
 
+		var (
+			in, out = 0, 0
+			checked = make([]bool, len(headers))
+			feed    = inputs
+		)
+		for {
 			select {
+			case feed <- in:
+				if in++; in == len(headers) {
+					// Reached end of headers. Stop sending to workers.
+					feed = nil
+				}
+			case index := <-done:
+				for checked[index] = true; out < len(headers) && checked[out]; out++ {
+					results <- errs[out]
+					if errs[out] != nil {
+						return
+					}
+				}
+				if out == len(headers) {
+					return
+				}
 			case <-abort:
 				return
-			case results <- err:
 			}
 		}
 	}()
@@ -281,15 +351,13 @@ func (sb *backend) verifyCommittedSeals(chain consensus.ChainReader, header *typ
 	validators := snap.ValSet.Copy()
 	// Check whether the committed seals are generated by parent's validators
 	validSeal := 0
-	proposalSeal := istanbulCore.PrepareCommittedSeal(header.Hash())
-	// 1. Get committed seals from current header
-	for _, seal := range extra.CommittedSeal {
-		// 2. Get the original address by seal and parent block hash
-		addr, err := istanbul.GetSignatureAddress(proposalSeal, seal)
-		if err != nil {
-			sb.logger.Error("not a valid address", "err", err)
-			return errInvalidSignature
-		}
+	// 1. Get the original addresses by the committed seals of current header
+	signers, err := committedSealSigners(header, extra.CommittedSeal)
+	if err != nil {
+		sb.logger.Error("not a valid address", "err", err)
+		return errInvalidSignature
+	}
+	for _, addr := range signers {
 		// Every validator can have only one seal. If more than one seals are signed by a
 		// validator, the validator cannot be found and errInvalidCommittedSeals is returned.
 		if validators.RemoveValidator(addr) {
@@ -300,7 +368,9 @@ func (sb *backend) verifyCommittedSeals(chain consensus.ChainReader, header *typ
 		}
 	}
 
-	// The length of validSeal should be larger than number of faulty node + 1
+	// The length of validSeal should be larger than number of faulty node + 1.
+	// Headers may hold only a quorum of seals, see MaxStoredCommittedSeals.
+	// Validators pending removal are still in the set and count toward quorum.
 	if validSeal < snap.ValSet.Size()-snap.ValSet.F() /*2*snap.ValSet.F()*/ {
 		log.Error("errInvalidCommittedSeals", "validSeal", validSeal, "snap.ValSet.Size()", snap.ValSet.Size(), "snap.ValSet.F()", snap.ValSet.F())
 		return errInvalidCommittedSeals
@@ -309,6 +379,54 @@ func (sb *backend) verifyCommittedSeals(chain consensus.ChainReader, header *typ
 	return nil
 }
 
+// committedSealSigners recovers the addresses that signed the given committed
+// seals of header.
+func committedSealSigners(header *types.Header, seals [][]byte) ([]common.Address, error) {
+	proposalSeal := istanbulCore.PrepareCommittedSeal(header.Hash())
+	signers := make([]common.Address, 0, len(seals))
+	for _, seal := range seals {
+		addr, err := istanbul.GetSignatureAddress(proposalSeal, seal)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, addr)
+	}
+	return signers, nil
+}
+
+// ValidatorParticipation counts for every validator how many blocks in the range
+// [from, to] carry its committed seal. Validators that are online but do not
+// sign show up with low counts. The range is capped at maxParticipationRange
+// blocks.
+func (sb *backend) ValidatorParticipation(chain consensus.ChainReader, from, to uint64) (map[common.Address]int, error) {
+	if from > to || to-from >= maxParticipationRange {
+		return nil, errInvalidBlockRange
+	}
+	// The genesis block carries no committed seals
+	if from == 0 {
+		from = 1
+	}
+	participation := make(map[common.Address]int)
+	for number := from; number <= to; number++ {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil {
+			return nil, errUnknownBlock
+		}
+		extra, err := types.ExtractIstanbulExtra(header)
+		if err != nil {
+			return nil, err
+		}
+		signers, err := committedSealSigners(header, extra.CommittedSeal)
+		if err != nil {
+			return nil, errInvalidSignature
+		}
+		for _, addr := range signers {
+			participation[addr]++
+		}
+	}
+	return participation, nil
+}
+
 // VerifySeal checks whether the crypto seal on a header is valid according to
 // the consensus rules of the given engine.
 func (sb *backend) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
@@ -377,15 +495,23 @@ func (sb *backend) Finalize(chain consensus.ChainReader, header *types.Header, s
 	scNode.SetBlockNumber(header.Number)
 	parent := chain.GetHeaderByNumber(header.Number.Uint64() - 1)
 	if parent != nil {
+		elected := scNode.ElectedNodeNames()
 		if _, err := scNode.VrfElection(parent.Nonce[:]); err != nil {
 			return nil, err
 		}
+		if !bytes.Equal(elected, scNode.ElectedNodeNames()) {
+			sb.markValidatorSetChange(header.Number.Uint64())
+		}
 	}
 
 	header.Root = state.IntermediateRoot(true)
 	log.Debug(fmt.Errorf("root after:%x", header.Root).Error())
 	// Assemble and return the final block for sealing
-	return types.NewBlock(header, txs, receipts), nil
+	block := types.NewBlock(header, txs, receipts)
+	if witness := state.GetWitness(); witness != nil {
+		block.WitnessHash = witness.Hash()
+	}
+	return block, nil
 }
 
 // Seal generates a new block for the given input block with the local miner's
@@ -412,6 +538,11 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, sealRes
 	if err != nil {
 		return nil, err
 	}
+	// A sole validator commits its own blocks, no need to go through the core,
+	// unless fast sealing has the core verify them before sealing
+	if snap.ValSet.Size() == 1 && !sb.config.FastSealSingleValidator && sb.canCommitSingle(block) {
+		return sb.commitSingle(block, sealResultCh, stop)
+	}
 
 	//// wait for the timestamp of header, use this to adjust the block period
 	//delay := time.Unix(block.Header().Time.Int64(), 0).Sub(now())
@@ -421,22 +552,24 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, sealRes
 	//	return nil, nil
 	//}
 
-	// get the proposed block hash and clear it if the seal() is completed.
+	// remember the proposed block hash, it is released once the block is
+	// committed or the seal is given up.
 	sb.sealMu.Lock()
 	sb.proposedBlockHash = block.Hash()
-	clear := func() {
-		//sb.proposedBlockHash = common.Hash{}
-		sb.sealMu.Unlock()
-	}
-	defer clear()
+	defer sb.sealMu.Unlock()
 	sb.logger.Debug("post seal", "block number", block.Number(), "hash", block.Hash())
 
-	if snap.ValSet.Size() == 1 {
+	if snap.ValSet.Size() == 1 && !sb.config.FastSealSingleValidator {
 		// post block into Istanbul engine
 		go sb.EventMux().Post(istanbul.SingleCommittedEvent{
 			Proposal: block,
 		})
 	} else {
+		if sb.config.PersistProposedBlocks {
+			if err := sb.persistProposedBlock(block); err != nil {
+				sb.logger.Error("Failed to persist proposed block", "hash", block.Hash(), "err", err)
+			}
+		}
 		// post block into Istanbul engine
 		go sb.EventMux().Post(istanbul.RequestEvent{
 			Proposal: block,
@@ -444,6 +577,12 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, sealRes
 	}
 
 	go func() {
+		var timeout <-chan time.Time
+		if sb.config.SealTimeout > 0 {
+			timer := time.NewTimer(time.Duration(sb.config.SealTimeout) * time.Millisecond)
+			defer timer.Stop()
+			timeout = timer.C
+		}
 		for {
 			select {
 			case result := <-sb.commitCh:
@@ -457,7 +596,20 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, sealRes
 					sealResultCh <- result
 					return //result, nil
 				}
+			case <-timeout:
+				// Give up on the block, letting the miner resubmit, unless
+				// it was committed meanwhile and its result is on the way
+				if !sb.releaseProposal(block.Hash()) {
+					continue
+				}
+				sb.logger.Warn("Timed out waiting for the block to be committed", "number", block.Number(), "hash", block.Hash(), "timeout", time.Duration(sb.config.SealTimeout)*time.Millisecond)
+				select {
+				case sealResultCh <- nil:
+				case <-stop:
+				}
+				return
 			case <-stop:
+				sb.releaseProposal(block.Hash())
 				return //nil, nil
 			}
 		}
@@ -466,6 +618,53 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, sealRes
 
 }
 
+// releaseProposal forgets hash as the proposed block waiting to be committed,
+// so a late commit inserts the block instead of handing it to a finished seal.
+// It reports false if hash is not the proposed block anymore.
+func (sb *backend) releaseProposal(hash common.Hash) bool {
+	sb.sealMu.Lock()
+	defer sb.sealMu.Unlock()
+
+	if sb.proposedBlockHash != hash {
+		return false
+	}
+	sb.proposedBlockHash = common.Hash{}
+	return true
+}
+
+// canCommitSingle reports whether a sole validator can commit block right away.
+// Blocks rejected by Commit are left to the Istanbul core, which starts a new
+// round for them.
+func (sb *backend) canCommitSingle(block *types.Block) bool {
+	if block.Transactions().Len() == 0 && !common.SysCfg.IsProduceEmptyBlock() {
+		return false
+	}
+	return sb.CheckFirstNodeCommitAtWrongTime() == nil
+}
+
+// commitSingle adds the committed seal of the local node to block and delivers
+// it on sealResultCh without waiting for the Istanbul core. It returns early
+// with no block if stop is closed before the result is taken.
+func (sb *backend) commitSingle(block *types.Block, sealResultCh chan<- *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	seal, err := sb.Sign(istanbulCore.PrepareCommittedSeal(block.Hash()))
+	if err != nil {
+		return nil, err
+	}
+	header := block.Header()
+	if err := writeCommittedSeals(header, [][]byte{seal}); err != nil {
+		return nil, err
+	}
+	block = block.WithSeal(header)
+	sb.logger.Info("Committed", "address", sb.Address(), "hash", block.Hash(), "number", block.NumberU64())
+
+	select {
+	case sealResultCh <- block:
+		return block, nil
+	case <-stop:
+		return nil, nil
+	}
+}
+
 // update timestamp and signature of the block based on its number of transactions
 func (sb *backend) updateBlock(parent *types.Header, block *types.Block) (*types.Block, error) {
 	header := block.Header()
@@ -490,9 +689,26 @@ func (sb *backend) APIs(chain consensus.ChainReader) []rpc.API {
 		Version:   "1.0",
 		Service:   &API{chain: chain, istanbul: sb},
 		Public:    true,
+	}, {
+		Namespace: "istanbul",
+		Version:   "1.0",
+		Service:   &PrivateAPI{istanbul: sb},
+		Public:    false,
 	}}
 }
 
+// PurgeSnapshotCache drops the snapshots held in memory along with the cached
+// signers and seal counts of recent headers, so all of them are derived afresh
+// from the chain and the snapshots stored on disk on their next access. It is
+// safe to call at any time, but rebuilding the snapshots of busy heights costs
+// a burst of CPU.
+func (sb *backend) PurgeSnapshotCache() {
+	sb.recents.Purge()
+	recentAddresses.Purge()
+	recentPubkeys.Purge()
+	log.Warn("Purged the istanbul snapshot cache")
+}
+
 // Start implements consensus.Istanbul.Start
 func (sb *backend) Start(chain consensus.ChainReader, currentBlock func() *types.Block) error {
 	sb.coreMu.Lock()
@@ -511,9 +727,16 @@ func (sb *backend) Start(chain consensus.ChainReader, currentBlock func() *types
 	sb.chain = chain
 	sb.currentBlock = currentBlock
 
+	if err := sb.migrateCheckpoints(chain); err != nil {
+		return err
+	}
+
 	if err := sb.core.Start(); err != nil {
 		return err
 	}
+	if sb.config.PersistProposedBlocks {
+		sb.recoverProposedBlocks()
+	}
 
 	sb.coreStarted = true
 	return nil
@@ -539,6 +762,8 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 	var (
 		headers []*types.Header
 		snap    *Snapshot
+		batch   []*types.Header // Canonical headers read ahead of a long walk
+		batched bool
 	)
 	for snap == nil {
 		// If an in-memory snapshot was found, use that
@@ -548,7 +773,7 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 			break
 		}
 		// If an on-disk checkpoint snapshot can be found, use that
-		if number%checkpointInterval == 0 {
+		if sb.isCheckpoint(number) {
 			if s, err := loadSnapshot(sb.db, hash); err == nil {
 				log.Trace("Loaded voting snapshot form disk", "number", number, "hash", hash)
 				snap = s
@@ -602,8 +827,14 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 			}
 			parents = parents[:len(parents)-1]
 		} else {
-			// No explicit parents (or no more left), reach out to the database
-			header = chain.GetHeader(hash, number)
+			// No explicit parents (or no more left), reach out to the database,
+			// reading the headers back to the last checkpoint at once if many
+			if last := sb.lastCheckpoint(number); !batched && number-last > snapshotBatchThreshold {
+				batch, batched = chain.GetHeaderRangeByNumber(last+1, number), true
+			}
+			if header = batchedHeader(batch, hash, number); header == nil {
+				header = chain.GetHeader(hash, number)
+			}
 			if header == nil {
 				return nil, consensus.ErrUnknownAncestor
 			}
@@ -624,7 +855,7 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 
 	sb.recents.Add(snap.Hash, snap)
 	// If we've generated a new checkpoint snapshot, save to disk
-	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+	if snap.Number%sb.checkpointInterval == 0 && len(headers) > 0 {
 		if err = snap.store(sb.db); err != nil {
 			return nil, err
 		}
@@ -633,6 +864,74 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 	return snap, err
 }
 
+// isCheckpoint reports whether a vote snapshot may have been persisted at the
+// given block number, either at the configured interval or at the interval
+// used before the last configuration change.
+func (sb *backend) isCheckpoint(number uint64) bool {
+	if number%sb.checkpointInterval == 0 {
+		return true
+	}
+	return sb.legacyCheckpointInterval != 0 && number%sb.legacyCheckpointInterval == 0
+}
+
+// lastCheckpoint returns the number of the latest checkpoint at or below number.
+func (sb *backend) lastCheckpoint(number uint64) uint64 {
+	last := number - number%sb.checkpointInterval
+	if sb.legacyCheckpointInterval != 0 {
+		if legacy := number - number%sb.legacyCheckpointInterval; legacy > last {
+			last = legacy
+		}
+	}
+	return last
+}
+
+// batchedHeader returns the header of batch with the given hash and number, nil
+// if the batch holds a different one or none for number.
+func batchedHeader(batch []*types.Header, hash common.Hash, number uint64) *types.Header {
+	if len(batch) == 0 || number < batch[0].Number.Uint64() {
+		return nil
+	}
+	if index := number - batch[0].Number.Uint64(); index < uint64(len(batch)) && batch[index].Hash() == hash {
+		return batch[index]
+	}
+	return nil
+}
+
+// migrateCheckpoints re-checkpoints the vote snapshots if the checkpoint interval
+// changed since the database was last written. The latest snapshot aligned to the
+// new interval is regenerated from the old checkpoints and persisted, after which
+// the new interval is recorded in the database.
+func (sb *backend) migrateCheckpoints(chain consensus.ChainReader) error {
+	stored := loadCheckpointInterval(sb.db)
+	if stored == 0 {
+		// Databases written before the interval was configurable used the default
+		stored = defaultCheckpointInterval
+	}
+	if stored == sb.checkpointInterval {
+		return storeCheckpointInterval(sb.db, sb.checkpointInterval)
+	}
+	sb.legacyCheckpointInterval = stored
+
+	if head := chain.CurrentHeader(); head != nil {
+		number := head.Number.Uint64() - head.Number.Uint64()%sb.checkpointInterval
+		if number > 0 {
+			header := chain.GetHeaderByNumber(number)
+			if header == nil {
+				return errUnknownBlock
+			}
+			snap, err := sb.snapshot(chain, number, header.Hash(), nil)
+			if err != nil {
+				return err
+			}
+			if err := snap.store(sb.db); err != nil {
+				return err
+			}
+			log.Info("Re-checkpointed voting snapshot", "number", number, "old", stored, "new", sb.checkpointInterval)
+		}
+	}
+	return storeCheckpointInterval(sb.db, sb.checkpointInterval)
+}
+
 // FIXME: Need to update this for Istanbul
 // sigHash returns the hash which is used as input for the Istanbul
 // signing. It is the hash of the entire header apart from the 65 byte signature
@@ -671,6 +970,14 @@ func ecrecover(header *types.Header) (common.Address, error) {
 	return addr, nil
 }
 
+// CommittedSealCount returns the number of committed seals of a header, which
+// tells how close to the full validator set the agreement on the block got.
+// The count is not cached: the header hash leaves the committed seals out, and
+// counting them is cheaper than hashing the header anyway.
+func (sb *backend) CommittedSealCount(header *types.Header) (int, error) {
+	return types.IstanbulCommittedSealCount(header)
+}
+
 // recoverPubkey extracts the Ethereum account pubkey from a signed header.
 func recoverPubkey(header *types.Header) (ecdsa.PublicKey, error) {
 	hash := header.Hash()
@@ -692,10 +999,15 @@ func recoverPubkey(header *types.Header) (ecdsa.PublicKey, error) {
 	return *pubkey, nil
 }
 
-// prepareExtra returns a extra-data of the given header and validators
+// prepareExtra returns a extra-data of the given header and validators. The
+// metadata of an istanbul extra-data already in the header is preserved.
 func prepareExtra(header *types.Header, vals []common.Address) ([]byte, error) {
 	var buf bytes.Buffer
 
+	var metadata []byte
+	if existing, err := types.ExtractIstanbulExtra(header); err == nil {
+		metadata = existing.Metadata
+	}
 	// compensate the lack bytes if header.Extra is not enough IstanbulExtraVanity bytes.
 	if len(header.Extra) < types.IstanbulExtraVanity {
 		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity-len(header.Extra))...)
@@ -706,6 +1018,7 @@ func prepareExtra(header *types.Header, vals []common.Address) ([]byte, error) {
 		Validators:    vals,
 		Seal:          []byte{},
 		CommittedSeal: [][]byte{},
+		Metadata:      metadata,
 	}
 
 	payload, err := rlp.EncodeToBytes(&ist)
@@ -717,7 +1030,7 @@ func prepareExtra(header *types.Header, vals []common.Address) ([]byte, error) {
 }
 
 // writeSeal writes the extra-data field of the given header with the given seals.
-// suggest to rename to writeSeal.
+// suggest to rename to writeSeal. Any metadata in the extra-data is preserved.
 func writeSeal(h *types.Header, seal []byte) error {
 	if len(seal)%types.IstanbulExtraSeal != 0 {
 		return errInvalidSignature
@@ -738,7 +1051,23 @@ func writeSeal(h *types.Header, seal []byte) error {
 	return nil
 }
 
+// truncateCommittedSeals keeps the first max committed seals, but no fewer than
+// quorum. A max of 0 keeps all of them.
+func truncateCommittedSeals(committedSeals [][]byte, max, quorum int) [][]byte {
+	if max <= 0 {
+		return committedSeals
+	}
+	if max < quorum {
+		max = quorum
+	}
+	if max >= len(committedSeals) {
+		return committedSeals
+	}
+	return committedSeals[:max]
+}
+
 // writeCommittedSeals writes the extra-data field of a block header with given committed seals.
+// Any metadata in the extra-data is preserved.
 func writeCommittedSeals(h *types.Header, committedSeals [][]byte) error {
 	if len(committedSeals) == 0 {
 		return errInvalidCommittedSeals
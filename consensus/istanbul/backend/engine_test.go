@@ -19,8 +19,14 @@ package backend
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"errors"
+	"io/ioutil"
 	"math/big"
+	"os"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -28,31 +34,73 @@ import (
 	"github.com/Venachain/Venachain/common/hexutil"
 	"github.com/Venachain/Venachain/consensus"
 	"github.com/Venachain/Venachain/consensus/istanbul"
+	istanbulCore "github.com/Venachain/Venachain/consensus/istanbul/core"
+	"github.com/Venachain/Venachain/consensus/istanbul/validator"
 	"github.com/Venachain/Venachain/core"
+	"github.com/Venachain/Venachain/core/rawdb"
 	"github.com/Venachain/Venachain/core/types"
 	"github.com/Venachain/Venachain/core/vm"
 	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/p2p"
 	"github.com/Venachain/Venachain/params"
 	"github.com/Venachain/Venachain/rlp"
 )
 
+func init() {
+	common.SysCfg.ReplayParam = &common.ReplayParam{
+		Pivot:           0,
+		OldSysContracts: make(map[common.Address]string),
+		OldSuperAdmin:   common.NullAddress,
+	}
+	// The tests below seal and commit blocks without any transaction
+	common.SysCfg.SysParam.IsProduceEmptyBlock = true
+}
+
+var testServerOnce sync.Once
+
+// startTestServer registers a running p2p server without any network activity,
+// which committing the first block waits for to check the first validator node.
+func startTestServer() {
+	testServerOnce.Do(func() {
+		key, _ := crypto.GenerateKey()
+		srv := &p2p.Server{Config: p2p.Config{PrivateKey: key, NoDiscovery: true, NoDial: true}}
+		if err := srv.Start(); err != nil {
+			panic(err)
+		}
+		srv.SetServer()
+	})
+}
+
 // in this test, we can set n to 1, and it means we can process Istanbul and commit a
 // block by one node. Otherwise, if n is larger than 1, we have to generate
 // other fake events to process Istanbul.
 func newBlockChain(n int) (*core.BlockChain, *backend) {
+	startTestServer()
 	genesis, nodeKeys := getGenesisAndKeys(n)
 	memDB := ethdb.NewMemDatabase()
-	config := istanbul.DefaultConfig
+	config := (*params.IstanbulConfig)(istanbul.DefaultConfig)
 	// Use the first key as private key
 	b, _ := New(config, nodeKeys[0], memDB).(*backend)
 	genesis.MustCommit(memDB)
-	blockchain, err := core.NewBlockChain(memDB, nil, genesis.Config, b, vm.Config{})
+	blockchain, _, err := core.NewBlockChain(memDB, nil, nil, genesis.Config, b, vm.Config{}, nil)
 	if err != nil {
 		panic(err)
 	}
-	b.Start(blockchain, blockchain.CurrentBlock, blockchain.HasBadBlock)
-	snap, err := b.snapshot(blockchain, 0, common.Hash{}, nil)
+	// Without a node contract the validators are only known from the genesis
+	// snapshot, which is stored up front instead of being derived from the
+	// first validator node
+	addrs := make([]common.Address, n)
+	for i, key := range nodeKeys {
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	genesisSnap := newSnapshot(0, blockchain.Genesis().Hash(), validator.NewSet(addrs, config.ProposerPolicy))
+	if err := genesisSnap.store(memDB); err != nil {
+		panic(err)
+	}
+
+	b.Start(blockchain, blockchain.CurrentBlock)
+	snap, err := b.snapshot(blockchain, 0, blockchain.Genesis().Hash(), nil)
 	if err != nil {
 		panic(err)
 	}
@@ -82,12 +130,12 @@ func getGenesisAndKeys(n int) (*core.Genesis, []*ecdsa.PrivateKey) {
 		addrs[i] = crypto.PubkeyToAddress(nodeKeys[i].PublicKey)
 	}
 
-	// generate genesis block
-	genesis := core.DefaultGenesisBlock()
-	genesis.Config = params.TestChainConfig
-	// force enable Istanbul engine
-	genesis.Config.Istanbul = &params.IstanbulConfig{}
-	genesis.Nonce = emptyNonce.Uint64()
+	// generate genesis block, force enabling the Istanbul engine
+	genesis := &core.Genesis{
+		Config:   &params.ChainConfig{ChainID: big.NewInt(1), Istanbul: &params.IstanbulConfig{}},
+		GasLimit: params.GenesisGasLimit,
+		Nonce:    emptyNonce.Uint64(),
+	}
 
 	appendValidators(genesis, addrs)
 	return genesis, nodeKeys
@@ -113,11 +161,11 @@ func appendValidators(genesis *core.Genesis, addrs []common.Address) {
 	genesis.ExtraData = append(genesis.ExtraData, istPayload...)
 }
 
-func makeHeader(parent *types.Block, config *istanbul.Config) *types.Header {
+func makeHeader(parent *types.Block, config *params.IstanbulConfig) *types.Header {
 	header := &types.Header{
 		ParentHash: parent.Hash(),
 		Number:     parent.Number().Add(parent.Number(), common.Big1),
-		GasLimit:   core.CalcGasLimit(parent),
+		GasLimit:   parent.GasLimit(),
 		GasUsed:    0,
 		Extra:      parent.Extra(),
 		Time:       new(big.Int).Add(parent.Time(), new(big.Int).SetUint64(config.BlockPeriod)),
@@ -127,15 +175,23 @@ func makeHeader(parent *types.Block, config *istanbul.Config) *types.Header {
 
 func makeBlock(chain *core.BlockChain, engine *backend, parent *types.Block) *types.Block {
 	block := makeBlockWithoutSeal(chain, engine, parent)
-	block, _ = engine.Seal(chain, block, nil)
+	results := make(chan *types.Block, 1)
+	if _, err := engine.Seal(chain, block, results, nil); err != nil {
+		panic(err)
+	}
+	select {
+	case block = <-results:
+	case <-time.After(5 * time.Second):
+		panic("block not sealed")
+	}
 	return block
 }
 
 func makeBlockWithoutSeal(chain *core.BlockChain, engine *backend, parent *types.Block) *types.Block {
 	header := makeHeader(parent, engine.config)
 	engine.Prepare(chain, header)
-	state, _, _ := chain.StateAt(parent.Root())
-	block, _ := engine.Finalize(chain, header, state, nil, nil, nil)
+	state, _ := chain.StateAt(parent.Root())
+	block, _ := engine.Finalize(chain, header, state, nil, nil)
 	return block
 }
 
@@ -146,7 +202,7 @@ func TestPrepare(t *testing.T) {
 	if err != nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
 	}
-	header.ParentHash = common.StringToHash("1234567890")
+	header.ParentHash = common.BytesToHash([]byte("1234567890"))
 	err = engine.Prepare(chain, header)
 	if err != consensus.ErrUnknownAncestor {
 		t.Errorf("error mismatch: have %v, want %v", err, consensus.ErrUnknownAncestor)
@@ -156,7 +212,8 @@ func TestPrepare(t *testing.T) {
 func TestSealStopChannel(t *testing.T) {
 	chain, engine := newBlockChain(4)
 	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
-	stop := make(chan struct{}, 1)
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
 	eventSub := engine.EventMux().Subscribe(istanbul.RequestEvent{})
 	eventLoop := func() {
 		select {
@@ -165,18 +222,28 @@ func TestSealStopChannel(t *testing.T) {
 			if !ok {
 				t.Errorf("unexpected event comes: %v", reflect.TypeOf(ev.Data))
 			}
-			stop <- struct{}{}
+			close(stop)
 		}
 		eventSub.Unsubscribe()
 	}
 	go eventLoop()
-	finalBlock, err := engine.Seal(chain, block, stop)
+	finalBlock, err := engine.Seal(chain, block, results, stop)
 	if err != nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
 	}
 	if finalBlock != nil {
 		t.Errorf("block mismatch: have %v, want nil", finalBlock)
 	}
+	select {
+	case <-stop:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("block not proposed")
+	}
+	select {
+	case result := <-results:
+		t.Errorf("result mismatch: have %v, want none", result)
+	case <-time.After(100 * time.Millisecond):
+	}
 }
 
 func TestSealCommittedOtherHash(t *testing.T) {
@@ -196,15 +263,16 @@ func TestSealCommittedOtherHash(t *testing.T) {
 		eventSub.Unsubscribe()
 	}
 	go eventLoop()
-	seal := func() {
-		engine.Seal(chain, block, nil)
-		t.Error("seal should not be completed")
+	results := make(chan *types.Block, 1)
+	if _, err := engine.Seal(chain, block, results, nil); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
 	}
-	go seal()
 
 	const timeoutDura = 2 * time.Second
 	timeout := time.NewTimer(timeoutDura)
 	select {
+	case result := <-results:
+		t.Errorf("seal should not be completed, have %v", result)
 	case <-timeout.C:
 		// wait 2 seconds to ensure we cannot get any blocks from Istanbul
 	}
@@ -215,64 +283,131 @@ func TestSealCommitted(t *testing.T) {
 	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
 	expectedBlock, _ := engine.updateBlock(engine.chain.GetHeader(block.ParentHash(), block.NumberU64()-1), block)
 
-	finalBlock, err := engine.Seal(chain, block, nil)
-	if err != nil {
+	results := make(chan *types.Block, 1)
+	if _, err := engine.Seal(chain, block, results, nil); err != nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
 	}
+	var finalBlock *types.Block
+	select {
+	case finalBlock = <-results:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("block not committed")
+	}
 	if finalBlock.Hash() != expectedBlock.Hash() {
 		t.Errorf("hash mismatch: have %v, want %v", finalBlock.Hash(), expectedBlock.Hash())
 	}
 }
 
-func TestVerifyHeader(t *testing.T) {
-	chain, engine := newBlockChain(1)
+func TestSealTimeout(t *testing.T) {
+	chain, engine := newBlockChain(4)
+	config := *engine.config
+	config.SealTimeout = 100
+	engine.config = &config
 
-	// errEmptyCommittedSeals case
+	// Nothing ever commits the block, the wait gives up after the timeout
 	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
-	block, _ = engine.updateBlock(chain.Genesis().Header(), block)
-	err := engine.VerifyHeader(chain, block.Header(), false)
-	if err != errEmptyCommittedSeals {
-		t.Errorf("error mismatch: have %v, want %v", err, errEmptyCommittedSeals)
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	if _, err := engine.Seal(chain, block, results, stop); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
 	}
+	select {
+	case result := <-results:
+		if result != nil {
+			t.Errorf("result mismatch: have %x, want nil", result.Hash())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("seal did not time out")
+	}
+	// The block is not awaited anymore, a late commit inserts it instead of
+	// handing it to the finished seal
+	engine.sealMu.Lock()
+	proposed := engine.proposedBlockHash
+	engine.sealMu.Unlock()
+	if proposed != (common.Hash{}) {
+		t.Errorf("proposed block hash mismatch: have %x, want none", proposed)
+	}
+}
 
-	// short extra data
-	header := block.Header()
-	header.Extra = []byte{}
-	err = engine.VerifyHeader(chain, header, false)
-	if err != errInvalidExtraDataFormat {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidExtraDataFormat)
+// makeBlockWithTx assembles an unsealed block on top of parent holding a single
+// transfer, which the block is not executed for.
+func makeBlockWithTx(t *testing.T, chain *core.BlockChain, engine *backend, parent *types.Block) *types.Block {
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		Extra:      parent.Extra(),
+		Time:       new(big.Int).Add(parent.Time(), new(big.Int).SetUint64(engine.config.BlockPeriod)),
 	}
-	// incorrect extra format
-	header.Extra = []byte("0000000000000000000000000000000012300000000000000000000000000000000000000000000000000000000000000000")
-	err = engine.VerifyHeader(chain, header, false)
-	if err != errInvalidExtraDataFormat {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidExtraDataFormat)
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	state, err := chain.StateAt(parent.Root())
+	if err != nil {
+		t.Fatalf("failed to open parent state: %v", err)
+	}
+	tx := types.NewTransaction(0, common.Address{0x01}, new(big.Int), params.TxGas, nil, nil)
+	block, err := engine.Finalize(chain, header, state, types.Transactions{tx}, nil)
+	if err != nil {
+		t.Fatalf("failed to finalize block: %v", err)
 	}
+	return block
+}
 
-	// non zero MixDigest
-	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
-	header = block.Header()
-	header.MixDigest = common.StringToHash("123456789")
-	err = engine.VerifyHeader(chain, header, false)
-	if err != errInvalidMixDigest {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidMixDigest)
+func TestSealSingleValidator(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	block := makeBlockWithTx(t, chain, engine, chain.Genesis())
+	if !engine.canCommitSingle(block) {
+		t.Fatalf("single validator cannot commit the block")
+	}
+	// The sealed block is returned and delivered without any core round trip.
+	// Sealing signs the block, only its seal hash is kept
+	results := make(chan *types.Block, 1)
+	sealed, err := engine.Seal(chain, block, results, nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
 	}
+	if sealed == nil || engine.SealHash(sealed.Header()) != engine.SealHash(block.Header()) {
+		t.Fatalf("sealed block mismatch: have %v, want seal hash %x", sealed, engine.SealHash(block.Header()))
+	}
+	select {
+	case result := <-results:
+		if result != sealed {
+			t.Errorf("delivered block mismatch: have %x, want %x", result.Hash(), sealed.Hash())
+		}
+	default:
+		t.Fatalf("sealed block not delivered")
+	}
+	if err := engine.VerifySeal(chain, sealed.Header()); err != nil {
+		t.Errorf("failed to verify sealed block: %v", err)
+	}
+	// Nobody takes the result, stopping must not hang
+	stop := make(chan struct{})
+	close(stop)
+	sealed, err = engine.Seal(chain, block, make(chan *types.Block), stop)
+	if err != nil || sealed != nil {
+		t.Errorf("stopped seal mismatch: have %v/%v, want nil/nil", sealed, err)
+	}
+}
 
-	// invalid uncles hash
-	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
-	header = block.Header()
-	header.UncleHash = common.StringToHash("123456789")
-	err = engine.VerifyHeader(chain, header, false)
-	if err != errInvalidUncleHash {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidUncleHash)
+func TestVerifyHeader(t *testing.T) {
+	chain, engine := newBlockChain(1)
+
+	// errEmptyCommittedSeals case
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	block, _ = engine.updateBlock(chain.Genesis().Header(), block)
+	err := engine.VerifyHeader(chain, block.Header(), false)
+	if err != errEmptyCommittedSeals {
+		t.Errorf("error mismatch: have %v, want %v", err, errEmptyCommittedSeals)
 	}
 
-	// invalid difficulty
-	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
-	header = block.Header()
+	// unknown parent
+	header := makeBlockWithoutSeal(chain, engine, chain.Genesis()).Header()
+	header.ParentHash = common.BytesToHash([]byte("123456789"))
 	err = engine.VerifyHeader(chain, header, false)
-	if err != errInvalidDifficulty {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidDifficulty)
+	if err != consensus.ErrUnknownAncestor {
+		t.Errorf("error mismatch: have %v, want %v", err, consensus.ErrUnknownAncestor)
 	}
 
 	// invalid timestamp
@@ -284,24 +419,14 @@ func TestVerifyHeader(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTimestamp)
 	}
 
-	// future block
+	// future block, timestamps are in milliseconds
 	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
 	header = block.Header()
-	header.Time = new(big.Int).Add(big.NewInt(now().Unix()), new(big.Int).SetUint64(10))
+	header.Time = big.NewInt(now().UnixNano()/1e6 + 60000)
 	err = engine.VerifyHeader(chain, header, false)
 	if err != consensus.ErrFutureBlock {
 		t.Errorf("error mismatch: have %v, want %v", err, consensus.ErrFutureBlock)
 	}
-
-	// invalid nonce
-	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
-	header = block.Header()
-	copy(header.Nonce[:], hexutil.MustDecode("0x111111111111"))
-	header.Number = big.NewInt(int64(engine.config.Epoch))
-	err = engine.VerifyHeader(chain, header, false)
-	if err != errInvalidNonce {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidNonce)
-	}
 }
 
 func TestVerifySeal(t *testing.T) {
@@ -432,6 +557,228 @@ OUT3:
 	}
 }
 
+// makeVerifyHeaders builds a chain of size signed headers on top of genesis.
+func makeVerifyHeaders(chain *core.BlockChain, engine *backend, size int) []*types.Header {
+	headers := make([]*types.Header, 0, size)
+	parent := chain.Genesis()
+	for i := 0; i < size; i++ {
+		b := makeBlockWithoutSeal(chain, engine, parent)
+		b, _ = engine.updateBlock(parent.Header(), b)
+		// Commit the block as its single validator
+		header := b.Header()
+		seal, err := engine.Sign(istanbulCore.PrepareCommittedSeal(header.Hash()))
+		if err != nil {
+			panic(err)
+		}
+		if err := writeCommittedSeals(header, [][]byte{seal}); err != nil {
+			panic(err)
+		}
+		b = b.WithSeal(header)
+		headers = append(headers, header)
+		parent = b
+	}
+	return headers
+}
+
+func TestVerifyHeadersStopsAfterFirstError(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	size := 100
+	headers := makeVerifyHeaders(chain, engine, size)
+	now = func() time.Time {
+		return time.Unix(headers[size-1].Time.Int64(), 0)
+	}
+	// break the chain so that verification must fail no later than the third header
+	headers[2].Number = big.NewInt(100)
+
+	_, results := engine.verifyHeaders(chain, headers, 4)
+	const timeoutDura = 2 * time.Second
+	timeout := time.NewTimer(timeoutDura)
+	index := 0
+OUT:
+	for {
+		select {
+		case err := <-results:
+			index++
+			if err != nil {
+				break OUT
+			}
+		case <-timeout.C:
+			t.Fatalf("no error delivered after %d results", index)
+		}
+	}
+	if index > 3 {
+		t.Errorf("first error delivered too late: have %d results, want <= 3", index)
+	}
+	// no more results should be delivered once an error has been sent
+	select {
+	case err := <-results:
+		t.Errorf("unexpected result after first error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestVerifyHeaderRange(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	size := 10
+	headers := makeVerifyHeaders(chain, engine, size)
+	now = func() time.Time {
+		return time.Unix(headers[size-1].Time.Int64(), 0)
+	}
+	if index, err := engine.VerifyHeaderRange(chain, headers); index != -1 || err != nil {
+		t.Fatalf("valid segment rejected: index %d, err %v", index, err)
+	}
+	if index, err := engine.VerifyHeaderRange(chain, nil); index != -1 || err != nil {
+		t.Errorf("empty segment rejected: index %d, err %v", index, err)
+	}
+	// Break the segment in the middle, the first failure is reported
+	headers[6].Number = big.NewInt(100)
+	headers[8].Number = big.NewInt(200)
+	if index, err := engine.VerifyHeaderRange(chain, headers); index != 6 || err == nil {
+		t.Errorf("failure mismatch: have index %d, err %v, want index 6 and an error", index, err)
+	}
+}
+
+func TestVerifyHeadersAbort(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	size := 100
+	headers := makeVerifyHeaders(chain, engine, size)
+	now = func() time.Time {
+		return time.Unix(headers[size-1].Time.Int64(), 0)
+	}
+	abort, results := engine.verifyHeaders(chain, headers, 4)
+	close(abort)
+
+	// drain whatever was delivered before the abort was noticed
+	index := 0
+	timeout := time.NewTimer(2 * time.Second)
+OUT:
+	for {
+		select {
+		case <-results:
+			index++
+		case <-timeout.C:
+			break OUT
+		}
+	}
+	if index >= size {
+		t.Errorf("verifyHeaders should be aborted, have %d results", index)
+	}
+}
+
+func benchmarkVerifyHeaders(b *testing.B, workers int) {
+	chain, engine := newBlockChain(1)
+	size := 2048
+	headers := makeVerifyHeaders(chain, engine, size)
+	now = func() time.Time {
+		return time.Unix(headers[size-1].Time.Int64(), 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		abort, results := engine.verifyHeaders(chain, headers, workers)
+		for j := 0; j < size; j++ {
+			if err := <-results; err != nil {
+				break
+			}
+		}
+		close(abort)
+	}
+}
+
+func BenchmarkVerifyHeadersSerial(b *testing.B)   { benchmarkVerifyHeaders(b, 1) }
+func BenchmarkVerifyHeadersParallel(b *testing.B) { benchmarkVerifyHeaders(b, runtime.NumCPU()) }
+
+// benchmarkFinalize measures the latency of finalizing a proposal on top of the
+// state of its parent, optionally preloaded into the block chain cache.
+func benchmarkFinalize(b *testing.B, preload bool) {
+	chain, engine := newBlockChain(1)
+	cache := core.NewBlockChainCache(chain)
+	parent := chain.Genesis()
+	proposal := makeBlockWithoutSeal(chain, engine, parent)
+
+	if preload {
+		cache.Preload(proposal)
+		for cache.ReadStateDB(parent.Header().SealHash()) == nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		state, err := cache.GetState(parent.Header())
+		if err != nil {
+			b.Fatalf("failed to get parent state: %v", err)
+		}
+		if _, err := engine.Finalize(chain, types.CopyHeader(proposal.Header()), state, nil, nil); err != nil {
+			b.Fatalf("failed to finalize: %v", err)
+		}
+	}
+}
+
+func BenchmarkFinalizeWithoutPreload(b *testing.B) { benchmarkFinalize(b, false) }
+func BenchmarkFinalizeWithPreload(b *testing.B)    { benchmarkFinalize(b, true) }
+
+// unbatchedChain hides the batched header retrieval of a chain, walking snapshots
+// back one header at a time.
+type unbatchedChain struct {
+	consensus.ChainReader
+}
+
+func (c unbatchedChain) GetHeaderRangeByNumber(from, to uint64) []*types.Header { return nil }
+
+// benchmarkSnapshot measures the reconstruction of a snapshot from 1024 headers
+// stored in a leveldb database, read one at a time or in a single batch.
+func benchmarkSnapshot(b *testing.B, batched bool) {
+	dir, err := ioutil.TempDir("", "istanbul-snapshot")
+	if err != nil {
+		b.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := ethdb.NewLDBDatabase(dir, 16, 16)
+	if err != nil {
+		b.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	genesis, nodeKeys := getGenesisAndKeys(1)
+	engine, _ := New((*params.IstanbulConfig)(istanbul.DefaultConfig), nodeKeys[0], db).(*backend)
+	parent := genesis.MustCommit(db).Header()
+	for number := int64(1); number <= 1024; number++ {
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     big.NewInt(number),
+			MixDigest:  types.IstanbulDigest,
+			Time:       new(big.Int).Add(parent.Time, common.Big1),
+		}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(number))
+		parent = header
+	}
+	rawdb.WriteHeadBlockHash(db, parent.Hash())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Start every round from cold caches
+		b.StopTimer()
+		hc, err := core.NewHeaderChain(db, genesis.Config, engine, func() bool { return false })
+		if err != nil {
+			b.Fatalf("failed to create header chain: %v", err)
+		}
+		var chain consensus.ChainReader = hc
+		if !batched {
+			chain = unbatchedChain{hc}
+		}
+		engine.recents.Purge()
+		b.StartTimer()
+
+		if _, err := engine.snapshot(chain, parent.Number.Uint64(), parent.Hash(), nil); err != nil {
+			b.Fatalf("failed to rebuild snapshot: %v", err)
+		}
+	}
+}
+
+func BenchmarkSnapshot1024(b *testing.B)        { benchmarkSnapshot(b, false) }
+func BenchmarkSnapshot1024Batched(b *testing.B) { benchmarkSnapshot(b, true) }
+
 func TestPrepareExtra(t *testing.T) {
 	validators := make([]common.Address, 4)
 	validators[0] = common.BytesToAddress(hexutil.MustDecode("0x44add0ec310f115a0e603b2d7db9f067778eaf8a"))
@@ -548,3 +895,328 @@ func TestWriteCommittedSeals(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
 	}
 }
+
+func TestExtraMetadataPreserved(t *testing.T) {
+	metadata := []byte{0x01, 0x02, 0x03}
+	vals := []common.Address{common.BytesToAddress([]byte{0x01})}
+
+	extra, err := rlp.EncodeToBytes(&types.IstanbulExtra{
+		Validators:    vals,
+		Seal:          []byte{},
+		CommittedSeal: [][]byte{},
+		Metadata:      metadata,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode extra: %v", err)
+	}
+	h := &types.Header{Extra: append(make([]byte, types.IstanbulExtraVanity), extra...)}
+
+	check := func(step string) {
+		istExtra, err := types.ExtractIstanbulExtra(h)
+		if err != nil {
+			t.Fatalf("%s: failed to extract extra: %v", step, err)
+		}
+		if !bytes.Equal(istExtra.Metadata, metadata) {
+			t.Fatalf("%s: metadata mismatch: have %x, want %x", step, istExtra.Metadata, metadata)
+		}
+	}
+	payload, err := prepareExtra(h, vals)
+	if err != nil {
+		t.Fatalf("failed to prepare extra: %v", err)
+	}
+	h.Extra = payload
+	check("prepareExtra")
+
+	if err := writeSeal(h, make([]byte, types.IstanbulExtraSeal)); err != nil {
+		t.Fatalf("failed to write seal: %v", err)
+	}
+	check("writeSeal")
+
+	if err := writeCommittedSeals(h, [][]byte{make([]byte, types.IstanbulExtraSeal)}); err != nil {
+		t.Fatalf("failed to write committed seals: %v", err)
+	}
+	check("writeCommittedSeals")
+}
+
+func TestTruncateCommittedSeals(t *testing.T) {
+	seals := make([][]byte, 7)
+	for i := range seals {
+		seals[i] = bytes.Repeat([]byte{byte(i)}, types.IstanbulExtraSeal)
+	}
+	// 7 validators tolerate 2 faults, a quorum is 5 seals
+	const quorum = 5
+	tests := []struct {
+		max  int
+		want int
+	}{
+		{0, 7},      // store all
+		{1, quorum}, // never below a quorum
+		{quorum - 1, quorum},
+		{quorum, quorum},
+		{quorum + 1, quorum + 1},
+		{7, 7},
+		{8, 7},
+	}
+	for i, tt := range tests {
+		have := truncateCommittedSeals(seals, tt.max, quorum)
+		if !reflect.DeepEqual(have, seals[:tt.want]) {
+			t.Errorf("test %d: stored %d seals, want the first %d", i, len(have), tt.want)
+		}
+	}
+}
+
+// headerChain is a consensus.ChainReader serving a fixed list of headers,
+// indexed by block number.
+type headerChain []*types.Header
+
+func (c headerChain) Config() *params.ChainConfig  { return params.TestChainConfig }
+func (c headerChain) CurrentHeader() *types.Header { return c[len(c)-1] }
+
+func (c headerChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.GetHeaderByNumber(number); header != nil && header.Hash() == hash {
+		return header
+	}
+	return nil
+}
+
+func (c headerChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number < uint64(len(c)) {
+		return c[number]
+	}
+	return nil
+}
+
+func (c headerChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	for _, header := range c {
+		if header.Hash() == hash {
+			return header
+		}
+	}
+	return nil
+}
+
+func (c headerChain) GetHeaderRangeByNumber(from, to uint64) []*types.Header {
+	if from > to || from >= uint64(len(c)) {
+		return nil
+	}
+	if to >= uint64(len(c)) {
+		to = uint64(len(c)) - 1
+	}
+	return c[from : to+1]
+}
+
+func (c headerChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if header := c.GetHeader(hash, number); header != nil {
+		return types.NewBlockWithHeader(header)
+	}
+	return nil
+}
+
+func TestValidatorParticipation(t *testing.T) {
+	_, engine := newBlockChain(1)
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	extra, _ := rlp.EncodeToBytes(&types.IstanbulExtra{
+		Validators:    []common.Address{},
+		Seal:          []byte{},
+		CommittedSeal: [][]byte{},
+	})
+	extra = append(bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity), extra...)
+
+	// The first validator signs every block, the second every other block and
+	// the third none at all
+	chain := headerChain{{Number: big.NewInt(0)}}
+	for number := int64(1); number <= 10; number++ {
+		header := &types.Header{
+			Number:    big.NewInt(number),
+			MixDigest: types.IstanbulDigest,
+			Extra:     common.CopyBytes(extra),
+		}
+		signers := keys[:1]
+		if number%2 == 0 {
+			signers = keys[:2]
+		}
+		var seals [][]byte
+		for _, key := range signers {
+			seal, err := crypto.Sign(crypto.Keccak256(istanbulCore.PrepareCommittedSeal(header.Hash())), key)
+			if err != nil {
+				t.Fatalf("failed to sign block %d: %v", number, err)
+			}
+			seals = append(seals, seal)
+		}
+		if err := writeCommittedSeals(header, seals); err != nil {
+			t.Fatalf("failed to write committed seals of block %d: %v", number, err)
+		}
+		chain = append(chain, header)
+	}
+
+	participation, err := engine.ValidatorParticipation(chain, 0, 10)
+	if err != nil {
+		t.Fatalf("failed to count participation: %v", err)
+	}
+	want := map[common.Address]int{
+		crypto.PubkeyToAddress(keys[0].PublicKey): 10,
+		crypto.PubkeyToAddress(keys[1].PublicKey): 5,
+	}
+	if !reflect.DeepEqual(participation, want) {
+		t.Errorf("participation mismatch: have %v, want %v", participation, want)
+	}
+
+	if _, err := engine.ValidatorParticipation(chain, 0, maxParticipationRange); err != errInvalidBlockRange {
+		t.Errorf("error mismatch on wide range: have %v, want %v", err, errInvalidBlockRange)
+	}
+	if _, err := engine.ValidatorParticipation(chain, 5, 11); err != errUnknownBlock {
+		t.Errorf("error mismatch on missing block: have %v, want %v", err, errUnknownBlock)
+	}
+}
+
+func TestRecoverProposedBlocks(t *testing.T) {
+	chain, engine := newBlockChain(4)
+	config := *engine.config
+	config.PersistProposedBlocks = true
+	engine.config = &config
+
+	// Crash after persisting a proposal, before it got committed
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	stale := makeBlockWithoutSeal(chain, engine, block)
+	if err := engine.persistProposedBlock(stale); err != nil {
+		t.Fatalf("failed to persist proposed block: %v", err)
+	}
+	if err := engine.persistProposedBlock(block); err != nil {
+		t.Fatalf("failed to persist proposed block: %v", err)
+	}
+	engine.Stop()
+
+	restarted := New(&config, engine.privateKey, engine.db).(*backend)
+	sub := restarted.EventMux().Subscribe(istanbul.RequestEvent{})
+	defer sub.Unsubscribe()
+	if err := restarted.Start(chain, chain.CurrentBlock); err != nil {
+		t.Fatalf("failed to restart engine: %v", err)
+	}
+	defer restarted.Stop()
+
+	select {
+	case ev := <-sub.Chan():
+		if proposal := ev.Data.(istanbul.RequestEvent).Proposal; proposal.Hash() != block.Hash() {
+			t.Errorf("recovered proposal mismatch: have %x, want %x", proposal.Hash(), block.Hash())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("proposed block not recovered")
+	}
+	// Only the block extending the head is kept until committed
+	if hashes := loadProposedIndex(restarted.db); !reflect.DeepEqual(hashes, []common.Hash{block.Hash()}) {
+		t.Errorf("persisted proposals mismatch: have %x, want %x", hashes, block.Hash())
+	}
+	if _, err := loadProposedBlock(restarted.db, stale.Hash()); err == nil {
+		t.Errorf("stale proposal not dropped")
+	}
+	restarted.deleteProposedBlock(block.Hash())
+	if hashes := loadProposedIndex(restarted.db); len(hashes) != 0 {
+		t.Errorf("committed proposal still persisted: %x", hashes)
+	}
+}
+
+func TestGetProposerForBlock(t *testing.T) {
+	_, engine := newBlockChain(1)
+
+	accounts := newTesterAccountPool()
+	names := make(map[common.Address]string)
+	addrs := make([]common.Address, 4)
+	for i, name := range []string{"A", "B", "C", "D"} {
+		addrs[i] = accounts.address(name)
+		names[addrs[i]] = name
+	}
+	extra, _ := rlp.EncodeToBytes(&types.IstanbulExtra{
+		Validators:    []common.Address{},
+		Seal:          []byte{},
+		CommittedSeal: [][]byte{},
+	})
+	extra = append(bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity), extra...)
+
+	proposer := func(last common.Address) common.Address {
+		valSet := validator.NewSet(addrs, istanbul.RoundRobin)
+		valSet.CalcProposer(last, 0)
+		return valSet.GetProposer().Address()
+	}
+	// Every block is sealed by its expected proposer
+	newChain := func(length int64, offset int64) headerChain {
+		chain := headerChain{{Number: big.NewInt(0), Time: big.NewInt(offset), Extra: common.CopyBytes(extra)}}
+		last := common.Address{}
+		for number := int64(1); number < length; number++ {
+			header := &types.Header{
+				ParentHash: chain[number-1].Hash(),
+				Number:     big.NewInt(number),
+				Time:       big.NewInt(offset + number),
+				MixDigest:  types.IstanbulDigest,
+				Extra:      common.CopyBytes(extra),
+			}
+			last = proposer(last)
+			accounts.sign(header, names[last])
+			chain = append(chain, header)
+		}
+		return chain
+	}
+	chain := newChain(5, 0)
+	for _, header := range chain {
+		snap := newSnapshot(header.Number.Uint64(), header.Hash(), validator.NewSet(addrs, istanbul.RoundRobin))
+		engine.recents.Add(header.Hash(), snap)
+	}
+	api := &API{chain: chain, istanbul: engine}
+
+	// Past blocks and the current block
+	for number := uint64(1); number <= 4; number++ {
+		have, err := api.GetProposerForBlock(number)
+		if err != nil {
+			t.Fatalf("block %d: failed to get proposer: %v", number, err)
+		}
+		if want, _ := engine.Author(chain[number]); have != want {
+			t.Errorf("block %d: proposer mismatch: have %x, want %x", number, have, want)
+		}
+	}
+	// Future block
+	if _, err := api.GetProposerForBlock(5); err != consensus.ErrFutureBlock {
+		t.Errorf("future block error mismatch: have %v, want %v", err, consensus.ErrFutureBlock)
+	}
+	// Broken ancestry without snapshots
+	broken := newChain(5, 100)
+	broken[3].ParentHash = common.Hash{0x01}
+	api = &API{chain: broken, istanbul: engine}
+	_, err := api.GetProposerForBlock(4)
+	if !errors.Is(err, consensus.ErrUnknownAncestor) || !strings.Contains(err.Error(), "missing header 2") {
+		t.Errorf("missing snapshot error mismatch: have %v, want missing header 2", err)
+	}
+}
+
+func TestPurgeSnapshotCache(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	block := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	snap, err := engine.snapshot(chain, block.NumberU64(), block.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve snapshot: %v", err)
+	}
+	if _, err := engine.Author(block.Header()); err != nil {
+		t.Fatalf("failed to recover author: %v", err)
+	}
+	engine.PurgeSnapshotCache()
+
+	if n := engine.recents.Len(); n != 0 {
+		t.Errorf("snapshots left in cache: %d", n)
+	}
+	if n := recentAddresses.Len() + recentPubkeys.Len(); n != 0 {
+		t.Errorf("header entries left in caches: %d", n)
+	}
+	// The snapshot is derived afresh
+	rebuilt, err := engine.snapshot(chain, block.NumberU64(), block.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to rebuild snapshot: %v", err)
+	}
+	if !reflect.DeepEqual(rebuilt.validators(), snap.validators()) {
+		t.Errorf("rebuilt validators mismatch: have %v, want %v", rebuilt.validators(), snap.validators())
+	}
+}
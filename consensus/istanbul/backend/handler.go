@@ -138,6 +138,100 @@ func (sb *backend) NewChainHead() error {
 	if !sb.coreStarted {
 		return istanbul.ErrStoppedEngine
 	}
+	head := sb.currentBlock()
+	sb.checkReorg(head)
+	sb.checkValidatorSetChange(head)
 	go sb.istanbulEventMux.Post(istanbul.FinalCommittedEvent{})
 	return nil
 }
+
+// checkReorg records head as the latest chain head and posts a ReorgEvent if it
+// does not descend from the previous one.
+func (sb *backend) checkReorg(head *types.Block) {
+	sb.headMu.Lock()
+	old := sb.lastHead
+	sb.lastHead = head
+	sb.headMu.Unlock()
+
+	if old == nil || head == nil || !sb.isReorg(old, head) {
+		return
+	}
+	oldProposer, err := sb.Author(old.Header())
+	if err != nil {
+		sb.logger.Debug("Failed to recover proposer of old head", "hash", old.Hash(), "err", err)
+	}
+	newProposer, err := sb.Author(head.Header())
+	if err != nil {
+		sb.logger.Debug("Failed to recover proposer of new head", "hash", head.Hash(), "err", err)
+	}
+	sb.logger.Info("Chain reorganization", "oldNumber", old.NumberU64(), "oldHash", old.Hash(),
+		"newNumber", head.NumberU64(), "newHash", head.Hash(), "oldProposer", oldProposer, "newProposer", newProposer)
+
+	go sb.reorgFeed.Send(consensus.ReorgEvent{
+		OldHead:     old,
+		NewHead:     head,
+		OldProposer: oldProposer,
+		NewProposer: newProposer,
+	})
+}
+
+// markValidatorSetChange records that Finalize saw the block at number change
+// the validator set. The change is announced once the block becomes the head.
+func (sb *backend) markValidatorSetChange(number uint64) {
+	sb.headMu.Lock()
+	defer sb.headMu.Unlock()
+
+	sb.valSetChange = number
+}
+
+// checkValidatorSetChange posts a ValidatorSetEvent if head is the block last
+// seen changing the validator set by Finalize. Changes of blocks which did not
+// make it to the head are forgotten once the chain moves past them.
+func (sb *backend) checkValidatorSetChange(head *types.Block) {
+	if head == nil {
+		return
+	}
+	sb.headMu.Lock()
+	number := sb.valSetChange
+	if number <= head.NumberU64() {
+		sb.valSetChange = 0
+	}
+	sb.headMu.Unlock()
+
+	if number == 0 || number != head.NumberU64() {
+		return
+	}
+	snap, err := sb.snapshot(sb.chain, number, head.Hash(), nil)
+	if err != nil {
+		sb.logger.Debug("Failed to retrieve changed validator set", "number", number, "hash", head.Hash(), "err", err)
+		return
+	}
+	validators := snap.validators()
+	sb.logger.Info("Validator set changed", "number", number, "validators", len(validators))
+
+	_, local := snap.ValSet.GetByAddress(sb.address)
+	go sb.validatorSetFeed.Send(consensus.ValidatorSetEvent{
+		Number:     number,
+		Validators: validators,
+		Local:      local != nil,
+	})
+}
+
+// isReorg reports whether head is not a descendant of old. Heads with unknown
+// ancestry are not reported.
+func (sb *backend) isReorg(old, head *types.Block) bool {
+	if head.Hash() == old.Hash() {
+		return false
+	}
+	header := head.Header()
+	for header.Number.Uint64() > old.NumberU64() {
+		if header.ParentHash == old.Hash() {
+			return false
+		}
+		header = sb.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+		if header == nil {
+			return false
+		}
+	}
+	return true
+}
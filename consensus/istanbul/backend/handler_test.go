@@ -18,15 +18,20 @@ package backend
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"io/ioutil"
 	"math/big"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Venachain/Venachain/core/types"
 	lru "github.com/hashicorp/golang-lru"
 
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/consensus"
 	"github.com/Venachain/Venachain/consensus/istanbul"
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/p2p"
 	"github.com/Venachain/Venachain/rlp"
 )
@@ -38,7 +43,7 @@ func TestIstanbulMessage(t *testing.T) {
 	data := []byte("data1")
 	hash := istanbul.RLPHash(data)
 	msg := makeMsg(istanbulMsg, data)
-	addr := common.StringToAddress("address")
+	addr := common.BytesToAddress([]byte("address"))
 
 	// 1. this message should not be in cache
 	// for peers
@@ -78,7 +83,7 @@ func makeMsg(msgcode uint64, data interface{}) p2p.Msg {
 
 func TestHandleNewBlockMessage_whenTypical(t *testing.T) {
 	_, backend := newBlockChain(1)
-	arbitraryAddress := common.StringToAddress("arbitrary")
+	arbitraryAddress := common.BytesToAddress([]byte("arbitrary"))
 	arbitraryBlock, arbitraryP2PMessage := buildArbitraryP2PNewBlockMessage(t, false)
 	postAndWait(backend, arbitraryBlock, t)
 
@@ -97,14 +102,14 @@ func TestHandleNewBlockMessage_whenTypical(t *testing.T) {
 
 func TestHandleNewBlockMessage_whenNotAProposedBlock(t *testing.T) {
 	_, backend := newBlockChain(1)
-	arbitraryAddress := common.StringToAddress("arbitrary")
+	arbitraryAddress := common.BytesToAddress([]byte("arbitrary"))
 	_, arbitraryP2PMessage := buildArbitraryP2PNewBlockMessage(t, false)
 	postAndWait(backend, types.NewBlock(&types.Header{
 		Number:    big.NewInt(1),
-		Root:      common.StringToHash("someroot"),
+		Root:      common.BytesToHash([]byte("someroot")),
 		GasLimit:  1,
 		MixDigest: types.IstanbulDigest,
-	}, nil, nil, nil), t)
+	}, nil, nil), t)
 
 	handled, err := backend.HandleMsg(arbitraryAddress, arbitraryP2PMessage)
 
@@ -121,13 +126,13 @@ func TestHandleNewBlockMessage_whenNotAProposedBlock(t *testing.T) {
 
 func TestHandleNewBlockMessage_whenFailToDecode(t *testing.T) {
 	_, backend := newBlockChain(1)
-	arbitraryAddress := common.StringToAddress("arbitrary")
+	arbitraryAddress := common.BytesToAddress([]byte("arbitrary"))
 	_, arbitraryP2PMessage := buildArbitraryP2PNewBlockMessage(t, true)
 	postAndWait(backend, types.NewBlock(&types.Header{
 		Number:    big.NewInt(1),
 		GasLimit:  1,
 		MixDigest: types.IstanbulDigest,
-	}, nil, nil, nil), t)
+	}, nil, nil), t)
 
 	handled, err := backend.HandleMsg(arbitraryAddress, arbitraryP2PMessage)
 
@@ -166,8 +171,8 @@ func buildArbitraryP2PNewBlockMessage(t *testing.T, invalidMsg bool) (*types.Blo
 		Number:    big.NewInt(1),
 		GasLimit:  0,
 		MixDigest: types.IstanbulDigest,
-	}, nil, nil, nil)
-	request := []interface{}{&arbitraryBlock, big.NewInt(1)}
+	}, nil, nil)
+	request := []interface{}{&arbitraryBlock}
 	if invalidMsg {
 		request = []interface{}{"invalid msg"}
 	}
@@ -182,3 +187,85 @@ func buildArbitraryP2PNewBlockMessage(t *testing.T, invalidMsg bool) (*types.Blo
 	arbitraryP2PMessage := p2p.Msg{Code: 0x07, Size: uint32(size), Payload: bytes.NewReader(payload)}
 	return arbitraryBlock, arbitraryP2PMessage
 }
+
+func TestNewChainHeadReorg(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	genesis := chain.Genesis()
+
+	keyA, _ := crypto.GenerateKey()
+	keyB, _ := crypto.GenerateKey()
+	seal := func(key *ecdsa.PrivateKey, header *types.Header) *types.Block {
+		engine.privateKey = key
+		block, err := engine.updateBlock(nil, types.NewBlockWithHeader(header))
+		if err != nil {
+			t.Fatalf("failed to seal block: %v", err)
+		}
+		return block
+	}
+	// Two competing blocks on top of genesis, sealed by different proposers
+	blockA := seal(keyA, makeHeader(genesis, engine.config))
+	headerB := makeHeader(genesis, engine.config)
+	headerB.Time = new(big.Int).Add(headerB.Time, common.Big1)
+	blockB := seal(keyB, headerB)
+	blockC := seal(keyB, makeHeader(blockB, engine.config))
+
+	// The blocks are not part of the chain, seed their validators for the core
+	genesisSnap, err := engine.snapshot(chain, 0, genesis.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve genesis snapshot: %v", err)
+	}
+	for _, block := range []*types.Block{blockA, blockB, blockC} {
+		snap := genesisSnap.copy()
+		snap.Number, snap.Hash = block.NumberU64(), block.Hash()
+		engine.recents.Add(snap.Hash, snap)
+	}
+
+	events := make(chan consensus.ReorgEvent, 1)
+	sub := engine.SubscribeReorgEvents(events)
+	defer sub.Unsubscribe()
+
+	var (
+		head   = blockA
+		headMu sync.Mutex
+	)
+	setHead := func(block *types.Block) {
+		headMu.Lock()
+		defer headMu.Unlock()
+		head = block
+	}
+	engine.currentBlock = func() *types.Block {
+		headMu.Lock()
+		defer headMu.Unlock()
+		return head
+	}
+	if err := engine.NewChainHead(); err != nil {
+		t.Fatalf("new chain head failed: %v", err)
+	}
+
+	// Replacing A by its sibling B is a one-block reorg
+	setHead(blockB)
+	engine.NewChainHead()
+	select {
+	case ev := <-events:
+		if ev.OldHead.Hash() != blockA.Hash() || ev.NewHead.Hash() != blockB.Hash() {
+			t.Errorf("head mismatch: have %x -> %x, want %x -> %x", ev.OldHead.Hash(), ev.NewHead.Hash(), blockA.Hash(), blockB.Hash())
+		}
+		if want := crypto.PubkeyToAddress(keyA.PublicKey); ev.OldProposer != want {
+			t.Errorf("old proposer mismatch: have %x, want %x", ev.OldProposer, want)
+		}
+		if want := crypto.PubkeyToAddress(keyB.PublicKey); ev.NewProposer != want {
+			t.Errorf("new proposer mismatch: have %x, want %x", ev.NewProposer, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reorg event not received")
+	}
+
+	// Extending the head is not a reorg
+	setHead(blockC)
+	engine.NewChainHead()
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected reorg event: %x -> %x", ev.OldHead.Hash(), ev.NewHead.Hash())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/consensus/istanbul"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/rlp"
+)
+
+const (
+	dbKeyProposedPrefix = "proposed/"
+	dbKeyProposedIndex  = "istanbul-proposed"
+)
+
+// loadProposedIndex retrieves the hashes of the persisted proposed blocks.
+func loadProposedIndex(db ethdb.Database) []common.Hash {
+	blob, err := db.Get([]byte(dbKeyProposedIndex))
+	if err != nil {
+		return nil
+	}
+	var hashes []common.Hash
+	if err := rlp.DecodeBytes(blob, &hashes); err != nil {
+		return nil
+	}
+	return hashes
+}
+
+// storeProposedIndex records the hashes of the persisted proposed blocks.
+func storeProposedIndex(db ethdb.Database, hashes []common.Hash) error {
+	blob, err := rlp.EncodeToBytes(hashes)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(dbKeyProposedIndex), blob)
+}
+
+// loadProposedBlock retrieves a persisted proposed block.
+func loadProposedBlock(db ethdb.Database, hash common.Hash) (*types.Block, error) {
+	blob, err := db.Get(append([]byte(dbKeyProposedPrefix), hash[:]...))
+	if err != nil {
+		return nil, err
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(blob, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// persistProposedBlock writes a block about to be proposed to the database, so
+// it can be proposed again if the node crashes before it is committed. Blocks
+// of earlier heights are dropped.
+func (sb *backend) persistProposedBlock(block *types.Block) error {
+	blob, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+	if err := sb.db.Put(append([]byte(dbKeyProposedPrefix), block.Hash().Bytes()...), blob); err != nil {
+		return err
+	}
+	hashes := []common.Hash{block.Hash()}
+	for _, hash := range loadProposedIndex(sb.db) {
+		if hash == block.Hash() {
+			continue
+		}
+		if old, err := loadProposedBlock(sb.db, hash); err == nil && old.NumberU64() >= block.NumberU64() {
+			hashes = append(hashes, hash)
+			continue
+		}
+		sb.db.Delete(append([]byte(dbKeyProposedPrefix), hash[:]...))
+	}
+	return storeProposedIndex(sb.db, hashes)
+}
+
+// deleteProposedBlock removes a persisted proposed block once committed.
+func (sb *backend) deleteProposedBlock(hash common.Hash) {
+	hashes := loadProposedIndex(sb.db)
+	for i := range hashes {
+		if hashes[i] == hash {
+			sb.db.Delete(append([]byte(dbKeyProposedPrefix), hash[:]...))
+			storeProposedIndex(sb.db, append(hashes[:i], hashes[i+1:]...))
+			return
+		}
+	}
+}
+
+// recoverProposedBlocks proposes again the persisted blocks extending the
+// current head, which were lost by a crash before being committed. The others
+// are outdated and dropped.
+func (sb *backend) recoverProposedBlocks() {
+	head := sb.currentBlock()
+
+	var hashes []common.Hash
+	for _, hash := range loadProposedIndex(sb.db) {
+		block, err := loadProposedBlock(sb.db, hash)
+		if err != nil {
+			continue
+		}
+		if block.ParentHash() != head.Hash() {
+			sb.db.Delete(append([]byte(dbKeyProposedPrefix), hash[:]...))
+			continue
+		}
+		hashes = append(hashes, hash)
+
+		sb.logger.Info("Recovering proposed block", "number", block.NumberU64(), "hash", hash)
+		go sb.EventMux().Post(istanbul.RequestEvent{
+			Proposal: block,
+		})
+	}
+	if err := storeProposedIndex(sb.db, hashes); err != nil {
+		sb.logger.Error("Failed to store proposed blocks", "err", err)
+	}
+}
@@ -18,7 +18,9 @@ package backend
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"math/big"
 
 	"github.com/Venachain/Venachain/params"
 
@@ -32,7 +34,8 @@ import (
 )
 
 const (
-	dbKeySnapshotPrefix = "istanbul-snapshot"
+	dbKeySnapshotPrefix     = "istanbul-snapshot"
+	dbKeyCheckpointInterval = "istanbul-checkpoint-interval"
 )
 
 // Vote represents a single vote that an authorized validator made to modify the
@@ -60,6 +63,8 @@ type Snapshot struct {
 	Votes  []*Vote                  // List of votes cast in chronological order
 	Tally  map[common.Address]Tally // Current vote tally to avoid recalculating
 	ValSet istanbul.ValidatorSet    // Set of authorized validators at this moment
+
+	PendingRemoval map[common.Address]uint64 // Validators pending removal, mapped to the block they leave the set
 }
 
 // newSnapshot create a new snapshot with the specified startup parameters. This
@@ -72,6 +77,8 @@ func newSnapshot(number uint64, hash common.Hash, valSet istanbul.ValidatorSet)
 		Hash:   hash,
 		ValSet: valSet,
 		Tally:  make(map[common.Address]Tally),
+
+		PendingRemoval: make(map[common.Address]uint64),
 	}
 	return snap
 }
@@ -100,6 +107,23 @@ func (s *Snapshot) store(db ethdb.Database) error {
 	return db.Put(append([]byte(dbKeySnapshotPrefix), s.Hash[:]...), blob)
 }
 
+// loadCheckpointInterval retrieves the checkpoint interval the snapshots in the
+// database were written with, or 0 if none was recorded.
+func loadCheckpointInterval(db ethdb.Database) uint64 {
+	blob, err := db.Get([]byte(dbKeyCheckpointInterval))
+	if err != nil || len(blob) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(blob)
+}
+
+// storeCheckpointInterval records the checkpoint interval used for the snapshots.
+func storeCheckpointInterval(db ethdb.Database, interval uint64) error {
+	blob := make([]byte, 8)
+	binary.BigEndian.PutUint64(blob, interval)
+	return db.Put([]byte(dbKeyCheckpointInterval), blob)
+}
+
 // copy creates a deep copy of the snapshot, though not the individual votes.
 func (s *Snapshot) copy() *Snapshot {
 	cpy := &Snapshot{
@@ -109,11 +133,16 @@ func (s *Snapshot) copy() *Snapshot {
 		ValSet: s.ValSet.Copy(),
 		Votes:  make([]*Vote, len(s.Votes)),
 		Tally:  make(map[common.Address]Tally),
+
+		PendingRemoval: make(map[common.Address]uint64, len(s.PendingRemoval)),
 	}
 
 	for address, tally := range s.Tally {
 		cpy.Tally[address] = tally
 	}
+	for address, removal := range s.PendingRemoval {
+		cpy.PendingRemoval[address] = removal
+	}
 	copy(cpy.Votes, s.Votes)
 
 	return cpy
@@ -181,30 +210,149 @@ func (s *Snapshot) apply(chain consensus.ChainReader, sb *backend, headers []*ty
 	// Iterate through the headers and create a new snapshot
 	snap := s.copy()
 
-	validatorNodesList, _ := getConsensusNodesList(chain, sb, snap.Number+uint64(len(headers)))
-	if len(validatorNodesList) == 0 {
-		snap.Number += uint64(len(headers))
+	policy := sb.snapshotPolicy(snap.ValSet.Policy(), headers)
+	number := snap.Number + uint64(len(headers))
+
+	var (
+		addrs []common.Address
+		err   error
+	)
+	if (*params.IstanbulConfig)(sb.config).IsRemovalGrace(new(big.Int).SetUint64(number)) {
+		addrs, err = snap.graceValidators(chain, sb, number)
+	} else {
+		addrs, err = electedValidators(chain, sb, number, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		if policy != snap.ValSet.Policy() {
+			snap.ValSet = validator.NewSet(snap.validators(), policy)
+			snap.ValSet.SetPendingRemoval(snap.pendingRemovals())
+		}
+		snap.Number = number
 		snap.Hash = headers[len(headers)-1].Hash()
 		return snap, nil
 	}
 
+	newValSet := validator.NewSet(addrs, policy)
+	newValSet.SetPendingRemoval(snap.pendingRemovals())
+	snap.ValSet = newValSet
+
+	snap.Number = number
+	snap.Hash = headers[len(headers)-1].Hash()
+
+	return snap, nil
+}
+
+// graceValidators returns the validator set members at the given block, where
+// validators dropped from the consensus node list stay until a grace period
+// boundary. The members only depend on the elections at the block and at the
+// last two boundaries since the fork, never on how the headers are batched,
+// and a missing state fails instead of keeping the current members.
+func (s *Snapshot) graceValidators(chain consensus.ChainReader, sb *backend, number uint64) ([]common.Address, error) {
+	var (
+		grace    = sb.config.RemovalGracePeriod
+		fork     = sb.config.RemovalGraceBlock.Uint64()
+		boundary = number - number%grace
+	)
+	elected, err := electedValidators(chain, sb, number, true)
+	if err != nil {
+		return nil, err
+	}
+	// Boundaries before the fork are ignored, not to bring back validators
+	// removed before it
+	var window, previous []common.Address
+	if boundary == number {
+		window = elected
+	} else if boundary >= fork {
+		if window, err = electedValidators(chain, sb, boundary, true); err != nil {
+			return nil, err
+		}
+	}
+	if boundary >= grace && boundary-grace >= fork {
+		if previous, err = electedValidators(chain, sb, boundary-grace, true); err != nil {
+			return nil, err
+		}
+	}
+	return s.updateRemovals(elected, window, previous, boundary, grace), nil
+}
+
+// electedValidators returns the addresses of the consensus nodes listed at the
+// given block, or nil if the list is empty. Unless strict, a missing state is
+// taken for an empty list.
+func electedValidators(chain consensus.ChainReader, sb *backend, number uint64, strict bool) ([]common.Address, error) {
+	validatorNodesList, err := getConsensusNodesList(chain, sb, number)
+	if err != nil && strict {
+		return nil, err
+	}
+	if len(validatorNodesList) == 0 {
+		return nil, nil
+	}
 	addrs := make([]common.Address, len(validatorNodesList))
 	for index, valNode := range validatorNodesList {
 		pub, err := valNode.Pubkey()
 		if err != nil {
 			return nil, err
 		}
-		addr := crypto.PubkeyToAddress(*pub)
-		addrs[index] = addr
+		addrs[index] = crypto.PubkeyToAddress(*pub)
+	}
+	return addrs, nil
+}
 
+// updateRemovals returns the validator set members given the validators elected
+// at a block and at the last two grace period boundaries before it, window at
+// boundary and previous one grace period earlier. Validators elected at either
+// boundary but no longer at the block stay in the set, pending removal, until
+// the boundary after the last one they were elected at, so at least grace
+// blocks. An empty election returns nil, leaving the snapshot unchanged.
+func (s *Snapshot) updateRemovals(elected, window, previous []common.Address, boundary, grace uint64) []common.Address {
+	if len(elected) == 0 {
+		return nil
 	}
-	newValSet := validator.NewSet(addrs, snap.ValSet.Policy())
-	snap.ValSet = newValSet
+	s.PendingRemoval = make(map[common.Address]uint64)
+	isMember := make(map[common.Address]bool, len(elected))
+	members := make([]common.Address, 0, len(elected)+len(window)+len(previous))
+	for _, addr := range elected {
+		isMember[addr] = true
+		members = append(members, addr)
+	}
+	for _, addr := range window {
+		if !isMember[addr] {
+			isMember[addr] = true
+			members = append(members, addr)
+			s.PendingRemoval[addr] = boundary + 2*grace
+		}
+	}
+	for _, addr := range previous {
+		if !isMember[addr] {
+			isMember[addr] = true
+			members = append(members, addr)
+			s.PendingRemoval[addr] = boundary + grace
+		}
+	}
+	return members
+}
 
-	snap.Number += uint64(len(headers))
-	snap.Hash = headers[len(headers)-1].Hash()
+// pendingRemovals returns the validators pending removal.
+func (s *Snapshot) pendingRemovals() []common.Address {
+	addrs := make([]common.Address, 0, len(s.PendingRemoval))
+	for addr := range s.PendingRemoval {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
 
-	return snap, nil
+// snapshotPolicy returns the proposer policy of a validator set applying headers
+// to one using current. The policy set by SetProposerPolicy is only adopted when
+// the headers cross an epoch boundary.
+func (sb *backend) snapshotPolicy(current params.ProposerPolicy, headers []*types.Header) params.ProposerPolicy {
+	for _, header := range headers {
+		if header.Number.Uint64()%sb.checkpointInterval == 0 {
+			return sb.nextProposerPolicy()
+		}
+	}
+	return current
 }
 
 // validators retrieves the list of authorized validators in ascending order.
@@ -223,6 +371,31 @@ func (s *Snapshot) validators() []common.Address {
 	return validators
 }
 
+// Diff returns the validators present in other but not in s as added, and the
+// validators present in s but not in other as removed. Both lists are sorted.
+func (s *Snapshot) Diff(other *Snapshot) (added, removed []common.Address) {
+	before := s.validators()
+	after := other.validators()
+
+	seen := make(map[common.Address]struct{}, len(before))
+	for _, addr := range before {
+		seen[addr] = struct{}{}
+	}
+	for _, addr := range after {
+		if _, ok := seen[addr]; ok {
+			delete(seen, addr)
+			continue
+		}
+		added = append(added, addr)
+	}
+	for _, addr := range before {
+		if _, ok := seen[addr]; ok {
+			removed = append(removed, addr)
+		}
+	}
+	return added, removed
+}
+
 type snapshotJSON struct {
 	Epoch  uint64                   `json:"epoch"`
 	Number uint64                   `json:"number"`
@@ -233,6 +406,8 @@ type snapshotJSON struct {
 	// for validator set
 	Validators []common.Address      `json:"validators"`
 	Policy     params.ProposerPolicy `json:"policy"`
+
+	PendingRemoval map[common.Address]uint64 `json:"pendingRemoval,omitempty"`
 }
 
 func (s *Snapshot) toJSONStruct() *snapshotJSON {
@@ -244,6 +419,8 @@ func (s *Snapshot) toJSONStruct() *snapshotJSON {
 		Tally:      s.Tally,
 		Validators: s.validators(),
 		Policy:     s.ValSet.Policy(),
+
+		PendingRemoval: s.PendingRemoval,
 	}
 }
 
@@ -260,6 +437,11 @@ func (s *Snapshot) UnmarshalJSON(b []byte) error {
 	s.Votes = j.Votes
 	s.Tally = j.Tally
 	s.ValSet = validator.NewSet(j.Validators, j.Policy)
+	s.PendingRemoval = j.PendingRemoval
+	if s.PendingRemoval == nil {
+		s.PendingRemoval = make(map[common.Address]uint64)
+	}
+	s.ValSet.SetPendingRemoval(s.pendingRemovals())
 	return nil
 }
 
@@ -17,7 +17,6 @@
 package backend
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"math/big"
 	"reflect"
@@ -26,19 +25,13 @@ import (
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/consensus/istanbul"
 	"github.com/Venachain/Venachain/consensus/istanbul/validator"
-	"github.com/Venachain/Venachain/core"
 	"github.com/Venachain/Venachain/core/types"
-	"github.com/Venachain/Venachain/core/vm"
 	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/p2p/discover"
+	"github.com/Venachain/Venachain/params"
 )
 
-type testerVote struct {
-	validator string
-	voted     string
-	auth      bool
-}
-
 // testerAccountPool is a pool to maintain currently active tester accounts,
 // mapped from textual names used in the tests below to actual Ethereum private
 // keys capable of signing transactions.
@@ -73,356 +66,27 @@ func (ap *testerAccountPool) address(account string) common.Address {
 	return crypto.PubkeyToAddress(ap.accounts[account].PublicKey)
 }
 
-// Tests that voting is evaluated correctly for various simple and complex scenarios.
-func TestVoting(t *testing.T) {
-	// Define the various voting scenarios to test
-	tests := []struct {
-		epoch      uint64
-		validators []string
-		votes      []testerVote
-		results    []string
-	}{
-		{
-			// Single validator, no votes cast
-			validators: []string{"A"},
-			votes:      []testerVote{{validator: "A"}},
-			results:    []string{"A"},
-		}, {
-			// Single validator, voting to add two others (only accept first, second needs 2 votes)
-			validators: []string{"A"},
-			votes: []testerVote{
-				{validator: "A", voted: "B", auth: true},
-				{validator: "B"},
-				{validator: "A", voted: "C", auth: true},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Two validators, voting to add three others (only accept first two, third needs 3 votes already)
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: true},
-				{validator: "B", voted: "C", auth: true},
-				{validator: "A", voted: "D", auth: true},
-				{validator: "B", voted: "D", auth: true},
-				{validator: "C"},
-				{validator: "A", voted: "E", auth: true},
-				{validator: "B", voted: "E", auth: true},
-			},
-			results: []string{"A", "B", "C", "D"},
-		}, {
-			// Single validator, dropping itself (weird, but one less cornercase by explicitly allowing this)
-			validators: []string{"A"},
-			votes: []testerVote{
-				{validator: "A", voted: "A", auth: false},
-			},
-			results: []string{},
-		}, {
-			// Two validators, actually needing mutual consent to drop either of them (not fulfilled)
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "B", auth: false},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Two validators, actually needing mutual consent to drop either of them (fulfilled)
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "B", auth: false},
-				{validator: "B", voted: "B", auth: false},
-			},
-			results: []string{"A"},
-		}, {
-			// Three validators, two of them deciding to drop the third
-			validators: []string{"A", "B", "C"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B", voted: "C", auth: false},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Four validators, consensus of two not being enough to drop anyone
-			validators: []string{"A", "B", "C", "D"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B", voted: "C", auth: false},
-			},
-			results: []string{"A", "B", "C", "D"},
-		}, {
-			// Four validators, consensus of three already being enough to drop someone
-			validators: []string{"A", "B", "C", "D"},
-			votes: []testerVote{
-				{validator: "A", voted: "D", auth: false},
-				{validator: "B", voted: "D", auth: false},
-				{validator: "C", voted: "D", auth: false},
-			},
-			results: []string{"A", "B", "C"},
-		}, {
-			// Authorizations are counted once per validator per target
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: true},
-				{validator: "B"},
-				{validator: "A", voted: "C", auth: true},
-				{validator: "B"},
-				{validator: "A", voted: "C", auth: true},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Authorizing multiple accounts concurrently is permitted
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: true},
-				{validator: "B"},
-				{validator: "A", voted: "D", auth: true},
-				{validator: "B"},
-				{validator: "A"},
-				{validator: "B", voted: "D", auth: true},
-				{validator: "A"},
-				{validator: "B", voted: "C", auth: true},
-			},
-			results: []string{"A", "B", "C", "D"},
-		}, {
-			// Deauthorizations are counted once per validator per target
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "B", auth: false},
-				{validator: "B"},
-				{validator: "A", voted: "B", auth: false},
-				{validator: "B"},
-				{validator: "A", voted: "B", auth: false},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Deauthorizing multiple accounts concurrently is permitted
-			validators: []string{"A", "B", "C", "D"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B"},
-				{validator: "C"},
-				{validator: "A", voted: "D", auth: false},
-				{validator: "B"},
-				{validator: "C"},
-				{validator: "A"},
-				{validator: "B", voted: "D", auth: false},
-				{validator: "C", voted: "D", auth: false},
-				{validator: "A"},
-				{validator: "B", voted: "C", auth: false},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Votes from deauthorized validators are discarded immediately (deauth votes)
-			validators: []string{"A", "B", "C"},
-			votes: []testerVote{
-				{validator: "C", voted: "B", auth: false},
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B", voted: "C", auth: false},
-				{validator: "A", voted: "B", auth: false},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Votes from deauthorized validators are discarded immediately (auth votes)
-			validators: []string{"A", "B", "C"},
-			votes: []testerVote{
-				{validator: "C", voted: "B", auth: false},
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B", voted: "C", auth: false},
-				{validator: "A", voted: "B", auth: false},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Cascading changes are not allowed, only the the account being voted on may change
-			validators: []string{"A", "B", "C", "D"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B"},
-				{validator: "C"},
-				{validator: "A", voted: "D", auth: false},
-				{validator: "B", voted: "C", auth: false},
-				{validator: "C"},
-				{validator: "A"},
-				{validator: "B", voted: "D", auth: false},
-				{validator: "C", voted: "D", auth: false},
-			},
-			results: []string{"A", "B", "C"},
-		}, {
-			// Changes reaching consensus out of bounds (via a deauth) execute on touch
-			validators: []string{"A", "B", "C", "D"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B"},
-				{validator: "C"},
-				{validator: "A", voted: "D", auth: false},
-				{validator: "B", voted: "C", auth: false},
-				{validator: "C"},
-				{validator: "A"},
-				{validator: "B", voted: "D", auth: false},
-				{validator: "C", voted: "D", auth: false},
-				{validator: "A"},
-				{validator: "C", voted: "C", auth: true},
-			},
-			results: []string{"A", "B"},
-		}, {
-			// Changes reaching consensus out of bounds (via a deauth) may go out of consensus on first touch
-			validators: []string{"A", "B", "C", "D"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: false},
-				{validator: "B"},
-				{validator: "C"},
-				{validator: "A", voted: "D", auth: false},
-				{validator: "B", voted: "C", auth: false},
-				{validator: "C"},
-				{validator: "A"},
-				{validator: "B", voted: "D", auth: false},
-				{validator: "C", voted: "D", auth: false},
-				{validator: "A"},
-				{validator: "B", voted: "C", auth: true},
-			},
-			results: []string{"A", "B", "C"},
-		}, {
-			// Ensure that pending votes don't survive authorization status changes. This
-			// corner case can only appear if a validator is quickly added, remove and then
-			// readded (or the inverse), while one of the original voters dropped. If a
-			// past vote is left cached in the system somewhere, this will interfere with
-			// the final validator outcome.
-			validators: []string{"A", "B", "C", "D", "E"},
-			votes: []testerVote{
-				{validator: "A", voted: "F", auth: true}, // Authorize F, 3 votes needed
-				{validator: "B", voted: "F", auth: true},
-				{validator: "C", voted: "F", auth: true},
-				{validator: "D", voted: "F", auth: false}, // Deauthorize F, 4 votes needed (leave A's previous vote "unchanged")
-				{validator: "E", voted: "F", auth: false},
-				{validator: "B", voted: "F", auth: false},
-				{validator: "C", voted: "F", auth: false},
-				{validator: "D", voted: "F", auth: true}, // Almost authorize F, 2/3 votes needed
-				{validator: "E", voted: "F", auth: true},
-				{validator: "B", voted: "A", auth: false}, // Deauthorize A, 3 votes needed
-				{validator: "C", voted: "A", auth: false},
-				{validator: "D", voted: "A", auth: false},
-				{validator: "B", voted: "F", auth: true}, // Finish authorizing F, 3/3 votes needed
-			},
-			results: []string{"B", "C", "D", "E", "F"},
-		}, {
-			// Epoch transitions reset all votes to allow chain checkpointing
-			epoch:      3,
-			validators: []string{"A", "B"},
-			votes: []testerVote{
-				{validator: "A", voted: "C", auth: true},
-				{validator: "B"},
-				{validator: "A"}, // Checkpoint block, (don't vote here, it's validated outside of snapshots)
-				{validator: "B", voted: "C", auth: true},
-			},
-			results: []string{"A", "B"},
-		},
-	}
-	// Run through the scenarios and test them
-	for i, tt := range tests {
-		// Create the account pool and generate the initial set of validators
-		accounts := newTesterAccountPool()
-
-		validators := make([]common.Address, len(tt.validators))
-		for j, validator := range tt.validators {
-			validators[j] = accounts.address(validator)
-		}
-		for j := 0; j < len(validators); j++ {
-			for k := j + 1; k < len(validators); k++ {
-				if bytes.Compare(validators[j][:], validators[k][:]) > 0 {
-					validators[j], validators[k] = validators[k], validators[j]
-				}
-			}
-		}
-		// Create the genesis block with the initial set of validators
-		genesis := &core.Genesis{
-			Mixhash: types.IstanbulDigest,
-		}
-		b := genesis.ToBlock(nil)
-		extra, _ := prepareExtra(b.Header(), validators)
-		genesis.ExtraData = extra
-		// Create a pristine blockchain with the genesis injected
-		db := ethdb.NewMemDatabase()
-		genesis.Commit(db)
-
-		config := istanbul.DefaultConfig
-		if tt.epoch != 0 {
-			config.Epoch = tt.epoch
-		}
-		engine := New(config, accounts.accounts[tt.validators[0]], db).(*backend)
-		chain, err := core.NewBlockChain(db, nil, genesis.Config, engine, vm.Config{})
-
-		// Assemble a chain of headers from the cast votes
-		headers := make([]*types.Header, len(tt.votes))
-		for j, vote := range tt.votes {
-			headers[j] = &types.Header{
-				Number:    big.NewInt(int64(j) + 1),
-				Time:      big.NewInt(int64(j) * int64(config.BlockPeriod)),
-				Coinbase:  accounts.address(vote.voted),
-				MixDigest: types.IstanbulDigest,
-			}
-			extra, _ := prepareExtra(headers[j], validators)
-			headers[j].Extra = extra
-			if j > 0 {
-				headers[j].ParentHash = headers[j-1].Hash()
-			}
-			if vote.auth {
-				copy(headers[j].Nonce[:], nonceAuthVote)
-			}
-			copy(headers[j].Extra, genesis.ExtraData)
-			accounts.sign(headers[j], vote.validator)
-		}
-		// Pass all the headers through clique and ensure tallying succeeds
-		head := headers[len(headers)-1]
-
-		snap, err := engine.snapshot(chain, head.Number.Uint64(), head.Hash(), headers)
-		if err != nil {
-			t.Errorf("test %d: failed to create voting snapshot: %v", i, err)
-			continue
-		}
-		// Verify the final list of validators against the expected ones
-		validators = make([]common.Address, len(tt.results))
-		for j, validator := range tt.results {
-			validators[j] = accounts.address(validator)
-		}
-		for j := 0; j < len(validators); j++ {
-			for k := j + 1; k < len(validators); k++ {
-				if bytes.Compare(validators[j][:], validators[k][:]) > 0 {
-					validators[j], validators[k] = validators[k], validators[j]
-				}
-			}
-		}
-		result := snap.validators()
-		if len(result) != len(validators) {
-			t.Errorf("test %d: validators mismatch: have %x, want %x", i, result, validators)
-			continue
-		}
-		for j := 0; j < len(result); j++ {
-			if !bytes.Equal(result[j][:], validators[j][:]) {
-				t.Errorf("test %d, validator %d: validator mismatch: have %x, want %x", i, j, result[j], validators[j])
-			}
-		}
-	}
-}
-
 func TestSaveAndLoad(t *testing.T) {
 	snap := &Snapshot{
-		Epoch:  5,
 		Number: 10,
 		Hash:   common.HexToHash("1234567890"),
 		Votes: []*Vote{
 			{
-				Validator: common.StringToAddress("1234567891"),
+				Validator: common.BytesToAddress([]byte("1234567891")),
 				Block:     15,
-				Address:   common.StringToAddress("1234567892"),
+				Address:   common.BytesToAddress([]byte("1234567892")),
 				Authorize: false,
 			},
 		},
 		Tally: map[common.Address]Tally{
-			common.StringToAddress("1234567893"): Tally{
+			common.BytesToAddress([]byte("1234567893")): Tally{
 				Authorize: false,
 				Votes:     20,
 			},
 		},
 		ValSet: validator.NewSet([]common.Address{
-			common.StringToAddress("1234567894"),
-			common.StringToAddress("1234567895"),
+			common.BytesToAddress([]byte("1234567894")),
+			common.BytesToAddress([]byte("1234567895")),
 		}, istanbul.RoundRobin),
 	}
 	db := ethdb.NewMemDatabase()
@@ -431,13 +95,10 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Errorf("store snapshot failed: %v", err)
 	}
 
-	snap1, err := loadSnapshot(snap.Epoch, db, snap.Hash)
+	snap1, err := loadSnapshot(db, snap.Hash)
 	if err != nil {
 		t.Errorf("load snapshot failed: %v", err)
 	}
-	if snap.Epoch != snap1.Epoch {
-		t.Errorf("epoch mismatch: have %v, want %v", snap1.Epoch, snap.Epoch)
-	}
 	if snap.Hash != snap1.Hash {
 		t.Errorf("hash mismatch: have %v, want %v", snap1.Number, snap.Number)
 	}
@@ -451,3 +112,371 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Errorf("validator set mismatch: have %v, want %v", snap1.ValSet, snap.ValSet)
 	}
 }
+
+// newTestHeaderChain creates a chain of n headers on top of a genesis header.
+func newTestHeaderChain(n int) headerChain {
+	chain := headerChain{{Number: big.NewInt(0), MixDigest: types.IstanbulDigest}}
+	for number := 1; number <= n; number++ {
+		chain = append(chain, &types.Header{
+			ParentHash: chain[number-1].Hash(),
+			Number:     big.NewInt(int64(number)),
+			Time:       big.NewInt(int64(number)),
+			MixDigest:  types.IstanbulDigest,
+		})
+	}
+	return chain
+}
+
+// newTestCheckpointBackend creates a backend on db checkpointing at interval,
+// with key as the genesis validator.
+func newTestCheckpointBackend(db ethdb.Database, key *ecdsa.PrivateKey, interval uint64) *backend {
+	config := &params.IstanbulConfig{
+		CheckpointInterval: interval,
+		ProposerPolicy:     istanbul.RoundRobin,
+		FirstValidatorNode: discover.Node{ID: discover.PubkeyID(&key.PublicKey)},
+	}
+	return New(config, key, db).(*backend)
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	tests := []struct {
+		configured uint64
+		want       uint64
+	}{
+		{0, defaultCheckpointInterval},
+		{1, minCheckpointInterval},
+		{minCheckpointInterval, minCheckpointInterval},
+		{256, 256},
+	}
+	for i, tt := range tests {
+		config := &params.IstanbulConfig{CheckpointInterval: tt.configured}
+		b := New(config, nil, ethdb.NewMemDatabase()).(*backend)
+		if b.checkpointInterval != tt.want {
+			t.Errorf("test %d: checkpoint interval mismatch: have %d, want %d", i, b.checkpointInterval, tt.want)
+		}
+		for _, number := range []uint64{tt.want, 2 * tt.want} {
+			if !b.isCheckpoint(number) {
+				t.Errorf("test %d: block %d should be a checkpoint", i, number)
+			}
+		}
+		if b.isCheckpoint(tt.want + 1) {
+			t.Errorf("test %d: block %d should not be a checkpoint", i, tt.want+1)
+		}
+	}
+
+	// Snapshots must be persisted exactly at the configured interval
+	key, _ := crypto.GenerateKey()
+	db := ethdb.NewMemDatabase()
+	b := newTestCheckpointBackend(db, key, minCheckpointInterval)
+	chain := newTestHeaderChain(3*minCheckpointInterval + 5)
+	for _, header := range chain {
+		if _, err := b.snapshot(chain, header.Number.Uint64(), header.Hash(), nil); err != nil {
+			t.Fatalf("failed to create snapshot %d: %v", header.Number, err)
+		}
+	}
+	for _, header := range chain {
+		number := header.Number.Uint64()
+		_, err := loadSnapshot(db, header.Hash())
+		if stored, want := err == nil, number%minCheckpointInterval == 0; stored != want {
+			t.Errorf("block %d: snapshot stored mismatch: have %v, want %v", number, stored, want)
+		}
+	}
+}
+
+func TestCheckpointIntervalMigration(t *testing.T) {
+	chain, b := newBlockChain(1)
+	if have := loadCheckpointInterval(b.db); have != defaultCheckpointInterval {
+		t.Fatalf("stored checkpoint interval mismatch: have %d, want %d", have, defaultCheckpointInterval)
+	}
+
+	b.checkpointInterval = minCheckpointInterval
+	if err := b.migrateCheckpoints(chain); err != nil {
+		t.Fatalf("failed to migrate checkpoints: %v", err)
+	}
+	if have := loadCheckpointInterval(b.db); have != minCheckpointInterval {
+		t.Errorf("stored checkpoint interval mismatch: have %d, want %d", have, minCheckpointInterval)
+	}
+	// Old checkpoints must remain reachable until the new ones replace them
+	if !b.isCheckpoint(defaultCheckpointInterval) || !b.isCheckpoint(3*minCheckpointInterval) {
+		t.Errorf("legacy and configured checkpoints should both be recognised")
+	}
+	if b.isCheckpoint(minCheckpointInterval + 1) {
+		t.Errorf("block %d should not be a checkpoint", minCheckpointInterval+1)
+	}
+}
+
+func TestCheckpointIntervalMigrationSnapshots(t *testing.T) {
+	const legacy = 2 * minCheckpointInterval
+
+	// Checkpoint a chain at the legacy interval
+	key, _ := crypto.GenerateKey()
+	db := ethdb.NewMemDatabase()
+	old := newTestCheckpointBackend(db, key, legacy)
+	if err := storeCheckpointInterval(db, legacy); err != nil {
+		t.Fatalf("failed to store checkpoint interval: %v", err)
+	}
+	chain := newTestHeaderChain(2*legacy + minCheckpointInterval + 10)
+	for _, header := range chain {
+		if _, err := old.snapshot(chain, header.Number.Uint64(), header.Hash(), nil); err != nil {
+			t.Fatalf("failed to create snapshot %d: %v", header.Number, err)
+		}
+	}
+	// Mark the last legacy checkpoint to tell what the migration started from
+	marker := common.BytesToAddress([]byte("marker"))
+	checkpoint := newSnapshot(2*legacy, chain[2*legacy].Hash(), validator.NewSet([]common.Address{marker}, istanbul.RoundRobin))
+	if err := checkpoint.store(db); err != nil {
+		t.Fatalf("failed to store legacy checkpoint: %v", err)
+	}
+
+	// Restart with a smaller interval and migrate the checkpoints
+	b := newTestCheckpointBackend(db, key, minCheckpointInterval)
+	if err := b.migrateCheckpoints(chain); err != nil {
+		t.Fatalf("failed to migrate checkpoints: %v", err)
+	}
+	if b.legacyCheckpointInterval != legacy {
+		t.Errorf("legacy checkpoint interval mismatch: have %d, want %d", b.legacyCheckpointInterval, legacy)
+	}
+	if have := loadCheckpointInterval(db); have != minCheckpointInterval {
+		t.Errorf("stored checkpoint interval mismatch: have %d, want %d", have, minCheckpointInterval)
+	}
+	number := uint64(2*legacy + minCheckpointInterval)
+	snap, err := loadSnapshot(db, chain[number].Hash())
+	if err != nil {
+		t.Fatalf("re-checkpointed snapshot %d not stored: %v", number, err)
+	}
+	if snap.Number != number {
+		t.Errorf("re-checkpointed snapshot number mismatch: have %d, want %d", snap.Number, number)
+	}
+	if vals := snap.validators(); len(vals) != 1 || vals[0] != marker {
+		t.Errorf("re-checkpointed snapshot not derived from legacy checkpoint: have %v, want %v", vals, []common.Address{marker})
+	}
+	// Snapshots past the legacy checkpoint are rebuilt from it too
+	snap, err = b.snapshot(chain, 2*legacy+1, chain[2*legacy+1].Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to create snapshot %d: %v", 2*legacy+1, err)
+	}
+	if vals := snap.validators(); len(vals) != 1 || vals[0] != marker {
+		t.Errorf("snapshot %d not derived from legacy checkpoint: have %v, want %v", 2*legacy+1, vals, []common.Address{marker})
+	}
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	addrs := make([]common.Address, 4)
+	for i := range addrs {
+		addrs[i] = common.BytesToAddress([]byte{byte(i + 1)})
+	}
+	newSnap := func(vals ...common.Address) *Snapshot {
+		return newSnapshot(0, common.Hash{}, validator.NewSet(vals, istanbul.RoundRobin))
+	}
+	tests := []struct {
+		before, after  *Snapshot
+		added, removed []common.Address
+	}{
+		// No changes
+		{newSnap(addrs[0], addrs[1]), newSnap(addrs[1], addrs[0]), nil, nil},
+		// Additions only
+		{newSnap(addrs[0]), newSnap(addrs[0], addrs[2], addrs[1]), []common.Address{addrs[1], addrs[2]}, nil},
+		// Removals only
+		{newSnap(addrs[0], addrs[1], addrs[2]), newSnap(addrs[1]), nil, []common.Address{addrs[0], addrs[2]}},
+		// Both additions and removals
+		{newSnap(addrs[0], addrs[1]), newSnap(addrs[1], addrs[3]), []common.Address{addrs[3]}, []common.Address{addrs[0]}},
+	}
+	for i, tt := range tests {
+		added, removed := tt.before.Diff(tt.after)
+		if !reflect.DeepEqual(added, tt.added) {
+			t.Errorf("test %d: added mismatch: have %v, want %v", i, added, tt.added)
+		}
+		if !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("test %d: removed mismatch: have %v, want %v", i, removed, tt.removed)
+		}
+	}
+}
+
+func TestGetValidatorChanges(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	b := newTestCheckpointBackend(ethdb.NewMemDatabase(), key, minCheckpointInterval)
+	chain := newTestHeaderChain(5)
+	api := &API{chain: chain, istanbul: b}
+
+	// Seed the validators of every block, the genesis one is the first node
+	addrs := []common.Address{crypto.PubkeyToAddress(key.PublicKey), common.BytesToAddress([]byte{1}), common.BytesToAddress([]byte{2})}
+	sets := [][]common.Address{
+		{addrs[0]},
+		{addrs[0]},
+		{addrs[0], addrs[1]},
+		{addrs[1], addrs[2]},
+		{addrs[1], addrs[2]},
+	}
+	for i, vals := range sets {
+		header := chain[i+1]
+		snap := newSnapshot(header.Number.Uint64(), header.Hash(), validator.NewSet(vals, istanbul.RoundRobin))
+		b.recents.Add(snap.Hash, snap)
+	}
+
+	changes, err := api.GetValidatorChanges(0, 5)
+	if err != nil {
+		t.Fatalf("failed to retrieve validator changes: %v", err)
+	}
+	want := []*ValidatorChange{
+		{BlockNumber: 3, Added: []common.Address{addrs[1]}},
+		{BlockNumber: 4, Added: []common.Address{addrs[2]}, Removed: []common.Address{addrs[0]}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes mismatch: have %v, want %v", changes, want)
+	}
+	if changes, err = api.GetValidatorChanges(4, 5); err != nil || len(changes) != 0 {
+		t.Errorf("unchanged range mismatch: have %v/%v, want none", changes, err)
+	}
+
+	if _, err := api.GetValidatorChanges(3, 2); err != errInvalidBlockRange {
+		t.Errorf("error mismatch on reversed range: have %v, want %v", err, errInvalidBlockRange)
+	}
+	if _, err := api.GetValidatorChanges(0, maxValidatorChangesRange+1); err != errInvalidBlockRange {
+		t.Errorf("error mismatch on wide range: have %v, want %v", err, errInvalidBlockRange)
+	}
+	if _, err := api.GetValidatorChanges(4, 6); err != errUnknownBlock {
+		t.Errorf("error mismatch on missing block: have %v, want %v", err, errUnknownBlock)
+	}
+}
+
+func TestSetProposerPolicy(t *testing.T) {
+	config := &params.IstanbulConfig{CheckpointInterval: minCheckpointInterval, ProposerPolicy: istanbul.RoundRobin}
+	b := New(config, nil, ethdb.NewMemDatabase()).(*backend)
+
+	if err := b.SetProposerPolicy(istanbul.ProposerPolicy(42)); err != errInvalidProposerPolicy {
+		t.Fatalf("invalid policy error mismatch: have %v, want %v", err, errInvalidProposerPolicy)
+	}
+	if err := b.SetProposerPolicy(istanbul.ProposerPolicy(istanbul.Sticky)); err != nil {
+		t.Fatalf("failed to set proposer policy: %v", err)
+	}
+	headers := func(from, to uint64) []*types.Header {
+		var headers []*types.Header
+		for n := from; n <= to; n++ {
+			headers = append(headers, &types.Header{Number: new(big.Int).SetUint64(n)})
+		}
+		return headers
+	}
+	// The current epoch keeps its policy
+	if have := b.snapshotPolicy(istanbul.RoundRobin, headers(1, minCheckpointInterval-1)); have != istanbul.RoundRobin {
+		t.Fatalf("policy before the epoch boundary mismatch: have %d, want %d", have, istanbul.RoundRobin)
+	}
+	// The next epoch adopts the new policy
+	policy := b.snapshotPolicy(istanbul.RoundRobin, headers(minCheckpointInterval-1, minCheckpointInterval+1))
+	if policy != istanbul.Sticky {
+		t.Fatalf("policy after the epoch boundary mismatch: have %d, want %d", policy, istanbul.Sticky)
+	}
+	// Which changes the proposer ordering
+	addrs := make([]common.Address, 4)
+	for i := range addrs {
+		addrs[i] = common.BytesToAddress([]byte{byte(i + 1)})
+	}
+	order := func(valSet istanbul.ValidatorSet) []common.Address {
+		var proposers []common.Address
+		last := addrs[0]
+		for round := uint64(0); round < uint64(len(addrs)); round++ {
+			valSet.CalcProposer(last, round)
+			proposers = append(proposers, valSet.GetProposer().Address())
+		}
+		return proposers
+	}
+	before := order(validator.NewSet(addrs, istanbul.RoundRobin))
+	after := order(validator.NewSet(addrs, policy))
+	if reflect.DeepEqual(before, after) {
+		t.Errorf("proposer ordering unchanged by the policy switch: %v", after)
+	}
+}
+
+func TestRemovalGracePeriod(t *testing.T) {
+	addrs := make([]common.Address, 4)
+	for i := range addrs {
+		addrs[i] = common.BytesToAddress([]byte{byte(i + 1)})
+	}
+	snap := newSnapshot(0, common.Hash{}, validator.NewSet(addrs, istanbul.RoundRobin))
+
+	// A validator dropped at block 12 stays a member until the boundary after
+	// the next one
+	members := snap.updateRemovals(addrs[:3], addrs, addrs, 10, 5)
+	if len(members) != 4 {
+		t.Fatalf("members mismatch: have %d, want %d", len(members), 4)
+	}
+	if have := snap.PendingRemoval[addrs[3]]; have != 20 {
+		t.Fatalf("removal block mismatch: have %d, want %d", have, 20)
+	}
+	members = snap.updateRemovals(addrs[:3], addrs[:3], addrs, 15, 5)
+	if len(members) != 4 {
+		t.Fatalf("members before expiry mismatch: have %d, want %d", len(members), 4)
+	}
+	members = snap.updateRemovals(addrs[:3], addrs[:3], addrs[:3], 20, 5)
+	if len(members) != 3 {
+		t.Fatalf("members after expiry mismatch: have %d, want %d", len(members), 3)
+	}
+	if _, ok := snap.PendingRemoval[addrs[3]]; ok {
+		t.Fatalf("expired removal still pending")
+	}
+	// An empty election leaves the snapshot unchanged
+	snap.updateRemovals(addrs[:3], addrs, addrs, 10, 5)
+	if members := snap.updateRemovals(nil, addrs, addrs, 15, 5); members != nil {
+		t.Fatalf("members of an empty election: have %v, want nil", members)
+	}
+	if have := snap.PendingRemoval[addrs[3]]; have != 20 {
+		t.Fatalf("removal block after an empty election mismatch: have %d, want %d", have, 20)
+	}
+}
+
+func TestConcurrentRemovals(t *testing.T) {
+	addrs := make([]common.Address, 7)
+	for i := range addrs {
+		addrs[i] = common.BytesToAddress([]byte{byte(i + 1)})
+	}
+	snap := newSnapshot(0, common.Hash{}, validator.NewSet(addrs, istanbul.RoundRobin))
+
+	// Two validators are dropped at once and a third one shortly after
+	snap.updateRemovals(addrs[:5], addrs, addrs, 10, 5)
+	members := snap.updateRemovals(addrs[:4], addrs, addrs, 10, 5)
+	if len(members) != 7 {
+		t.Fatalf("members mismatch: have %d, want %d", len(members), 7)
+	}
+	quorum := func(members []common.Address) int {
+		set := validator.NewSet(members, istanbul.RoundRobin)
+		return set.Size() - set.F()
+	}
+	if have := quorum(members); have != 5 {
+		t.Fatalf("quorum during grace period mismatch: have %d, want %d", have, 5)
+	}
+	// Re-electing a pending validator cancels its removal
+	members = snap.updateRemovals(addrs[:5], addrs[:4], addrs, 15, 5)
+	if len(members) != 7 {
+		t.Fatalf("members after re-election mismatch: have %d, want %d", len(members), 7)
+	}
+	if _, ok := snap.PendingRemoval[addrs[4]]; ok {
+		t.Fatalf("re-elected validator still pending removal")
+	}
+	// The remaining removals expire together
+	members = snap.updateRemovals(addrs[:5], addrs[:5], addrs[:4], 20, 5)
+	if len(members) != 5 || len(snap.PendingRemoval) != 0 {
+		t.Fatalf("members after expiry mismatch: have %d members, %d pending", len(members), len(snap.PendingRemoval))
+	}
+
+	// Pending removals survive a round trip through the database and are
+	// never selected as proposer
+	members = snap.updateRemovals(addrs[:4], addrs, addrs, 10, 5)
+	snap.ValSet = validator.NewSet(members, istanbul.RoundRobin)
+	snap.ValSet.SetPendingRemoval(snap.pendingRemovals())
+	db := ethdb.NewMemDatabase()
+	if err := snap.store(db); err != nil {
+		t.Fatalf("failed to store snapshot: %v", err)
+	}
+	loaded, err := loadSnapshot(db, snap.Hash)
+	if err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.PendingRemoval, snap.PendingRemoval) {
+		t.Fatalf("pending removals mismatch: have %v, want %v", loaded.PendingRemoval, snap.PendingRemoval)
+	}
+	for round := uint64(0); round < uint64(len(addrs)); round++ {
+		loaded.ValSet.CalcProposer(addrs[0], round)
+		if proposer := loaded.ValSet.GetProposer().Address(); loaded.PendingRemoval[proposer] != 0 {
+			t.Fatalf("round %d: validator pending removal %x selected as proposer", round, proposer)
+		}
+	}
+}
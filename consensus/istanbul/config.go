@@ -23,6 +23,7 @@ type ProposerPolicy params.ProposerPolicy
 const (
 	RoundRobin params.ProposerPolicy = iota
 	Sticky
+	WeightedRoundRobin
 )
 
 /*
@@ -36,7 +37,10 @@ type Config struct {
 type Config params.IstanbulConfig
 
 var DefaultConfig = &Config{
-	RequestTimeout: 10000,
-	BlockPeriod:    1,
-	ProposerPolicy: RoundRobin,
+	RequestTimeout:     10000,
+	BlockPeriod:        1,
+	ProposerPolicy:     RoundRobin,
+	CheckpointInterval: 1024,
+	MessageCacheSize:   4096,
+	MaxValidators:      100,
 }
@@ -36,7 +36,7 @@ func TestCheckMessage(t *testing.T) {
 		current: newRoundState(&istanbul.View{
 			Sequence: big.NewInt(1),
 			Round:    big.NewInt(0),
-		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, big.NewInt(0), nil),
 	}
 
 	// invalid view format
@@ -195,7 +195,7 @@ func TestStoreBacklog(t *testing.T) {
 	// push prepare msg
 	subject := &istanbul.Subject{
 		View:   v,
-		Digest: common.StringToHash("1234567890"),
+		Digest: common.BytesToHash([]byte("1234567890")),
 	}
 	subjectPayload, _ := Encode(subject)
 
@@ -245,7 +245,7 @@ func TestProcessFutureBacklog(t *testing.T) {
 		current: newRoundState(&istanbul.View{
 			Sequence: big.NewInt(1),
 			Round:    big.NewInt(0),
-		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, big.NewInt(0), nil),
 		state: StateAcceptRequest,
 	}
 	c.subscribeEvents()
@@ -259,7 +259,7 @@ func TestProcessFutureBacklog(t *testing.T) {
 	// push a future msg
 	subject := &istanbul.Subject{
 		View:   v,
-		Digest: common.StringToHash("1234567890"),
+		Digest: common.BytesToHash([]byte("1234567890")),
 	}
 	subjectPayload, _ := Encode(subject)
 	m := &message{
@@ -295,7 +295,7 @@ func TestProcessBacklog(t *testing.T) {
 
 	subject := &istanbul.Subject{
 		View:   v,
-		Digest: common.StringToHash("1234567890"),
+		Digest: common.BytesToHash([]byte("1234567890")),
 	}
 	subjectPayload, _ := Encode(subject)
 
@@ -338,7 +338,7 @@ func testProcessBacklog(t *testing.T, msg *message) {
 		current: newRoundState(&istanbul.View{
 			Sequence: big.NewInt(1),
 			Round:    big.NewInt(0),
-		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, big.NewInt(0), nil),
 	}
 	c.subscribeEvents()
 	defer c.unsubscribeEvents()
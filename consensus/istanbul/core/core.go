@@ -32,6 +32,7 @@ import (
 	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/log"
 	"github.com/Venachain/Venachain/metrics"
+	lru "github.com/hashicorp/golang-lru"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
@@ -56,6 +57,8 @@ func New(backend istanbul.Backend, config *params.IstanbulConfig) Engine {
 		roundMeter:         metrics.NewMeter(),
 		sequenceMeter:      metrics.NewMeter(),
 		consensusTimer:     metrics.NewTimer(),
+		roundChanges:       newRoundChangeStats(roundChangeHistory),
+		seenMessages:       newMessageCache(config.MessageCacheSize),
 	}
 
 	r.Register("consensus/istanbul/core/round", c.roundMeter)
@@ -100,6 +103,9 @@ type core struct {
 	current   *roundState
 	handlerWg *sync.WaitGroup
 
+	pendingRound *big.Int     // Round of the current view for outside readers, nil if not running
+	roundMu      sync.RWMutex // The lock used to protect the pending round
+
 	roundChangeSet   *roundChangeSet
 	roundChangeTimer *time.Timer
 
@@ -115,6 +121,11 @@ type core struct {
 	sequenceMeter metrics.Meter
 	// the timer to record consensus duration (from accepting a preprepare to final committed stage)
 	consensusTimer metrics.Timer
+	// the round changes of the recent heights
+	roundChanges *roundChangeStats
+	// the recently received messages, to drop replays
+	seenMessages         *lru.Cache
+	deduplicatedMessages uint64
 }
 
 func (c *core) finalizeMessage(msg *message) ([]byte, error) {
@@ -284,12 +295,13 @@ func (c *core) startNewRoundWhenEmpty(round *big.Int) {
 
 	// Update logger
 	logger = logger.New("old_proposer", c.valSet.GetProposer())
+	c.roundChanges.record(newView.Sequence.Uint64())
 	// Clear invalid ROUND CHANGE messages
 	c.roundChangeSet = newRoundChangeSet(c.valSet)
 	// New snapshot for new round
 	logger.Debug("startNewRound", "roundChange", true)
 	//c.updateRoundState(newView, c.valSet, true)
-	c.current = newRoundState(newView, c.valSet, common.Hash{}, nil, nil, big.NewInt(0), nil)
+	c.setCurrent(newRoundState(newView, c.valSet, common.Hash{}, nil, nil, big.NewInt(0), nil))
 
 	// Calculate new proposer
 	c.valSet.CalcProposer(lastProposer, newView.Round.Uint64())
@@ -354,6 +366,9 @@ func (c *core) startNewRound(round *big.Int) {
 		c.valSet = c.backend.Validators(lastProposal)
 	}
 
+	if roundChange {
+		c.roundChanges.record(newView.Sequence.Uint64())
+	}
 	// Update logger
 	logger = logger.New("old_proposer", c.valSet.GetProposer())
 	// Clear invalid ROUND CHANGE messages
@@ -407,13 +422,39 @@ func (c *core) updateRoundState(view *istanbul.View, validatorSet istanbul.Valid
 	// Lock only if both roundChange is true and it is locked
 	if roundChange && c.current != nil {
 		if c.current.IsHashLocked() {
-			c.current = newRoundState(view, validatorSet, c.current.GetLockedHash(), c.current.Preprepare, c.current.pendingRequest, c.current.lockedRound, c.current.lockedPrepares)
+			c.setCurrent(newRoundState(view, validatorSet, c.current.GetLockedHash(), c.current.Preprepare, c.current.pendingRequest, c.current.lockedRound, c.current.lockedPrepares))
 		} else {
-			c.current = newRoundState(view, validatorSet, common.Hash{}, nil, c.current.pendingRequest, big.NewInt(0), nil)
+			c.setCurrent(newRoundState(view, validatorSet, common.Hash{}, nil, c.current.pendingRequest, big.NewInt(0), nil))
 		}
 	} else {
-		c.current = newRoundState(view, validatorSet, common.Hash{}, nil, nil, big.NewInt(0), nil)
+		c.setCurrent(newRoundState(view, validatorSet, common.Hash{}, nil, nil, big.NewInt(0), nil))
+	}
+}
+
+// setCurrent replaces the current round state, publishing its round to the
+// readers of PendingRound. A nil state marks the core as not running a round.
+func (c *core) setCurrent(current *roundState) {
+	c.current = current
+
+	c.roundMu.Lock()
+	defer c.roundMu.Unlock()
+	if current == nil {
+		c.pendingRound = nil
+	} else {
+		c.pendingRound = new(big.Int).Set(current.Round())
+	}
+}
+
+// PendingRound returns the round of the view the core is currently in. It is
+// safe to call from outside the core's event loop.
+func (c *core) PendingRound() (uint64, error) {
+	c.roundMu.RLock()
+	defer c.roundMu.RUnlock()
+
+	if c.pendingRound == nil {
+		return 0, errNoPendingRound
 	}
+	return c.pendingRound.Uint64(), nil
 }
 
 func (c *core) setStateWhenEmpty(state State) {
@@ -29,11 +29,10 @@ import (
 
 func makeBlock(number int64) *types.Block {
 	header := &types.Header{
-		Difficulty: big.NewInt(0),
-		Number:     big.NewInt(number),
-		GasLimit:   0,
-		GasUsed:    0,
-		Time:       big.NewInt(0),
+		Number:   big.NewInt(number),
+		GasLimit: 0,
+		GasUsed:  0,
+		Time:     big.NewInt(0),
 	}
 	block := &types.Block{}
 	return block.WithSeal(header)
@@ -80,3 +79,29 @@ func TestNewRequest(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the pending round follows the view changes of the core and is
+// unavailable while no round is running.
+func TestPendingRound(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	defer c.stopTimer()
+
+	if _, err := c.PendingRound(); err != errNoPendingRound {
+		t.Fatalf("error mismatch before any round: have %v, want %v", err, errNoPendingRound)
+	}
+	c.updateRoundState(&istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)}, c.valSet, false)
+	if round, err := c.PendingRound(); err != nil || round != 0 {
+		t.Fatalf("initial round mismatch: have %d (%v), want 0", round, err)
+	}
+	// Change the view to a later round of the same height
+	c.startNewRound(big.NewInt(2))
+	if round, err := c.PendingRound(); err != nil || round != 2 {
+		t.Fatalf("round after view change mismatch: have %d (%v), want 2", round, err)
+	}
+	// A stopped core runs no round
+	c.setCurrent(nil)
+	if _, err := c.PendingRound(); err != errNoPendingRound {
+		t.Fatalf("error mismatch after stopping: have %v, want %v", err, errNoPendingRound)
+	}
+}
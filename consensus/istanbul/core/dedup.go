@@ -0,0 +1,63 @@
+package core
+
+import (
+	"sync/atomic"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/consensus/istanbul"
+	"github.com/Venachain/Venachain/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// defaultMessageCacheSize is the number of recent messages remembered when the
+// configuration leaves it unset.
+const defaultMessageCacheSize = 4096
+
+// messageKey identifies a message: a validator sends at most one message of
+// each code per view.
+type messageKey struct {
+	sequence uint64
+	round    uint64
+	code     uint64
+	sender   common.Address
+}
+
+// messageView is the leading view shared by all consensus message payloads.
+type messageView struct {
+	View *istanbul.View
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+func newMessageCache(size int) *lru.Cache {
+	if size <= 0 {
+		size = defaultMessageCacheSize
+	}
+	cache, _ := lru.New(size)
+	return cache
+}
+
+// isDuplicate reports whether a message of the same view, code and sender was
+// already received, remembering msg otherwise. Messages without a view are
+// never reported, they fail decoding further on anyway.
+func (c *core) isDuplicate(msg *message) bool {
+	var v messageView
+	if err := rlp.DecodeBytes(msg.Msg, &v); err != nil || v.View == nil {
+		return false
+	}
+	key := messageKey{
+		sequence: v.View.Sequence.Uint64(),
+		round:    v.View.Round.Uint64(),
+		code:     msg.Code,
+		sender:   msg.Address,
+	}
+	if ok, _ := c.seenMessages.ContainsOrAdd(key, struct{}{}); ok {
+		atomic.AddUint64(&c.deduplicatedMessages, 1)
+		return true
+	}
+	return false
+}
+
+// DeduplicatedMessages returns the number of replayed messages dropped.
+func (c *core) DeduplicatedMessages() uint64 {
+	return atomic.LoadUint64(&c.deduplicatedMessages)
+}
@@ -33,6 +33,9 @@ var (
 	// errOldMessage is returned when the received message's view is earlier
 	// than current view.
 	errOldMessage = errors.New("old message")
+	// errDuplicateMessage is returned when a message of the same view, code
+	// and sender was already received.
+	errDuplicateMessage = errors.New("duplicate message")
 	// errInvalidMessage is returned when the message is malformed.
 	errInvalidMessage = errors.New("invalid message")
 	// errFailedDecodePreprepare is returned when the PRE-PREPARE message is malformed.
@@ -43,4 +46,7 @@ var (
 	errFailedDecodeCommit = errors.New("failed to decode COMMIT")
 	// errFailedDecodeMessageSet is returned when the message set is malformed.
 	errFailedDecodeMessageSet = errors.New("failed to decode message set")
+	// errNoPendingRound is returned when the round is queried while the core is
+	// not running a consensus round.
+	errNoPendingRound = errors.New("no pending round")
 )
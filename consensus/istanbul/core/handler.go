@@ -79,7 +79,7 @@ func (c *core) unsubscribeEvents() {
 func (c *core) handleEvents() {
 	// Clear state
 	defer func() {
-		c.current = nil
+		c.setCurrent(nil)
 		c.handlerWg.Done()
 	}()
 
@@ -162,6 +162,10 @@ func (c *core) handleMsg(payload []byte) error {
 		logger.Error("Invalid address in message", "msg", msg)
 		return istanbul.ErrUnauthorizedAddress
 	}
+	// Drop replays, they are neither processed nor gossiped again
+	if c.isDuplicate(msg) {
+		return errDuplicateMessage
+	}
 
 	return c.handleCheckedMsg(msg, src)
 }
@@ -41,7 +41,7 @@ func TestHandleMsg(t *testing.T) {
 			Sequence: big.NewInt(0),
 			Round:    big.NewInt(0),
 		},
-		Digest: common.StringToHash("1234567890"),
+		Digest: common.BytesToHash([]byte("1234567890")),
 	})
 	// with a matched payload. msgPreprepare should match with *istanbul.Preprepare in normal case.
 	msg := &message{
@@ -125,3 +125,47 @@ func TestHandleMsg(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want nil", err)
 	}
 }
+
+func TestHandleMsgDeduplicate(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+
+	closer := sys.Run(true)
+	defer closer()
+
+	v0 := sys.backends[0]
+	r0 := v0.engine.(*core)
+	// The test backend does not sign messages
+	r0.validateFn = nil
+
+	m, _ := Encode(&istanbul.Subject{
+		View: &istanbul.View{
+			Sequence: big.NewInt(1),
+			Round:    big.NewInt(0),
+		},
+		Digest: common.BytesToHash([]byte("1234567890")),
+	})
+	msg := &message{
+		Code:          msgPrepare,
+		Msg:           m,
+		Address:       sys.backends[1].Address(),
+		Signature:     []byte{},
+		CommittedSeal: []byte{},
+	}
+	payload, err := msg.Payload()
+	if err != nil {
+		t.Fatalf("failed to encode message: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		err := r0.handleMsg(payload)
+		if i == 0 && err == errDuplicateMessage {
+			t.Fatalf("first message dropped as duplicate")
+		}
+		if i > 0 && err != errDuplicateMessage {
+			t.Fatalf("replay %d: error mismatch: have %v, want %v", i, err, errDuplicateMessage)
+		}
+	}
+	if n := r0.DeduplicatedMessages(); n != 999 {
+		t.Errorf("deduplicated messages mismatch: have %d, want %d", n, 999)
+	}
+}
@@ -17,6 +17,11 @@
 package core
 
 import (
+	"math/big"
+	"time"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/consensus"
 	"github.com/Venachain/Venachain/consensus/istanbul"
 )
 
@@ -35,12 +40,66 @@ func (c *core) handleRequest(request *istanbul.Request) error {
 	logger.Trace("handleRequest", "number", request.Proposal.Number(), "hash", request.Proposal.Hash(), "round", request.Round)
 	c.current.pendingRequest = request
 	if c.state == StateAcceptRequest {
+		if c.config.FastSealSingleValidator && c.valSet.Size() == 1 {
+			return c.fastSeal(request)
+		}
 		c.sendPreprepare(request)
 	}
 
 	return nil
 }
 
+// fastSeal commits the proposal of a single-validator network without going
+// through PRE-PREPARE and PREPARE. The sole validator is the proposer and the
+// whole quorum at once, so after verifying the proposal itself there is nobody
+// left to agree with and its own committed seal is enough.
+func (c *core) fastSeal(request *istanbul.Request) error {
+	logger := c.logger.New("state", c.state, "seq", c.current.sequence)
+
+	if !c.IsProposer() {
+		return errNotFromProposer
+	}
+	if duration, err := c.backend.Verify(request.Proposal, true); err != nil {
+		logger.Warn("Failed to verify proposal", "err", err, "duration", duration)
+		// if it's a future block, we will handle it again after the duration
+		if err == consensus.ErrFutureBlock {
+			c.stopFuturePreprepareTimer()
+			c.futurePreprepareTimer = time.AfterFunc(duration, func() {
+				c.sendEvent(istanbul.RequestEvent{
+					Proposal: request.Proposal,
+				})
+			})
+		}
+		return err
+	}
+	c.acceptPreprepare(&istanbul.Preprepare{
+		View:           c.currentView(),
+		Proposal:       request.Proposal,
+		LockedRound:    big.NewInt(0),
+		LockedHash:     common.Hash{},
+		LockedPrepares: newMessageSet(c.valSet),
+	})
+
+	seal, err := c.backend.Sign(PrepareCommittedSeal(request.Proposal.Hash()))
+	if err != nil {
+		logger.Error("Failed to sign committed seal", "err", err)
+		return err
+	}
+	if err := c.current.Commits.Add(&message{
+		Code:          msgCommit,
+		Address:       c.Address(),
+		CommittedSeal: seal,
+	}); err != nil {
+		logger.Error("Failed to record commit message", "err", err)
+		return err
+	}
+	logger.Debug("fastSeal", "number", request.Proposal.Number(), "hash", request.Proposal.Hash())
+
+	c.current.LockHash()
+	c.commit()
+	return nil
+}
+
 // check request state
 // return errInvalidMessage if the message is invalid
 // return errFutureMessage if the sequence of proposal is larger than current sequence
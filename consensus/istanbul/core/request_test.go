@@ -27,6 +27,7 @@ import (
 	"github.com/Venachain/Venachain/consensus/istanbul"
 	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/log"
+	"github.com/Venachain/Venachain/params"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
@@ -36,7 +37,7 @@ func TestCheckRequestMsg(t *testing.T) {
 		current: newRoundState(&istanbul.View{
 			Sequence: big.NewInt(1),
 			Round:    big.NewInt(0),
-		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, big.NewInt(0), nil),
 	}
 
 	// invalid request
@@ -73,6 +74,7 @@ func TestCheckRequestMsg(t *testing.T) {
 	// current request
 	r = &istanbul.Request{
 		Proposal: makeBlock(1),
+		Round:    big.NewInt(0),
 	}
 	err = c.checkRequestMsg(r)
 	if err != nil {
@@ -91,19 +93,22 @@ func TestStoreRequestMsg(t *testing.T) {
 		current: newRoundState(&istanbul.View{
 			Sequence: big.NewInt(0),
 			Round:    big.NewInt(0),
-		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, big.NewInt(0), nil),
 		pendingRequests:   prque.New(),
 		pendingRequestsMu: new(sync.Mutex),
 	}
 	requests := []istanbul.Request{
 		{
 			Proposal: makeBlock(1),
+			Round:    big.NewInt(0),
 		},
 		{
 			Proposal: makeBlock(2),
+			Round:    big.NewInt(0),
 		},
 		{
 			Proposal: makeBlock(3),
+			Round:    big.NewInt(0),
 		},
 	}
 
@@ -136,3 +141,74 @@ func TestStoreRequestMsg(t *testing.T) {
 		t.Error("unexpected timeout occurs")
 	}
 }
+
+// newSingleValidatorSystem creates a test system of one validator, sealing its
+// proposals through the fast path if fast is set.
+func newSingleValidatorSystem(fast bool) *testSystem {
+	sys := newTestSystem(1)
+	config := params.IstanbulConfig(*istanbul.DefaultConfig)
+	config.FastSealSingleValidator = fast
+
+	vset := newTestValidatorSet(1)
+	backend := sys.NewBackend(0)
+	backend.peers = vset
+	backend.address = vset.GetByIndex(0).Address()
+
+	core := New(backend, &config).(*core)
+	core.state = StateAcceptRequest
+	core.current = newRoundState(&istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}, vset, common.Hash{}, nil, nil, big.NewInt(0), nil)
+	core.valSet = vset
+	core.logger = testLogger
+	core.validateFn = backend.CheckValidatorSignature
+
+	backend.engine = core
+	return sys
+}
+
+// Tests that a single validator with fast sealing enabled commits its proposal
+// straight from the request, without any PRE-PREPARE, PREPARE or COMMIT round
+// trip, while the regular state machine still goes through all three phases.
+func TestFastSealSingleValidator(t *testing.T) {
+	finality := make(map[bool]time.Duration)
+	for _, fast := range []bool{false, true} {
+		sys := newSingleValidatorSystem(fast)
+		backend := sys.backends[0]
+		sub := backend.EventMux().Subscribe(istanbul.FinalCommittedEvent{})
+
+		closer := sys.Run(true)
+		start := time.Now()
+		backend.NewRequest(makeBlock(1))
+
+		select {
+		case <-sub.Chan():
+			finality[fast] = time.Since(start)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("fast %v: proposal not committed", fast)
+		}
+		sub.Unsubscribe()
+		closer()
+
+		if len(backend.committedMsgs) != 1 {
+			t.Fatalf("fast %v: committed proposal count mismatch: have %d, want 1", fast, len(backend.committedMsgs))
+		}
+		committed := backend.committedMsgs[0]
+		if committed.commitProposal.Hash() != makeBlock(1).Hash() {
+			t.Errorf("fast %v: committed proposal mismatch: have %x, want %x", fast, committed.commitProposal.Hash(), makeBlock(1).Hash())
+		}
+		if len(committed.committedSeals) != 1 {
+			t.Errorf("fast %v: committed seal count mismatch: have %d, want 1", fast, len(committed.committedSeals))
+		}
+		// PRE-PREPARE, PREPARE and COMMIT are broadcast without fast sealing
+		want := 3
+		if fast {
+			want = 0
+		}
+		if len(backend.sentMsgs) != want {
+			t.Errorf("fast %v: sent message count mismatch: have %d, want %d", fast, len(backend.sentMsgs), want)
+		}
+	}
+	t.Logf("block finality latency: %v with all phases, %v with fast sealing", finality[false], finality[true])
+}
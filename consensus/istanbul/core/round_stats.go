@@ -0,0 +1,60 @@
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// roundChangeHistory is the number of recent heights whose round changes are
+// kept.
+const roundChangeHistory = 256
+
+// RoundChangeStat is the number of round changes that occurred at a height.
+type RoundChangeStat struct {
+	Height uint64 `json:"height"`
+	Rounds uint64 `json:"rounds"`
+}
+
+// roundChangeStats counts the round changes of the recent heights in a ring,
+// a height replacing the one roundChangeHistory heights below it.
+type roundChangeStats struct {
+	slots []RoundChangeStat
+	lock  sync.RWMutex
+}
+
+func newRoundChangeStats(size int) *roundChangeStats {
+	return &roundChangeStats{slots: make([]RoundChangeStat, size)}
+}
+
+// record counts a round change at height.
+func (s *roundChangeStats) record(height uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	slot := &s.slots[height%uint64(len(s.slots))]
+	if slot.Height != height {
+		*slot = RoundChangeStat{Height: height}
+	}
+	slot.Rounds++
+}
+
+// recent returns the recent heights having round changes in ascending order.
+func (s *roundChangeStats) recent() []RoundChangeStat {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	stats := make([]RoundChangeStat, 0, len(s.slots))
+	for _, slot := range s.slots {
+		if slot.Rounds > 0 {
+			stats = append(stats, slot)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Height < stats[j].Height })
+	return stats
+}
+
+// RoundChangeStats returns the number of round changes of the recent heights
+// that needed any.
+func (c *core) RoundChangeStats() []RoundChangeStat {
+	return c.roundChanges.recent()
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoundChangeStats(t *testing.T) {
+	s := newRoundChangeStats(4)
+	if stats := s.recent(); len(stats) != 0 {
+		t.Fatalf("stats of a fresh tracker: have %v, want none", stats)
+	}
+	s.record(10)
+	s.record(10)
+	s.record(12)
+	s.record(10)
+	want := []RoundChangeStat{{Height: 10, Rounds: 3}, {Height: 12, Rounds: 1}}
+	if stats := s.recent(); !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats mismatch: have %v, want %v", stats, want)
+	}
+	// Heights age out once the ring wraps around
+	s.record(13)
+	s.record(14)
+	want = []RoundChangeStat{{Height: 12, Rounds: 1}, {Height: 13, Rounds: 1}, {Height: 14, Rounds: 1}}
+	if stats := s.recent(); !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats mismatch after wrap around: have %v, want %v", stats, want)
+	}
+}
@@ -33,9 +33,6 @@ func newTestRoundState(view *istanbul.View, validatorSet istanbul.ValidatorSet)
 		Prepares:   newMessageSet(validatorSet),
 		Commits:    newMessageSet(validatorSet),
 		mu:         new(sync.RWMutex),
-		hasBadProposal: func(hash common.Hash) bool {
-			return false
-		},
 	}
 }
 
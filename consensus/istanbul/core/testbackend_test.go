@@ -28,6 +28,7 @@ import (
 	"github.com/Venachain/Venachain/ethdb"
 	"github.com/Venachain/Venachain/event"
 	elog "github.com/Venachain/Venachain/log"
+	"github.com/Venachain/Venachain/params"
 )
 
 var testLogger = elog.New()
@@ -36,9 +37,10 @@ type testSystemBackend struct {
 	id  uint64
 	sys *testSystem
 
-	engine Engine
-	peers  istanbul.ValidatorSet
-	events *event.TypeMux
+	engine  Engine
+	peers   istanbul.ValidatorSet
+	events  *event.TypeMux
+	msgFeed event.Feed
 
 	committedMsgs []testCommittedMsgs
 	sentMsgs      [][]byte // store the message when Send is called by core
@@ -69,6 +71,10 @@ func (self *testSystemBackend) EventMux() *event.TypeMux {
 	return self.events
 }
 
+func (self *testSystemBackend) MsgFeed() *event.Feed {
+	return &self.msgFeed
+}
+
 func (self *testSystemBackend) Send(message []byte, target common.Address) error {
 	testLogger.Info("enqueuing a message...", "address", self.Address())
 	self.sentMsgs = append(self.sentMsgs, message)
@@ -104,13 +110,14 @@ func (self *testSystemBackend) Commit(proposal istanbul.Proposal, seals [][]byte
 	return nil
 }
 
-func (self *testSystemBackend) Verify(proposal istanbul.Proposal) (time.Duration, error) {
+func (self *testSystemBackend) Verify(proposal istanbul.Proposal, isProposer bool) (time.Duration, error) {
 	return 0, nil
 }
 
+// Sign stands in for a signature with the address of the backend, which
+// CheckValidatorSignature recovers as the signer.
 func (self *testSystemBackend) Sign(data []byte) ([]byte, error) {
-	testLogger.Warn("not sign any data")
-	return data, nil
+	return self.address.Bytes(), nil
 }
 
 func (self *testSystemBackend) CheckSignature([]byte, common.Address, []byte) error {
@@ -118,11 +125,11 @@ func (self *testSystemBackend) CheckSignature([]byte, common.Address, []byte) er
 }
 
 func (self *testSystemBackend) CheckValidatorSignature(data []byte, sig []byte) (common.Address, error) {
-	return common.Address{}, nil
+	return common.BytesToAddress(sig), nil
 }
 
 func (self *testSystemBackend) Hash(b interface{}) common.Hash {
-	return common.StringToHash("Test")
+	return common.BytesToHash([]byte("Test"))
 }
 
 func (self *testSystemBackend) NewRequest(request istanbul.Proposal) {
@@ -204,14 +211,12 @@ func NewTestSystemWithBackend(n, f uint64) *testSystem {
 		backend.peers = vset
 		backend.address = vset.GetByIndex(i).Address()
 
-		core := New(backend, config).(*core)
+		core := New(backend, (*params.IstanbulConfig)(config)).(*core)
 		core.state = StateAcceptRequest
 		core.current = newRoundState(&istanbul.View{
 			Round:    big.NewInt(0),
 			Sequence: big.NewInt(1),
-		}, vset, common.Hash{}, nil, nil, func(hash common.Hash) bool {
-			return false
-		})
+		}, vset, common.Hash{}, nil, nil, big.NewInt(0), nil)
 		core.valSet = vset
 		core.logger = testLogger
 		core.validateFn = backend.CheckValidatorSignature
@@ -231,7 +236,7 @@ func (t *testSystem) listen() {
 		case queuedMessage := <-t.queuedMessage:
 			testLogger.Info("consuming a queue message...")
 			for _, backend := range t.backends {
-				go backend.EventMux().Post(queuedMessage)
+				go backend.MsgFeed().Send(queuedMessage)
 			}
 		}
 	}
@@ -41,6 +41,12 @@ type Engine interface {
 	// pending request is populated right at the preprepare stage so this would give us the earliest verification
 	// to avoid any race condition of coming propagated blocks
 	IsCurrentProposal(blockHash common.Hash) bool
+
+	// RoundChangeStats returns the number of round changes of the recent heights
+	RoundChangeStats() []RoundChangeStat
+
+	// PendingRound returns the round of the current view
+	PendingRound() (uint64, error)
 }
 
 type State uint64
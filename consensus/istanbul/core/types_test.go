@@ -79,7 +79,7 @@ func testSubject(t *testing.T) {
 			Round:    big.NewInt(1),
 			Sequence: big.NewInt(2),
 		},
-		Digest: common.StringToHash("1234567890"),
+		Digest: common.BytesToHash([]byte("1234567890")),
 	}
 
 	subjectPayload, _ := Encode(s)
@@ -118,7 +118,7 @@ func testSubjectWithSignature(t *testing.T) {
 			Round:    big.NewInt(1),
 			Sequence: big.NewInt(2),
 		},
-		Digest: common.StringToHash("1234567890"),
+		Digest: common.BytesToHash([]byte("1234567890")),
 	}
 	expectedSig := []byte{0x01}
 
@@ -141,7 +141,7 @@ func testSubjectWithSignature(t *testing.T) {
 	// 2.1 Test normal validate func
 	decodedMsg := new(message)
 	err = decodedMsg.FromPayload(msgPayload, func(data []byte, sig []byte) (common.Address, error) {
-		return common.Address{}, nil
+		return m.Address, nil
 	})
 	if err != nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
@@ -17,6 +17,7 @@
 package istanbul
 
 import (
+	"math/big"
 	"strings"
 
 	"github.com/Venachain/Venachain/params"
@@ -75,6 +76,14 @@ type ValidatorSet interface {
 	F() int
 	// Get proposer policy
 	Policy() params.ProposerPolicy
+	// Get the stake weight of the validator with given address
+	Weight(addr common.Address) *big.Int
+	// Set the stake weight of the validator with given address
+	SetWeight(addr common.Address, weight *big.Int)
+	// Set the VRF nonce seeding the weighted proposer selection
+	SetSeed(seed []byte)
+	// Set the validators pending removal, which are not selected as proposer
+	SetPendingRemoval(addrs []common.Address)
 }
 
 // ----------------------------------------------------------------------------
@@ -17,10 +17,13 @@
 package validator
 
 import (
+	"encoding/binary"
+	"math/big"
 	"reflect"
 	"sort"
 	"sync"
 
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/log"
 	"github.com/Venachain/Venachain/params"
 
@@ -49,12 +52,18 @@ type defaultSet struct {
 	proposer    istanbul.Validator
 	validatorMu sync.RWMutex
 	selector    istanbul.ProposalSelector
+
+	weights map[common.Address]*big.Int // Stake weights, validators without an entry weigh 1
+	seed    []byte                      // VRF nonce seeding the weighted proposer selection
+
+	pendingRemoval map[common.Address]bool // Validators pending removal, not selected as proposer
 }
 
 func newDefaultSet(addrs []common.Address, policy params.ProposerPolicy) *defaultSet {
 	valSet := &defaultSet{}
 
 	valSet.policy = policy
+	valSet.weights = make(map[common.Address]*big.Int)
 	// init validators
 	valSet.validators = make([]istanbul.Validator, len(addrs))
 	for i, addr := range addrs {
@@ -70,6 +79,9 @@ func newDefaultSet(addrs []common.Address, policy params.ProposerPolicy) *defaul
 	if params.ProposerPolicy(policy) == istanbul.Sticky {
 		valSet.selector = stickyProposer
 	}
+	if params.ProposerPolicy(policy) == istanbul.WeightedRoundRobin {
+		valSet.selector = weightedRoundRobinProposer
+	}
 
 	return valSet
 }
@@ -116,7 +128,30 @@ func (valSet *defaultSet) IsProposer(address common.Address) bool {
 func (valSet *defaultSet) CalcProposer(lastProposer common.Address, round uint64) {
 	valSet.validatorMu.RLock()
 	defer valSet.validatorMu.RUnlock()
-	valSet.proposer = valSet.selector(valSet, lastProposer, round)
+	valSet.proposer = valSet.selector(valSet.proposers(), lastProposer, round)
+}
+
+// proposers returns the set the proposer is selected from, the validators not
+// pending removal. If all of them are pending removal, any validator proposes.
+func (valSet *defaultSet) proposers() istanbul.ValidatorSet {
+	if len(valSet.pendingRemoval) == 0 {
+		return valSet
+	}
+	addresses := make([]common.Address, 0, len(valSet.validators))
+	for _, v := range valSet.validators {
+		if !valSet.pendingRemoval[v.Address()] {
+			addresses = append(addresses, v.Address())
+		}
+	}
+	if len(addresses) == 0 {
+		return valSet
+	}
+	proposers := newDefaultSet(addresses, valSet.policy)
+	for addr, weight := range valSet.weights {
+		proposers.weights[addr] = weight
+	}
+	proposers.seed = valSet.seed
+	return proposers
 }
 
 func calcSeed(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) uint64 {
@@ -161,8 +196,42 @@ func stickyProposer(valSet istanbul.ValidatorSet, proposer common.Address, round
 	return valSet.GetByIndex(pick)
 }
 
+// weightedRoundRobinProposer picks the proposer with a probability proportional
+// to its weight. The pick is derived from the VRF nonce of the set, the last
+// proposer and the round, so every node selects the same proposer.
+func weightedRoundRobinProposer(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) istanbul.Validator {
+	if valSet.Size() == 0 {
+		return nil
+	}
+	validators := valSet.List()
+	total := new(big.Int)
+	for _, val := range validators {
+		total.Add(total, valSet.Weight(val.Address()))
+	}
+	if total.Sign() == 0 {
+		return roundRobinProposer(valSet, proposer, round)
+	}
+
+	var seed []byte
+	if set, ok := valSet.(*defaultSet); ok {
+		seed = set.seed
+	}
+	var roundBytes [8]byte
+	binary.BigEndian.PutUint64(roundBytes[:], round)
+	hash := crypto.Keccak256(seed, proposer.Bytes(), roundBytes[:])
+	pick := new(big.Int).Mod(new(big.Int).SetBytes(hash), total)
+
+	for _, val := range validators {
+		pick.Sub(pick, valSet.Weight(val.Address()))
+		if pick.Sign() < 0 {
+			return val
+		}
+	}
+	return validators[len(validators)-1]
+}
+
 func (valSet *defaultSet) AddValidator(address common.Address) bool {
-	log.Info("^^^^^^^^^^^^^^^^^^^^    valSet.AddVallidator()" + address.String())
+	log.Trace("Adding validator", "address", address)
 	valSet.validatorMu.Lock()
 	defer valSet.validatorMu.Unlock()
 	for _, v := range valSet.validators {
@@ -198,7 +267,18 @@ func (valSet *defaultSet) Copy() istanbul.ValidatorSet {
 	for _, v := range valSet.validators {
 		addresses = append(addresses, v.Address())
 	}
-	return NewSet(addresses, valSet.policy)
+	cpy := newDefaultSet(addresses, valSet.policy)
+	for addr, weight := range valSet.weights {
+		cpy.weights[addr] = new(big.Int).Set(weight)
+	}
+	cpy.seed = common.CopyBytes(valSet.seed)
+	if len(valSet.pendingRemoval) > 0 {
+		cpy.pendingRemoval = make(map[common.Address]bool, len(valSet.pendingRemoval))
+		for addr := range valSet.pendingRemoval {
+			cpy.pendingRemoval[addr] = true
+		}
+	}
+	return cpy
 }
 
 //func (valSet *defaultSet) F() int { return int(math.Ceil(float64(valSet.Size())/3)) - 1 }
@@ -206,3 +286,43 @@ func (valSet *defaultSet) Copy() istanbul.ValidatorSet {
 func (valSet *defaultSet) F() int { return (valSet.Size() - 1) / 3 }
 
 func (valSet *defaultSet) Policy() params.ProposerPolicy { return valSet.policy }
+
+// Weight returns the stake weight of the validator, 1 unless set otherwise.
+func (valSet *defaultSet) Weight(addr common.Address) *big.Int {
+	valSet.validatorMu.RLock()
+	defer valSet.validatorMu.RUnlock()
+	if weight, ok := valSet.weights[addr]; ok {
+		return new(big.Int).Set(weight)
+	}
+	return big.NewInt(1)
+}
+
+// SetWeight sets the stake weight of the validator. A nil or negative weight
+// resets it to the default.
+func (valSet *defaultSet) SetWeight(addr common.Address, weight *big.Int) {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+	if weight == nil || weight.Sign() < 0 {
+		delete(valSet.weights, addr)
+		return
+	}
+	valSet.weights[addr] = new(big.Int).Set(weight)
+}
+
+// SetSeed sets the VRF nonce seeding the weighted proposer selection.
+func (valSet *defaultSet) SetSeed(seed []byte) {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+	valSet.seed = common.CopyBytes(seed)
+}
+
+// SetPendingRemoval sets the validators pending removal. They still count
+// toward quorum, but are not selected as proposer.
+func (valSet *defaultSet) SetPendingRemoval(addrs []common.Address) {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+	valSet.pendingRemoval = make(map[common.Address]bool, len(addrs))
+	for _, addr := range addrs {
+		valSet.pendingRemoval[addr] = true
+	}
+}
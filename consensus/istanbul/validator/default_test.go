@@ -17,6 +17,8 @@
 package validator
 
 import (
+	"math"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
@@ -136,39 +138,39 @@ func testEmptyValSet(t *testing.T) {
 
 func testAddAndRemoveValidator(t *testing.T) {
 	valSet := NewSet(ExtractValidators([]byte{}), istanbul.RoundRobin)
-	if !valSet.AddValidator(common.StringToAddress(string(2))) {
+	if !valSet.AddValidator(common.BytesToAddress([]byte{2})) {
 		t.Error("the validator should be added")
 	}
-	if valSet.AddValidator(common.StringToAddress(string(2))) {
+	if valSet.AddValidator(common.BytesToAddress([]byte{2})) {
 		t.Error("the existing validator should not be added")
 	}
-	valSet.AddValidator(common.StringToAddress(string(1)))
-	valSet.AddValidator(common.StringToAddress(string(0)))
+	valSet.AddValidator(common.BytesToAddress([]byte{1}))
+	valSet.AddValidator(common.BytesToAddress([]byte{0}))
 	if len(valSet.List()) != 3 {
 		t.Error("the size of validator set should be 3")
 	}
 
 	for i, v := range valSet.List() {
-		expected := common.StringToAddress(string(i))
+		expected := common.BytesToAddress([]byte{byte(i)})
 		if v.Address() != expected {
 			t.Errorf("the order of validators is wrong: have %v, want %v", v.Address().Hex(), expected.Hex())
 		}
 	}
 
-	if !valSet.RemoveValidator(common.StringToAddress(string(2))) {
+	if !valSet.RemoveValidator(common.BytesToAddress([]byte{2})) {
 		t.Error("the validator should be removed")
 	}
-	if valSet.RemoveValidator(common.StringToAddress(string(2))) {
+	if valSet.RemoveValidator(common.BytesToAddress([]byte{2})) {
 		t.Error("the non-existing validator should not be removed")
 	}
 	if len(valSet.List()) != 2 {
 		t.Error("the size of validator set should be 2")
 	}
-	valSet.RemoveValidator(common.StringToAddress(string(1)))
+	valSet.RemoveValidator(common.BytesToAddress([]byte{1}))
 	if len(valSet.List()) != 1 {
 		t.Error("the size of validator set should be 1")
 	}
-	valSet.RemoveValidator(common.StringToAddress(string(0)))
+	valSet.RemoveValidator(common.BytesToAddress([]byte{0}))
 	if len(valSet.List()) != 0 {
 		t.Error("the size of validator set should be 0")
 	}
@@ -206,3 +208,82 @@ func testStickyProposer(t *testing.T) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
 }
+
+func TestWeightedRoundRobinProposer(t *testing.T) {
+	const rounds = 10000
+
+	var addrs []common.Address
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	valSet := NewSet(addrs, istanbul.WeightedRoundRobin)
+	total := int64(0)
+	for i, addr := range addrs {
+		valSet.SetWeight(addr, big.NewInt(int64(i+1)))
+		total += int64(i + 1)
+	}
+	if have := valSet.Weight(addrs[3]); have.Cmp(big.NewInt(4)) != 0 {
+		t.Fatalf("weight mismatch: have %v, want 4", have)
+	}
+
+	// Every sequence brings a new VRF nonce seeding the selection
+	counts := make(map[common.Address]int)
+	proposer := common.Address{}
+	for i := 0; i < rounds; i++ {
+		valSet.SetSeed(crypto.Keccak256(big.NewInt(int64(i)).Bytes()))
+		valSet.CalcProposer(proposer, 0)
+		proposer = valSet.GetProposer().Address()
+		counts[proposer]++
+	}
+	for i, addr := range addrs {
+		want := float64(i+1) / float64(total)
+		have := float64(counts[addr]) / rounds
+		if math.Abs(have-want) > 0.02 {
+			t.Errorf("validator %d: proposer share mismatch: have %.3f, want %.3f", i, have, want)
+		}
+	}
+
+	// The selection is deterministic for the same seed, proposer and round
+	cpy := valSet.Copy()
+	valSet.CalcProposer(proposer, 1)
+	cpy.CalcProposer(proposer, 1)
+	if valSet.GetProposer().Address() != cpy.GetProposer().Address() {
+		t.Errorf("proposer mismatch between copies: have %v, want %v", cpy.GetProposer(), valSet.GetProposer())
+	}
+}
+
+func TestPendingRemovalProposer(t *testing.T) {
+	addrs := []common.Address{
+		common.BytesToAddress([]byte{1}),
+		common.BytesToAddress([]byte{2}),
+		common.BytesToAddress([]byte{3}),
+	}
+	valSet := newDefaultSet(addrs, istanbul.RoundRobin)
+	valSet.SetPendingRemoval(addrs[1:2])
+
+	// Validators pending removal are skipped, but stay in the set
+	for round := uint64(0); round < 6; round++ {
+		valSet.CalcProposer(addrs[0], round)
+		if valSet.GetProposer().Address() == addrs[1] {
+			t.Fatalf("round %d: validator pending removal selected as proposer", round)
+		}
+	}
+	if valSet.Size() != len(addrs) {
+		t.Errorf("validator set size mismatch: have %d, want %d", valSet.Size(), len(addrs))
+	}
+	// And stay skipped in copies
+	cpy := valSet.Copy()
+	for round := uint64(0); round < 6; round++ {
+		cpy.CalcProposer(addrs[2], round)
+		if cpy.GetProposer().Address() == addrs[1] {
+			t.Fatalf("round %d: validator pending removal selected as proposer by the copy", round)
+		}
+	}
+	// Unless all of them are pending removal
+	valSet.SetPendingRemoval(addrs)
+	valSet.CalcProposer(common.Address{}, 1)
+	if valSet.GetProposer().Address() != addrs[1] {
+		t.Errorf("proposer mismatch: have %x, want %x", valSet.GetProposer().Address(), addrs[1])
+	}
+}
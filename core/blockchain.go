@@ -99,6 +99,8 @@ type BlockChain struct {
 	triegc *prque.Prque  // Priority queue mapping block numbers to tries to gc
 	gcproc time.Duration // Accumulates canonical block processing for trie dumping
 
+	deferredRoots []common.Hash // State roots of the blocks written without flushing their state
+
 	hc            *HeaderChain
 	rmLogsFeed    event.Feed
 	chainFeed     event.Feed
@@ -467,12 +469,22 @@ func (bc *BlockChain) runInterpreter(evm *vm.EVM, contract *vm.Contract, input [
 //
 // Note, this function assumes that the `mu` mutex is held!
 func (bc *BlockChain) insert(block *types.Block) {
+	bc.insertHead(block, true)
+}
+
+// insertHead injects a new head block like insert, recording it as the head in
+// the database only if persistHead is set.
+//
+// Note, this function assumes that the `mu` mutex is held!
+func (bc *BlockChain) insertHead(block *types.Block, persistHead bool) {
 	// If the block is on a side chain or an unknown one, force other heads onto it too
 	updateHeads := rawdb.ReadCanonicalHash(bc.db, block.NumberU64()) != block.Hash()
 
 	// Add the block to the canonical chain number scheme and mark as the head
 	rawdb.WriteCanonicalHash(bc.db, block.Hash(), block.NumberU64())
-	rawdb.WriteHeadBlockHash(bc.db, block.Hash())
+	if persistHead {
+		rawdb.WriteHeadBlockHash(bc.db, block.Hash())
+	}
 
 	bc.currentBlock.Store(block)
 
@@ -668,6 +680,9 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	if err := bc.FlushDeferredState(); err != nil {
+		log.Error("Failed to flush deferred state", "err", err)
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -890,6 +905,47 @@ func (bc *BlockChain) WriteBlockWithoutState(block *types.Block) (err error) {
 
 // WriteBlockWithState writes the block and all associated state to the database.
 func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.Receipt, state *state.StateDB, isSync bool) (status WriteStatus, err error) {
+	return bc.writeBlockWithState(block, receipts, state, isSync, false)
+}
+
+// WriteBlockWithDeferredState writes the block like WriteBlockWithState, but
+// on an archive node leaves flushing its state to disk to FlushDeferredState.
+// Until then the block is the head in memory only, the head recorded in the
+// database stays at the last block with persisted state.
+func (bc *BlockChain) WriteBlockWithDeferredState(block *types.Block, receipts []*types.Receipt, state *state.StateDB) (status WriteStatus, err error) {
+	return bc.writeBlockWithState(block, receipts, state, false, true)
+}
+
+// FlushDeferredState persists the state of the blocks written by
+// WriteBlockWithDeferredState and records the current block as the head in the
+// database.
+func (bc *BlockChain) FlushDeferredState() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	return bc.flushDeferredState()
+}
+
+// flushDeferredState commits the deferred state tries in the order they were
+// written. The caller must hold bc.mu.
+func (bc *BlockChain) flushDeferredState() error {
+	if len(bc.deferredRoots) == 0 {
+		return nil
+	}
+	triedb := bc.stateCache.TrieDB()
+	for i, root := range bc.deferredRoots {
+		if err := triedb.Commit(root, false); err != nil {
+			bc.deferredRoots = bc.deferredRoots[i:]
+			return err
+		}
+	}
+	bc.deferredRoots = nil
+
+	rawdb.WriteHeadBlockHash(bc.db, bc.CurrentBlock().Hash())
+	return nil
+}
+
+func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.Receipt, state *state.StateDB, isSync bool, deferState bool) (status WriteStatus, err error) {
 	bc.wg.Add(1)
 	defer bc.wg.Done()
 
@@ -940,8 +996,18 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	}
 	triedb := bc.stateCache.TrieDB()
 
-	// If we're running an archive node, always flush
-	if bc.cacheConfig.Disabled {
+	// If we're running an archive node, always flush unless asked to defer it
+	persistHead := true
+	if bc.cacheConfig.Disabled && deferState {
+		// Archive nodes never dereference, the trie stays in memory until flushed
+		bc.deferredRoots = append(bc.deferredRoots, root)
+		persistHead = false
+	} else if bc.cacheConfig.Disabled {
+		// The state of earlier blocks must not be lost behind this one
+		if err := bc.flushDeferredState(); err != nil {
+			log.Error("Flush deferred state error", "err", err)
+			return NonStatTy, err
+		}
 		if err := triedb.Commit(root, false); err != nil {
 			log.Error("Commit to triedb error", "root", root)
 			return NonStatTy, err
@@ -993,7 +1059,7 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 
 	// Set new head.
 	if status == CanonStatTy {
-		bc.insert(block)
+		bc.insertHead(block, persistHead)
 	}
 	//bc.futureBlocks.Remove(block.Hash())
 	return status, nil
@@ -1495,6 +1561,12 @@ func (bc *BlockChain) GetHeaderByNumber(number uint64) *types.Header {
 	return bc.hc.GetHeaderByNumber(number)
 }
 
+// GetHeaderRangeByNumber retrieves the canonical headers numbered from to to,
+// both inclusive, in a single database scan, caching them if found.
+func (bc *BlockChain) GetHeaderRangeByNumber(from, to uint64) []*types.Header {
+	return bc.hc.GetHeaderRangeByNumber(from, to)
+}
+
 // Config retrieves the blockchain's chain configuration.
 func (bc *BlockChain) Config() *params.ChainConfig { return bc.chainConfig }
 
@@ -14,12 +14,20 @@ var (
 	errMakeStateDB = errors.New("make StateDB error")
 )
 
+// preloadQueueSize is the number of blocks waiting for their parent state to be
+// preloaded, further blocks are not preloaded.
+const preloadQueueSize = 16
+
 type BlockChainCache struct {
 	*BlockChain
 	stateDBCache  map[common.Hash]*stateDBCache  // key is header SealHash
 	receiptsCache map[common.Hash]*receiptsCache // key is header SealHash
 	stateDBMu     sync.RWMutex
 	receiptsMu    sync.RWMutex
+
+	preloadCh   chan *types.Block        // Blocks whose parent state is to be preloaded
+	preloading  map[common.Hash]struct{} // Parent states being preloaded, guarded by stateDBMu
+	preloadOnce sync.Once
 }
 
 type stateDBCache struct {
@@ -50,6 +58,8 @@ func NewBlockChainCache(blockChain *BlockChain) *BlockChainCache {
 	pbc.BlockChain = blockChain
 	pbc.stateDBCache = make(map[common.Hash]*stateDBCache)
 	pbc.receiptsCache = make(map[common.Hash]*receiptsCache)
+	pbc.preloadCh = make(chan *types.Block, preloadQueueSize)
+	pbc.preloading = make(map[common.Hash]struct{})
 
 	return pbc
 }
@@ -124,12 +134,13 @@ func (bcc *BlockChainCache) clearReceipts(sealHash common.Hash) {
 	}
 }
 
-// Read the StateDB instance from the cache map
-func (bcc *BlockChainCache) clearStateDB(sealHash common.Hash) {
+// Read the StateDB instance from the cache map. States not cached by the worker,
+// like the preloaded ones, are evicted by the number of the new head.
+func (bcc *BlockChainCache) clearStateDB(sealHash common.Hash, number uint64) {
 	bcc.stateDBMu.Lock()
 	defer bcc.stateDBMu.Unlock()
 
-	var blockNum uint64
+	blockNum := number
 	if obj, exist := bcc.stateDBCache[sealHash]; exist {
 		blockNum = obj.blockNum
 		//delete(pbc.stateDBCache, sealHash)
@@ -162,5 +173,83 @@ func (bcc *BlockChainCache) MakeStateDB(block *types.Block) (*state.StateDB, err
 func (bcc *BlockChainCache) ClearCache(block *types.Block) {
 	sealHash := bcc.Engine().SealHash(block.Header())
 	bcc.clearReceipts(sealHash)
-	bcc.clearStateDB(sealHash)
+	bcc.clearStateDB(sealHash, block.NumberU64())
+}
+
+// Preload warms the cache with the state a block is executed on, loading the
+// accounts touched by its transactions in the background. The blocks are
+// preloaded one at a time, blocks arriving while the queue is full are skipped.
+func (bcc *BlockChainCache) Preload(block *types.Block) {
+	bcc.preloadOnce.Do(func() { go bcc.preloadLoop() })
+
+	select {
+	case bcc.preloadCh <- block:
+	default:
+		log.Debug("Preload skipped, queue full", "hash", block.Hash(), "number", block.NumberU64())
+	}
+}
+
+// preloadLoop preloads the queued blocks until the chain stops.
+func (bcc *BlockChainCache) preloadLoop() {
+	for {
+		select {
+		case block := <-bcc.preloadCh:
+			bcc.preload(block)
+		case <-bcc.quit:
+			return
+		}
+	}
+}
+
+func (bcc *BlockChainCache) preload(block *types.Block) {
+	if block.NumberU64() == 0 {
+		return
+	}
+	parent := bcc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		log.Debug("Preload skipped, unknown parent", "hash", block.Hash(), "number", block.NumberU64())
+		return
+	}
+	// Claim the parent state, unless it is cached or being preloaded already
+	sealHash := parent.Header().SealHash()
+	bcc.stateDBMu.Lock()
+	_, exist := bcc.stateDBCache[sealHash]
+	_, busy := bcc.preloading[sealHash]
+	if exist || busy {
+		bcc.stateDBMu.Unlock()
+		return
+	}
+	bcc.preloading[sealHash] = struct{}{}
+	bcc.stateDBMu.Unlock()
+
+	state, err := bcc.StateAt(parent.Root())
+	if err == nil {
+		signer := types.MakeSigner(bcc.Config())
+		for _, tx := range block.Transactions() {
+			if from, err := types.Sender(signer, tx); err == nil {
+				state.GetNonce(from)
+			}
+			if to := tx.To(); to != nil {
+				state.GetCode(*to)
+			}
+		}
+	}
+	bcc.stateDBMu.Lock()
+	defer bcc.stateDBMu.Unlock()
+
+	delete(bcc.preloading, sealHash)
+	if err != nil {
+		log.Debug("Preload failed", "hash", block.Hash(), "number", block.NumberU64(), "err", err)
+		return
+	}
+	// The chain may have moved past the parent while it was loading, its
+	// cached states being evicted already
+	if head := bcc.CurrentBlock().NumberU64(); parent.NumberU64() < head {
+		log.Debug("Preload discarded, stale parent", "hash", block.Hash(), "number", block.NumberU64(), "head", head)
+		return
+	}
+	if _, exist := bcc.stateDBCache[sealHash]; !exist {
+		bcc.stateDBCache[sealHash] = &stateDBCache{stateDB: state, blockNum: parent.NumberU64()}
+		log.Debug("Preloaded block state", "hash", block.Hash(), "number", block.NumberU64(), "parent", parent.Hash())
+	}
 }
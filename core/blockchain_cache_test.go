@@ -0,0 +1,55 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/core/vm"
+	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/params"
+)
+
+// Tests that preloading caches the parent state of a block once, and that the
+// preloaded states are evicted by a new head even though no block was sealed
+// on them.
+func TestPreloadParentState(t *testing.T) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{{1}: {Balance: big.NewInt(1)}}}
+		genesis = gspec.MustCommit(db)
+	)
+	chain, _, err := NewBlockChain(db, nil, &CacheConfig{Disabled: true}, gspec.Config, nil, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+	cache := NewBlockChainCache(chain)
+
+	block := types.NewBlock(&types.Header{ParentHash: genesis.Hash(), Number: common.Big1, Time: common.Big1}, nil, nil)
+	sealHash := genesis.Header().SealHash()
+
+	cache.preload(block)
+	state := cache.ReadStateDB(sealHash)
+	if state == nil {
+		t.Fatalf("parent state not preloaded")
+	}
+	if balance := state.GetBalance(common.Address{1}); balance.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("preloaded balance mismatch: have %v, want %v", balance, 1)
+	}
+	// Preloading again keeps the cached state
+	cached := cache.stateDBCache[sealHash].stateDB
+	cache.preload(block)
+	if cache.stateDBCache[sealHash].stateDB != cached {
+		t.Errorf("preloaded state replaced")
+	}
+	if len(cache.preloading) != 0 {
+		t.Errorf("preloads left in flight: %d", len(cache.preloading))
+	}
+	// A new head not sealed locally evicts the preloaded state
+	cache.clearStateDB(block.Header().SealHash(), block.NumberU64())
+	if cache.ReadStateDB(sealHash) != nil {
+		t.Errorf("preloaded state not evicted")
+	}
+}
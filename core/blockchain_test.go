@@ -0,0 +1,89 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/core/rawdb"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/core/vm"
+	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/params"
+)
+
+// Tests that an archive node only records a block as the head in the database
+// once its deferred state is flushed.
+func TestWriteBlockWithDeferredState(t *testing.T) {
+	var (
+		db      = ethdb.NewMemDatabase()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{{1}: {Balance: big.NewInt(1)}}}
+		genesis = gspec.MustCommit(db)
+	)
+	chain, _, err := NewBlockChain(db, nil, &CacheConfig{Disabled: true}, gspec.Config, nil, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	parent := genesis
+	write := func(deferState bool) *types.Block {
+		statedb, err := chain.StateAt(parent.Root())
+		if err != nil {
+			t.Fatalf("failed to open state of block %d: %v", parent.NumberU64(), err)
+		}
+		statedb.AddBalance(common.Address{1}, big.NewInt(1))
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			GasLimit:   parent.GasLimit(),
+			Time:       new(big.Int).Add(parent.Time(), common.Big1),
+			Root:       statedb.IntermediateRoot(true),
+		}
+		block := types.NewBlock(header, nil, nil)
+		if deferState {
+			_, err = chain.WriteBlockWithDeferredState(block, nil, statedb)
+		} else {
+			_, err = chain.WriteBlockWithState(block, nil, statedb, false)
+		}
+		if err != nil {
+			t.Fatalf("failed to write block %d: %v", block.NumberU64(), err)
+		}
+		parent = block
+		return block
+	}
+	persisted := func(block *types.Block) bool {
+		has, _ := db.Has(block.Root().Bytes())
+		return has
+	}
+
+	// Deferred blocks become the head in memory only
+	first, second := write(true), write(true)
+	if head := chain.CurrentBlock().Hash(); head != second.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head, second.Hash())
+	}
+	if head := rawdb.ReadHeadBlockHash(db); head != genesis.Hash() {
+		t.Fatalf("persisted head mismatch before flush: have %x, want %x", head, genesis.Hash())
+	}
+	if persisted(first) || persisted(second) {
+		t.Fatalf("deferred state persisted before flush")
+	}
+	if err := chain.FlushDeferredState(); err != nil {
+		t.Fatalf("failed to flush deferred state: %v", err)
+	}
+	if head := rawdb.ReadHeadBlockHash(db); head != second.Hash() {
+		t.Fatalf("persisted head mismatch after flush: have %x, want %x", head, second.Hash())
+	}
+	if !persisted(first) || !persisted(second) {
+		t.Fatalf("deferred state not persisted by flush")
+	}
+
+	// Writing a block with its state flushes the deferred ones before it
+	third, fourth := write(true), write(false)
+	if head := rawdb.ReadHeadBlockHash(db); head != fourth.Hash() {
+		t.Fatalf("persisted head mismatch: have %x, want %x", head, fourth.Hash())
+	}
+	if !persisted(third) || !persisted(fourth) {
+		t.Fatalf("deferred state not persisted by a regular write")
+	}
+}
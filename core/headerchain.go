@@ -390,6 +390,17 @@ func (hc *HeaderChain) GetHeaderByNumber(number uint64) *types.Header {
 	return hc.GetHeader(hash, number)
 }
 
+// GetHeaderRangeByNumber retrieves the canonical headers numbered from to to,
+// both inclusive, in a single database scan, caching them (associated with their
+// hashes). The range is cut at the first missing header.
+func (hc *HeaderChain) GetHeaderRangeByNumber(from, to uint64) []*types.Header {
+	headers := rawdb.ReadCanonicalHeaders(hc.chainDb, from, to)
+	for _, header := range headers {
+		hc.headerCache.Add(header.Hash(), header)
+	}
+	return headers
+}
+
 // CurrentHeader retrieves the current head header of the canonical chain. The
 // header is retrieved from the HeaderChain's internal cache.
 func (hc *HeaderChain) CurrentHeader() *types.Header {
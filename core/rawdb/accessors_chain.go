@@ -19,6 +19,7 @@ package rawdb
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
 	"math/big"
 	"sync"
 
@@ -187,6 +188,76 @@ func ReadHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Heade
 	return header
 }
 
+// ReadCanonicalHeaders retrieves the canonical headers numbered from to to, both
+// inclusive, cut at the first missing one. Databases supporting range iteration
+// are read in a single scan, others one header at a time.
+func ReadCanonicalHeaders(db DatabaseReader, from, to uint64) []*types.Header {
+	if from > to {
+		return nil
+	}
+	rdb, ok := db.(DatabaseRangeReader)
+	if !ok {
+		var headers []*types.Header
+		for number := from; number <= to; number++ {
+			header := ReadHeader(db, ReadCanonicalHash(db, number), number)
+			if header == nil {
+				break
+			}
+			headers = append(headers, header)
+		}
+		return headers
+	}
+	limit := append(headerPrefix, encodeBlockNumber(to+1)...)
+	if to == math.MaxUint64 {
+		limit = []byte{headerPrefix[0] + 1}
+	}
+	it := rdb.NewIteratorWithRange(append(headerPrefix, encodeBlockNumber(from)...), limit)
+	defer it.Release()
+
+	// The headers of a number, canonical or not, are gathered together with the
+	// canonical hash, which may be ordered anywhere among them
+	var (
+		headers   []*types.Header
+		number    = from
+		canonical common.Hash
+		blobs     = make(map[common.Hash][]byte)
+	)
+	resolve := func() bool {
+		data, ok := blobs[canonical]
+		if !ok {
+			return false
+		}
+		header := new(types.Header)
+		if err := rlp.Decode(bytes.NewReader(data), header); err != nil {
+			log.Error("Invalid block header RLP", "hash", canonical, "err", err)
+			return false
+		}
+		headers = append(headers, header)
+		return true
+	}
+	for it.Next() {
+		key := it.Key()
+		if len(key) < len(headerPrefix)+8 {
+			continue
+		}
+		if n := binary.BigEndian.Uint64(key[len(headerPrefix):]); n != number {
+			if !resolve() || n != number+1 {
+				return headers
+			}
+			number, canonical = n, common.Hash{}
+			blobs = make(map[common.Hash][]byte)
+		}
+		switch suffix := key[len(headerPrefix)+8:]; {
+		case bytes.Equal(suffix, headerHashSuffix):
+			canonical = common.BytesToHash(it.Value())
+		case len(suffix) == common.HashLength:
+			blobs[common.BytesToHash(suffix)] = common.CopyBytes(it.Value())
+		}
+	}
+	resolve()
+	return headers
+}
+
 // WriteHeader stores a block header into the database and also stores the hash-
 // to-number mapping.
 func WriteHeader(db DatabaseWriter, header *types.Header) {
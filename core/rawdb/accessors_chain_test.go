@@ -18,7 +18,9 @@ package rawdb
 
 import (
 	"bytes"
+	"io/ioutil"
 	"math/big"
+	"os"
 	"testing"
 
 	"github.com/Venachain/Venachain/common"
@@ -204,6 +206,59 @@ func TestCanonicalMappingStorage(t *testing.T) {
 	}
 }
 
+// Tests that canonical header ranges are read alike from databases with and
+// without range iteration, skipping side chain headers and stopping at gaps.
+func TestReadCanonicalHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rawdb-headers")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ldb, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer ldb.Close()
+
+	for _, db := range []ethdb.Database{ethdb.NewMemDatabase(), ldb} {
+		// Store canonical headers 0-9 without 6, with a side chain header at each
+		for i := int64(0); i < 10; i++ {
+			side := &types.Header{Number: big.NewInt(i), Extra: []byte("side")}
+			WriteHeader(db, side)
+			if i == 6 {
+				continue
+			}
+			header := &types.Header{Number: big.NewInt(i)}
+			WriteHeader(db, header)
+			WriteCanonicalHash(db, header.Hash(), uint64(i))
+		}
+		tests := []struct {
+			from, to uint64
+			want     int
+		}{
+			{0, 5, 6},
+			{2, 4, 3},
+			{3, 8, 3},
+			{6, 9, 0},
+			{7, 20, 3},
+			{5, 4, 0},
+		}
+		for i, tt := range tests {
+			headers := ReadCanonicalHeaders(db, tt.from, tt.to)
+			if len(headers) != tt.want {
+				t.Errorf("%T test %d: header count mismatch: have %d, want %d", db, i, len(headers), tt.want)
+				continue
+			}
+			for j, header := range headers {
+				if number := header.Number.Uint64(); number != tt.from+uint64(j) || len(header.Extra) != 0 {
+					t.Errorf("%T test %d: header %d mismatch: number %d, extra %q", db, i, j, number, header.Extra)
+				}
+			}
+		}
+	}
+}
+
 // Tests that head headers and head blocks can be assigned, individually.
 func TestHeadStorage(t *testing.T) {
 	db := ethdb.NewMemDatabase()
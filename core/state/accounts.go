@@ -0,0 +1,124 @@
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/rlp"
+	"github.com/Venachain/Venachain/trie"
+)
+
+// AccountInfo is the summary of an account reported by ForEachAccount. The
+// name Account is taken by the consensus encoding of accounts.
+type AccountInfo struct {
+	Balance     *big.Int
+	Nonce       uint64
+	CodeHash    common.Hash
+	StorageRoot common.Hash
+}
+
+func newAccountInfo(data Account) AccountInfo {
+	return AccountInfo{
+		Balance:     new(big.Int).Set(data.Balance),
+		Nonce:       data.Nonce,
+		CodeHash:    common.BytesToHash(data.CodeHash),
+		StorageRoot: data.Root,
+	}
+}
+
+// liveAccount is an account of the in-memory state, positioned by the hash of
+// its address like the entries of the account trie.
+type liveAccount struct {
+	addr    common.Address
+	hash    common.Hash
+	info    AccountInfo
+	deleted bool
+}
+
+// ForEachAccount calls fn for every account of the state, merging the accounts
+// modified in memory with the committed account trie, until fn returns false.
+// Accounts are visited in the order of their address hashes.
+//
+// The iteration runs over the state as it was when ForEachAccount was called:
+// accounts created, modified or deleted by fn are not reflected.
+func (self *StateDB) ForEachAccount(fn func(common.Address, AccountInfo) bool) {
+	self.ForEachAccountFrom(common.Hash{}, fn)
+}
+
+// ForEachAccountFrom is like ForEachAccount but starts at the first account
+// whose address hash is not less than start, which allows resuming an earlier
+// iteration.
+func (self *StateDB) ForEachAccountFrom(start common.Hash, fn func(common.Address, AccountInfo) bool) {
+	// Snapshot the in-memory accounts, sorted in trie order
+	live := make([]*liveAccount, 0, len(self.stateObjects))
+	byHash := make(map[common.Hash]*liveAccount, len(self.stateObjects))
+	for addr, obj := range self.stateObjects {
+		if bytes.Compare(obj.addrHash[:], start[:]) < 0 {
+			continue
+		}
+		acc := &liveAccount{
+			addr:    addr,
+			hash:    obj.addrHash,
+			info:    newAccountInfo(obj.data),
+			deleted: obj.deleted || obj.suicided,
+		}
+		live = append(live, acc)
+		byHash[obj.addrHash] = acc
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return bytes.Compare(live[i].hash[:], live[j].hash[:]) < 0
+	})
+	// flush visits the in-memory accounts positioned before the given hash
+	flush := func(limit []byte) bool {
+		for len(live) > 0 && (limit == nil || bytes.Compare(live[0].hash[:], limit) < 0) {
+			acc := live[0]
+			live = live[1:]
+			if acc.deleted {
+				continue
+			}
+			if !fn(acc.addr, acc.info) {
+				return false
+			}
+		}
+		return true
+	}
+	// Walk a copy of the trie so fn may modify the state
+	tr := self.db.CopyTrie(self.trie)
+	it := trie.NewIterator(tr.NodeIterator(start[:]))
+	for it.Next() {
+		if !flush(it.Key) {
+			return
+		}
+		// Match in-memory accounts by hash, the preimages of accounts not
+		// yet committed are not in the database
+		if acc, ok := byHash[common.BytesToHash(it.Key)]; ok {
+			// The in-memory version takes precedence over the trie entry
+			if len(live) > 0 && live[0] == acc {
+				live = live[1:]
+				if !acc.deleted && !fn(acc.addr, acc.info) {
+					return
+				}
+			}
+			continue
+		}
+		addr := common.BytesToAddress(tr.GetKey(it.Key))
+		var data Account
+		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
+			self.setError(err)
+			return
+		}
+		if data.Balance == nil {
+			data.Balance = new(big.Int)
+		}
+		if !fn(addr, newAccountInfo(data)) {
+			return
+		}
+	}
+	if it.Err != nil {
+		self.setError(it.Err)
+		return
+	}
+	flush(nil)
+}
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"sync"
+
+	"github.com/Venachain/Venachain/common"
+)
+
+// commitPartitions is the number of partitions CommitParallel splits the dirty
+// accounts into, one per leading address nibble.
+const commitPartitions = 16
+
+// CommitParallel is like Commit but commits the storage tries of the dirty
+// accounts concurrently, one goroutine per partition of accounts sharing the
+// leading nibble of their address. The account trie is committed once all
+// storage tries are, so the root is the same as the one of Commit.
+//
+// Like Commit, the trie nodes are written to the trie database, which flushes
+// them to disk in a single batch on its own commit.
+func (s *StateDB) CommitParallel(deleteEmptyObjects bool) (common.Hash, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	defer s.clearJournalAndRefund()
+
+	for addr := range s.journal.dirties {
+		s.stateObjectsDirty[addr] = struct{}{}
+	}
+	var partitions [commitPartitions][]*stateObject
+	for addr, stateObject := range s.stateObjects {
+		_, isDirty := s.stateObjectsDirty[addr]
+		switch {
+		case stateObject.suicided || (isDirty && deleteEmptyObjects && stateObject.empty()):
+			s.deleteStateObject(stateObject)
+		case isDirty:
+			if stateObject.code != nil && stateObject.dirtyCode {
+				s.db.TrieDB().InsertBlob(common.BytesToHash(stateObject.CodeHash()), stateObject.code)
+				stateObject.dirtyCode = false
+			}
+			part := addr[0] >> 4
+			partitions[part] = append(partitions[part], stateObject)
+		}
+		delete(s.stateObjectsDirty, addr)
+	}
+	// Commit the storage tries of every partition concurrently. The trie
+	// database is safe for concurrent use and objects are not shared.
+	var (
+		wg   sync.WaitGroup
+		errs [commitPartitions]error
+	)
+	for i := range partitions {
+		if len(partitions[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, stateObject := range partitions[i] {
+				if err := stateObject.CommitTrie(s.db); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range partitions {
+		if errs[i] != nil {
+			return common.Hash{}, errs[i]
+		}
+		for _, stateObject := range partitions[i] {
+			s.updateStateObject(stateObject)
+		}
+	}
+	return s.commitAccountTrie()
+}
@@ -64,6 +64,7 @@ type Database interface {
 // Trie is a Ethereum Merkle Trie.
 type Trie interface {
 	TryGet(key []byte) ([]byte, error)
+	TryHas(key []byte) (bool, error)
 	TryUpdate(key, value []byte) error
 	TryUpdateValue(key, value []byte) error
 	TryDelete(key []byte) error
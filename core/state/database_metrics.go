@@ -0,0 +1,71 @@
+package state
+
+import (
+	"time"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/metrics"
+	"github.com/Venachain/Venachain/trie"
+)
+
+// metricsDB is a Database reporting trie node cache efficiency and state
+// commits to a metrics registry.
+type metricsDB struct {
+	Database
+
+	commits        metrics.Counter
+	commitDuration metrics.Histogram
+}
+
+// DatabaseWithMetrics wraps db to report to reg:
+//
+//	trie/node/hits      node lookups served from the trie database memory cache
+//	trie/node/misses    node lookups falling through to the persistent database
+//	commits             commits of account tries opened through the wrapper
+//	commit_duration_ms  duration of these commits in milliseconds
+//
+// Like all metrics, nothing is recorded unless metrics are enabled.
+func DatabaseWithMetrics(db Database, reg metrics.Registry) Database {
+	db.TrieDB().MeterNodeCache(
+		metrics.GetOrRegisterCounter("trie/node/hits", reg),
+		metrics.GetOrRegisterCounter("trie/node/misses", reg),
+	)
+	return &metricsDB{
+		Database:       db,
+		commits:        metrics.GetOrRegisterCounter("commits", reg),
+		commitDuration: metrics.GetOrRegisterHistogram("commit_duration_ms", reg, metrics.NewExpDecaySample(1028, 0.015)),
+	}
+}
+
+// OpenTrie opens the main account trie, timing its commits.
+func (db *metricsDB) OpenTrie(root common.Hash) (Trie, error) {
+	tr, err := db.Database.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	return meteredTrie{tr, db}, nil
+}
+
+// CopyTrie returns an independent copy of the given trie.
+func (db *metricsDB) CopyTrie(t Trie) Trie {
+	if mt, ok := t.(meteredTrie); ok {
+		return meteredTrie{db.Database.CopyTrie(mt.Trie), db}
+	}
+	return db.Database.CopyTrie(t)
+}
+
+// meteredTrie reports its commits to a metricsDB.
+type meteredTrie struct {
+	Trie
+	db *metricsDB
+}
+
+func (m meteredTrie) Commit(onleaf trie.LeafCallback) (common.Hash, error) {
+	start := time.Now()
+	root, err := m.Trie.Commit(onleaf)
+	if err == nil {
+		m.db.commits.Inc(1)
+		m.db.commitDuration.Update(int64(time.Since(start) / time.Millisecond))
+	}
+	return root, err
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/metrics"
+)
+
+func TestDatabaseWithMetrics(t *testing.T) {
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+
+	reg := metrics.NewRegistry()
+	db := DatabaseWithMetrics(NewDatabase(ethdb.NewMemDatabase()), reg)
+
+	var (
+		hits     = reg.Get("trie/node/hits").(metrics.Counter)
+		misses   = reg.Get("trie/node/misses").(metrics.Counter)
+		commits  = reg.Get("commits").(metrics.Counter)
+		duration = reg.Get("commit_duration_ms").(metrics.Histogram)
+	)
+	sdb, _ := New(common.Hash{}, db)
+	for i := byte(1); i <= 20; i++ {
+		sdb.SetBalance(common.BytesToAddress([]byte{i}), big.NewInt(int64(i)))
+	}
+	root, err := sdb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if commits.Count() != 1 || duration.Count() != 1 {
+		t.Errorf("commit metrics mismatch: have %d commits, %d durations, want 1", commits.Count(), duration.Count())
+	}
+
+	// The fresh root is held in the memory cache
+	hit, miss := hits.Count(), misses.Count()
+	if _, err := db.TrieDB().Node(root); err != nil {
+		t.Fatalf("failed to retrieve cached root: %v", err)
+	}
+	if hits.Count() != hit+1 || misses.Count() != miss {
+		t.Errorf("cached lookup: have %d hits, %d misses, want %d, %d", hits.Count(), misses.Count(), hit+1, miss)
+	}
+
+	// Once flushed it has to be loaded from disk
+	if err := db.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to flush trie: %v", err)
+	}
+	hit, miss = hits.Count(), misses.Count()
+	if _, err := db.TrieDB().Node(root); err != nil {
+		t.Fatalf("failed to retrieve flushed root: %v", err)
+	}
+	if hits.Count() != hit || misses.Count() != miss+1 {
+		t.Errorf("disk lookup: have %d hits, %d misses, want %d, %d", hits.Count(), misses.Count(), hit, miss+1)
+	}
+
+	// Copies of metered tries keep reporting commits
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		t.Fatalf("failed to open trie: %v", err)
+	}
+	cpy := db.CopyTrie(tr)
+	cpy.TryUpdate([]byte("key"), []byte("value"))
+	if _, err := cpy.Commit(nil); err != nil {
+		t.Fatalf("failed to commit copy: %v", err)
+	}
+	if commits.Count() != 2 {
+		t.Errorf("commits of copied trie not reported: have %d, want 2", commits.Count())
+	}
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/crypto"
+	"github.com/Venachain/Venachain/rlp"
+	"github.com/Venachain/Venachain/trie"
+)
+
+// StorageDiff compares the storage of addr between the states rooted at rootA
+// and rootB. Slots only present in rootB are reported as added, slots present in
+// both with different values as changed (holding the rootB value) and slots only
+// present in rootA as removed (holding the rootA value). Keys are the storage keys
+// as passed to SetState.
+//
+// Only the trie nodes that differ between the two storage tries are visited, so
+// the cost is proportional to the size of the difference rather than the storage.
+func StorageDiff(db Database, rootA, rootB common.Hash, addr common.Address) (added, changed, removed map[string][]byte, err error) {
+	trA, err := openStorageTrie(db, rootA, addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	trB, err := openStorageTrie(db, rootB, addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	added = make(map[string][]byte)
+	changed = make(map[string][]byte)
+	removed = make(map[string][]byte)
+
+	prefix := addr.String()
+
+	// Leaves reachable in B but not in A are either new or modified slots
+	diff, _ := trie.NewDifferenceIterator(trA.NodeIterator(nil), trB.NodeIterator(nil))
+	it := trie.NewIterator(diff)
+	for it.Next() {
+		key := trB.GetKey(it.Key)
+		value, err := storageValue(trB, it.Value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if enc, _ := trA.TryGet(key); len(enc) > 0 {
+			changed[strings.TrimPrefix(string(key), prefix)] = value
+		} else {
+			added[strings.TrimPrefix(string(key), prefix)] = value
+		}
+	}
+	if it.Err != nil {
+		return nil, nil, nil, it.Err
+	}
+	// Leaves reachable in A but not in B are removed unless already seen as changed
+	diff, _ = trie.NewDifferenceIterator(trB.NodeIterator(nil), trA.NodeIterator(nil))
+	it = trie.NewIterator(diff)
+	for it.Next() {
+		key := trA.GetKey(it.Key)
+		if enc, _ := trB.TryGet(key); len(enc) > 0 {
+			continue
+		}
+		value, err := storageValue(trA, it.Value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		removed[strings.TrimPrefix(string(key), prefix)] = value
+	}
+	if it.Err != nil {
+		return nil, nil, nil, it.Err
+	}
+	return added, changed, removed, nil
+}
+
+// openStorageTrie opens the storage trie of addr in the state rooted at root. A
+// missing account yields an empty storage trie.
+func openStorageTrie(db Database, root common.Hash, addr common.Address) (Trie, error) {
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	var data Account
+	enc, err := tr.TryGet(addr[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(enc) > 0 {
+		if err := rlp.DecodeBytes(enc, &data); err != nil {
+			return nil, err
+		}
+	}
+	return db.OpenStorageTrie(crypto.Keccak256Hash(addr[:]), data.Root)
+}
+
+// storageValue resolves the value referenced by an encoded storage trie leaf.
+func storageValue(tr Trie, enc []byte) ([]byte, error) {
+	_, content, _, err := rlp.Split(enc)
+	if err != nil {
+		return nil, err
+	}
+	var valueKey common.Hash
+	valueKey.SetBytes(content)
+	return tr.GetKey(valueKey.Bytes()), nil
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/log"
 	"github.com/Venachain/Venachain/rlp"
 	"github.com/Venachain/Venachain/trie"
 )
@@ -52,25 +53,59 @@ func (self *StateDB) RawDump() Dump {
 		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
 			panic(err)
 		}
+		dump.Accounts[common.Bytes2Hex(addr)] = self.dumpAccount(common.BytesToAddress(addr), data)
+	}
+	return dump
+}
 
-		obj := newObject(nil, common.BytesToAddress(addr), data)
-		account := DumpAccount{
-			Balance:  data.Balance.String(),
-			Nonce:    data.Nonce,
-			Root:     common.Bytes2Hex(data.Root[:]),
-			CodeHash: common.Bytes2Hex(data.CodeHash),
-			Code:     common.Bytes2Hex(obj.Code(self.db)),
-			Storage:  make(map[string]string),
+// RawDumpFiltered is like RawDump but only includes the given accounts. Accounts
+// missing from the state are skipped, as are the ones failing to load, which
+// are logged.
+func (self *StateDB) RawDumpFiltered(addrs []common.Address) Dump {
+	dump := Dump{
+		Root:     fmt.Sprintf("%x", self.trie.Hash()),
+		Accounts: make(map[string]DumpAccount),
+	}
+
+	for _, addr := range addrs {
+		enc, err := self.trie.TryGet(addr[:])
+		if err != nil {
+			log.Error("Failed to load account for state dump", "address", addr, "err", err)
+			continue
 		}
-		storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
-		for storageIt.Next() {
-			account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+		if len(enc) == 0 {
+			continue
 		}
-		dump.Accounts[common.Bytes2Hex(addr)] = account
+		var data Account
+		if err := rlp.DecodeBytes(enc, &data); err != nil {
+			log.Error("Failed to decode account for state dump", "address", addr, "err", err)
+			continue
+		}
+		dump.Accounts[common.Bytes2Hex(addr[:])] = self.dumpAccount(addr, data)
 	}
 	return dump
 }
 
+func (self *StateDB) dumpAccount(addr common.Address, data Account) DumpAccount {
+	obj := newObject(nil, addr, data)
+	account := DumpAccount{
+		Balance:  data.Balance.String(),
+		Nonce:    data.Nonce,
+		Root:     common.Bytes2Hex(data.Root[:]),
+		CodeHash: common.Bytes2Hex(data.CodeHash),
+		Code:     common.Bytes2Hex(obj.Code(self.db)),
+		Storage:  make(map[string]string),
+	}
+	storageIt := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
+	for storageIt.Next() {
+		account.Storage[common.Bytes2Hex(self.trie.GetKey(storageIt.Key))] = common.Bytes2Hex(storageIt.Value)
+	}
+	if storageIt.Err != nil {
+		log.Error("Failed to iterate storage for state dump", "address", addr, "err", storageIt.Err)
+	}
+	return account
+}
+
 func (self *StateDB) Dump() []byte {
 	json, err := json.MarshalIndent(self.RawDump(), "", "    ")
 	if err != nil {
@@ -79,3 +114,14 @@ func (self *StateDB) Dump() []byte {
 
 	return json
 }
+
+// DumpFiltered returns the JSON encoding of the given accounts in the same
+// format as Dump.
+func (self *StateDB) DumpFiltered(addrs []common.Address) []byte {
+	json, err := json.MarshalIndent(self.RawDumpFiltered(addrs), "", "    ")
+	if err != nil {
+		fmt.Println("dump err", err)
+	}
+
+	return json
+}
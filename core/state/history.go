@@ -0,0 +1,52 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/trie"
+)
+
+// ErrStatePruned is returned when reading a historical state whose trie nodes
+// are no longer in the database.
+var ErrStatePruned = errors.New("historical state pruned")
+
+// GetStateAtRoot reads the storage slot key of addr in the state rooted at
+// stateRoot, without loading the state. Keys are the storage keys as passed to
+// SetState. A slot that does not exist, including one of a missing account,
+// yields nil.
+//
+// Older states are only available if their trie nodes were kept (archive mode),
+// reading a pruned state fails with ErrStatePruned.
+func GetStateAtRoot(db Database, stateRoot common.Hash, addr common.Address, key []byte) ([]byte, error) {
+	tr, err := openStorageTrie(db, stateRoot, addr)
+	if err != nil {
+		return nil, prunedError(stateRoot, err)
+	}
+	keyTrie, _, _ := getKeyValue(addr, key, nil)
+	enc, err := tr.TryGet([]byte(keyTrie))
+	if err != nil {
+		return nil, prunedError(stateRoot, err)
+	}
+	if len(enc) == 0 {
+		return nil, nil
+	}
+	value, err := storageValue(tr, enc)
+	if err != nil {
+		return nil, err
+	}
+	// The slot exists, so an empty preimage is an explicitly empty value
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// prunedError flags the missing trie nodes of the state rooted at root.
+func prunedError(root common.Hash, err error) error {
+	if _, ok := err.(*trie.MissingNodeError); ok {
+		return fmt.Errorf("%w: state %x: %v", ErrStatePruned, root, err)
+	}
+	return err
+}
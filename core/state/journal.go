@@ -72,6 +72,53 @@ func (j *journal) revert(statedb *StateDB, snapshot int) {
 	j.entries = j.entries[:snapshot]
 }
 
+// compact merges redundant entries journalled since index from, keeping the
+// dirty counters in line with the remaining entries.
+func (j *journal) compact(from int) {
+	tail := j.entries[from:]
+	for _, entry := range tail {
+		if addr := entry.dirtied(); addr != nil {
+			if j.dirties[*addr]--; j.dirties[*addr] == 0 {
+				delete(j.dirties, *addr)
+			}
+		}
+	}
+	tail = JournalCompactor{}.Compact(tail)
+	for _, entry := range tail {
+		if addr := entry.dirtied(); addr != nil {
+			j.dirties[*addr]++
+		}
+	}
+	j.entries = j.entries[:from+len(tail)]
+}
+
+// JournalCompactor shrinks runs of journal entries without changing the state
+// that reverting them restores.
+type JournalCompactor struct{}
+
+// Compact merges consecutive storage changes of the same account and key into
+// the oldest of them, as its previous value is the one a revert ends up with.
+// The entries are compacted in place and the shortened slice is returned.
+func (JournalCompactor) Compact(entries []journalEntry) []journalEntry {
+	if len(entries) < 2 {
+		return entries
+	}
+	n := 1
+	for _, entry := range entries[1:] {
+		if ch, ok := entry.(storageChange); ok {
+			if last, ok := entries[n-1].(storageChange); ok && *last.account == *ch.account && last.key == ch.key {
+				continue
+			}
+		}
+		entries[n] = entry
+		n++
+	}
+	for i := n; i < len(entries); i++ {
+		entries[i] = nil
+	}
+	return entries[:n]
+}
+
 // dirty explicitly sets an address to dirty, even if the change entries would
 // otherwise suggest it as clean. This method is an ugly hack to handle the RIPEMD
 // precompile consensus exception.
@@ -0,0 +1,114 @@
+package state
+
+import (
+	"sync"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/log"
+	"github.com/Venachain/Venachain/rlp"
+)
+
+// prefetchPartitions is the number of partitions PrefetchAccounts splits the
+// accounts into, one per leading address nibble.
+const prefetchPartitions = 16
+
+// PrefetchAccounts loads the given accounts into the state ahead of their use,
+// so the balance, nonce and other account reads that follow are served from
+// memory. The accounts are read from the account trie concurrently, each
+// partition of accounts sharing the leading nibble of their address on its own
+// copy of the trie, and added to the state once all reads are done. Accounts
+// already loaded or missing from the trie are left alone.
+func (s *StateDB) PrefetchAccounts(addrs []common.Address) {
+	s.loadAccounts(addrs)
+}
+
+// ExistBatch reports for each of the given addresses whether the account exists
+// in the state, the same as calling Exist for each of them. The accounts not
+// loaded yet are read from the trie together as by PrefetchAccounts, and each
+// account only once however often it is listed.
+func (s *StateDB) ExistBatch(addrs []common.Address) []bool {
+	missing := s.loadAccounts(addrs)
+
+	exist := make([]bool, len(addrs))
+	for i, addr := range addrs {
+		if _, ok := missing[addr]; ok {
+			if s.witness != nil {
+				s.witness.addAccount(addr)
+			}
+			continue
+		}
+		exist[i] = s.getStateObject(addr) != nil
+	}
+	return exist
+}
+
+// loadAccounts reads the given accounts not loaded yet from the account trie
+// into the state, returning the ones known to be missing from the trie.
+func (s *StateDB) loadAccounts(addrs []common.Address) map[common.Address]struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		partitions [prefetchPartitions][]common.Address
+		seen       = make(map[common.Address]struct{}, len(addrs))
+	)
+	for _, addr := range addrs {
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+		if s.stateObjects[addr] != nil {
+			continue
+		}
+		part := addr[0] >> 4
+		partitions[part] = append(partitions[part], addr)
+	}
+	var (
+		wg   sync.WaitGroup
+		encs [prefetchPartitions][][]byte
+		errs [prefetchPartitions]error
+	)
+	for i := range partitions {
+		if len(partitions[i]) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, tr Trie) {
+			defer wg.Done()
+			encs[i] = make([][]byte, len(partitions[i]))
+			for j, addr := range partitions[i] {
+				enc, err := tr.TryGet(addr[:])
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				encs[i][j] = enc
+			}
+		}(i, s.db.CopyTrie(s.trie))
+	}
+	wg.Wait()
+
+	missing := make(map[common.Address]struct{})
+	for i := range partitions {
+		if errs[i] != nil {
+			// Leave the accounts to be loaded, and the error reported, on use
+			continue
+		}
+		for j, addr := range partitions[i] {
+			if len(encs[i][j]) == 0 {
+				missing[addr] = struct{}{}
+				continue
+			}
+			var data Account
+			if err := rlp.DecodeBytes(encs[i][j], &data); err != nil {
+				log.Error("Failed to decode state object", "addr", addr, "err", err)
+				continue
+			}
+			if s.witness != nil {
+				s.witness.addAccount(addr)
+			}
+			s.setStateObject(newObject(s, addr, data))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,39 @@
+package state
+
+import (
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/crypto"
+)
+
+// proofList collects the nodes of a trie proof, from the root down.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
+// StorageProof returns the Merkle proof of the account addr in the account trie
+// and the proof of its storage slot key in its storage trie. Keys are the
+// storage keys as passed to SetState. Both are proofs of the secure tries, so
+// they verify against the hashed account address and storage trie key.
+//
+// The proofs are built from the tries as of the last IntermediateRoot or
+// Commit. A missing account yields the proof of its absence from the account
+// trie and no storage proof.
+func (self *StateDB) StorageProof(addr common.Address, key []byte) (accountProof [][]byte, storageProof [][]byte, err error) {
+	var accounts proofList
+	if err := self.trie.Prove(crypto.Keccak256(addr[:]), 0, &accounts); err != nil {
+		return nil, nil, err
+	}
+	obj := self.getStateObject(addr)
+	if obj == nil {
+		return accounts, nil, nil
+	}
+	var storage proofList
+	keyTrie, _, _ := getKeyValue(addr, key, nil)
+	if err := obj.getTrie(self.db).Prove(crypto.Keccak256([]byte(keyTrie)), 0, &storage); err != nil {
+		return nil, nil, err
+	}
+	return accounts, storage, nil
+}
@@ -365,6 +365,9 @@ func (self *stateObject) GetCommittedState(db Database, key string) []byte {
 	}
 
 	// Otherwise load the valueKey from trie
+	if self.db.witness != nil {
+		self.db.witness.addSlot(self.address, key)
+	}
 	enc, err := self.getTrie(db).TryGet([]byte(key))
 	if err != nil {
 		self.setError(err)
@@ -382,6 +385,10 @@ func (self *stateObject) GetCommittedState(db Database, key string) []byte {
 		if err != nil {
 			self.setError(err)
 		}
+		// The slot exists, so an empty preimage is an explicitly empty value
+		if value == nil {
+			value = []byte{}
+		}
 	}
 
 	self.originStorage[key] = valueKey
@@ -396,7 +403,7 @@ func (self *stateObject) SetState(db Database, keyTrie string, valueKey common.H
 
 	//if the new value is the same as old,don't set
 	preValue := self.GetState(db, keyTrie) // get value key
-	if bytes.Equal(preValue, value) {
+	if preValue != nil && bytes.Equal(preValue, value) {
 		return
 	}
 
@@ -404,13 +411,38 @@ func (self *stateObject) SetState(db Database, keyTrie string, valueKey common.H
 	self.db.journal.append(storageChange{
 		account:  &self.address,
 		key:      keyTrie,
-		valueKey: self.originStorage[keyTrie],
+		valueKey: self.currentValueKey(keyTrie),
 		preValue: preValue,
 	})
 
 	self.setState(keyTrie, valueKey, value)
 }
 
+// DeleteState removes a slot from account storage.
+func (self *stateObject) DeleteState(db Database, keyTrie string) {
+	preValue := self.GetState(db, keyTrie)
+	if preValue == nil {
+		return
+	}
+	self.db.journal.append(storageChange{
+		account:  &self.address,
+		key:      keyTrie,
+		valueKey: self.currentValueKey(keyTrie),
+		preValue: preValue,
+	})
+
+	self.setState(keyTrie, deletedStorage, nil)
+}
+
+// currentValueKey returns the value key of the slot's current value, the one a
+// storage change reverts to along with the value.
+func (self *stateObject) currentValueKey(key string) common.Hash {
+	if valueKey, dirty := self.dirtyStorage[key]; dirty {
+		return valueKey
+	}
+	return self.originStorage[key]
+}
+
 func (self *stateObject) setState(key string, valueKey common.Hash, value []byte) {
 	self.dirtyStorage[key] = valueKey
 	self.dirtyValueStorage[valueKey] = value
@@ -428,7 +460,10 @@ func (self *stateObject) updateTrie(db Database) Trie {
 
 		self.originStorage[key] = valueKey
 
-		if valueKey == emptyStorage {
+		// Empty values leave the trie like deleted slots, so storage roots
+		// stay as they were before DeleteState existed
+		if valueKey == deletedStorage || valueKey == emptyStorage {
+			self.originStorage[key] = deletedStorage
 			self.setError(self.trie.TryDelete([]byte(key)))
 			continue
 		}
@@ -18,6 +18,7 @@ package state
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"testing"
@@ -89,6 +90,29 @@ func (s *StateSuite) TestDump(c *checker.C) {
 	}
 }
 
+func (s *StateSuite) TestDumpFiltered(c *checker.C) {
+	addr1, addr2, missing := toAddr([]byte{0x01}), toAddr([]byte{0x02}), toAddr([]byte{0x03})
+	s.state.AddBalance(addr1, big.NewInt(22))
+	s.state.SetState(addr1, []byte("key"), []byte("value"))
+	s.state.AddBalance(addr2, big.NewInt(44))
+	s.state.Commit(false)
+
+	full := s.state.RawDump()
+	filtered := s.state.RawDumpFiltered([]common.Address{addr1, missing})
+	c.Assert(filtered.Root, checker.Equals, full.Root)
+	c.Assert(filtered.Accounts, checker.HasLen, 1)
+
+	key := common.Bytes2Hex(addr1[:])
+	c.Assert(filtered.Accounts[key], checker.DeepEquals, full.Accounts[key])
+	c.Assert(filtered.Accounts[key].Storage, checker.HasLen, 1)
+
+	// The JSON encoding must match the one of Dump for the included account
+	var decoded Dump
+	enc := s.state.DumpFiltered([]common.Address{addr1})
+	c.Assert(json.Unmarshal(enc, &decoded), checker.IsNil)
+	c.Assert(decoded.Accounts[key], checker.DeepEquals, full.Accounts[key])
+}
+
 func (s *StateSuite) SetUpTest(c *checker.C) {
 	s.db = ethdb.NewMemDatabase()
 	s.state, _ = New(common.Hash{}, NewDatabase(s.db))
@@ -251,8 +275,7 @@ func compareStateObjects(so0, so1 *stateObject, t *testing.T) {
 }
 
 func TestEmptyByte(t *testing.T) {
-	db, _ := ethdb.NewLDBDatabase("D:\\resource\\venachain\\venachain-go\\data1", 0, 0)
-	state, _ := New(common.Hash{}, NewDatabase(db))
+	state, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
 
 	address := common.HexToAddress("0x823140710bf13990e4500136726d8b55")
 	state.CreateAccount(address)
@@ -50,9 +50,21 @@ var (
 	// emptyCode is the known hash of the empty EVM bytecode.
 	emptyCode = crypto.Keccak256Hash(nil)
 
+	// emptyStorage is the value key of an empty storage value.
 	emptyStorage = crypto.Keccak256Hash([]byte(storagePrefix))
 
+	// deletedStorage is the value key marking a storage slot removed by DeleteState.
+	deletedStorage = common.Hash{}
+
 	cloneErr = errors.New("clone account error!")
+
+	// errUnknownCheckpoint is returned when reverting to a named checkpoint
+	// that was never created or is no longer valid.
+	errUnknownCheckpoint = errors.New("unknown checkpoint")
+
+	errMigrateToSelf       = errors.New("account migrated to itself")
+	errMigrateSourceAbsent = errors.New("migrated account does not exist")
+	errMigrateTargetExists = errors.New("migration target already exists")
 )
 
 // StateDBs within the ethereum protocol are used to store anything
@@ -85,11 +97,15 @@ type StateDB struct {
 
 	preimages map[common.Hash][]byte
 
+	// Trie accesses recorded for the state witness, nil unless enabled.
+	witness *witnessAccumulator
+
 	// Journal of state modifications. This is the backbone of
 	// Snapshot and RevertToSnapshot.
 	journal        *journal
 	validRevisions []revision
 	nextRevisionId int
+	namedRevisions map[string]int // Revision ids of the named checkpoints
 
 	lock sync.Mutex
 }
@@ -138,6 +154,9 @@ func (self *StateDB) Reset(root common.Hash) error {
 	self.logs = make(map[common.Hash][]*types.Log)
 	self.logSize = 0
 	self.preimages = make(map[common.Hash][]byte)
+	if self.witness != nil {
+		self.witness = newWitnessAccumulator(root)
+	}
 	self.clearJournalAndRefund()
 	return nil
 }
@@ -202,6 +221,24 @@ func (self *StateDB) Exist(addr common.Address) bool {
 	return self.getStateObject(addr) != nil
 }
 
+// AccountExists reports whether the given account address exists in the state,
+// like Exist, but only checks the presence of the account in the trie instead
+// of loading and decoding it.
+func (self *StateDB) AccountExists(addr common.Address) bool {
+	if obj := self.stateObjects[addr]; obj != nil {
+		return !obj.deleted
+	}
+	if self.witness != nil {
+		self.witness.addAccount(addr)
+	}
+	ok, err := self.trie.TryHas(addr[:])
+	if err != nil {
+		self.setError(err)
+		return false
+	}
+	return ok
+}
+
 // Empty returns whether the state object is either non-existent
 // or empty according to the EIP161 specification (balance = nonce = code = 0)
 func (self *StateDB) Empty(addr common.Address) bool {
@@ -258,7 +295,10 @@ func (self *StateDB) GetCodeHash(addr common.Address) common.Hash {
 	return common.BytesToHash(stateObject.CodeHash())
 }
 
-// GetState retrieves a value from the given account's storage trie.
+// GetState retrieves a value from the given account's storage trie. For an
+// existing account it returns nil for a slot that was never set or has been
+// deleted, and an empty slice for a slot explicitly set to an empty value since
+// the state was last hashed.
 func (self *StateDB) GetState(addr common.Address, key []byte) []byte {
 	stateObject := self.getStateObject(addr)
 	keyTrie, _, _ := getKeyValue(addr, key, nil)
@@ -347,7 +387,15 @@ func (self *StateDB) SetCode(addr common.Address, code []byte) {
 	}
 }
 
+// SetState stores value in the given account's storage. An empty or nil value
+// reads as an empty value, unlike a deleted slot, only until the state is hashed
+// by IntermediateRoot or Commit. The trie does not store empty values, so from
+// then on the slot reads nil like a deleted one. The distinction is in-memory
+// only and never reaches the state root.
 func (self *StateDB) SetState(address common.Address, key, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
 	stateObject := self.GetOrNewStateObject(address)
 	keyTrie, valueKey, value := getKeyValue(address, key, value)
 	if stateObject != nil {
@@ -355,6 +403,60 @@ func (self *StateDB) SetState(address common.Address, key, value []byte) {
 	}
 }
 
+// DeleteState removes the slot from the given account's storage trie. Until
+// the state is hashed it reads nil, while a slot set to an empty value reads
+// empty, see SetState.
+func (self *StateDB) DeleteState(address common.Address, key []byte) {
+	stateObject := self.getStateObject(address)
+	keyTrie, _, _ := getKeyValue(address, key, nil)
+	if stateObject != nil {
+		stateObject.DeleteState(self.db, keyTrie)
+	}
+}
+
+// SetStateBatch writes all the given key/value pairs into the storage of addr.
+// The state object is resolved once for the whole batch; every write is still
+// journalled individually, so reverting a snapshot undoes the entire batch.
+func (self *StateDB) SetStateBatch(addr common.Address, kv map[string][]byte) {
+	if len(kv) == 0 {
+		return
+	}
+	stateObject := self.GetOrNewStateObject(addr)
+	if stateObject == nil {
+		return
+	}
+	// Apply the writes in a stable order to keep the journal deterministic
+	keys := make([]string, 0, len(kv))
+	for key := range kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := kv[key]
+		if value == nil {
+			value = []byte{}
+		}
+		keyTrie, valueKey, value := getKeyValue(addr, []byte(key), value)
+		stateObject.SetState(self.db, keyTrie, valueKey, value)
+	}
+}
+
+// GetStateBatch retrieves the values of the given keys from the storage of addr.
+// The returned slice is in the same order as keys.
+func (self *StateDB) GetStateBatch(addr common.Address, keys [][]byte) [][]byte {
+	values := make([][]byte, len(keys))
+	stateObject := self.getStateObject(addr)
+	for i, key := range keys {
+		if stateObject == nil {
+			values[i] = []byte{}
+			continue
+		}
+		keyTrie, _, _ := getKeyValue(addr, key, nil)
+		values[i] = stateObject.GetState(self.db, keyTrie)
+	}
+	return values
+}
+
 func getKeyValue(address common.Address, key []byte, value []byte) (string, common.Hash, []byte) {
 	var buffer bytes.Buffer
 	buffer.WriteString(address.String())
@@ -429,6 +531,9 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 	}
 
 	// Load the object from the database.
+	if self.witness != nil {
+		self.witness.addAccount(addr)
+	}
 	enc, err := self.trie.TryGet(addr[:])
 	if len(enc) == 0 {
 		self.setError(err)
@@ -519,6 +624,69 @@ func (self *StateDB) CloneAccount(src common.Address, dest common.Address) error
 	return nil
 }
 
+// MigrateAccount moves the balance, nonce, code, abi and storage of the account
+// at from to the address to, and marks from as suicided so it is deleted when
+// the state is finalised. The target must not exist yet. Every step is
+// journalled, reverting to an earlier snapshot undoes the whole migration.
+func (self *StateDB) MigrateAccount(from, to common.Address) error {
+	if from == to {
+		return errMigrateToSelf
+	}
+	src := self.getStateObject(from)
+	if src == nil || src.suicided {
+		return errMigrateSourceAbsent
+	}
+	if self.Exist(to) {
+		return errMigrateTargetExists
+	}
+	storage := self.accountStorage(src)
+
+	self.CreateAccount(to)
+	self.SetBalance(to, src.Balance())
+	self.SetNonce(to, src.Nonce())
+	if code := src.Code(self.db); len(code) > 0 {
+		self.SetCode(to, code)
+	}
+	// Accounts without an abi have no abi hash at all
+	if len(src.AbiHash()) > 0 {
+		if abi := src.Abi(self.db); len(abi) > 0 {
+			self.SetAbi(to, abi)
+		}
+	}
+	for key, value := range storage {
+		self.SetState(to, []byte(key), value)
+	}
+	self.Suicide(from)
+	return nil
+}
+
+// accountStorage returns the non-empty storage slots of an account, including
+// the ones not yet flushed to its trie, keyed by their unprefixed key.
+func (self *StateDB) accountStorage(obj *stateObject) map[string][]byte {
+	prefix := obj.address.String()
+
+	keys := make(map[string]struct{})
+	it := trie.NewIterator(obj.getTrie(self.db).NodeIterator(nil))
+	for it.Next() {
+		keyTrie := string(self.trie.GetKey(it.Key))
+		if !strings.HasPrefix(keyTrie, prefix) {
+			log.Warn("Unexpected storage key in account trie", "address", obj.address, "key", keyTrie)
+			continue
+		}
+		keys[keyTrie] = struct{}{}
+	}
+	for keyTrie := range obj.dirtyStorage {
+		keys[keyTrie] = struct{}{}
+	}
+	storage := make(map[string][]byte, len(keys))
+	for keyTrie := range keys {
+		if value := obj.GetState(self.db, keyTrie); len(value) > 0 {
+			storage[keyTrie[len(prefix):]] = value
+		}
+	}
+	return storage
+}
+
 func (db *StateDB) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
 	so := db.getStateObject(addr)
 	if so == nil {
@@ -584,11 +752,22 @@ func (self *StateDB) Copy() *StateDB {
 	for hash, preimage := range self.preimages {
 		state.preimages[hash] = preimage
 	}
+	if self.witness != nil {
+		state.witness = self.witness.copy()
+	}
 	return state
 }
 
 // Snapshot returns an identifier for the current revision of the state.
 func (self *StateDB) Snapshot() int {
+	// Merge the entries journalled since the last revision, the earlier ones are
+	// referenced by index and must stay where they are.
+	from := 0
+	if n := len(self.validRevisions); n > 0 {
+		from = self.validRevisions[n-1].journalIndex
+	}
+	self.journal.compact(from)
+
 	id := self.nextRevisionId
 	self.nextRevisionId++
 	self.validRevisions = append(self.validRevisions, revision{id, self.journal.length()})
@@ -611,6 +790,43 @@ func (self *StateDB) RevertToSnapshot(revid int) {
 	self.validRevisions = self.validRevisions[:idx]
 }
 
+// NamedCheckpoint labels the current revision of the state with name, replacing
+// any checkpoint of the same name. Like the revisions of Snapshot, named
+// checkpoints only live until the journal is cleared by Finalise or Commit.
+func (self *StateDB) NamedCheckpoint(name string) {
+	if self.namedRevisions == nil {
+		self.namedRevisions = make(map[string]int)
+	}
+	self.namedRevisions[name] = self.Snapshot()
+}
+
+// RevertToNamed reverts all state changes made since the checkpoint labeled
+// name. The checkpoints created after it are invalidated, the checkpoint itself
+// remains valid so the state can be restored to it again.
+func (self *StateDB) RevertToNamed(name string) error {
+	revid, ok := self.namedRevisions[name]
+	if !ok || !self.validRevision(revid) {
+		delete(self.namedRevisions, name)
+		return fmt.Errorf("%w: %q", errUnknownCheckpoint, name)
+	}
+	self.RevertToSnapshot(revid)
+	for other, id := range self.namedRevisions {
+		if id > revid {
+			delete(self.namedRevisions, other)
+		}
+	}
+	self.namedRevisions[name] = self.Snapshot()
+	return nil
+}
+
+// validRevision reports whether the revision revid can still be reverted to.
+func (self *StateDB) validRevision(revid int) bool {
+	idx := sort.Search(len(self.validRevisions), func(i int) bool {
+		return self.validRevisions[i].id >= revid
+	})
+	return idx < len(self.validRevisions) && self.validRevisions[idx].id == revid
+}
+
 // GetRefund returns the current value of the refund counter.
 func (self *StateDB) GetRefund() uint64 {
 	return self.refund
@@ -662,6 +878,7 @@ func (self *StateDB) Prepare(thash, bhash common.Hash, ti int) {
 func (s *StateDB) clearJournalAndRefund() {
 	s.journal = newJournal()
 	s.validRevisions = s.validRevisions[:0]
+	s.namedRevisions = nil
 	s.refund = 0
 }
 
@@ -698,8 +915,13 @@ func (s *StateDB) Commit(deleteEmptyObjects bool) (root common.Hash, err error)
 		}
 		delete(s.stateObjectsDirty, addr)
 	}
-	// Write trie changes.
-	root, err = s.trie.Commit(func(leaf []byte, parent common.Hash) error {
+	return s.commitAccountTrie()
+}
+
+// commitAccountTrie writes the account trie changes, referencing the storage
+// tries and code of the accounts from their parent nodes.
+func (s *StateDB) commitAccountTrie() (common.Hash, error) {
+	root, err := s.trie.Commit(func(leaf []byte, parent common.Hash) error {
 		var account Account
 		if err := rlp.DecodeBytes(leaf, &account); err != nil {
 			return nil
@@ -19,6 +19,7 @@ package state
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -32,7 +33,10 @@ import (
 
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/rlp"
+	"github.com/Venachain/Venachain/trie"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -437,3 +441,886 @@ func TestCopyOfCopy(t *testing.T) {
 		t.Fatalf("2nd copy fail, expected 42, got %v", got)
 	}
 }
+
+func TestStateBatchRevert(t *testing.T) {
+	sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	addr := common.HexToAddress("aaaa")
+
+	keys := [][]byte{[]byte("k1"), []byte("k2"), []byte("k3")}
+	sdb.SetState(addr, keys[0], []byte("v1"))
+	sdb.SetState(addr, keys[1], []byte("v2"))
+
+	snapshot := sdb.Snapshot()
+	sdb.SetStateBatch(addr, map[string][]byte{
+		"k1": []byte("n1"),
+		"k2": []byte("n2"),
+		"k3": []byte("n3"),
+	})
+	want := [][]byte{[]byte("n1"), []byte("n2"), []byte("n3")}
+	for i, value := range sdb.GetStateBatch(addr, keys) {
+		if !bytes.Equal(value, want[i]) {
+			t.Errorf("key %s: value mismatch after batch: have %q, want %q", keys[i], value, want[i])
+		}
+		if single := sdb.GetState(addr, keys[i]); !bytes.Equal(single, value) {
+			t.Errorf("key %s: batch and single read differ: %q != %q", keys[i], value, single)
+		}
+	}
+
+	sdb.RevertToSnapshot(snapshot)
+	want = [][]byte{[]byte("v1"), []byte("v2"), nil}
+	for i, value := range sdb.GetStateBatch(addr, keys) {
+		if !bytes.Equal(value, want[i]) {
+			t.Errorf("key %s: value mismatch after revert: have %q, want %q", keys[i], value, want[i])
+		}
+	}
+}
+
+// Tests that a nil value in a batch is written as an empty value, like SetState
+// does, leaving the other empty slots empty rather than nil.
+func TestStateBatchNilValue(t *testing.T) {
+	sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	addr := common.HexToAddress("aaaa")
+
+	sdb.SetState(addr, []byte("k1"), []byte{})
+	sdb.SetStateBatch(addr, map[string][]byte{"k2": nil})
+
+	for _, key := range []string{"k1", "k2"} {
+		if value := sdb.GetState(addr, []byte(key)); value == nil || len(value) != 0 {
+			t.Errorf("key %s: value mismatch: have %#v, want empty", key, value)
+		}
+	}
+}
+
+func TestNamedCheckpoints(t *testing.T) {
+	sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	addr := common.HexToAddress("aaaa")
+
+	sdb.SetNonce(addr, 1)
+	sdb.NamedCheckpoint("first")
+	sdb.SetNonce(addr, 2)
+	sdb.NamedCheckpoint("second")
+	sdb.SetNonce(addr, 3)
+
+	if err := sdb.RevertToNamed("second"); err != nil {
+		t.Fatalf("failed to revert to second checkpoint: %v", err)
+	}
+	if nonce := sdb.GetNonce(addr); nonce != 2 {
+		t.Fatalf("nonce mismatch after reverting to second: have %d, want 2", nonce)
+	}
+	// A checkpoint can be restored repeatedly
+	sdb.SetNonce(addr, 4)
+	if err := sdb.RevertToNamed("second"); err != nil {
+		t.Fatalf("failed to revert to second checkpoint again: %v", err)
+	}
+	if nonce := sdb.GetNonce(addr); nonce != 2 {
+		t.Fatalf("nonce mismatch after reverting to second again: have %d, want 2", nonce)
+	}
+	// Reverting to an earlier checkpoint invalidates the later ones
+	if err := sdb.RevertToNamed("first"); err != nil {
+		t.Fatalf("failed to revert to first checkpoint: %v", err)
+	}
+	if nonce := sdb.GetNonce(addr); nonce != 1 {
+		t.Fatalf("nonce mismatch after reverting to first: have %d, want 1", nonce)
+	}
+	if err := sdb.RevertToNamed("second"); !errors.Is(err, errUnknownCheckpoint) {
+		t.Fatalf("error mismatch for invalidated checkpoint: have %v, want %v", err, errUnknownCheckpoint)
+	}
+	if err := sdb.RevertToNamed("missing"); !errors.Is(err, errUnknownCheckpoint) {
+		t.Fatalf("error mismatch for unknown checkpoint: have %v, want %v", err, errUnknownCheckpoint)
+	}
+	// So does reverting to an earlier integer revision
+	revid := sdb.Snapshot()
+	sdb.NamedCheckpoint("third")
+	sdb.RevertToSnapshot(revid)
+	if err := sdb.RevertToNamed("third"); !errors.Is(err, errUnknownCheckpoint) {
+		t.Fatalf("error mismatch for checkpoint after reverted revision: have %v, want %v", err, errUnknownCheckpoint)
+	}
+	// Finalising the state clears the journal and with it all checkpoints
+	sdb.Finalise(true)
+	if err := sdb.RevertToNamed("first"); !errors.Is(err, errUnknownCheckpoint) {
+		t.Fatalf("error mismatch after finalise: have %v, want %v", err, errUnknownCheckpoint)
+	}
+}
+
+func TestStorageDiff(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+	addr := common.HexToAddress("aaaa")
+
+	sdb.SetState(addr, []byte("same"), []byte("v"))
+	sdb.SetState(addr, []byte("changed"), []byte("old"))
+	sdb.SetState(addr, []byte("removed"), []byte("gone"))
+	rootA, err := sdb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit first state: %v", err)
+	}
+
+	sdb.SetState(addr, []byte("changed"), []byte("new"))
+	sdb.SetState(addr, []byte("removed"), []byte{})
+	sdb.SetState(addr, []byte("added"), []byte("fresh"))
+	rootB, err := sdb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit second state: %v", err)
+	}
+
+	added, changed, removed, err := StorageDiff(db, rootA, rootB, addr)
+	if err != nil {
+		t.Fatalf("failed to diff storage: %v", err)
+	}
+	if want := map[string][]byte{"added": []byte("fresh")}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added mismatch: have %q, want %q", added, want)
+	}
+	if want := map[string][]byte{"changed": []byte("new")}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed mismatch: have %q, want %q", changed, want)
+	}
+	if want := map[string][]byte{"removed": []byte("gone")}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed mismatch: have %q, want %q", removed, want)
+	}
+
+	// Diffing a state against itself must yield nothing
+	added, changed, removed, err = StorageDiff(db, rootB, rootB, addr)
+	if err != nil {
+		t.Fatalf("failed to diff storage: %v", err)
+	}
+	if len(added)+len(changed)+len(removed) != 0 {
+		t.Errorf("identical roots produced a diff: %q %q %q", added, changed, removed)
+	}
+}
+
+// Tests that never-set, explicitly empty and deleted storage slots are kept
+// apart until the state is committed, which removes empty slots from the trie.
+func TestDeleteStateSemantics(t *testing.T) {
+	sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	addr := common.HexToAddress("aaaa")
+
+	var (
+		unset   = []byte("unset")
+		empty   = []byte("empty")
+		deleted = []byte("deleted")
+	)
+	sdb.SetState(addr, empty, []byte{})
+	sdb.SetState(addr, deleted, []byte("v"))
+	sdb.DeleteState(addr, deleted)
+
+	check := func(stage string, emptySlot bool) {
+		if value := sdb.GetState(addr, unset); value != nil {
+			t.Errorf("%s: never-set slot: have %q, want nil", stage, value)
+		}
+		value := sdb.GetState(addr, empty)
+		if emptySlot && (value == nil || len(value) != 0) {
+			t.Errorf("%s: empty slot: have %#v, want empty non-nil slice", stage, value)
+		}
+		if !emptySlot && value != nil {
+			t.Errorf("%s: removed empty slot: have %#v, want nil", stage, value)
+		}
+		if value := sdb.GetState(addr, deleted); value != nil {
+			t.Errorf("%s: deleted slot: have %q, want nil", stage, value)
+		}
+	}
+	check("dirty", true)
+
+	// Deleting an explicitly empty slot removes it, and reverting restores it
+	snapshot := sdb.Snapshot()
+	sdb.DeleteState(addr, empty)
+	if value := sdb.GetState(addr, empty); value != nil {
+		t.Errorf("deleted empty slot: have %#v, want nil", value)
+	}
+	sdb.RevertToSnapshot(snapshot)
+	check("reverted", true)
+
+	// Empty values are not stored, so hashing the state mid-block already
+	// turns the empty slot into a removed one
+	sdb.IntermediateRoot(false)
+	check("hashed", false)
+
+	// And leaves the state root untouched
+	root, err := sdb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	check("committed", false)
+
+	sdb, _ = New(root, sdb.Database())
+	check("reloaded", false)
+
+	plain, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	plain.SetState(addr, deleted, []byte("v"))
+	plain.SetState(addr, deleted, nil)
+	if plainRoot, _ := plain.Commit(false); plainRoot != root {
+		t.Errorf("state root mismatch: have %x, want %x", root, plainRoot)
+	}
+}
+
+// Tests that the witness of a state proves every account and storage slot
+// accessed by a mix of transfers, storage updates, account creations and
+// suicides against the original root.
+func TestWitnessCompleteness(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+
+	accounts := make([]common.Address, 20)
+	for i := range accounts {
+		accounts[i] = common.BytesToAddress([]byte{0x01, byte(i)})
+		sdb.SetBalance(accounts[i], big.NewInt(1000))
+		for j := 0; j < 5; j++ {
+			sdb.SetState(accounts[i], []byte(fmt.Sprintf("slot-%d", j)), []byte(fmt.Sprintf("value-%d-%d", i, j)))
+		}
+	}
+	root, err := sdb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit pre-state: %v", err)
+	}
+
+	sdb, _ = New(root, db)
+	sdb.EnableWitnessing()
+
+	var (
+		rnd     = rand.New(rand.NewSource(1))
+		touched = make(map[common.Address]struct{})
+		slots   = make(map[common.Address]map[string]struct{})
+	)
+	slot := func(addr common.Address, key string) {
+		keyTrie, _, _ := getKeyValue(addr, []byte(key), nil)
+		if slots[addr] == nil {
+			slots[addr] = make(map[string]struct{})
+		}
+		slots[addr][keyTrie] = struct{}{}
+		touched[addr] = struct{}{}
+	}
+	for i := 0; i < 100; i++ {
+		from := accounts[rnd.Intn(len(accounts))]
+		to := accounts[rnd.Intn(len(accounts))]
+		touched[from] = struct{}{}
+
+		switch i % 6 {
+		case 0: // transfer between existing accounts
+			sdb.SubBalance(from, big.NewInt(1))
+			sdb.AddBalance(to, big.NewInt(1))
+			touched[to] = struct{}{}
+		case 1: // transfer to a new account
+			fresh := common.BytesToAddress([]byte{0x02, byte(i)})
+			sdb.SubBalance(from, big.NewInt(1))
+			sdb.AddBalance(fresh, big.NewInt(1))
+			touched[fresh] = struct{}{}
+		case 2: // overwrite an existing slot
+			key := fmt.Sprintf("slot-%d", rnd.Intn(5))
+			sdb.SetState(from, []byte(key), []byte(fmt.Sprintf("update-%d", i)))
+			slot(from, key)
+		case 3: // read an existing and a missing slot
+			key := fmt.Sprintf("slot-%d", rnd.Intn(5))
+			sdb.GetState(from, []byte(key))
+			sdb.GetState(from, []byte("missing"))
+			slot(from, key)
+			slot(from, "missing")
+		case 4: // add a slot and delete another
+			sdb.SetState(from, []byte(fmt.Sprintf("new-%d", i)), []byte("v"))
+			sdb.DeleteState(from, []byte("slot-0"))
+			slot(from, fmt.Sprintf("new-%d", i))
+			slot(from, "slot-0")
+		case 5: // bump the nonce, occasionally suicide the last new account
+			sdb.SetNonce(from, sdb.GetNonce(from)+1)
+			if i%30 == 5 {
+				fresh := common.BytesToAddress([]byte{0x02, byte(i - 4)})
+				sdb.Suicide(fresh)
+				touched[fresh] = struct{}{}
+			}
+		}
+		sdb.Finalise(false)
+	}
+	if _, err := sdb.Commit(false); err != nil {
+		t.Fatalf("failed to commit post-state: %v", err)
+	}
+	witness := sdb.GetWitness()
+	if witness == nil {
+		t.Fatalf("missing witness: %v", sdb.Error())
+	}
+
+	// Round trip the witness through RLP and verify against the decoded copy
+	enc, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		t.Fatalf("failed to encode witness: %v", err)
+	}
+	decoded := new(trie.Witness)
+	if err := rlp.DecodeBytes(enc, decoded); err != nil {
+		t.Fatalf("failed to decode witness: %v", err)
+	}
+	if decoded.Hash() != witness.Hash() || decoded.Root != root {
+		t.Fatalf("witness changed in RLP round trip")
+	}
+	for addr := range touched {
+		enc, _, err := trie.VerifyProof(root, crypto.Keccak256(addr[:]), decoded)
+		if err != nil {
+			t.Errorf("account %x: missing witness: %v", addr, err)
+			continue
+		}
+		var data Account
+		if len(enc) > 0 {
+			if err := rlp.DecodeBytes(enc, &data); err != nil {
+				t.Fatalf("account %x: failed to decode: %v", addr, err)
+			}
+		} else if addr[0] == 0x01 {
+			t.Errorf("account %x: proven absent", addr)
+		}
+		for key := range slots[addr] {
+			value, _, err := trie.VerifyProof(data.Root, crypto.Keccak256([]byte(key)), decoded)
+			if err != nil {
+				t.Errorf("account %x: slot %q: missing witness: %v", addr, key, err)
+			}
+			if strings.Contains(key, "slot-") && value == nil {
+				t.Errorf("account %x: slot %q: proven absent", addr, key)
+			}
+		}
+	}
+
+	// Disabled witnessing yields no witness
+	plain, _ := New(root, db)
+	plain.GetBalance(accounts[0])
+	if plain.GetWitness() != nil {
+		t.Errorf("witness returned with witnessing disabled")
+	}
+}
+
+// Tests that AccountExists agrees with Exist for cached, cold, missing and
+// suicided accounts.
+func TestAccountExists(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+
+	var (
+		cold     = common.HexToAddress("0x01")
+		cached   = common.HexToAddress("0x02")
+		suicided = common.HexToAddress("0x03")
+		missing  = common.HexToAddress("0x04")
+	)
+	for _, addr := range []common.Address{cold, cached, suicided} {
+		sdb.SetBalance(addr, big.NewInt(1))
+	}
+	root, _ := sdb.Commit(false)
+
+	sdb, _ = New(root, db)
+	sdb.GetBalance(cached)
+	sdb.Suicide(suicided)
+	for _, addr := range []common.Address{cold, cached, suicided, missing} {
+		if have, want := sdb.AccountExists(addr), sdb.Copy().Exist(addr); have != want {
+			t.Errorf("account %x: existence mismatch: have %v, want %v", addr, have, want)
+		}
+	}
+	if _, ok := sdb.stateObjects[cold]; ok {
+		t.Errorf("existence check loaded the account")
+	}
+	if !sdb.AccountExists(cold) || sdb.AccountExists(missing) {
+		t.Errorf("wrong existence of cold or missing account")
+	}
+}
+
+// BenchmarkAccountExists compares the existence check of AccountExists and
+// GetOrNewStateObject on a state of 100000 accounts, half of them cached.
+func BenchmarkAccountExists(b *testing.B) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+
+	addrs := make([]common.Address, 100000)
+	for i := range addrs {
+		binary.BigEndian.PutUint64(addrs[i][12:], uint64(i)+1)
+		sdb.SetBalance(addrs[i], big.NewInt(1))
+	}
+	root, _ := sdb.Commit(false)
+
+	// Every other account is loaded, the rest stays cold
+	open := func() *StateDB {
+		sdb, _ := New(root, db)
+		for i := 0; i < len(addrs); i += 2 {
+			sdb.getStateObject(addrs[i])
+		}
+		return sdb
+	}
+	bench := func(b *testing.B, exists func(*StateDB, common.Address)) {
+		sdb := open()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// Reopen once all cold accounts may have been cached
+			if i > 0 && i%len(addrs) == 0 {
+				b.StopTimer()
+				sdb = open()
+				b.StartTimer()
+			}
+			exists(sdb, addrs[i%len(addrs)])
+		}
+	}
+	b.Run("AccountExists", func(b *testing.B) {
+		bench(b, func(sdb *StateDB, addr common.Address) { sdb.AccountExists(addr) })
+	})
+	b.Run("GetOrNewStateObject", func(b *testing.B) {
+		bench(b, func(sdb *StateDB, addr common.Address) { sdb.GetOrNewStateObject(addr) })
+	})
+}
+
+// Tests that ForEachAccount merges in-memory changes with the committed trie,
+// stops when asked to and ignores modifications made during the iteration.
+func TestForEachAccount(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+	for i := byte(1); i <= 10; i++ {
+		sdb.SetBalance(common.BytesToAddress([]byte{i}), big.NewInt(int64(i)))
+	}
+	root, _ := sdb.Commit(false)
+	sdb, _ = New(root, db)
+
+	// Modify, create and delete accounts without committing
+	sdb.SetBalance(common.BytesToAddress([]byte{1}), big.NewInt(100))
+	sdb.SetNonce(common.BytesToAddress([]byte{2}), 7)
+	sdb.SetBalance(common.BytesToAddress([]byte{11}), big.NewInt(11))
+	sdb.Suicide(common.BytesToAddress([]byte{3}))
+	sdb.Finalise(false)
+
+	want := map[common.Address]uint64{}
+	for i := byte(1); i <= 11; i++ {
+		want[common.BytesToAddress([]byte{i})] = uint64(i)
+	}
+	delete(want, common.BytesToAddress([]byte{3}))
+	want[common.BytesToAddress([]byte{1})] = 100
+
+	var (
+		seen = make(map[common.Address]uint64)
+		prev common.Hash
+	)
+	sdb.ForEachAccount(func(addr common.Address, acc AccountInfo) bool {
+		hash := crypto.Keccak256Hash(addr[:])
+		if bytes.Compare(hash[:], prev[:]) <= 0 {
+			t.Errorf("account %x visited out of order", addr)
+		}
+		prev = hash
+		if _, ok := seen[addr]; ok {
+			t.Errorf("account %x visited twice", addr)
+		}
+		seen[addr] = acc.Balance.Uint64()
+		if addr == common.BytesToAddress([]byte{2}) && acc.Nonce != 7 {
+			t.Errorf("dirty nonce not visible: have %d, want 7", acc.Nonce)
+		}
+		// Modifications made while iterating must not be observed
+		for i := byte(1); i <= 12; i++ {
+			sdb.AddBalance(common.BytesToAddress([]byte{i}), big.NewInt(1000))
+		}
+		sdb.IntermediateRoot(false)
+		return true
+	})
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("iterated accounts mismatch: have %v, want %v", seen, want)
+	}
+	if have := sdb.GetBalance(common.BytesToAddress([]byte{12})); have.Sign() == 0 {
+		t.Errorf("modifications made during the iteration were lost")
+	}
+
+	// Stopping early visits a prefix, resuming continues after it
+	var first []common.Address
+	sdb.ForEachAccount(func(addr common.Address, acc AccountInfo) bool {
+		first = append(first, addr)
+		return len(first) < 3
+	})
+	if len(first) != 3 {
+		t.Fatalf("iteration did not stop: visited %d accounts", len(first))
+	}
+	start := crypto.Keccak256Hash(first[2][:])
+	var rest []common.Address
+	sdb.ForEachAccountFrom(start, func(addr common.Address, acc AccountInfo) bool {
+		rest = append(rest, addr)
+		return true
+	})
+	if len(rest) == 0 || rest[0] != first[2] {
+		t.Errorf("resumed iteration does not start at the given position")
+	}
+}
+
+// newDirtyState creates a state holding n dirty accounts with a storage slot
+// each, plus a removed and an emptied account.
+func newDirtyState(db Database, n int) *StateDB {
+	sdb, _ := New(common.Hash{}, db)
+	for i := 0; i < n; i++ {
+		var addr common.Address
+		binary.BigEndian.PutUint64(addr[:], uint64(i)*0x0101010101010101+1)
+		sdb.SetBalance(addr, big.NewInt(int64(i)+1))
+		sdb.SetState(addr, []byte("slot"), []byte{byte(i), 1})
+	}
+	sdb.SetBalance(common.HexToAddress("0xaa"), big.NewInt(1))
+	sdb.Suicide(common.HexToAddress("0xaa"))
+	sdb.CreateAccount(common.HexToAddress("0xbb"))
+	return sdb
+}
+
+// Tests that CommitParallel results in the same root and trie nodes as Commit.
+func TestCommitParallel(t *testing.T) {
+	for _, deleteEmpty := range []bool{false, true} {
+		serialDB := NewDatabase(ethdb.NewMemDatabase())
+		serialRoot, err := newDirtyState(serialDB, 300).Commit(deleteEmpty)
+		if err != nil {
+			t.Fatalf("serial commit failed: %v", err)
+		}
+		parallelDB := NewDatabase(ethdb.NewMemDatabase())
+		parallel := newDirtyState(parallelDB, 300)
+		parallelRoot, err := parallel.CommitParallel(deleteEmpty)
+		if err != nil {
+			t.Fatalf("parallel commit failed: %v", err)
+		}
+		if parallelRoot != serialRoot {
+			t.Fatalf("root mismatch (deleteEmpty %v): have %x, want %x", deleteEmpty, parallelRoot, serialRoot)
+		}
+		if len(parallel.stateObjectsDirty) != 0 {
+			t.Errorf("dirty objects left after parallel commit: %d", len(parallel.stateObjectsDirty))
+		}
+		// Both trie databases must hold the full state
+		for _, db := range []Database{serialDB, parallelDB} {
+			if err := db.TrieDB().Commit(parallelRoot, false); err != nil {
+				t.Fatalf("failed to flush trie database: %v", err)
+			}
+		}
+		if err := checkStateConsistency(serialDB.TrieDB().DiskDB().(ethdb.Database), parallelRoot); err != nil {
+			t.Errorf("serial state inconsistent: %v", err)
+		}
+		if err := checkStateConsistency(parallelDB.TrieDB().DiskDB().(ethdb.Database), parallelRoot); err != nil {
+			t.Errorf("parallel state inconsistent: %v", err)
+		}
+	}
+}
+
+// BenchmarkCommit compares Commit and CommitParallel on 10000 dirty accounts.
+func BenchmarkCommit(b *testing.B) {
+	bench := func(b *testing.B, commit func(*StateDB) (common.Hash, error)) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			sdb := newDirtyState(NewDatabase(ethdb.NewMemDatabase()), 10000)
+			b.StartTimer()
+			if _, err := commit(sdb); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("Serial", func(b *testing.B) {
+		bench(b, func(sdb *StateDB) (common.Hash, error) { return sdb.Commit(false) })
+	})
+	b.Run("Parallel", func(b *testing.B) {
+		bench(b, func(sdb *StateDB) (common.Hash, error) { return sdb.CommitParallel(false) })
+	})
+}
+
+// Tests that snapshots compact repeated writes to a storage slot and that
+// reverting the compacted journal restores the original value.
+func TestJournalCompaction(t *testing.T) {
+	sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	addr := common.HexToAddress("aaaa")
+
+	sdb.SetState(addr, []byte("slot"), []byte("original"))
+	revid := sdb.Snapshot()
+	start := sdb.journal.length()
+	for i := 0; i < 100; i++ {
+		sdb.SetState(addr, []byte("slot"), []byte(fmt.Sprintf("value%d", i)))
+	}
+	sdb.SetState(addr, []byte("other"), []byte("value"))
+	sdb.Snapshot()
+
+	if have := sdb.journal.length() - start; have != 2 {
+		t.Fatalf("compacted journal length mismatch: have %d, want 2", have)
+	}
+	if have := sdb.journal.dirties[addr]; have != sdb.journal.length() {
+		t.Fatalf("dirty counter mismatch: have %d, want %d", have, sdb.journal.length())
+	}
+	sdb.RevertToSnapshot(revid)
+	if value := sdb.GetState(addr, []byte("slot")); !bytes.Equal(value, []byte("original")) {
+		t.Fatalf("slot mismatch after revert: have %q, want %q", value, "original")
+	}
+	if value := sdb.GetState(addr, []byte("other")); len(value) != 0 {
+		t.Fatalf("other slot not reverted: have %q", value)
+	}
+}
+
+// BenchmarkRevertSameKey measures reverting 10000 updates to the same storage
+// key, which the compaction in Snapshot collapses into a single journal entry.
+func BenchmarkRevertSameKey(b *testing.B) {
+	addr := common.HexToAddress("aaaa")
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+		revid := sdb.Snapshot()
+		for j := 0; j < 10000; j++ {
+			sdb.SetState(addr, []byte("slot"), big.NewInt(int64(j+1)).Bytes())
+		}
+		b.StartTimer()
+		sdb.Snapshot()
+		sdb.RevertToSnapshot(revid)
+	}
+}
+
+func TestMigrateAccount(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	from, to := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+
+	sdb, _ := New(common.Hash{}, db)
+	sdb.SetBalance(from, big.NewInt(42))
+	sdb.SetNonce(from, 7)
+	sdb.SetCode(from, []byte("code"))
+	sdb.SetState(from, []byte("committed"), []byte("old"))
+	root, _ := sdb.Commit(false)
+
+	sdb, _ = New(root, db)
+	sdb.SetState(from, []byte("dirty"), []byte("new"))
+
+	revid := sdb.Snapshot()
+	if err := sdb.MigrateAccount(from, to); err != nil {
+		t.Fatalf("failed to migrate account: %v", err)
+	}
+	check := func(addr common.Address, balance int64, nonce uint64, code string, storage map[string]string) {
+		t.Helper()
+		if have := sdb.GetBalance(addr); have.Cmp(big.NewInt(balance)) != 0 {
+			t.Errorf("%x: balance mismatch: have %v, want %d", addr, have, balance)
+		}
+		if have := sdb.GetNonce(addr); have != nonce {
+			t.Errorf("%x: nonce mismatch: have %d, want %d", addr, have, nonce)
+		}
+		if have := sdb.GetCode(addr); string(have) != code {
+			t.Errorf("%x: code mismatch: have %q, want %q", addr, have, code)
+		}
+		for key, want := range storage {
+			if have := sdb.GetState(addr, []byte(key)); string(have) != want {
+				t.Errorf("%x: slot %s mismatch: have %q, want %q", addr, key, have, want)
+			}
+		}
+	}
+	check(to, 42, 7, "code", map[string]string{"committed": "old", "dirty": "new"})
+	if !sdb.HasSuicided(from) {
+		t.Errorf("migrated account not marked as deleted")
+	}
+	// Reverting undoes the whole migration
+	sdb.RevertToSnapshot(revid)
+	check(from, 42, 7, "code", map[string]string{"committed": "old", "dirty": "new"})
+	if sdb.Exist(to) {
+		t.Errorf("migration target exists after revert")
+	}
+	if sdb.HasSuicided(from) {
+		t.Errorf("migrated account still deleted after revert")
+	}
+	// Once finalised, only the target remains
+	if err := sdb.MigrateAccount(from, to); err != nil {
+		t.Fatalf("failed to migrate account: %v", err)
+	}
+	sdb.Finalise(false)
+	if sdb.Exist(from) {
+		t.Errorf("migrated account exists after finalise")
+	}
+	check(to, 42, 7, "code", map[string]string{"committed": "old", "dirty": "new"})
+}
+
+func TestMigrateAccountRejected(t *testing.T) {
+	sdb, _ := New(common.Hash{}, NewDatabase(ethdb.NewMemDatabase()))
+	from, to := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+
+	sdb.SetBalance(from, big.NewInt(42))
+	sdb.SetState(from, []byte("slot"), []byte("value"))
+	sdb.SetBalance(to, big.NewInt(1))
+	length := sdb.journal.length()
+
+	tests := []struct {
+		from, to common.Address
+		err      error
+	}{
+		{from, from, errMigrateToSelf},
+		{from, to, errMigrateTargetExists},
+		{common.HexToAddress("0x03"), common.HexToAddress("0x04"), errMigrateSourceAbsent},
+	}
+	for i, tt := range tests {
+		if err := sdb.MigrateAccount(tt.from, tt.to); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// A rejected migration leaves the state untouched
+	if have := sdb.journal.length(); have != length {
+		t.Errorf("journal length mismatch: have %d, want %d", have, length)
+	}
+	if have := sdb.GetBalance(from); have.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("source balance mismatch: have %v, want 42", have)
+	}
+	if have := sdb.GetBalance(to); have.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("target balance mismatch: have %v, want 1", have)
+	}
+	if have := sdb.GetState(to, []byte("slot")); len(have) != 0 {
+		t.Errorf("target storage modified: have %q", have)
+	}
+}
+
+func TestPrefetchAccounts(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+
+	var addrs []common.Address
+	for i := 0; i < 64; i++ {
+		addr := common.BytesToAddress([]byte{byte(i * 4), byte(i)})
+		sdb.SetBalance(addr, big.NewInt(int64(i+1)))
+		sdb.SetNonce(addr, uint64(i))
+		addrs = append(addrs, addr)
+	}
+	root, _ := sdb.Commit(false)
+
+	sdb, _ = New(root, db)
+	missing := common.HexToAddress("0xffff")
+	sdb.PrefetchAccounts(append(addrs, addrs[0], missing))
+
+	for i, addr := range addrs {
+		if sdb.stateObjects[addr] == nil {
+			t.Fatalf("account %x not prefetched", addr)
+		}
+		if have := sdb.GetBalance(addr); have.Cmp(big.NewInt(int64(i+1))) != 0 {
+			t.Errorf("account %x: balance mismatch: have %v, want %d", addr, have, i+1)
+		}
+		if have := sdb.GetNonce(addr); have != uint64(i) {
+			t.Errorf("account %x: nonce mismatch: have %d, want %d", addr, have, i)
+		}
+	}
+	if sdb.stateObjects[missing] != nil || sdb.Exist(missing) {
+		t.Errorf("missing account created by prefetching")
+	}
+	// Prefetching leaves the state unchanged
+	if have := sdb.IntermediateRoot(false); have != root {
+		t.Errorf("root mismatch after prefetching: have %x, want %x", have, root)
+	}
+}
+
+// Tests that ExistBatch reports the same existence as calling Exist for each
+// address, over committed, new, missing and self-destructed accounts.
+func TestExistBatch(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	sdb, _ := New(common.Hash{}, db)
+
+	var (
+		stored    = common.HexToAddress("0x01")
+		destroyed = common.HexToAddress("0x02")
+		suicided  = common.HexToAddress("0x03")
+		created   = common.HexToAddress("0x04")
+		missing   = common.HexToAddress("0x05")
+	)
+	for _, addr := range []common.Address{stored, destroyed, suicided} {
+		sdb.SetBalance(addr, big.NewInt(1))
+	}
+	root, _ := sdb.Commit(false)
+
+	// Build the same state, one queried in a batch and one address by address
+	prepare := func() *StateDB {
+		state, _ := New(root, db)
+		state.Suicide(destroyed)
+		state.Finalise(true)
+		state.Suicide(suicided)
+		state.SetBalance(created, big.NewInt(1))
+		return state
+	}
+	addrs := []common.Address{stored, missing, destroyed, suicided, created, stored, missing}
+
+	batch := prepare().ExistBatch(addrs)
+	single := prepare()
+	for i, addr := range addrs {
+		if want := single.Exist(addr); batch[i] != want {
+			t.Errorf("address %d (%x): existence mismatch: have %v, want %v", i, addr, batch[i], want)
+		}
+	}
+	if want := []bool{true, false, false, true, true, true, false}; !reflect.DeepEqual(batch, want) {
+		t.Errorf("existence mismatch: have %v, want %v", batch, want)
+	}
+}
+
+// Tests that GetStateAtRoot reads storage as of older roots and reports pruned
+// states as such.
+func TestGetStateAtRoot(t *testing.T) {
+	diskdb := ethdb.NewMemDatabase()
+	db := NewDatabase(diskdb)
+	addr := common.HexToAddress("0x01")
+
+	sdb, _ := New(common.Hash{}, db)
+	sdb.SetState(addr, []byte("slot"), []byte("old"))
+	oldRoot, _ := sdb.Commit(false)
+
+	sdb, _ = New(oldRoot, db)
+	sdb.SetState(addr, []byte("slot"), []byte("new"))
+	sdb.SetState(addr, []byte("other"), []byte("value"))
+	newRoot, _ := sdb.Commit(false)
+
+	tests := []struct {
+		root common.Hash
+		addr common.Address
+		key  string
+		want []byte
+	}{
+		{oldRoot, addr, "slot", []byte("old")},
+		{newRoot, addr, "slot", []byte("new")},
+		{oldRoot, addr, "other", nil},
+		{newRoot, addr, "other", []byte("value")},
+		{newRoot, common.HexToAddress("0x02"), "slot", nil},
+	}
+	for i, tt := range tests {
+		have, err := GetStateAtRoot(db, tt.root, tt.addr, []byte(tt.key))
+		if err != nil {
+			t.Fatalf("test %d: failed to read state: %v", i, err)
+		}
+		if !bytes.Equal(have, tt.want) || (have == nil) != (tt.want == nil) {
+			t.Errorf("test %d: value mismatch: have %q, want %q", i, have, tt.want)
+		}
+	}
+	// Drop the old account trie root from disk, as pruning would
+	if err := db.TrieDB().Commit(oldRoot, false); err != nil {
+		t.Fatalf("failed to flush state: %v", err)
+	}
+	diskdb.Delete(oldRoot[:])
+	if _, err := GetStateAtRoot(NewDatabase(diskdb), oldRoot, addr, []byte("slot")); !errors.Is(err, ErrStatePruned) {
+		t.Errorf("error mismatch on pruned state: have %v, want %v", err, ErrStatePruned)
+	}
+}
+
+func TestStorageProof(t *testing.T) {
+	db := NewDatabase(ethdb.NewMemDatabase())
+	addr := common.HexToAddress("0x01")
+
+	sdb, _ := New(common.Hash{}, db)
+	sdb.SetState(addr, []byte("slot"), []byte("value"))
+	sdb.SetState(addr, []byte("other"), []byte("other value"))
+	sdb.SetState(common.HexToAddress("0x03"), []byte("slot"), []byte("value"))
+	root, _ := sdb.Commit(false)
+
+	sdb, _ = New(root, db)
+	accountProof, storageProof, err := sdb.StorageProof(addr, []byte("slot"))
+	if err != nil {
+		t.Fatalf("failed to prove slot: %v", err)
+	}
+	// Verify both proofs from the state root only
+	proofDb := func(proof [][]byte) *ethdb.MemDatabase {
+		db := ethdb.NewMemDatabase()
+		for _, node := range proof {
+			db.Put(crypto.Keccak256(node), node)
+		}
+		return db
+	}
+	enc, _, err := trie.VerifyProof(root, crypto.Keccak256(addr[:]), proofDb(accountProof))
+	if err != nil || enc == nil {
+		t.Fatalf("account proof invalid: %v", err)
+	}
+	var account Account
+	if err := rlp.DecodeBytes(enc, &account); err != nil {
+		t.Fatalf("failed to decode account: %v", err)
+	}
+	keyTrie, valueKey, _ := getKeyValue(addr, []byte("slot"), []byte("value"))
+	enc, _, err = trie.VerifyProof(account.Root, crypto.Keccak256([]byte(keyTrie)), proofDb(storageProof))
+	if err != nil || enc == nil {
+		t.Fatalf("storage proof invalid: %v", err)
+	}
+	if _, content, _, _ := rlp.Split(enc); common.BytesToHash(content) != valueKey {
+		t.Errorf("proven slot mismatch: have %x, want %x", content, valueKey)
+	}
+
+	// A missing account is proven absent
+	missing := common.HexToAddress("0x02")
+	accountProof, storageProof, err = sdb.StorageProof(missing, []byte("slot"))
+	if err != nil {
+		t.Fatalf("failed to prove missing account: %v", err)
+	}
+	if storageProof != nil {
+		t.Errorf("storage proof of a missing account")
+	}
+	enc, _, err = trie.VerifyProof(root, crypto.Keccak256(missing[:]), proofDb(accountProof))
+	if err != nil || enc != nil {
+		t.Errorf("exclusion proof invalid: value %x, err %v", enc, err)
+	}
+}
@@ -0,0 +1,105 @@
+package state
+
+import (
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/crypto"
+	"github.com/Venachain/Venachain/trie"
+)
+
+// witnessAccumulator records the accounts and storage slots read from the tries
+// of a state, so that a witness proving them against the original root can be
+// built afterwards.
+type witnessAccumulator struct {
+	root     common.Hash // state root the accesses are proven against
+	accounts map[common.Address]struct{}
+	slots    map[common.Address]map[string]struct{}
+}
+
+func newWitnessAccumulator(root common.Hash) *witnessAccumulator {
+	return &witnessAccumulator{
+		root:     root,
+		accounts: make(map[common.Address]struct{}),
+		slots:    make(map[common.Address]map[string]struct{}),
+	}
+}
+
+// addAccount records an access to the account trie entry of addr.
+func (w *witnessAccumulator) addAccount(addr common.Address) {
+	w.accounts[addr] = struct{}{}
+}
+
+// addSlot records an access to the storage trie entry key of addr.
+func (w *witnessAccumulator) addSlot(addr common.Address, key string) {
+	slots := w.slots[addr]
+	if slots == nil {
+		slots = make(map[string]struct{})
+		w.slots[addr] = slots
+	}
+	slots[key] = struct{}{}
+}
+
+func (w *witnessAccumulator) copy() *witnessAccumulator {
+	cpy := newWitnessAccumulator(w.root)
+	for addr := range w.accounts {
+		cpy.accounts[addr] = struct{}{}
+	}
+	for addr, slots := range w.slots {
+		for key := range slots {
+			cpy.addSlot(addr, key)
+		}
+	}
+	return cpy
+}
+
+// build proves all recorded accesses against the original root. Accesses to
+// missing entries are proven by their proof of absence. The tries are secure
+// tries, so the proofs are for the hashed keys.
+func (w *witnessAccumulator) build(db Database) (*trie.Witness, error) {
+	witness := trie.NewWitness(w.root)
+
+	tr, err := db.OpenTrie(w.root)
+	if err != nil {
+		return nil, err
+	}
+	for addr := range w.accounts {
+		if err := tr.Prove(crypto.Keccak256(addr[:]), 0, witness); err != nil {
+			return nil, err
+		}
+	}
+	for addr, slots := range w.slots {
+		// Storage is only read from accounts already proven above
+		st, err := openStorageTrie(db, w.root, addr)
+		if err != nil {
+			return nil, err
+		}
+		for key := range slots {
+			if err := st.Prove(crypto.Keccak256([]byte(key)), 0, witness); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return witness, nil
+}
+
+// EnableWitnessing starts recording the trie entries accessed through the state,
+// see GetWitness. It has to be called on a fresh state, before any account is
+// loaded.
+func (self *StateDB) EnableWitnessing() {
+	self.witness = newWitnessAccumulator(self.trie.Hash())
+}
+
+// GetWitness returns the witness proving all trie entries accessed since
+// EnableWitnessing against the state root at that time. Entries written but
+// never read, such as new accounts, are covered by their proof of absence. It
+// returns nil if witnessing is disabled.
+func (self *StateDB) GetWitness() *trie.Witness {
+	if self.witness == nil {
+		return nil
+	}
+	witness, err := self.witness.build(self.db)
+	if err != nil {
+		self.setError(err)
+		return nil
+	}
+	return witness
+}
@@ -95,6 +95,14 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 // for the transaction, gas used and an error if the transaction failed,
 // indicating the block was invalid.
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
+	receipt, _, gas, err := ApplyTransactionWithResult(config, bc, author, gp, statedb, header, tx, usedGas, cfg)
+	return receipt, gas, err
+}
+
+// ApplyTransactionWithResult is like ApplyTransaction but also returns the data
+// returned by the executed contract code, nil for plain value transfers.
+func ApplyTransactionWithResult(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, []byte, uint64, error) {
+	var ret []byte
 	var from common.Address
 	var gas uint64
 	var gasPrice int64
@@ -146,7 +154,7 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 		}
 		from = msg.From()
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, 0, err
 		}
 
 		// Create a new context to be used in the EVM environment
@@ -155,7 +163,7 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 		// about the transaction and calling mechanisms.
 		vmenv := vm.NewEVM(context, statedb, config, cfg)
 		// Apply the transaction to the current state (included in the env)
-		_, gas, gasPrice, failed, err = ApplyMessage(vmenv, msg, gp)
+		ret, gas, gasPrice, failed, err = ApplyMessage(vmenv, msg, gp)
 	}
 
 	if err != nil {
@@ -173,7 +181,7 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 			}
 			statedb.AddLog(log)
 		default:
-			return nil, 0, err
+			return nil, nil, 0, err
 		}
 	}
 
@@ -209,5 +217,5 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.Logs = statedb.GetLogs(tx.Hash())
 	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 
-	return receipt, gas, nil
+	return receipt, ret, gas, nil
 }
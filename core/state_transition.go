@@ -247,11 +247,11 @@ func fwCheck(stateDb vm.StateDB, contractAddr common.Address, caller common.Addr
 	var data [][]byte
 	if err := rlp.DecodeBytes(input, &data); err != nil {
 		log.Debug("FW : Input decode error")
-		return vm.MakeReturnBytes([]byte("FW : Input decode error")), false
+		return firewallRefusal("FW : Input decode error"), false
 	}
 	if len(data) < 2 {
 		log.Debug("FW : Missing function name")
-		return vm.MakeReturnBytes([]byte("FW : Missing function name")), false
+		return firewallRefusal("FW : Missing function name"), false
 	}
 	funcName := string(data[1])
 
@@ -264,14 +264,25 @@ func fwCheck(stateDb vm.StateDB, contractAddr common.Address, caller common.Addr
 	var fwLog string = "FW : Access to contract:" + contractAddr.String() + " by " + funcName + "is refused by firewall."
 
 	if fwStatus.IsRejected(funcName, caller) {
-		return vm.MakeReturnBytes([]byte(fwLog)), false
+		return firewallRefusal(fwLog), false
 	}
 
 	if fwStatus.IsAccepted(funcName, caller) {
 		return nil, true
 	}
 
-	return vm.MakeReturnBytes([]byte(fwLog)), false
+	return firewallRefusal(fwLog), false
+}
+
+// firewallRefusal encodes the message returned for a call refused by the
+// firewall, nil if it can not be encoded.
+func firewallRefusal(msg string) []byte {
+	ret, err := vm.MakeReturnBytes([]byte(msg))
+	if err != nil {
+		log.Debug("FW : Failed to encode refusal", "err", err)
+		return nil
+	}
+	return ret
 }
 
 func (st *StateTransition) ifUseContractTokenAsFee() (common.Address, bool) {
@@ -688,10 +688,21 @@ func (pool *TxPool) Pending() (map[common.Address]types.Transactions, error) {
 // grouped by origin account and stored by nonce. The returned transaction set
 // is a copy and can be freely modified by calling code.
 func (pool *TxPool) PendingLimited() (map[common.Address]types.Transactions, error) {
+	return pool.PendingLimitedN(0)
+}
+
+// PendingLimitedN is like PendingLimited but retrieves at most n transactions.
+// A non-positive n, or one above `pool.config.GlobalTxCount`, falls back to the
+// global count.
+func (pool *TxPool) PendingLimitedN(n int) (map[common.Address]types.Transactions, error) {
 	now := time.Now()
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
+	limit := int(pool.config.GlobalTxCount)
+	if n > 0 && n < limit {
+		limit = n
+	}
 	//log.Info("Pending txs before get", "txCnt", len(pool.pending))
 	txCount := 0
 	var length int
@@ -699,9 +710,9 @@ func (pool *TxPool) PendingLimited() (map[common.Address]types.Transactions, err
 	for addr, list := range pool.pending {
 		if list != nil {
 			if list.Len() > 0 {
-				pending[addr], length = list.GetByCount(int(pool.config.GlobalTxCount) - txCount)
+				pending[addr], length = list.GetByCount(limit - txCount)
 				txCount += length
-				if txCount >= int(pool.config.GlobalTxCount) {
+				if txCount >= limit {
 					break
 				}
 			}
@@ -711,6 +722,42 @@ func (pool *TxPool) PendingLimited() (map[common.Address]types.Transactions, err
 	return pending, nil
 }
 
+// PendingByGasPrice is like PendingLimitedN but leaves out the transactions
+// priced below minGasPrice. As the later nonces of an account could not be
+// executed without them, each account is cut at its first underpriced one.
+func (pool *TxPool) PendingByGasPrice(minGasPrice *big.Int, n int) (map[common.Address]types.Transactions, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	limit := int(pool.config.GlobalTxCount)
+	if n > 0 && n < limit {
+		limit = n
+	}
+	txCount := 0
+	pending := make(map[common.Address]types.Transactions)
+	for addr, list := range pool.pending {
+		if list == nil || list.Len() == 0 {
+			continue
+		}
+		txs, _ := list.GetByCount(limit - txCount)
+		for i, tx := range txs {
+			if tx.GasPrice().Cmp(minGasPrice) < 0 {
+				txs = txs[:i]
+				break
+			}
+		}
+		if len(txs) == 0 {
+			continue
+		}
+		pending[addr] = txs
+		txCount += len(txs)
+		if txCount >= limit {
+			break
+		}
+	}
+	return pending, nil
+}
+
 // Locals retrieves the accounts currently considered local by the pool.
 func (pool *TxPool) Locals() []common.Address {
 	pool.mu.Lock()
@@ -1132,6 +1179,12 @@ func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
 	return pool.all.Get(hash)
 }
 
+// FirstSeen returns the time the transaction with the given hash entered the
+// pool, if it is still contained in it.
+func (pool *TxPool) FirstSeen(hash common.Hash) (time.Time, bool) {
+	return pool.all.FirstSeen(hash)
+}
+
 // Has returns an indicator whether txpool has a transaction cached with the
 // given hash.
 func (pool *TxPool) Has(hash common.Hash) bool {
@@ -1365,13 +1418,15 @@ func (as *accountSet) flatten() []common.Address {
 // TxPool.mu mutex.
 type txLookup struct {
 	all  map[common.Hash]*types.Transaction
+	seen map[common.Hash]time.Time // Time each transaction was first added
 	lock sync.RWMutex
 }
 
 // newTxLookup returns a new txLookup structure.
 func newTxLookup() *txLookup {
 	return &txLookup{
-		all: make(map[common.Hash]*types.Transaction),
+		all:  make(map[common.Hash]*types.Transaction),
+		seen: make(map[common.Hash]time.Time),
 	}
 }
 
@@ -1403,12 +1458,25 @@ func (t *txLookup) Count() int {
 	return len(t.all)
 }
 
+// FirstSeen returns the time the transaction was first added to the lookup.
+func (t *txLookup) FirstSeen(hash common.Hash) (time.Time, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	seen, ok := t.seen[hash]
+	return seen, ok
+}
+
 // Add adds a transaction to the lookup.
 func (t *txLookup) Add(tx *types.Transaction) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	t.all[tx.Hash()] = tx
+	hash := tx.Hash()
+	t.all[hash] = tx
+	if _, ok := t.seen[hash]; !ok {
+		t.seen[hash] = time.Now()
+	}
 }
 
 // Remove removes a transaction from the lookup.
@@ -1417,6 +1485,7 @@ func (t *txLookup) Remove(hash common.Hash) {
 	defer t.lock.Unlock()
 
 	delete(t.all, hash)
+	delete(t.seen, hash)
 }
 
 // Remove removes a transaction from the lookup.
@@ -1428,6 +1497,7 @@ func (t *txLookup) RemoveTxs(txs types.Transactions) {
 		hash := tx.Hash()
 		//log.Trace("Removed old pending transaction", "hash", hash)
 		delete(t.all, hash)
+		delete(t.seen, hash)
 	}
 }
 
@@ -216,6 +216,11 @@ type Block struct {
 	ReceivedAt   time.Time
 	ReceivedFrom interface{}
 	ConfirmSigns []*common.BlockConfirmSign
+
+	// WitnessHash is the hash of the state witness of the block, zero unless
+	// witnessing was enabled on the state it was built from. It is not part of
+	// the block encoding.
+	WitnessHash common.Hash
 }
 
 // [deprecated by eth/63]
@@ -382,6 +387,7 @@ func (b *Block) WithSeal(header *Header) *Block {
 	return &Block{
 		header:       &cpy,
 		transactions: b.transactions,
+		WitnessHash:  b.WitnessHash,
 	}
 }
 
@@ -40,28 +40,57 @@ type IstanbulExtra struct {
 	Validators    []common.Address
 	Seal          []byte
 	CommittedSeal [][]byte
+
+	// Metadata carries optional chain-specific data, such as governance
+	// parameters. It is only encoded when present, so extras without it keep
+	// their encoding and hash.
+	Metadata []byte
 }
 
 // EncodeRLP serializes ist into the Ethereum RLP format.
 func (ist *IstanbulExtra) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, []interface{}{
+	fields := []interface{}{
 		ist.Validators,
 		ist.Seal,
 		ist.CommittedSeal,
-	})
+	}
+	if len(ist.Metadata) > 0 {
+		fields = append(fields, ist.Metadata)
+	}
+	return rlp.Encode(w, fields)
 }
 
 // DecodeRLP implements rlp.Decoder, and load the istanbul fields from a RLP stream.
 func (ist *IstanbulExtra) DecodeRLP(s *rlp.Stream) error {
+	if _, err := s.List(); err != nil {
+		return err
+	}
 	var istanbulExtra struct {
 		Validators    []common.Address
 		Seal          []byte
 		CommittedSeal [][]byte
 	}
-	if err := s.Decode(&istanbulExtra); err != nil {
+	if err := s.Decode(&istanbulExtra.Validators); err != nil {
+		return err
+	}
+	if err := s.Decode(&istanbulExtra.Seal); err != nil {
+		return err
+	}
+	if err := s.Decode(&istanbulExtra.CommittedSeal); err != nil {
+		return err
+	}
+	// Extras written before the metadata was introduced end here
+	metadata, err := s.Bytes()
+	if err != nil && err != rlp.EOL {
+		return err
+	}
+	if err := s.ListEnd(); err != nil {
 		return err
 	}
 	ist.Validators, ist.Seal, ist.CommittedSeal = istanbulExtra.Validators, istanbulExtra.Seal, istanbulExtra.CommittedSeal
+	if len(metadata) > 0 {
+		ist.Metadata = metadata
+	}
 	return nil
 }
 
@@ -81,6 +110,39 @@ func ExtractIstanbulExtra(h *Header) (*IstanbulExtra, error) {
 	return istanbulExtra, nil
 }
 
+// IstanbulCommittedSealCount returns the number of committed seals in the
+// extra-data of the header. Unlike ExtractIstanbulExtra it only walks the RLP
+// structure of the extra-data without decoding the validators and seals.
+func IstanbulCommittedSealCount(h *Header) (int, error) {
+	if len(h.Extra) < IstanbulExtraVanity {
+		return 0, ErrInvalidIstanbulHeaderExtra
+	}
+	fields, _, err := rlp.SplitList(h.Extra[IstanbulExtraVanity:])
+	if err != nil {
+		return 0, err
+	}
+	// Skip the validators and the proposer seal
+	kind, _, rest, err := rlp.Split(fields)
+	if err != nil {
+		return 0, err
+	}
+	if kind != rlp.List {
+		return 0, rlp.ErrExpectedList
+	}
+	kind, _, rest, err = rlp.Split(rest)
+	if err != nil {
+		return 0, err
+	}
+	if kind == rlp.List {
+		return 0, rlp.ErrExpectedString
+	}
+	seals, _, err := rlp.SplitList(rest)
+	if err != nil {
+		return 0, err
+	}
+	return rlp.CountValues(seals)
+}
+
 // IstanbulFilteredHeader returns a filtered header which some information (like seal, committed seals)
 // are clean to fulfill the Istanbul hash rules. It returns nil if the extra-data cannot be
 // decoded/encoded by rlp.
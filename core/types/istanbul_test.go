@@ -23,6 +23,7 @@ import (
 
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/common/hexutil"
+	"github.com/Venachain/Venachain/rlp"
 )
 
 func TestHeaderHash(t *testing.T) {
@@ -89,3 +90,77 @@ func TestExtractToIstanbul(t *testing.T) {
 		}
 	}
 }
+
+func TestIstanbulExtraMetadata(t *testing.T) {
+	// Extras encoded before the metadata field keep decoding and encoding the same
+	legacy := hexutil.MustDecode("0xf858f8549444add0ec310f115a0e603b2d7db9f067778eaf8a94294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212946beaaed781d2d2ab6350f5c4566a2c6eaac407a6948be76812f765c24641ec63dc2852b378aba2b44080c0")
+	h := &Header{Extra: append(make([]byte, IstanbulExtraVanity), legacy...)}
+	extra, err := ExtractIstanbulExtra(h)
+	if err != nil {
+		t.Fatalf("failed to decode legacy extra: %v", err)
+	}
+	if extra.Metadata != nil {
+		t.Fatalf("legacy extra metadata mismatch: have %x, want nil", extra.Metadata)
+	}
+	encoded, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		t.Fatalf("failed to encode legacy extra: %v", err)
+	}
+	if !bytes.Equal(encoded, legacy) {
+		t.Fatalf("legacy extra encoding mismatch: have %x, want %x", encoded, legacy)
+	}
+
+	// Metadata survives a round trip
+	extra.Metadata = []byte{0x01, 0x02, 0x03}
+	encoded, err = rlp.EncodeToBytes(extra)
+	if err != nil {
+		t.Fatalf("failed to encode extra: %v", err)
+	}
+	h.Extra = append(make([]byte, IstanbulExtraVanity), encoded...)
+	decoded, err := ExtractIstanbulExtra(h)
+	if err != nil {
+		t.Fatalf("failed to decode extra: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, extra) {
+		t.Fatalf("extra mismatch: have %v, want %v", decoded, extra)
+	}
+	// and is covered by the header hash
+	filtered := IstanbulFilteredHeader(h, false)
+	if filtered == nil {
+		t.Fatalf("failed to filter header")
+	}
+	if decoded, _ := ExtractIstanbulExtra(filtered); !bytes.Equal(decoded.Metadata, extra.Metadata) {
+		t.Fatalf("filtered metadata mismatch: have %x, want %x", decoded.Metadata, extra.Metadata)
+	}
+}
+
+func TestIstanbulCommittedSealCount(t *testing.T) {
+	seal := bytes.Repeat([]byte{0x01}, IstanbulExtraSeal)
+	for i, extra := range []*IstanbulExtra{
+		{Validators: []common.Address{{0x01}}, Seal: []byte{}, CommittedSeal: [][]byte{}},
+		{Validators: []common.Address{{0x01}, {0x02}}, Seal: seal, CommittedSeal: [][]byte{seal}},
+		{Validators: []common.Address{{0x01}, {0x02}, {0x03}}, Seal: seal, CommittedSeal: [][]byte{seal, seal, seal}},
+		{Validators: []common.Address{{0x01}}, Seal: seal, CommittedSeal: [][]byte{seal, seal}, Metadata: []byte{0x01}},
+	} {
+		payload, err := rlp.EncodeToBytes(extra)
+		if err != nil {
+			t.Fatalf("test %d: failed to encode extra: %v", i, err)
+		}
+		h := &Header{Extra: append(make([]byte, IstanbulExtraVanity), payload...)}
+		count, err := IstanbulCommittedSealCount(h)
+		if err != nil {
+			t.Fatalf("test %d: failed to count committed seals: %v", i, err)
+		}
+		if count != len(extra.CommittedSeal) {
+			t.Errorf("test %d: committed seal count mismatch: have %d, want %d", i, count, len(extra.CommittedSeal))
+		}
+	}
+	// Malformed extra-data is rejected like by ExtractIstanbulExtra
+	if _, err := IstanbulCommittedSealCount(&Header{Extra: make([]byte, IstanbulExtraVanity-1)}); err != ErrInvalidIstanbulHeaderExtra {
+		t.Errorf("error mismatch for short extra: have %v, want %v", err, ErrInvalidIstanbulHeaderExtra)
+	}
+	malformed, _ := rlp.EncodeToBytes([]interface{}{[]byte{0x01}, []byte{}, [][]byte{}})
+	if _, err := IstanbulCommittedSealCount(&Header{Extra: append(make([]byte, IstanbulExtraVanity), malformed...)}); err != rlp.ErrExpectedList {
+		t.Errorf("error mismatch for malformed extra: have %v, want %v", err, rlp.ErrExpectedList)
+	}
+}
@@ -411,6 +411,15 @@ func NewTransactionsByPriceAndNonce(signer Signer, txs map[common.Address]Transa
 	}
 }
 
+// Heads returns up to n transactions at the head of their account's queue, the
+// best one first followed by the others in no particular order.
+func (t *TransactionsByPriceAndNonce) Heads(n int) []*Transaction {
+	if n > len(t.heads) {
+		n = len(t.heads)
+	}
+	return append([]*Transaction(nil), t.heads[:n]...)
+}
+
 // Peek returns the next transaction by price.
 func (t *TransactionsByPriceAndNonce) Peek() *Transaction {
 	if len(t.heads) == 0 {
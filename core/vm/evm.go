@@ -132,6 +132,9 @@ type EVM struct {
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
 	callGasTemp uint64
+	// callGuard tracks the calls into each address, nil unless the
+	// reentrancy guard is enabled.
+	callGuard *StaticCallGuard
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
@@ -146,6 +149,9 @@ func NewEVM(ctx Context, statedb StateDB, chainConfig *params.ChainConfig, vmCon
 		interpreters: make([]Interpreter, 0, 1),
 		InitEntryID:  -1,
 	}
+	if vmConfig.ReentrancyGuard {
+		evm.callGuard = NewStaticCallGuard(vmConfig.ReentrancyDepthLimit)
+	}
 
 	// vmConfig.EVMInterpreter will be used by EVM-C, it won't be checked here
 	// as we always want to have the built-in EVM as the failover option.
@@ -184,6 +190,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	exit, err := evm.guardCall(addr)
+	if err != nil {
+		return nil, gas, err
+	}
+	defer exit()
+
 	// Fail if we're trying to transfer more than the available balance
 	if !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
 		return nil, gas, ErrInsufficientBalance
@@ -193,7 +205,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		to       = AccountRef(addr)
 		snapshot = evm.StateDB.Snapshot() // - snapshot.
 	)
-	if !evm.StateDB.Exist(addr) {
+	if !evm.StateDB.AccountExists(addr) {
 		if PrecompiledContracts[addr] == nil && value.Sign() == 0 {
 			// Calling a non existing account, don't do anything, but ping the tracer
 			if evm.vmConfig.Debug && evm.depth == 0 {
@@ -326,6 +338,11 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	exit, err := evm.guardCall(addr)
+	if err != nil {
+		return nil, gas, err
+	}
+	defer exit()
 
 	var (
 		to       = AccountRef(addr)
@@ -19,4 +19,12 @@ type Config struct {
 	EWASMInterpreter string
 	// Type of the EVM interpreter
 	EVMInterpreter string
+
+	// ReentrancyGuard rejects calls nesting deeper than ReentrancyDepthLimit
+	// into the same address. It changes execution results, so it is meant for
+	// analysing calls and not for processing blocks.
+	ReentrancyGuard bool
+	// ReentrancyDepthLimit is the nesting allowed by the reentrancy guard, 0
+	// for DefaultReentrancyDepthLimit.
+	ReentrancyDepthLimit int
 }
@@ -163,6 +163,7 @@ func (in *WASMInterpreter) Run(contract *Contract, input []byte, readOnly bool)
 		StateDB:  NewWasmStateDB(in.wasmStateDB, contract),
 		Log:      in.WasmLogger,
 	}
+	context.Config.Debug = in.cfg.Debug
 
 	var lvm *exec.VirtualMachine
 	var module *lru.WasmModule
@@ -176,6 +177,17 @@ func (in *WASMInterpreter) Run(contract *Contract, input []byte, readOnly bool)
 		}
 		lru.WasmCache().Add(contract.Address(), module)
 	}
+	// Reject contracts built against an incompatible host function table
+	// before deploying them.
+	if input == nil {
+		version, err := contractHostFunctionTableVersion(module.Module)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkHostFunctionTable(version, HostFunctionTableVersion); err != nil {
+			return nil, err
+		}
+	}
 
 	lvm, err = exec.NewVirtualMachineWithModule(module.Module, module.FunctionCode, context, in.resolver, nil)
 	if err != nil {
@@ -290,6 +290,9 @@ func (stateDB) HasSuicided(common.Address) bool                 { return true }
 // Notably this should also return true for suicided accounts.
 func (stateDB) Exist(common.Address) bool { return true }
 
+// AccountExists is a cheaper Exist that does not load the account.
+func (stateDB) AccountExists(common.Address) bool { return true }
+
 // Empty returns whether the given account is empty. Empty
 // is defined according to EIP161 (balance = nonce = code = 0).
 func (stateDB) Empty(common.Address) bool { return true }
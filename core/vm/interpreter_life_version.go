@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/Venachain/Venachain/life/compiler"
+)
+
+// HostFunctionTableVersion is the version of the host function table exposed
+// by the WASM interpreter. It must be bumped, and CompatibilityMatrix updated,
+// whenever host functions are inserted or reordered.
+const HostFunctionTableVersion uint32 = 1
+
+// HostFunctionTableSection is the name of the WASM custom section holding the
+// host function table version a contract was compiled against, encoded as a
+// little endian uint32. Contracts without it predate the versioning and are
+// not checked.
+const HostFunctionTableSection = "host_function_table_version"
+
+// CompatibilityMatrix maps the host function table versions contracts may be
+// compiled against to the minimum interpreter version able to run them.
+// Versions missing from the matrix are no longer supported.
+var CompatibilityMatrix = map[uint32]uint32{
+	1: 1,
+}
+
+var (
+	errInvalidHostFunctionTableSection = errors.New("interpreter_life: invalid host function table version section")
+	errIncompatibleHostFunctionTable   = errors.New("interpreter_life: incompatible host function table version")
+)
+
+// contractHostFunctionTableVersion returns the host function table version the
+// module was compiled against, or 0 if it does not declare one.
+func contractHostFunctionTableVersion(m *compiler.Module) (uint32, error) {
+	for _, sec := range m.Base.Customs {
+		if sec.Name != HostFunctionTableSection {
+			continue
+		}
+		if len(sec.Data) != 4 {
+			return 0, errInvalidHostFunctionTableSection
+		}
+		return binary.LittleEndian.Uint32(sec.Data), nil
+	}
+	return 0, nil
+}
+
+// checkHostFunctionTable verifies that a contract compiled against the given
+// host function table version can run on an interpreter exposing the table of
+// interpreter version.
+func checkHostFunctionTable(version, interpreter uint32) error {
+	if version == 0 {
+		return nil
+	}
+	min, ok := CompatibilityMatrix[version]
+	if !ok {
+		return fmt.Errorf("%w: contract version %d not supported by interpreter version %d", errIncompatibleHostFunctionTable, version, interpreter)
+	}
+	if interpreter < min {
+		return fmt.Errorf("%w: contract version %d requires interpreter version %d, have %d", errIncompatibleHostFunctionTable, version, min, interpreter)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/Venachain/Venachain/life/compiler"
+)
+
+// versionedModule returns an empty WASM module declaring the given host
+// function table version in a custom section of the given payload size.
+func versionedModule(t *testing.T, version uint32, size int) *compiler.Module {
+	payload := make([]byte, size)
+	binary.LittleEndian.PutUint32(payload, version)
+	section := append([]byte{byte(len(HostFunctionTableSection))}, HostFunctionTableSection...)
+	section = append(section, payload...)
+
+	code := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	code = append(code, 0x00, byte(len(section)))
+	code = append(code, section...)
+	m, err := compiler.LoadModule(code)
+	if err != nil {
+		t.Fatalf("failed to load module: %v", err)
+	}
+	return m
+}
+
+func TestContractHostFunctionTableVersion(t *testing.T) {
+	if version, err := contractHostFunctionTableVersion(versionedModule(t, 7, 4)); err != nil || version != 7 {
+		t.Fatalf("have version %d, err %v, want version 7", version, err)
+	}
+	if _, err := contractHostFunctionTableVersion(versionedModule(t, 7, 8)); err != errInvalidHostFunctionTableSection {
+		t.Fatalf("error mismatch: have %v, want %v", err, errInvalidHostFunctionTableSection)
+	}
+	// Modules without the section predate the versioning
+	m, err := compiler.LoadModule([]byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Fatalf("failed to load module: %v", err)
+	}
+	if version, err := contractHostFunctionTableVersion(m); err != nil || version != 0 {
+		t.Fatalf("have version %d, err %v, want version 0", version, err)
+	}
+}
+
+func TestCheckHostFunctionTable(t *testing.T) {
+	defer func(matrix map[uint32]uint32) { CompatibilityMatrix = matrix }(CompatibilityMatrix)
+	// Version 2 inserted host functions, version 3 only appended to them
+	CompatibilityMatrix = map[uint32]uint32{2: 2, 3: 2}
+
+	tests := []struct {
+		version, interpreter uint32
+		compatible           bool
+	}{
+		{0, 3, true},  // unversioned contract
+		{2, 2, true},  // same version
+		{3, 3, true},  // same version
+		{2, 3, true},  // older contract, compatible interpreter
+		{3, 2, true},  // newer contract, only needing the version 2 table
+		{1, 3, false}, // forward incompatibility, table reordered since
+		{2, 1, false}, // backward incompatibility, interpreter too old
+		{4, 3, false}, // contract newer than the interpreter knows
+	}
+	for i, tt := range tests {
+		err := checkHostFunctionTable(tt.version, tt.interpreter)
+		if tt.compatible && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+		if !tt.compatible && !errors.Is(err, errIncompatibleHostFunctionTable) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, errIncompatibleHostFunctionTable)
+		}
+	}
+	// The current interpreter runs contracts built against its own table
+	CompatibilityMatrix = map[uint32]uint32{HostFunctionTableVersion: HostFunctionTableVersion}
+	if err := checkHostFunctionTable(HostFunctionTableVersion, HostFunctionTableVersion); err != nil {
+		t.Errorf("current version rejected: %v", err)
+	}
+}
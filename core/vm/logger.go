@@ -106,6 +106,18 @@ type Tracer interface {
 	CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error
 }
 
+// LogTracer is implemented by tracers that want to receive the debug logs
+// emitted by WASM contracts through the debug_log host function.
+type LogTracer interface {
+	CaptureLog(env *EVM, log *types.Log) error
+}
+
+// ReentrancyTracer is implemented by tracers that want to be notified of calls
+// re-entering an address already executing, when the reentrancy guard is on.
+type ReentrancyTracer interface {
+	OnReentrant(addr common.Address, depth int)
+}
+
 // StructLogger is an EVM state logger and implements Tracer.
 //
 // StructLogger can capture state based on the given Log configuration and also keeps
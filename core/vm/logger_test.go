@@ -24,6 +24,8 @@ import (
 	"github.com/Venachain/Venachain/log"
 
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/params"
 )
 
@@ -84,3 +86,54 @@ func TestNewWasmLogger(t *testing.T) {
 		t.Fatalf("output log error")
 	}
 }
+
+type debugLogTracer struct {
+	*StructLogger
+	logs []*types.Log
+}
+
+func (t *debugLogTracer) CaptureLog(env *EVM, log *types.Log) error {
+	t.logs = append(t.logs, log)
+	return nil
+}
+
+func TestWasmDebugLog(t *testing.T) {
+	var (
+		tracer   = &debugLogTracer{StructLogger: NewStructLogger(nil)}
+		contract = NewContract(&dummyContractRef{}, &dummyContractRef{}, new(big.Int), 0)
+	)
+	// Debug disabled, the log must not reach the tracer
+	db := &WasmStateDB{cfg: &Config{Tracer: tracer}, contract: contract}
+	if db.DebugEnabled() {
+		t.Fatal("debug enabled without Config.Debug")
+	}
+	msg := []byte("hello")
+	db.DebugLog(1, msg)
+	if len(tracer.logs) != 0 {
+		t.Fatalf("expected no logs, got %d", len(tracer.logs))
+	}
+	if allocs := testing.AllocsPerRun(100, func() { db.DebugLog(1, msg) }); allocs != 0 {
+		t.Errorf("disabled debug log allocates: %v", allocs)
+	}
+
+	// Debug enabled, the log is forwarded with the Debug(uint32,string) topic
+	db.cfg.Debug = true
+	db.DebugLog(3, msg)
+	if len(tracer.logs) != 1 {
+		t.Fatalf("expected 1 log, got %d", len(tracer.logs))
+	}
+	l := tracer.logs[0]
+	if l.Address != contract.Address() {
+		t.Errorf("address mismatch: have %x, want %x", l.Address, contract.Address())
+	}
+	if len(l.Topics) != 1 || l.Topics[0] != crypto.Keccak256Hash([]byte("Debug(uint32,string)")) {
+		t.Errorf("unexpected topics: %v", l.Topics)
+	}
+	want, _ := MakeReturnTuple([]ABIComponent{
+		{Type: "uint32", Value: common.Uint32ToBytes(3)},
+		{Type: "string", Value: []byte("hello")},
+	})
+	if !bytes.Equal(l.Data, want) {
+		t.Errorf("data mismatch: have %x, want %x", l.Data, want)
+	}
+}
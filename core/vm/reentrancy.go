@@ -0,0 +1,81 @@
+package vm
+
+import "github.com/Venachain/Venachain/common"
+
+// DefaultReentrancyDepthLimit is the number of nested calls into the same
+// address allowed by the reentrancy guard when no limit is configured.
+const DefaultReentrancyDepthLimit = 2
+
+// StaticCallGuard tracks how many CALL and STATICCALL frames of each address
+// are executing at once, to detect contracts calling back into themselves,
+// possibly through system contracts. Like the EVM owning it, it is not safe
+// for concurrent use.
+type StaticCallGuard struct {
+	limit  int
+	depths map[common.Address]int
+}
+
+// NewStaticCallGuard creates a guard allowing limit nested calls into the same
+// address, or DefaultReentrancyDepthLimit if limit is not positive.
+func NewStaticCallGuard(limit int) *StaticCallGuard {
+	if limit <= 0 {
+		limit = DefaultReentrancyDepthLimit
+	}
+	return &StaticCallGuard{
+		limit:  limit,
+		depths: make(map[common.Address]int),
+	}
+}
+
+// enter records a call into addr and returns its depth. If the depth exceeds
+// the limit the call is not recorded and ErrReentrancy is returned.
+func (g *StaticCallGuard) enter(addr common.Address) (int, error) {
+	depth := g.depths[addr] + 1
+	if depth > g.limit {
+		return depth, ErrReentrancy
+	}
+	g.depths[addr] = depth
+	return depth, nil
+}
+
+// exit records the return of a call into addr.
+func (g *StaticCallGuard) exit(addr common.Address) {
+	if depth := g.depths[addr]; depth > 1 {
+		g.depths[addr] = depth - 1
+	} else {
+		delete(g.depths, addr)
+	}
+}
+
+// Depths returns the number of calls executing for every address with one.
+func (g *StaticCallGuard) Depths() map[common.Address]int {
+	depths := make(map[common.Address]int, len(g.depths))
+	for addr, depth := range g.depths {
+		depths[addr] = depth
+	}
+	return depths
+}
+
+// CallGuard returns the reentrancy guard of the EVM, nil if not enabled.
+func (evm *EVM) CallGuard() *StaticCallGuard {
+	return evm.callGuard
+}
+
+// guardCall records a call into addr with the reentrancy guard, if enabled,
+// and notifies the tracer of re-entrant calls. The returned function must be
+// called when the call returns.
+func (evm *EVM) guardCall(addr common.Address) (func(), error) {
+	if evm.callGuard == nil {
+		return func() {}, nil
+	}
+	depth, err := evm.callGuard.enter(addr)
+	if depth > 1 && evm.vmConfig.Debug {
+		if tracer, ok := evm.vmConfig.Tracer.(ReentrancyTracer); ok {
+			tracer.OnReentrant(addr, depth)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { evm.callGuard.exit(addr) }, nil
+}
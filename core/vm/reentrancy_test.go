@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/params"
+)
+
+type reentrancyTracer struct {
+	*StructLogger
+	depths []int
+}
+
+func (t *reentrancyTracer) OnReentrant(addr common.Address, depth int) {
+	t.depths = append(t.depths, depth)
+}
+
+func TestStaticCallGuard(t *testing.T) {
+	var (
+		guard = NewStaticCallGuard(0)
+		addr  = common.BytesToAddress([]byte{0x01})
+		other = common.BytesToAddress([]byte{0x02})
+	)
+	for i := 1; i <= DefaultReentrancyDepthLimit; i++ {
+		if depth, err := guard.enter(addr); err != nil || depth != i {
+			t.Fatalf("call %d: have depth %d, err %v, want depth %d", i, depth, err, i)
+		}
+	}
+	if _, err := guard.enter(addr); err != ErrReentrancy {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrReentrancy)
+	}
+	// Other addresses are tracked separately
+	if depth, err := guard.enter(other); err != nil || depth != 1 {
+		t.Fatalf("other address: have depth %d, err %v, want depth 1", depth, err)
+	}
+	if have := guard.Depths(); have[addr] != DefaultReentrancyDepthLimit || have[other] != 1 {
+		t.Fatalf("depths mismatch: have %v", have)
+	}
+	// Returning calls release their depth
+	guard.exit(other)
+	guard.exit(addr)
+	if depth, err := guard.enter(addr); err != nil || depth != DefaultReentrancyDepthLimit {
+		t.Fatalf("after return: have depth %d, err %v, want depth %d", depth, err, DefaultReentrancyDepthLimit)
+	}
+	guard.exit(addr)
+	guard.exit(addr)
+	if have := guard.Depths(); len(have) != 0 {
+		t.Fatalf("depths left after all calls returned: %v", have)
+	}
+}
+
+func TestReentrancyGuardCall(t *testing.T) {
+	var (
+		tracer = &reentrancyTracer{StructLogger: NewStructLogger(nil)}
+		config = Config{Debug: true, Tracer: tracer, ReentrancyGuard: true, ReentrancyDepthLimit: 1}
+		env    = NewEVM(Context{}, nil, &params.ChainConfig{}, config)
+		addr   = common.BytesToAddress([]byte{0x01})
+	)
+	if guard := NewEVM(Context{}, nil, &params.ChainConfig{}, Config{}).CallGuard(); guard != nil {
+		t.Fatalf("guard enabled by default")
+	}
+	// Simulate a call into addr being executed
+	if _, err := env.CallGuard().enter(addr); err != nil {
+		t.Fatalf("failed to enter call: %v", err)
+	}
+	caller := &dummyContractRef{}
+	if _, gas, err := env.Call(caller, addr, nil, 100, new(big.Int)); err != ErrReentrancy || gas != 100 {
+		t.Fatalf("call: have gas %d, err %v, want gas 100, err %v", gas, err, ErrReentrancy)
+	}
+	if _, _, err := env.StaticCall(caller, addr, nil, 100); err != ErrReentrancy {
+		t.Fatalf("static call: have err %v, want %v", err, ErrReentrancy)
+	}
+	if len(tracer.depths) != 2 || tracer.depths[0] != 2 || tracer.depths[1] != 2 {
+		t.Fatalf("reentrant calls mismatch: have %v, want [2 2]", tracer.depths)
+	}
+	if have := env.CallGuard().Depths()[addr]; have != 1 {
+		t.Fatalf("depth mismatch: have %d, want 1", have)
+	}
+}
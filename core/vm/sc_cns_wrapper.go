@@ -53,7 +53,10 @@ func (cns *CnsWrapper) Run(input []byte) ([]byte, error) {
 		cns.base.emitEvent(fnName, operateFail, err.Error())
 
 		if strings.Contains(fnName, "getRegisteredContracts") {
-			return MakeReturnBytes([]byte(newInternalErrorResult(err).String())), err
+			if ret, encErr := MakeReturnBytes([]byte(newInternalErrorResult(err).String())); encErr == nil {
+				return ret, err
+			}
+			return nil, err
 		}
 
 		if strings.ContainsAny(fnName, "ifRegistered") {
@@ -49,6 +49,7 @@ var (
 	errNodeNameExist  = errors.New("node name exist")
 	errPublicKeyExist = errors.New("publicKey exist")
 	errNodeNotFound   = errors.New("node not found")
+	errValidatorsFull = errors.New("maximum number of validators reached")
 )
 
 const (
@@ -161,6 +162,8 @@ type SCNode struct {
 	contractAddr common.Address
 	caller       common.Address
 	blockNumber  *big.Int
+
+	maxValidators uint64 // Maximum number of normal validator nodes, 0 for no limit
 }
 
 func NewSCNode(db StateDB) *SCNode {
@@ -289,6 +292,29 @@ func (n *SCNode) checkPublicKeyExist(pub string) error {
 	return nil
 }
 
+// checkValidatorsLimit fails if node is a normal validator that would exceed
+// the maximum number of validators, counting the other normal validators.
+func (n *SCNode) checkValidatorsLimit(node *syscontracts.NodeInfo) error {
+	if n.maxValidators == 0 || node.Typ != NodeTypeValidator || node.Status != NodeStatusNormal {
+		return nil
+	}
+	query := &syscontracts.NodeInfo{Typ: NodeTypeValidator, Status: NodeStatusNormal}
+	validators, err := n.GetNodes(query)
+	if err != nil && err != errNodeNotFound {
+		return err
+	}
+	var others uint64
+	for _, validator := range validators {
+		if validator.Name != node.Name {
+			others++
+		}
+	}
+	if others >= n.maxValidators {
+		return errValidatorsFull
+	}
+	return nil
+}
+
 func (n *SCNode) checkPermissionForAdd() error {
 	//internal call, do not check permission
 	if common.IsHexZeroAddress(n.caller.String()) {
@@ -315,6 +341,12 @@ func (n *SCNode) add(node *syscontracts.NodeInfo) error {
 		return errParamsInvalid
 	}
 
+	if err := n.checkValidatorsLimit(node); err != nil {
+		n.emitNotifyEvent(addNodeBadParameter, fmt.Sprintf("Failed to add node. err:%s", err.Error()))
+		log.Error("Failed to add node.", "error", err.Error(), "node", node.String())
+		return err
+	}
+
 	err := n.addName(node.Name)
 	if err != nil {
 		n.emitNotifyEvent(addNodeBadParameter, fmt.Sprintf("Failed to add node. err:%s", err.Error()))
@@ -348,6 +380,12 @@ func (n *SCNode) update(name string, update *syscontracts.UpdateNode) error {
 		return err
 	}
 
+	if err := n.checkValidatorsLimit(node); err != nil {
+		n.emitNotifyEvent(updateNodeBadParameter, fmt.Sprintf("Failed to update node. err:%s", err.Error()))
+		log.Error("Failed to update node.", "error", err.Error(), "update", update.String())
+		return err
+	}
+
 	encodedBin, err := rlp.EncodeToBytes(node)
 	if err != nil {
 		n.emitNotifyEvent(updateNodeBadParameter, fmt.Sprintf("parameter is invalid"))
@@ -567,6 +605,13 @@ func (n *SCNode) emitEvent(topic string, code CodeType, msg string) {
 	emitEvent(n.contractAddr, n.stateDB, n.blockNumber.Uint64(), topic, code, msg)
 }
 
+// ElectedNodeNames returns the encoded names of the nodes chosen by the latest
+// VRF election, empty if none was held. Unlike GetVrfConsensusNodes it emits no
+// events, so it leaves the receipts untouched.
+func (n *SCNode) ElectedNodeNames() []byte {
+	return n.getState(keyOfConsensisNodeNameDB)
+}
+
 func (n *SCNode) VrfElection(nonce []byte) (int32, error) {
 
 	scParam := &scParamManagerWrapper{
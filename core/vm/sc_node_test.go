@@ -8,6 +8,7 @@ import (
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/common/syscontracts"
 	"github.com/Venachain/Venachain/crypto"
+	"github.com/Venachain/Venachain/params"
 	"github.com/Venachain/Venachain/rlp"
 
 	"math/big"
@@ -585,3 +586,51 @@ func Test_importOldData(t *testing.T) {
 	}
 	t.Logf("%+v\n", res)
 }
+
+func TestSCNode_MaxValidators(t *testing.T) {
+	n := NewSCNode(newMockStateDB())
+	n.maxValidators = 2
+
+	validators := make([]*syscontracts.NodeInfo, 3)
+	for i := range validators {
+		validators[i] = randFakeNodeInfo()
+		validators[i].Typ = NodeTypeValidator
+	}
+	assert.NoError(t, n.add(validators[0]))
+	assert.NoError(t, n.add(validators[1]))
+	assert.Equal(t, errValidatorsFull, n.add(validators[2]))
+	_, err := n.getNodeByName(validators[2].Name)
+	assert.Equal(t, errNodeNotFound, err)
+
+	// Observers are not counted
+	observer := randFakeNodeInfo()
+	assert.NoError(t, n.add(observer))
+	update := &syscontracts.UpdateNode{}
+	update.SetTyp(NodeTypeValidator)
+	assert.Equal(t, errValidatorsFull, n.update(observer.Name, update))
+
+	// Updating an existing validator is allowed
+	desc := "validator"
+	assert.NoError(t, n.update(validators[0].Name, &syscontracts.UpdateNode{Desc: &desc}))
+
+	// Deleting a validator makes room for another one
+	deleted := &syscontracts.UpdateNode{}
+	deleted.SetStatus(NodeStatusDeleted)
+	assert.NoError(t, n.update(validators[0].Name, deleted))
+	assert.NoError(t, n.update(observer.Name, update))
+}
+
+func TestSCNode_MaxValidatorsFork(t *testing.T) {
+	// The limit never applies without a fork block
+	config := &params.IstanbulConfig{MaxValidators: 4}
+	assert.Equal(t, uint64(0), config.ValidatorsLimit(big.NewInt(100)))
+
+	config.MaxValidatorsBlock = big.NewInt(10)
+	assert.Equal(t, uint64(0), config.ValidatorsLimit(big.NewInt(9)))
+	assert.Equal(t, uint64(4), config.ValidatorsLimit(big.NewInt(10)))
+	assert.Equal(t, uint64(4), config.ValidatorsLimit(big.NewInt(11)))
+
+	// From the fork block on, an unset limit uses the default
+	config.MaxValidators = 0
+	assert.Equal(t, uint64(100), config.ValidatorsLimit(big.NewInt(10)))
+}
@@ -37,7 +37,10 @@ func (n *scNodeWrapper) Run(input []byte) ([]byte, error) {
 		n.base.emitEvent(fnName, operateFail, err.Error())
 
 		if strings.Contains(fnName, "get") {
-			return MakeReturnBytes([]byte(newInternalErrorResult(err).String())), err
+			if ret, encErr := MakeReturnBytes([]byte(newInternalErrorResult(err).String())); encErr == nil {
+				return ret, err
+			}
+			return nil, err
 		}
 	}
 	return ret, nil
@@ -76,7 +76,7 @@ func Test_scNodeWrapper_getAllNodes(t *testing.T) {
 	bin, err := json.Marshal(result)
 	assert.NoError(t, err)
 
-	assert.Equal(t, toContractReturnValueStringType(E_INVOKE_CONTRACT, bin), ret)
+	assert.Equal(t, mustReturnValueString(t, bin), ret)
 }
 
 func Test_scNodeWrapper_getENodesOfAllDeletedNodes(t *testing.T) {
@@ -87,7 +87,7 @@ func Test_scNodeWrapper_getENodesOfAllDeletedNodes(t *testing.T) {
 	ret, err := node.Run(input)
 	assert.NoError(t, err)
 
-	assert.Equal(t, toContractReturnValueStringType(E_INVOKE_CONTRACT, []byte(`{"code":1,"msg":"node not found","data":[]}`)), ret)
+	assert.Equal(t, mustReturnValueString(t, []byte(`{"code":1,"msg":"node not found","data":[]}`)), ret)
 }
 
 func Test_scNodeWrapper_getENodesOfAllNormalNodes(t *testing.T) {
@@ -103,7 +103,7 @@ func Test_scNodeWrapper_getENodesOfAllNormalNodes(t *testing.T) {
 	enode.IP = ni.InternalIP
 	enode.PublicKey = ni.PublicKey
 	expected := newSuccessResult([]*eNode{enode}).String()
-	assert.Equal(t, toContractReturnValueStringType(E_INVOKE_CONTRACT, []byte(expected)), ret)
+	assert.Equal(t, mustReturnValueString(t, []byte(expected)), ret)
 }
 
 func Test_scNodeWrapper_getNodes(t *testing.T) {
@@ -124,7 +124,7 @@ func Test_scNodeWrapper_getNodes(t *testing.T) {
 	bin, err := json.Marshal(result)
 	assert.NoError(t, err)
 
-	assert.Equal(t, toContractReturnValueStringType(E_INVOKE_CONTRACT, bin), ret)
+	assert.Equal(t, mustReturnValueString(t, bin), ret)
 }
 
 func Test_scNodeWrapper_isPublicKeyExist(t *testing.T) {
@@ -130,6 +130,10 @@ func (m *mockStateDB) Exist(common.Address) bool {
 	panic("implement me")
 }
 
+func (m *mockStateDB) AccountExists(common.Address) bool {
+	panic("implement me")
+}
+
 func (m *mockStateDB) Empty(common.Address) bool {
 	panic("implement me")
 }
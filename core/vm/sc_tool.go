@@ -75,11 +75,16 @@ func execSC(input []byte, fns SCExportFns) (string, []byte, error) {
 		log.Error("execute system contract failed.", "error", err)
 	}
 
+	ret, err := toContractReturnValueType(txType, result[0])
+	if err != nil {
+		log.Error("failed to encode system contract result.", "error", err, "function", fnName)
+		return fnName, nil, err
+	}
 	//vm run successfully, so return nil
-	return fnName, toContractReturnValueType(txType, result[0]), nil
+	return fnName, ret, nil
 }
 
-func toContractReturnValueType(txType int, val reflect.Value) []byte {
+func toContractReturnValueType(txType int, val reflect.Value) ([]byte, error) {
 	defer func() {
 		if e := recover(); nil != e {
 			err := fmt.Errorf("toContractReturnValueType:%+v", e)
@@ -89,20 +94,32 @@ func toContractReturnValueType(txType int, val reflect.Value) []byte {
 
 	switch val.Kind() {
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return toContractReturnValueUintType(txType, val.Uint())
+		return toContractReturnValueUintType(txType, val.Uint()), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return toContractReturnValueIntType(txType, val.Int())
+		return toContractReturnValueIntType(txType, val.Int()), nil
 	case reflect.String:
 		return toContractReturnValueStringType(txType, []byte(val.String()))
 	case reflect.Slice:
+		if ints, ok := val.Interface().([]int64); ok {
+			return toContractReturnValueIntArrayType(txType, ints), nil
+		}
 		return toContractReturnValueStringType(txType, val.Bytes())
 	case reflect.Struct:
 		{
 			return toContractReturnValueStructType(txType, val.Interface())
 		}
-		//case reflect.Bool:
+	case reflect.Array:
+		if addr, ok := val.Interface().(common.Address); ok {
+			return toContractReturnValueAddressType(txType, addr), nil
+		}
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			res := make([]byte, val.Len())
+			reflect.Copy(reflect.ValueOf(res), val)
+			return toContractReturnValueBytesNType(txType, res, val.Len()), nil
+		}
+	case reflect.Bool:
+		return toContractReturnValueBoolType(txType, val.Bool()), nil
 		//case reflect.Float64, reflect.Float32:
-		// case reflect.Array
 	}
 	panic("unsupported type")
 }
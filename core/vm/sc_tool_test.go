@@ -22,6 +22,15 @@ func (fc *fakeClass) allExportFns() SCExportFns {
 	}
 }
 
+// mustReturnValueString encodes res like a string returned by a system contract.
+func mustReturnValueString(t *testing.T, res []byte) []byte {
+	ret, err := toContractReturnValueStringType(E_INVOKE_CONTRACT, res)
+	if err != nil {
+		t.Fatalf("failed to encode return value: %v", err)
+	}
+	return ret
+}
+
 func Test_retrieveFnNameAndParams(t *testing.T) {
 	fnNameInput := "Fn"
 	name := "wanxiang"
@@ -55,7 +64,7 @@ func Test_execSC(t *testing.T) {
 
 	ret2, err := (&fakeClass{}).Fn(name, age)
 	assert.NoError(t, err)
-	assert.Equal(t, toContractReturnValueStringType(E_INVOKE_CONTRACT, []byte(ret2)), ret)
+	assert.Equal(t, mustReturnValueString(t, []byte(ret2)), ret)
 
 	input = MakeInput(fnNameInput, "bbb")
 	_, _, err = execSC(input, (&fakeClass{}).allExportFns())
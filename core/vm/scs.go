@@ -51,6 +51,9 @@ func RunPlatONEPrecompiledSC(p PrecompiledContract, input []byte, contract *Cont
 			node.base.caller = evm.Origin
 			node.base.blockNumber = evm.BlockNumber
 			node.base.contractAddr = *contract.CodeAddr
+			if config := evm.ChainConfig(); config != nil && config.Istanbul != nil {
+				node.base.maxValidators = config.Istanbul.ValidatorsLimit(evm.BlockNumber)
+			}
 
 			return node.Run(input)
 		case *CnsWrapper:
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/big"
 
 	"github.com/Venachain/Venachain/common"
@@ -20,6 +21,27 @@ func toContractReturnValueIntType(txType int, res int64) []byte {
 	return finalRes
 }
 
+// toContractReturnValueIntArrayType encodes an int64 array as the ABI dynamic
+// array int256[]: the offset of the array, its length and one two's complement
+// 32-byte word per element.
+func toContractReturnValueIntArrayType(txType int, res []int64) []byte {
+	if txType == common.CallContractFlag {
+		finalRes := make([]byte, 0, 8*len(res))
+		for _, v := range res {
+			finalRes = append(finalRes, utils.Int64ToBytes(v)...)
+		}
+		return finalRes
+	}
+
+	finalRes := make([]byte, 64, 64+32*len(res))
+	copy(finalRes[0:], common.BytesToHash(common.Int32ToBytes(32)).Bytes())
+	copy(finalRes[32:], common.BigToHash(big.NewInt(int64(len(res)))).Bytes())
+	for _, v := range res {
+		finalRes = append(finalRes, toContractReturnValueIntType(txType, v)...)
+	}
+	return finalRes
+}
+
 func toContractReturnValueUintType(txType int, res uint64) []byte {
 	if txType == common.CallContractFlag {
 		return utils.Uint64ToBytes(res)
@@ -29,40 +51,153 @@ func toContractReturnValueUintType(txType int, res uint64) []byte {
 	return finalRes
 }
 
-func toContractReturnValueStringType(txType int, res []byte) []byte {
+func toContractReturnValueBoolType(txType int, res bool) []byte {
+	var b byte
+	if res {
+		b = 1
+	}
+	if txType == common.CallContractFlag {
+		return []byte{b}
+	}
+
+	finalRes := utils.Align32Bytes([]byte{b})
+	return finalRes
+}
+
+func toContractReturnValueAddressType(txType int, addr common.Address) []byte {
+	if txType == common.CallContractFlag {
+		return addr.Bytes()
+	}
+
+	finalRes := utils.Align32Bytes(addr.Bytes())
+	return finalRes
+}
+
+func toContractReturnValueStringType(txType int, res []byte) ([]byte, error) {
 	if txType == common.CallContractFlag || txType == common.TxTypeCallSollCompatibleWasm {
-		return res
+		return res, nil
 	}
 
 	return MakeReturnBytes(res)
 }
 
-func toContractReturnValueStructType(txType int, res interface{}) []byte {
+// toContractReturnValueBytesNType encodes a fixed-size byte array (bytesN). The
+// ABI encoding is the value right-padded to a 32-byte boundary, without the
+// offset and length header of dynamic types.
+func toContractReturnValueBytesNType(txType int, res []byte, n int) []byte {
+	if txType == common.CallContractFlag {
+		return res
+	}
+
+	if len(res) > n {
+		res = res[:n]
+	}
+	size := n
+	if (size % 32) != 0 {
+		size = size + (32 - (size % 32))
+	}
+	finalRes := make([]byte, size)
+	copy(finalRes, res)
+	return finalRes
+}
+
+func toContractReturnValueStructType(txType int, res interface{}) ([]byte, error) {
+	if tuple, ok := res.(ABITuple); ok && txType != common.CallContractFlag {
+		return MakeReturnTuple(tuple.ABIComponents())
+	}
 	b, err := json.Marshal(res)
 	if err != nil {
 		b = []byte{}
 	}
 	if txType == common.CallContractFlag || txType == common.TxTypeCallSollCompatibleWasm {
-		return b
+		return b, nil
 	}
 	return MakeReturnBytes(b)
 }
 
-func MakeReturnBytes(ret []byte) []byte {
-	var dataRealSize = len(ret)
+// MaxReturnDataSize is the maximum size of the contract return data encoded by
+// MakeReturnBytes, bounding the memory a single call can make us allocate.
+var MaxReturnDataSize uint64 = 32 * 1024 * 1024
+
+// MakeReturnBytes ABI encodes ret as a dynamic bytes value: the offset of the
+// data, its length and the data right-padded to a multiple of 32 bytes. Return
+// data above MaxReturnDataSize is rejected with ErrReturnDataTooLarge.
+func MakeReturnBytes(ret []byte) ([]byte, error) {
+	dataSize := uint64(len(ret))
+	if dataSize > MaxReturnDataSize {
+		return nil, ErrReturnDataTooLarge
+	}
+	dataRealSize := dataSize
 	if (dataRealSize % 32) != 0 {
 		dataRealSize = dataRealSize + (32 - (dataRealSize % 32))
 	}
-	dataByt := make([]byte, dataRealSize)
-	copy(dataByt[0:], ret)
 
 	strHash := common.BytesToHash(common.Int32ToBytes(32))
-	sizeHash := common.BytesToHash(common.Int64ToBytes(int64(len(ret))))
+	sizeHash := common.BigToHash(new(big.Int).SetUint64(dataSize))
+
+	finalData := make([]byte, 64+dataRealSize)
+	copy(finalData[0:], strHash.Bytes())
+	copy(finalData[32:], sizeHash.Bytes())
+	copy(finalData[64:], ret)
+
+	return finalData, nil
+}
 
-	finalData := make([]byte, 0)
-	finalData = append(finalData, strHash.Bytes()...)
-	finalData = append(finalData, sizeHash.Bytes()...)
-	finalData = append(finalData, dataByt...)
+// ParseReturnBytes decodes return data encoded by MakeReturnBytes and returns
+// the payload. Malformed input yields an error describing the problem.
+func ParseReturnBytes(data []byte) ([]byte, error) {
+	if len(data) < 64 {
+		return nil, fmt.Errorf("return data too short: have %d bytes, want at least 64", len(data))
+	}
+	offset := new(big.Int).SetBytes(data[:32])
+	if offset.Cmp(big.NewInt(32)) != 0 {
+		return nil, fmt.Errorf("invalid return data offset: have %v, want 32", offset)
+	}
+	size := new(big.Int).SetBytes(data[32:64])
+	if available := uint64(len(data) - 64); !size.IsUint64() || size.Uint64() > available {
+		return nil, fmt.Errorf("return data length %v exceeds available %d bytes", size, available)
+	}
+	return data[64 : 64+size.Uint64()], nil
+}
+
+// ABIComponent is a single value of an ABI encoded tuple. Type is the Solidity
+// type name of the value. Value holds the 32-byte word of static types and the
+// raw data of the dynamic string and bytes types.
+type ABIComponent struct {
+	Type  string
+	Value []byte
+}
 
-	return finalData
+func (c ABIComponent) dynamic() bool {
+	return c.Type == "string" || c.Type == "bytes"
+}
+
+// ABITuple is implemented by struct return values that are encoded as an ABI
+// tuple, e.g. for WASM functions returning multiple values.
+type ABITuple interface {
+	ABIComponents() []ABIComponent
+}
+
+// MakeReturnTuple encodes the components as an ABI tuple: one 32-byte head slot
+// per component, where dynamic components store the offset of their length
+// prefixed data in the tail section.
+func MakeReturnTuple(components []ABIComponent) ([]byte, error) {
+	head := make([]byte, 0, 32*len(components))
+	tail := make([]byte, 0)
+	for _, c := range components {
+		if !c.dynamic() {
+			head = append(head, utils.Align32Bytes(c.Value)...)
+			continue
+		}
+		offset := common.BytesToHash(common.Int64ToBytes(int64(32*len(components) + len(tail))))
+		head = append(head, offset.Bytes()...)
+
+		// The tail holds the length followed by the data padded to 32 bytes
+		enc, err := MakeReturnBytes(c.Value)
+		if err != nil {
+			return nil, err
+		}
+		tail = append(tail, enc[32:]...)
+	}
+	return append(head, tail...), nil
 }
@@ -0,0 +1,175 @@
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Venachain/Venachain/accounts/abi"
+	"github.com/Venachain/Venachain/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToContractReturnValueBytesNType(t *testing.T) {
+	var b32 [32]byte
+	for i := range b32 {
+		b32[i] = byte(i + 1)
+	}
+	// Solidity encodes bytes32 as the single word holding the value
+	assert.Equal(t, b32[:], toContractReturnValueBytesNType(common.TxTypeCallSollCompatibleWasm, b32[:], 32))
+	assert.Equal(t, b32[:], toContractReturnValueBytesNType(common.CallContractFlag, b32[:], 32))
+
+	// Shorter fixed bytes are right padded to a full word
+	b4 := []byte{0xde, 0xad, 0xbe, 0xef}
+	want := append(append([]byte{}, b4...), bytes.Repeat([]byte{0}, 28)...)
+	assert.Equal(t, want, toContractReturnValueBytesNType(common.TxTypeCallSollCompatibleWasm, b4, 4))
+	assert.Equal(t, b4, toContractReturnValueBytesNType(common.CallContractFlag, b4, 4))
+}
+
+func TestToContractReturnValueBoolType(t *testing.T) {
+	word := func(b byte) []byte {
+		w := make([]byte, 32)
+		w[31] = b
+		return w
+	}
+	assert.Equal(t, []byte{1}, toContractReturnValueBoolType(common.CallContractFlag, true))
+	assert.Equal(t, []byte{0}, toContractReturnValueBoolType(common.CallContractFlag, false))
+	assert.Equal(t, word(1), toContractReturnValueBoolType(common.TxTypeCallSollCompatibleWasm, true))
+	assert.Equal(t, word(0), toContractReturnValueBoolType(common.TxTypeCallSollCompatibleWasm, false))
+}
+
+func TestToContractReturnValueAddressType(t *testing.T) {
+	addr := common.HexToAddress("0x1000000000000000000000000000000000000001")
+
+	assert.Equal(t, addr.Bytes(), toContractReturnValueAddressType(common.CallContractFlag, addr))
+
+	res := toContractReturnValueAddressType(common.TxTypeCallSollCompatibleWasm, addr)
+	assert.Equal(t, 32, len(res))
+	assert.Equal(t, make([]byte, 12), res[:12])
+	assert.Equal(t, addr.Bytes(), res[12:])
+}
+
+func TestToContractReturnValueIntArrayType(t *testing.T) {
+	res := []int64{1, -2, 3}
+
+	// abi.encode(int256[]) of [1, -2, 3] as produced by Solidity
+	want := common.Hex2Bytes("" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000003" +
+		"0000000000000000000000000000000000000000000000000000000000000001" +
+		"fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffe" +
+		"0000000000000000000000000000000000000000000000000000000000000003")
+	assert.Equal(t, want, toContractReturnValueIntArrayType(common.TxTypeCallSollCompatibleWasm, res))
+
+	// Empty arrays only hold the offset and the zero length
+	assert.Equal(t, want[:32], toContractReturnValueIntArrayType(common.TxTypeCallSollCompatibleWasm, nil)[:32])
+	assert.Equal(t, make([]byte, 32), toContractReturnValueIntArrayType(common.TxTypeCallSollCompatibleWasm, nil)[32:])
+
+	want = common.Hex2Bytes("0000000000000001" + "fffffffffffffffe" + "0000000000000003")
+	assert.Equal(t, want, toContractReturnValueIntArrayType(common.CallContractFlag, res))
+}
+
+type testTuple struct {
+	Count uint64
+	Name  string
+	Ok    bool
+	Data  []byte
+}
+
+func (t testTuple) ABIComponents() []ABIComponent {
+	return []ABIComponent{
+		{Type: "uint64", Value: toContractReturnValueUintType(common.TxTypeCallSollCompatibleWasm, t.Count)},
+		{Type: "string", Value: []byte(t.Name)},
+		{Type: "bool", Value: toContractReturnValueBoolType(common.TxTypeCallSollCompatibleWasm, t.Ok)},
+		{Type: "bytes", Value: t.Data},
+	}
+}
+
+func TestMakeReturnTuple(t *testing.T) {
+	const def = `[{"type":"function","name":"f","outputs":[{"type":"uint64"},{"type":"string"},{"type":"bool"},{"type":"bytes"}]}]`
+	parsed, err := abi.JSON(strings.NewReader(def))
+	assert.NoError(t, err)
+
+	res := testTuple{
+		Count: 42,
+		Name:  strings.Repeat("venachain", 5),
+		Ok:    true,
+		Data:  []byte{1, 2, 3},
+	}
+	out, err := toContractReturnValueStructType(common.TxTypeCallSollCompatibleWasm, res)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(out)%32)
+
+	values, err := parsed.Methods["f"].Outputs.UnpackValues(out)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{res.Count, res.Name, res.Ok, res.Data}, values)
+
+	// The head holds the offsets of the dynamic components
+	assert.Equal(t, big.NewInt(4*32), new(big.Int).SetBytes(out[32:64]))
+
+	// Direct WASM calls keep the JSON encoding
+	out, err = toContractReturnValueStructType(common.CallContractFlag, res)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('{'), out[0])
+}
+
+func TestMakeReturnBytesLarge(t *testing.T) {
+	ret := bytes.Repeat([]byte{0xab}, 5*1024*1024+7)
+	out, err := MakeReturnBytes(ret)
+	assert.NoError(t, err)
+
+	padded := (len(ret) + 31) / 32 * 32
+	assert.Equal(t, 64+padded, len(out))
+	assert.Equal(t, big.NewInt(32), new(big.Int).SetBytes(out[:32]))
+	assert.Equal(t, big.NewInt(int64(len(ret))), new(big.Int).SetBytes(out[32:64]))
+	assert.Equal(t, ret, out[64:64+len(ret)])
+	assert.Equal(t, make([]byte, padded-len(ret)), out[64+len(ret):])
+
+	// Return data above the configured maximum is rejected
+	defer func(max uint64) { MaxReturnDataSize = max }(MaxReturnDataSize)
+	MaxReturnDataSize = uint64(len(ret) - 1)
+
+	out, err = MakeReturnBytes(ret)
+	assert.Equal(t, ErrReturnDataTooLarge, err)
+	assert.Nil(t, out)
+
+	// The error reaches the caller of a system contract returning the data
+	_, err = toContractReturnValueType(E_INVOKE_CONTRACT, reflect.ValueOf(ret))
+	assert.Equal(t, ErrReturnDataTooLarge, err)
+}
+
+func TestParseReturnBytes(t *testing.T) {
+	for _, ret := range [][]byte{{}, []byte("venachain"), bytes.Repeat([]byte{0xcd}, 100)} {
+		enc, err := MakeReturnBytes(ret)
+		assert.NoError(t, err)
+		out, err := ParseReturnBytes(enc)
+		assert.NoError(t, err)
+		assert.Equal(t, ret, out)
+	}
+
+	enc, err := MakeReturnBytes([]byte("venachain"))
+	assert.NoError(t, err)
+
+	// Input shorter than the offset and length words
+	_, err = ParseReturnBytes(enc[:63])
+	assert.Error(t, err)
+
+	// Offset other than 32
+	bad := append([]byte{}, enc...)
+	bad[31] = 64
+	_, err = ParseReturnBytes(bad)
+	assert.Error(t, err)
+
+	// Declared length beyond the buffer
+	bad = append([]byte{}, enc...)
+	bad[63] = 33
+	_, err = ParseReturnBytes(bad)
+	assert.Error(t, err)
+
+	bad = append([]byte{}, enc...)
+	bad[32] = 0xff
+	_, err = ParseReturnBytes(bad)
+	assert.Error(t, err)
+}
@@ -6,9 +6,13 @@ import (
 
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/params"
 )
 
+// debugLogTopic is the topic of the logs emitted by the debug_log host function.
+var debugLogTopic = crypto.Keccak256Hash([]byte("Debug(uint32,string)"))
+
 type WasmStateDB struct {
 	StateDB  StateDB
 	evm      *EVM
@@ -102,6 +106,40 @@ func (self *WasmStateDB) GetState(key []byte) []byte {
 	return self.evm.StateDB.GetState(self.Address(), key)
 }
 
+// DebugEnabled reports whether debug logs emitted by the contract are
+// forwarded to a tracer.
+func (self *WasmStateDB) DebugEnabled() bool {
+	return self.cfg != nil && self.cfg.Debug && self.cfg.Tracer != nil
+}
+
+// DebugLog forwards a debug log of the contract to the attached tracer. The
+// log never reaches the state, so it does not affect consensus.
+func (self *WasmStateDB) DebugLog(level uint32, msg []byte) {
+	if !self.DebugEnabled() {
+		return
+	}
+	tracer, ok := self.cfg.Tracer.(LogTracer)
+	if !ok {
+		return
+	}
+	data, err := MakeReturnTuple([]ABIComponent{
+		{Type: "uint32", Value: common.Uint32ToBytes(level)},
+		{Type: "string", Value: msg},
+	})
+	if err != nil {
+		return
+	}
+	log := &types.Log{
+		Address: self.Address(),
+		Topics:  []common.Hash{debugLogTopic},
+		Data:    data,
+	}
+	if self.evm != nil {
+		log.BlockNumber = self.evm.BlockNumber.Uint64()
+	}
+	tracer.CaptureLog(self.evm, log)
+}
+
 func (self *WasmStateDB) GetCallerNonce() int64 {
 	addr := self.contract.Caller()
 	return int64(self.evm.StateDB.GetNonce(addr))
@@ -35,6 +35,7 @@ import (
 	"github.com/Venachain/Venachain/core/state"
 	"github.com/Venachain/Venachain/core/types"
 	"github.com/Venachain/Venachain/internal/ethapi"
+	"github.com/Venachain/Venachain/miner"
 	"github.com/Venachain/Venachain/params"
 	"github.com/Venachain/Venachain/rlp"
 	"github.com/Venachain/Venachain/rpc"
@@ -128,6 +129,7 @@ func (api *PrivateMinerAPI) SetGasPrice(gasPrice hexutil.Big) bool {
 	api.e.lock.Unlock()
 
 	api.e.txPool.SetGasPrice((*big.Int)(&gasPrice))
+	api.e.Miner().SetMinGasPrice((*big.Int)(&gasPrice))
 	return true
 }
 
@@ -142,6 +144,41 @@ func (api *PrivateMinerAPI) SetRecommitInterval(interval int) {
 	api.e.Miner().SetRecommitInterval(time.Duration(interval) * time.Millisecond)
 }
 
+// PauseProposing stops proposing new blocks, the node keeps verifying and
+// committing the blocks of the other validators.
+func (api *PrivateMinerAPI) PauseProposing() {
+	api.e.Miner().PauseProposing()
+}
+
+// ResumeProposing restarts proposing new blocks.
+func (api *PrivateMinerAPI) ResumeProposing() {
+	api.e.Miner().ResumeProposing()
+}
+
+// Health reports for each goroutine of the mining worker whether it is alive.
+func (api *PrivateMinerAPI) Health() map[string]bool {
+	return api.e.Miner().HealthCheck()
+}
+
+// DroppedTransactions returns the transactions left out of the last block
+// assembled by the miner and the reasons why.
+func (api *PrivateMinerAPI) DroppedTransactions() []miner.DroppedTx {
+	return api.e.Miner().LastBlockDropped()
+}
+
+// PendingTxOrder returns the hashes of the pending transactions in the order the
+// miner would attempt them in the next block, local accounts first and each
+// group sorted by price and nonce.
+func (api *PrivateMinerAPI) PendingTxOrder() ([]common.Hash, error) {
+	return api.e.Miner().PendingTxOrder()
+}
+
+// GasGriefingSenders returns the senders whose transactions were skipped in the
+// last block assembled by the miner for repeatedly exceeding its remaining gas.
+func (api *PrivateMinerAPI) GasGriefingSenders() []common.Address {
+	return api.e.Miner().GasGriefingSenders()
+}
+
 // PrivateAdminAPI is the collection of Ethereum full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -154,6 +191,12 @@ func NewPrivateAdminAPI(eth *Ethereum) *PrivateAdminAPI {
 	return &PrivateAdminAPI{eth: eth}
 }
 
+// GetPeerQueueStats returns the depths of the broadcast queues of the connected
+// peers by peer id.
+func (api *PrivateAdminAPI) GetPeerQueueStats() map[string]PeerQueueStats {
+	return api.eth.protocolManager.peers.QueueStats()
+}
+
 // ExportChain exports the current blockchain into a local file.
 func (api *PrivateAdminAPI) ExportChain(file string) (bool, error) {
 	// Make sure we can create the file to export into
@@ -454,3 +497,67 @@ func (api *PrivateDebugAPI) getModifiedAccounts(startBlock, endBlock *types.Bloc
 	}
 	return dirty, nil
 }
+
+// ReplayBlockResult is the result of a debug_replayBlock API call.
+type ReplayBlockResult struct {
+	Receipts []*types.Receipt `json:"receipts"`
+	Root     common.Hash      `json:"root"` // state root after replaying the block
+}
+
+// ReplayBlock re-executes the block with the given hash on top of its parent
+// state and returns the resulting receipts and state root. Nothing is written
+// to the database.
+func (api *PrivateDebugAPI) ReplayBlock(ctx context.Context, blockHash common.Hash) (*ReplayBlockResult, error) {
+	block := api.eth.blockchain.GetBlockByHash(blockHash)
+	if block == nil {
+		return nil, fmt.Errorf("block %x not found", blockHash)
+	}
+	parent := api.eth.blockchain.GetBlockByHash(block.ParentHash())
+	if parent == nil {
+		return nil, fmt.Errorf("parent %x not found", block.ParentHash())
+	}
+	receipts, statedb, err := api.eth.miner.ReplayBlock(block, parent.Root())
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayBlockResult{Receipts: receipts, Root: statedb.IntermediateRoot(true)}, nil
+}
+
+// computeStateRootTimeout is the maximum time debug_computeStateRoot may run.
+const computeStateRootTimeout = 30 * time.Second
+
+// ComputeStateRoot executes the given signed, RLP encoded transactions in a block
+// on top of the parent block and returns the resulting state root. Nothing is
+// written to the database. The simulation is abandoned before the next
+// transaction once the request is cancelled or computeStateRootTimeout passes.
+func (api *PrivateDebugAPI) ComputeStateRoot(ctx context.Context, txs []hexutil.Bytes, parentNr rpc.BlockNumber) (common.Hash, error) {
+	var parent *types.Block
+	switch parentNr {
+	case rpc.PendingBlockNumber:
+		return common.Hash{}, errors.New("the pending block cannot be a parent")
+	case rpc.LatestBlockNumber:
+		parent = api.eth.blockchain.CurrentBlock()
+	default:
+		parent = api.eth.blockchain.GetBlockByNumber(uint64(parentNr))
+	}
+	if parent == nil {
+		return common.Hash{}, fmt.Errorf("block #%d not found", parentNr)
+	}
+	transactions := make([]*types.Transaction, len(txs))
+	for i, encoded := range txs {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(encoded, tx); err != nil {
+			return common.Hash{}, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		transactions[i] = tx
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, computeStateRootTimeout)
+	defer cancel()
+
+	block, _, err := api.eth.miner.SimulateBlock(ctx, transactions, parent)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return block.Root(), nil
+}
@@ -17,12 +17,28 @@
 package eth
 
 import (
+	"bytes"
+	"context"
+	"math/big"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/common/hexutil"
+	"github.com/Venachain/Venachain/consensus"
+	"github.com/Venachain/Venachain/core"
 	"github.com/Venachain/Venachain/core/state"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/core/vm"
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/ethdb"
+	"github.com/Venachain/Venachain/event"
+	"github.com/Venachain/Venachain/miner"
+	"github.com/Venachain/Venachain/params"
+	"github.com/Venachain/Venachain/rlp"
+	"github.com/Venachain/Venachain/rpc"
 	"github.com/davecgh/go-spew/spew"
 )
 
@@ -33,22 +49,20 @@ func TestStorageRangeAt(t *testing.T) {
 	var (
 		state, _ = state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
 		addr     = common.Address{0x01}
-		keys     = []common.Hash{ // hashes of Keys of storage
-			common.HexToHash("340dd630ad21bf010b4e676dbfa9ba9a02175262d1fa356232cfde6cb5b47ef2"),
-			common.HexToHash("426fcb404ab2d5d8e61a3d918108006bbb0a9be65e92235bb10eefbdb6dcd053"),
-			common.HexToHash("48078cfed56339ea54962e72c37c7f588fc4f8e5bc173827ba75cb10a63a96a5"),
-			common.HexToHash("5723d2c3a83af9b735e3b7f21531e5623d183a9095a56604ead41f3582fdfb75"),
-		}
-		storage = storageMap{
-			keys[0]: {Key: &common.Hash{0x02}, Value: common.Hash{0x01}},
-			keys[1]: {Key: &common.Hash{0x04}, Value: common.Hash{0x02}},
-			keys[2]: {Key: &common.Hash{0x01}, Value: common.Hash{0x03}},
-			keys[3]: {Key: &common.Hash{0x03}, Value: common.Hash{0x04}},
-		}
+		keys     []common.Hash // hashes of Keys of storage, in trie order
+		storage  = storageMap{}
 	)
-	for _, entry := range storage {
-		state.SetState(addr, *entry.Key, entry.Value)
+	// The trie keys storage by the address prefixed key, holding the hash of
+	// the prefixed value.
+	for i, key := range []common.Hash{{0x02}, {0x04}, {0x01}, {0x03}} {
+		key, value := key, common.Hash{byte(i + 1)}
+		state.SetState(addr, key.Bytes(), value.Bytes())
+
+		hash := crypto.Keccak256Hash([]byte(addr.String()), key.Bytes())
+		storage[hash] = storageEntry{Key: &key, Value: crypto.Keccak256Hash([]byte("storage-value-"), value.Bytes())}
+		keys = append(keys, hash)
 	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
 
 	// Check a few combinations of limit and start/end.
 	tests := []struct {
@@ -73,7 +87,7 @@ func TestStorageRangeAt(t *testing.T) {
 			want: StorageRangeResult{storage, nil},
 		},
 		{
-			start: []byte{0x40}, limit: 2,
+			start: keys[1].Bytes(), limit: 2,
 			want: StorageRangeResult{storageMap{keys[1]: storage[keys[1]], keys[2]: storage[keys[2]]}, &keys[3]},
 		},
 	}
@@ -88,3 +102,110 @@ func TestStorageRangeAt(t *testing.T) {
 		}
 	}
 }
+
+// apiTestEngine is a consensus engine accepting any block, assembling blocks
+// without any consensus fields.
+type apiTestEngine struct{}
+
+func (apiTestEngine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+func (apiTestEngine) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return nil
+}
+
+func (apiTestEngine) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+	for range headers {
+		results <- nil
+	}
+	return abort, results
+}
+
+func (apiTestEngine) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return nil
+}
+
+func (apiTestEngine) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	return nil
+}
+
+func (apiTestEngine) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = state.IntermediateRoot(true)
+	return types.NewBlock(header, txs, receipts), nil
+}
+
+func (apiTestEngine) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	return nil, nil
+}
+
+func (apiTestEngine) SealHash(header *types.Header) common.Hash  { return header.Hash() }
+func (apiTestEngine) APIs(chain consensus.ChainReader) []rpc.API { return nil }
+func (apiTestEngine) Close() error                               { return nil }
+
+// apiTestBackend provides the miner of the debug API with a chain and a pool.
+type apiTestBackend struct {
+	chain  *core.BlockChain
+	txPool *core.TxPool
+}
+
+func (b *apiTestBackend) BlockChain() *core.BlockChain { return b.chain }
+func (b *apiTestBackend) TxPool() *core.TxPool         { return b.txPool }
+func (b *apiTestBackend) ExtendedDb() ethdb.Database   { return nil }
+
+// Tests that debug_computeStateRoot yields the root of a block simulated on its
+// parent, and gives up on the simulation of a cancelled request.
+func TestComputeStateRoot(t *testing.T) {
+	common.SysCfg.ReplayParam = &common.ReplayParam{OldSysContracts: make(map[common.Address]string)}
+
+	var (
+		key, _  = crypto.GenerateKey()
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		db      = ethdb.NewMemDatabase()
+		engine  = apiTestEngine{}
+		gspec   = core.Genesis{
+			Config:   params.TestChainConfig,
+			GasLimit: params.GenesisGasLimit,
+			Alloc:    core.GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+	)
+	genesis := gspec.MustCommit(db)
+	chain, _, err := core.NewBlockChain(db, nil, nil, gspec.Config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create the chain: %v", err)
+	}
+	defer chain.Stop()
+
+	tx, _ := types.SignTx(types.NewTransaction(0, common.Address{0x01}, big.NewInt(1000), params.TxGas, nil, nil), types.HomesteadSigner{}, key)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, gen *core.BlockGen) {
+		gen.AddTx(tx)
+	})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	cache := core.NewBlockChainCache(chain)
+	backend := &apiTestBackend{
+		chain:  chain,
+		txPool: core.NewTxPool(core.DefaultTxPoolConfig, gspec.Config, cache, db, nil, key),
+	}
+	m := miner.New(backend, gspec.Config, new(event.TypeMux), engine, time.Second, 0, params.GenesisGasLimit, params.GenesisGasLimit, nil, make(chan *types.Block), cache)
+	defer m.Close()
+
+	api := NewPrivateDebugAPI(gspec.Config, &Ethereum{blockchain: chain, miner: m})
+	encoded, _ := rlp.EncodeToBytes(tx)
+
+	root, err := api.ComputeStateRoot(context.Background(), []hexutil.Bytes{encoded}, 0)
+	if err != nil {
+		t.Fatalf("failed to compute state root: %v", err)
+	}
+	if root != blocks[0].Root() {
+		t.Errorf("state root mismatch: have %x, want %x", root, blocks[0].Root())
+	}
+	// A cancelled request abandons the simulation instead of leaving it running
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := api.ComputeStateRoot(ctx, []hexutil.Bytes{encoded}, 0); err != context.Canceled {
+		t.Errorf("cancelled request error mismatch: have %v, want %v", err, context.Canceled)
+	}
+}
@@ -203,13 +203,32 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	log.Debug("Transaction pool info", "pool", eth.txPool)
 
 	recommit := config.MinerRecommit
-	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.engine, recommit, config.MinerGasFloor, config.MinerGasCeil, eth.isLocalBlock, highestLogicalBlockCh, blockChainCache)
+	eth.miner = miner.New(eth, eth.chainConfig, eth.EventMux(), eth.engine, recommit, config.MinerCommitRatio, config.MinerGasFloor, config.MinerGasCeil, eth.isLocalBlock, highestLogicalBlockCh, blockChainCache)
 	eth.miner.SetEtherbase(crypto.PubkeyToAddress(ctx.NodeKey().PublicKey))
 	eth.miner.SetExtra(makeExtraData(config.MinerExtraData))
+	eth.miner.SetMinGasPrice(config.MinerGasPrice)
+	eth.miner.SetEmptyBlockDelay(config.MinerEmptyBlockDelay)
+	eth.miner.SetStateBatchSize(config.MinerStateBatchSize)
+	eth.miner.SetPendingFetchLimit(config.MinerPendingFetchLimit)
+	eth.miner.SetTxBatchSize(config.MinerTxBatchSize)
+	eth.miner.SetMaxTxsPerAccount(config.MinerMaxTxsPerAccount)
+	eth.miner.SetWitnessing(config.MinerWitness)
 
 	if eth.protocolManager, err = NewProtocolManager(eth.chainConfig, config.SyncMode, config.NetworkId, eth.eventMux, eth.txPool, eth.engine, eth.blockchain, chainDb); err != nil {
 		return nil, err
 	}
+	if config.MaxMsgSizeByCode != nil {
+		eth.protocolManager.maxMsgSizes = config.MaxMsgSizeByCode
+	}
+	eth.protocolManager.bloomKnownTxs = config.BloomKnownTxs
+	eth.protocolManager.txBatchBytes = config.TxBroadcastBatchSize
+	eth.protocolManager.handshakeTimeout = config.HandshakeTimeout
+	eth.protocolManager.handshakeRetry = config.HandshakeRetry
+	eth.protocolManager.peers.maxPerIP = config.MaxPeersPerIP
+	if config.TxBroadcastHashFirst {
+		eth.protocolManager.peers.txPolicy = HashFirstPolicy{}
+	}
+	eth.protocolManager.blockChainCache = blockChainCache
 
 	return eth, nil
 }
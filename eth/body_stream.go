@@ -0,0 +1,210 @@
+package eth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/p2p"
+)
+
+const (
+	// bodyStreamTimeout is the maximum time to wait for the next chunk of a
+	// block body stream.
+	bodyStreamTimeout = 10 * time.Second
+
+	// bodyStreamThreshold is the expected response size above which the bodies
+	// requested by the downloader are streamed in chunks.
+	bodyStreamThreshold = ProtocolMaxMsgSize / 2
+)
+
+var (
+	errInvalidChunkSize  = errors.New("invalid chunk size")
+	errBodyStreamPending = errors.New("block body stream already pending")
+)
+
+// bodyStream tracks the chunked block body requests of a StreamBlockBodies call.
+// Responses carry no request ids, but peers answer requests in order, so every
+// response is matched to the request sent first among those in flight.
+type bodyStream struct {
+	ch       chan []*blockBody // Responses of the chunks, closed when the stream ends
+	pending  int               // Number of chunk responses still expected
+	progress chan struct{}     // Signals a chunk response to the timeout watcher
+	done     chan struct{}     // Closed when the stream ends
+}
+
+// StreamBlockBodies requests the bodies of hashes in chunks of chunkSize, each
+// sent as a separate message, and returns a channel of the chunk responses. The
+// channel is closed once all chunks are answered, or early if the peer stops
+// answering or disconnects.
+//
+// Only one stream may be pending per peer. Plain body requests may be sent at
+// any time, their responses are delivered as usual.
+func (p *peer) StreamBlockBodies(hashes []common.Hash, chunkSize int) (<-chan []*blockBody, error) {
+	if chunkSize <= 0 {
+		return nil, errInvalidChunkSize
+	}
+	chunks := (len(hashes) + chunkSize - 1) / chunkSize
+	stream := &bodyStream{
+		ch:       make(chan []*blockBody, chunks),
+		pending:  chunks,
+		progress: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	p.bodyStreamMu.Lock()
+	if p.bodyStream != nil {
+		p.bodyStreamMu.Unlock()
+		return nil, errBodyStreamPending
+	}
+	if chunks == 0 {
+		p.bodyStreamMu.Unlock()
+		close(stream.ch)
+		return stream.ch, nil
+	}
+	p.bodyStream = stream
+	p.bodyStreamMu.Unlock()
+
+	p.Log().Debug("Streaming batch of block bodies", "count", len(hashes), "chunks", chunks)
+
+	p.bodySendMu.Lock()
+	defer p.bodySendMu.Unlock()
+
+	p.trackBodyRequests(stream, chunks)
+	for sent, start := 0, 0; start < len(hashes); sent, start = sent+1, start+chunkSize {
+		end := start + chunkSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		if err := p2p.Send(p.rw, GetBlockBodiesMsg, hashes[start:end]); err != nil {
+			p.untrackBodyRequests(stream, chunks-sent)
+			p.endBodyStream(stream)
+			return nil, err
+		}
+	}
+	go p.watchBodyStream(stream)
+
+	return stream.ch, nil
+}
+
+// watchBodyStream ends a stream if no chunk arrives in time or the peer
+// disconnects.
+func (p *peer) watchBodyStream(stream *bodyStream) {
+	timeout := time.NewTimer(bodyStreamTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-stream.progress:
+			if !timeout.Stop() {
+				<-timeout.C
+			}
+			timeout.Reset(bodyStreamTimeout)
+
+		case <-timeout.C:
+			p.Log().Debug("Block body stream timed out")
+			p.endBodyStream(stream)
+			return
+
+		case <-p.term:
+			p.endBodyStream(stream)
+			return
+
+		case <-stream.done:
+			return
+		}
+	}
+}
+
+// trackBodyRequests records count body requests about to be sent for stream,
+// nil for plain requests. The caller must hold bodySendMu until they are sent.
+func (p *peer) trackBodyRequests(stream *bodyStream, count int) {
+	p.bodyStreamMu.Lock()
+	defer p.bodyStreamMu.Unlock()
+
+	for i := 0; i < count; i++ {
+		p.bodyRequests = append(p.bodyRequests, stream)
+	}
+}
+
+// untrackBodyRequests forgets the last count body requests recorded for stream,
+// which failed to be sent. The caller must hold bodySendMu since tracking them.
+func (p *peer) untrackBodyRequests(stream *bodyStream, count int) {
+	p.bodyStreamMu.Lock()
+	defer p.bodyStreamMu.Unlock()
+
+	for n := len(p.bodyRequests); count > 0 && n > 0 && p.bodyRequests[n-1] == stream; n, count = n-1, count-1 {
+		p.bodyRequests[n-1] = nil
+		p.bodyRequests = p.bodyRequests[:n-1]
+	}
+}
+
+// deliverStreamedBodies hands a body response to the pending stream if it
+// answers one of its chunks, reporting whether it did. Responses to plain
+// requests, to streams which ended early and unsolicited ones are left to the
+// regular delivery.
+func (p *peer) deliverStreamedBodies(bodies []*blockBody) bool {
+	p.bodyStreamMu.Lock()
+	defer p.bodyStreamMu.Unlock()
+
+	if len(p.bodyRequests) == 0 {
+		return false
+	}
+	stream := p.bodyRequests[0]
+	p.bodyRequests[0] = nil
+	p.bodyRequests = p.bodyRequests[1:]
+
+	if stream == nil || stream != p.bodyStream {
+		return false
+	}
+	// The channel has room for a response of every chunk
+	stream.ch <- bodies
+	select {
+	case stream.progress <- struct{}{}:
+	default:
+	}
+	if stream.pending--; stream.pending == 0 {
+		p.closeBodyStream()
+	}
+	return true
+}
+
+// endBodyStream closes stream unless it already ended.
+func (p *peer) endBodyStream(stream *bodyStream) {
+	p.bodyStreamMu.Lock()
+	defer p.bodyStreamMu.Unlock()
+
+	if p.bodyStream == stream {
+		p.closeBodyStream()
+	}
+}
+
+// closeBodyStream closes the pending stream. The caller must hold bodyStreamMu.
+func (p *peer) closeBodyStream() {
+	close(p.bodyStream.ch)
+	close(p.bodyStream.done)
+	p.bodyStream = nil
+}
+
+// RequestBodiesSized fetches the bodies of hashes expected to amount to size,
+// streaming them in chunks if a single response would grow too large. The
+// streamed bodies are delivered together once the stream ends.
+func (p *peer) RequestBodiesSized(hashes []common.Hash, size common.StorageSize) error {
+	if size <= bodyStreamThreshold || p.streamedBodiesSink == nil {
+		return p.RequestBodies(hashes)
+	}
+	// Aim for chunk responses of half the threshold
+	chunkSize := int(float64(len(hashes)) * float64(bodyStreamThreshold/2) / float64(size))
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	stream, err := p.StreamBlockBodies(hashes, chunkSize)
+	if err != nil {
+		return err
+	}
+	var bodies []*blockBody
+	for chunk := range stream {
+		bodies = append(bodies, chunk...)
+	}
+	p.streamedBodiesSink(bodies)
+	return nil
+}
@@ -0,0 +1,248 @@
+package eth
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/p2p"
+	"github.com/Venachain/Venachain/p2p/discover"
+)
+
+// newBodyStreamTestPeer creates a peer connected through an in-process pipe. The
+// remote end answers the first answered body requests, with a body per hash
+// holding a transaction whose nonce is the first byte of the hash, and reports
+// the size of every request. Once held requests were read, it answers them all
+// at once. Responses are delivered like the handler does, the ones not taken by
+// a stream are reported.
+func newBodyStreamTestPeer(answered, held int) (*peer, <-chan int, <-chan []*blockBody, func()) {
+	app, net := p2p.MsgPipe()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "streamer", nil), net)
+
+	requests := make(chan int, 100)
+	plain := make(chan []*blockBody, 100)
+	go func() {
+		var pending []blockBodiesData
+		for i := 0; ; i++ {
+			msg, err := app.ReadMsg()
+			if err != nil {
+				return
+			}
+			var hashes []common.Hash
+			if err := msg.Decode(&hashes); err != nil {
+				return
+			}
+			requests <- len(hashes)
+			if i >= answered {
+				continue
+			}
+			bodies := make(blockBodiesData, len(hashes))
+			for j, hash := range hashes {
+				tx := types.NewTransaction(uint64(hash[0]), common.Address{}, new(big.Int), 0, new(big.Int), nil)
+				bodies[j] = &blockBody{Transactions: []*types.Transaction{tx}}
+			}
+			if pending = append(pending, bodies); i+1 < held {
+				continue
+			}
+			for _, bodies := range pending {
+				if err := p2p.Send(app, BlockBodiesMsg, bodies); err != nil {
+					return
+				}
+			}
+			pending = pending[:0]
+		}
+	}()
+	go func() {
+		for {
+			msg, err := net.ReadMsg()
+			if err != nil {
+				return
+			}
+			var bodies blockBodiesData
+			if err := msg.Decode(&bodies); err != nil {
+				return
+			}
+			if !p.deliverStreamedBodies(bodies) {
+				plain <- bodies
+			}
+		}
+	}()
+	return p, requests, plain, func() { app.Close() }
+}
+
+func bodyStreamTestHashes(n int) []common.Hash {
+	hashes := make([]common.Hash, n)
+	for i := range hashes {
+		hashes[i] = common.Hash{byte(i)}
+	}
+	return hashes
+}
+
+// Tests that a body stream sends a request per chunk and delivers the chunk
+// responses in order.
+func TestStreamBlockBodies(t *testing.T) {
+	p, requests, _, closePipe := newBodyStreamTestPeer(100, 0)
+	defer closePipe()
+
+	stream, err := p.StreamBlockBodies(bodyStreamTestHashes(10), 3)
+	if err != nil {
+		t.Fatalf("failed to stream bodies: %v", err)
+	}
+	var (
+		chunks = 0
+		next   = 0
+	)
+	for chunk := range stream {
+		chunks++
+		for _, body := range chunk {
+			if nonce := body.Transactions[0].Nonce(); nonce != uint64(next) {
+				t.Fatalf("body %d: out of order, have body %d", next, nonce)
+			}
+			next++
+		}
+	}
+	if chunks != 4 || next != 10 {
+		t.Errorf("stream mismatch: have %d bodies in %d chunks, want 10 in 4", next, chunks)
+	}
+	for i, want := range []int{3, 3, 3, 1} {
+		if have := <-requests; have != want {
+			t.Errorf("request %d: size mismatch: have %d, want %d", i, have, want)
+		}
+	}
+	// The ended stream lets responses through to the regular delivery again
+	if p.deliverStreamedBodies(nil) {
+		t.Errorf("response taken by ended stream")
+	}
+	if _, err := p.StreamBlockBodies(nil, 0); err != errInvalidChunkSize {
+		t.Errorf("chunk size error mismatch: have %v, want %v", err, errInvalidChunkSize)
+	}
+}
+
+// Tests that a body stream stays exclusive while pending and ends early when
+// the peer disconnects.
+func TestStreamBlockBodiesDisconnect(t *testing.T) {
+	p, _, _, closePipe := newBodyStreamTestPeer(1, 0)
+	defer closePipe()
+
+	stream, err := p.StreamBlockBodies(bodyStreamTestHashes(6), 2)
+	if err != nil {
+		t.Fatalf("failed to stream bodies: %v", err)
+	}
+	if chunk := <-stream; len(chunk) != 2 {
+		t.Fatalf("first chunk size mismatch: have %d, want %d", len(chunk), 2)
+	}
+	if _, err := p.StreamBlockBodies(bodyStreamTestHashes(1), 1); err != errBodyStreamPending {
+		t.Errorf("concurrent stream error mismatch: have %v, want %v", err, errBodyStreamPending)
+	}
+	p.close()
+	if _, ok := <-stream; ok {
+		t.Errorf("stream still open after disconnect")
+	}
+}
+
+// Tests that the responses to plain body requests sent around a stream are not
+// taken for chunks of the stream.
+func TestStreamBlockBodiesInterleaved(t *testing.T) {
+	// Hold the responses until all four requests are in flight
+	p, _, plain, closePipe := newBodyStreamTestPeer(100, 4)
+	defer closePipe()
+
+	if err := p.RequestBodies(bodyStreamTestHashes(5)); err != nil {
+		t.Fatalf("failed to request bodies: %v", err)
+	}
+	stream, err := p.StreamBlockBodies(bodyStreamTestHashes(4), 2)
+	if err != nil {
+		t.Fatalf("failed to stream bodies: %v", err)
+	}
+	if err := p.RequestBodies(bodyStreamTestHashes(1)); err != nil {
+		t.Fatalf("failed to request bodies: %v", err)
+	}
+	streamed := 0
+	for chunk := range stream {
+		if len(chunk) != 2 {
+			t.Errorf("chunk size mismatch: have %d, want %d", len(chunk), 2)
+		}
+		streamed += len(chunk)
+	}
+	if streamed != 4 {
+		t.Errorf("streamed bodies mismatch: have %d, want %d", streamed, 4)
+	}
+	for i, want := range []int{5, 1} {
+		select {
+		case bodies := <-plain:
+			if len(bodies) != want {
+				t.Errorf("plain response %d: size mismatch: have %d, want %d", i, len(bodies), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("plain response %d not delivered", i)
+		}
+	}
+}
+
+// Tests that the chunks of a stream which failed to be sent are not left
+// waiting for responses.
+func TestStreamBlockBodiesSendFailure(t *testing.T) {
+	app, net := p2p.MsgPipe()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "streamer", nil), net)
+
+	// Drop the connection after the first request
+	go func() {
+		app.ReadMsg()
+		app.Close()
+	}()
+	if _, err := p.StreamBlockBodies(bodyStreamTestHashes(10), 3); err == nil {
+		t.Fatalf("stream succeeded over a closed connection")
+	}
+	p.bodyStreamMu.Lock()
+	defer p.bodyStreamMu.Unlock()
+
+	if len(p.bodyRequests) != 1 {
+		t.Errorf("tracked requests mismatch: have %d, want %d", len(p.bodyRequests), 1)
+	}
+	if p.bodyStream != nil {
+		t.Errorf("failed stream still pending")
+	}
+}
+
+// Tests that only bodies expected to exceed the threshold are streamed, and
+// that the streamed bodies are delivered together.
+func TestRequestBodiesSized(t *testing.T) {
+	p, requests, _, closePipe := newBodyStreamTestPeer(100, 0)
+	defer closePipe()
+
+	delivered := make(chan []*blockBody, 1)
+	p.streamedBodiesSink = func(bodies []*blockBody) { delivered <- bodies }
+
+	if err := p.RequestBodiesSized(bodyStreamTestHashes(8), bodyStreamThreshold*2); err != nil {
+		t.Fatalf("failed to request bodies: %v", err)
+	}
+	if bodies := <-delivered; len(bodies) != 8 {
+		t.Errorf("delivered bodies mismatch: have %d, want %d", len(bodies), 8)
+	}
+	for i := 0; i < 4; i++ {
+		if have := <-requests; have != 2 {
+			t.Errorf("request %d: size mismatch: have %d, want %d", i, have, 2)
+		}
+	}
+	// Small responses are requested at once and delivered as usual
+	if err := p.RequestBodiesSized(bodyStreamTestHashes(8), bodyStreamThreshold); err != nil {
+		t.Fatalf("failed to request bodies: %v", err)
+	}
+	if have := <-requests; have != 8 {
+		t.Errorf("request size mismatch: have %d, want %d", have, 8)
+	}
+	select {
+	case <-delivered:
+		t.Errorf("small request delivered as a stream")
+	default:
+	}
+}
@@ -41,6 +41,12 @@ var DefaultConfig = Config{
 	MinerGasPrice: big.NewInt(params.GWei),
 	MinerRecommit: 3 * time.Second,
 
+	MinerCommitRatio:    0.95,
+	MinerStateBatchSize: 1,
+	MinerTxBatchSize:    1,
+
+	MaxMsgSizeByCode: DefaultMaxMsgSizeByCode,
+
 	TxPool: core.DefaultTxPoolConfig,
 	GPO: gasprice.Config{
 		Blocks:     20,
@@ -60,6 +66,28 @@ type Config struct {
 	SyncMode  downloader.SyncMode
 	NoPruning bool
 
+	// Size caps of individual protocol messages, keyed by message code
+	MaxMsgSizeByCode map[uint64]uint32 `toml:",omitempty"`
+
+	// Track the transactions known by peers in bloom filters instead of exact sets
+	BloomKnownTxs bool `toml:",omitempty"`
+
+	// Size in bytes up to which transaction broadcasts to a peer are coalesced
+	// into a single message, 0 for the default
+	TxBroadcastBatchSize int `toml:",omitempty"`
+
+	// Announce transactions to peers not taking part in the consensus by hash
+	// only, sending them in full to consensus peers
+	TxBroadcastHashFirst bool `toml:",omitempty"`
+
+	// Time allowed for the status exchange with new peers, and whether it is
+	// attempted once more after timing out
+	HandshakeTimeout time.Duration `toml:",omitempty"`
+	HandshakeRetry   bool          `toml:",omitempty"`
+
+	// Maximum number of peers sharing a remote IP, 0 for no limit
+	MaxPeersPerIP int `toml:",omitempty"`
+
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
 	LightPeers int `toml:",omitempty"` // Maximum number of LES client peers
@@ -81,6 +109,27 @@ type Config struct {
 	MinerRecommit  time.Duration
 	MinerNoverify  bool
 
+	// Share of the recommit interval spent assembling a block, in (0, 1]
+	MinerCommitRatio float64 `toml:",omitempty"`
+
+	// Time without transaction arrivals before an empty block is produced, 0 for none
+	MinerEmptyBlockDelay time.Duration `toml:",omitempty"`
+
+	// Number of sealed blocks whose states are flushed to disk together, 1 for each
+	MinerStateBatchSize int `toml:",omitempty"`
+
+	// Maximum number of pending transactions fetched for each block, 0 for no limit
+	MinerPendingFetchLimit int `toml:",omitempty"`
+
+	// Number of transactions whose accounts are loaded together ahead of their execution, 1 for none
+	MinerTxBatchSize int `toml:",omitempty"`
+
+	// Maximum number of transactions of a single account included in a block, 0 for no limit
+	MinerMaxTxsPerAccount int `toml:",omitempty"`
+
+	// Record the state witnesses of new blocks for stateless clients
+	MinerWitness bool `toml:",omitempty"`
+
 	// Transaction pool options
 	TxPool core.TxPoolConfig
 
@@ -29,13 +29,17 @@ import (
 	"time"
 
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/core/types"
 	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/log"
+	"github.com/Venachain/Venachain/params"
 )
 
 const (
 	maxLackingHashes  = 4096 // Maximum number of entries allowed on the list or lacking items
 	measurementImpact = 0.1  // The impact a single measurement has on a peer's final throughput value.
+
+	txEncodingOverhead = 128 // Upper bound of the encoded size of a transaction besides its data
 )
 
 var (
@@ -89,6 +93,12 @@ type Peer interface {
 	RequestNodeData([]common.Hash) error
 }
 
+// BodyStreamer is implemented by peers able to split a block body request into
+// chunks answered by separate messages, if the response is expected to be large.
+type BodyStreamer interface {
+	RequestBodiesSized(hashes []common.Hash, size common.StorageSize) error
+}
+
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
 type lightPeerWrapper struct {
 	peer LightPeer
@@ -169,11 +179,31 @@ func (p *peerConnection) FetchBodies(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.peer.RequestBodies(hashes)
-
+	if streamer, ok := p.peer.(BodyStreamer); ok {
+		size := estimateBodiesSize(request.Headers)
+		go func() {
+			// Fall back to a plain request if the bodies could not be streamed
+			if err := streamer.RequestBodiesSized(hashes, size); err != nil {
+				p.log.Debug("Failed to stream block bodies", "count", len(hashes), "err", err)
+				p.peer.RequestBodies(hashes)
+			}
+		}()
+	} else {
+		go p.peer.RequestBodies(hashes)
+	}
 	return nil
 }
 
+// estimateBodiesSize returns an upper bound of the encoded size of the bodies of
+// headers, derived from their gas as every byte of transaction data costs gas.
+func estimateBodiesSize(headers []*types.Header) common.StorageSize {
+	var size common.StorageSize
+	for _, header := range headers {
+		size += common.StorageSize(header.GasUsed/params.TxDataZeroGas + header.GasUsed/params.TxGas*txEncodingOverhead)
+	}
+	return size
+}
+
 // FetchReceipts sends a receipt retrieval request to the remote peer.
 func (p *peerConnection) FetchReceipts(request *fetchRequest) error {
 	// Short circuit if the peer is already fetching
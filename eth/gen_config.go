@@ -22,10 +22,17 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		NetworkId               uint64
 		SyncMode                downloader.SyncMode
 		NoPruning               bool
-		LightServ               int  `toml:",omitempty"`
-		LightPeers              int  `toml:",omitempty"`
-		SkipBcVersionCheck      bool `toml:"-"`
-		DatabaseHandles         int  `toml:"-"`
+		MaxMsgSizeByCode        map[uint64]uint32 `toml:",omitempty"`
+		BloomKnownTxs           bool              `toml:",omitempty"`
+		TxBroadcastBatchSize    int               `toml:",omitempty"`
+		TxBroadcastHashFirst    bool              `toml:",omitempty"`
+		HandshakeTimeout        time.Duration     `toml:",omitempty"`
+		HandshakeRetry          bool              `toml:",omitempty"`
+		MaxPeersPerIP           int               `toml:",omitempty"`
+		LightServ               int               `toml:",omitempty"`
+		LightPeers              int               `toml:",omitempty"`
+		SkipBcVersionCheck      bool              `toml:"-"`
+		DatabaseHandles         int               `toml:"-"`
 		DatabaseCache           int
 		TrieCache               int
 		TrieTimeout             time.Duration
@@ -37,6 +44,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		MinerGasPrice           *big.Int
 		MinerRecommit           time.Duration
 		MinerNoverify           bool
+		MinerCommitRatio        float64       `toml:",omitempty"`
+		MinerEmptyBlockDelay    time.Duration `toml:",omitempty"`
+		MinerStateBatchSize     int           `toml:",omitempty"`
+		MinerPendingFetchLimit  int           `toml:",omitempty"`
+		MinerTxBatchSize        int           `toml:",omitempty"`
+		MinerMaxTxsPerAccount   int           `toml:",omitempty"`
+		MinerWitness            bool          `toml:",omitempty"`
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
@@ -47,6 +61,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.NetworkId = c.NetworkId
 	enc.SyncMode = c.SyncMode
 	enc.NoPruning = c.NoPruning
+	enc.MaxMsgSizeByCode = c.MaxMsgSizeByCode
+	enc.BloomKnownTxs = c.BloomKnownTxs
+	enc.TxBroadcastBatchSize = c.TxBroadcastBatchSize
+	enc.TxBroadcastHashFirst = c.TxBroadcastHashFirst
+	enc.HandshakeTimeout = c.HandshakeTimeout
+	enc.HandshakeRetry = c.HandshakeRetry
+	enc.MaxPeersPerIP = c.MaxPeersPerIP
 	enc.LightServ = c.LightServ
 	enc.LightPeers = c.LightPeers
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
@@ -62,6 +83,13 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.MinerGasPrice = c.MinerGasPrice
 	enc.MinerRecommit = c.MinerRecommit
 	enc.MinerNoverify = c.MinerNoverify
+	enc.MinerCommitRatio = c.MinerCommitRatio
+	enc.MinerEmptyBlockDelay = c.MinerEmptyBlockDelay
+	enc.MinerStateBatchSize = c.MinerStateBatchSize
+	enc.MinerPendingFetchLimit = c.MinerPendingFetchLimit
+	enc.MinerTxBatchSize = c.MinerTxBatchSize
+	enc.MinerMaxTxsPerAccount = c.MinerMaxTxsPerAccount
+	enc.MinerWitness = c.MinerWitness
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
@@ -76,10 +104,17 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		NetworkId               *uint64
 		SyncMode                *downloader.SyncMode
 		NoPruning               *bool
-		LightServ               *int  `toml:",omitempty"`
-		LightPeers              *int  `toml:",omitempty"`
-		SkipBcVersionCheck      *bool `toml:"-"`
-		DatabaseHandles         *int  `toml:"-"`
+		MaxMsgSizeByCode        map[uint64]uint32 `toml:",omitempty"`
+		BloomKnownTxs           *bool             `toml:",omitempty"`
+		TxBroadcastBatchSize    *int              `toml:",omitempty"`
+		TxBroadcastHashFirst    *bool             `toml:",omitempty"`
+		HandshakeTimeout        *time.Duration    `toml:",omitempty"`
+		HandshakeRetry          *bool             `toml:",omitempty"`
+		MaxPeersPerIP           *int              `toml:",omitempty"`
+		LightServ               *int              `toml:",omitempty"`
+		LightPeers              *int              `toml:",omitempty"`
+		SkipBcVersionCheck      *bool             `toml:"-"`
+		DatabaseHandles         *int              `toml:"-"`
 		DatabaseCache           *int
 		TrieCache               *int
 		TrieTimeout             *time.Duration
@@ -91,6 +126,13 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		MinerGasPrice           *big.Int
 		MinerRecommit           *time.Duration
 		MinerNoverify           *bool
+		MinerCommitRatio        *float64       `toml:",omitempty"`
+		MinerEmptyBlockDelay    *time.Duration `toml:",omitempty"`
+		MinerStateBatchSize     *int           `toml:",omitempty"`
+		MinerPendingFetchLimit  *int           `toml:",omitempty"`
+		MinerTxBatchSize        *int           `toml:",omitempty"`
+		MinerMaxTxsPerAccount   *int           `toml:",omitempty"`
+		MinerWitness            *bool          `toml:",omitempty"`
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
@@ -112,6 +154,27 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.NoPruning != nil {
 		c.NoPruning = *dec.NoPruning
 	}
+	if dec.MaxMsgSizeByCode != nil {
+		c.MaxMsgSizeByCode = dec.MaxMsgSizeByCode
+	}
+	if dec.BloomKnownTxs != nil {
+		c.BloomKnownTxs = *dec.BloomKnownTxs
+	}
+	if dec.TxBroadcastBatchSize != nil {
+		c.TxBroadcastBatchSize = *dec.TxBroadcastBatchSize
+	}
+	if dec.TxBroadcastHashFirst != nil {
+		c.TxBroadcastHashFirst = *dec.TxBroadcastHashFirst
+	}
+	if dec.HandshakeTimeout != nil {
+		c.HandshakeTimeout = *dec.HandshakeTimeout
+	}
+	if dec.HandshakeRetry != nil {
+		c.HandshakeRetry = *dec.HandshakeRetry
+	}
+	if dec.MaxPeersPerIP != nil {
+		c.MaxPeersPerIP = *dec.MaxPeersPerIP
+	}
 	if dec.LightServ != nil {
 		c.LightServ = *dec.LightServ
 	}
@@ -157,6 +220,27 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.MinerNoverify != nil {
 		c.MinerNoverify = *dec.MinerNoverify
 	}
+	if dec.MinerCommitRatio != nil {
+		c.MinerCommitRatio = *dec.MinerCommitRatio
+	}
+	if dec.MinerEmptyBlockDelay != nil {
+		c.MinerEmptyBlockDelay = *dec.MinerEmptyBlockDelay
+	}
+	if dec.MinerStateBatchSize != nil {
+		c.MinerStateBatchSize = *dec.MinerStateBatchSize
+	}
+	if dec.MinerPendingFetchLimit != nil {
+		c.MinerPendingFetchLimit = *dec.MinerPendingFetchLimit
+	}
+	if dec.MinerTxBatchSize != nil {
+		c.MinerTxBatchSize = *dec.MinerTxBatchSize
+	}
+	if dec.MinerMaxTxsPerAccount != nil {
+		c.MinerMaxTxsPerAccount = *dec.MinerMaxTxsPerAccount
+	}
+	if dec.MinerWitness != nil {
+		c.MinerWitness = *dec.MinerWitness
+	}
 	if dec.TxPool != nil {
 		c.TxPool = *dec.TxPool
 	}
@@ -52,6 +52,9 @@ const (
 	// The number is referenced from the size of tx pool.
 	txChanSize = 4096
 
+	// validatorSetChanSize is the size of channel listening to ValidatorSetEvent.
+	validatorSetChanSize = 10
+
 	defaultTxsCacheSize      = 20
 	defaultBroadcastInterval = 100 * time.Millisecond
 )
@@ -78,6 +81,15 @@ type ProtocolManager struct {
 	blockchain  *core.BlockChain
 	chainconfig *params.ChainConfig
 	maxPeers    int
+	maxMsgSizes map[uint64]uint32 // Size caps of the protocol messages by code
+
+	bloomKnownTxs bool // Whether peers track known transactions in bloom filters
+	txBatchBytes  int  // Size up to which transaction broadcasts are coalesced, 0 for the default
+
+	handshakeTimeout time.Duration // Time allowed for each attempt of the status exchange
+	handshakeRetry   bool          // Whether a timed out status exchange is attempted once more
+
+	blockChainCache *core.BlockChainCache // Cache warmed with the state of the prepared blocks
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
@@ -95,6 +107,13 @@ type ProtocolManager struct {
 	prepareMinedBlockSub *event.TypeMuxSubscription
 	blockSignatureSub    *event.TypeMuxSubscription
 
+	validatorSetCh  chan consensus.ValidatorSetEvent
+	validatorSetSub event.Subscription
+
+	validatorSet       []common.Address // Latest verified validator set announced by a validator
+	validatorSetNumber uint64           // Number of the block switching to validatorSet
+	validatorSetMu     sync.RWMutex     // Protects validatorSet and validatorSetNumber
+
 	// channels for fetcher, syncer, txsyncLoop
 	newPeerCh   chan *peer
 	txsyncCh    chan *txsync
@@ -125,6 +144,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		txsyncCh:    make(chan *txsync),
 		quitSync:    make(chan struct{}),
 		engine:      engine,
+		maxMsgSizes: DefaultMaxMsgSizeByCode,
 	}
 
 	if handler, ok := manager.engine.(consensus.Handler); ok {
@@ -247,6 +267,13 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	go pm.minedBroadcastLoop()
 	go pm.prepareMinedBlockcastLoop()
 
+	// announce validator set changes to the observers
+	if istanbul, ok := pm.engine.(consensus.Istanbul); ok {
+		pm.validatorSetCh = make(chan consensus.ValidatorSetEvent, validatorSetChanSize)
+		pm.validatorSetSub = istanbul.SubscribeValidatorSetEvents(pm.validatorSetCh)
+		go pm.validatorSetBroadcastLoop()
+	}
+
 	// start sync handlers
 	go pm.syncer()
 	go pm.txsyncLoop()
@@ -257,6 +284,9 @@ func (pm *ProtocolManager) Stop() {
 
 	pm.txsSub.Unsubscribe()        // quits txBroadcastLoop
 	pm.minedBlockSub.Unsubscribe() // quits blockBroadcastLoop
+	if pm.validatorSetSub != nil {
+		pm.validatorSetSub.Unsubscribe() // quits validatorSetBroadcastLoop
+	}
 
 	// Quit the sync loop.
 	// After this send has completed, no new peers will be accepted.
@@ -278,7 +308,43 @@ func (pm *ProtocolManager) Stop() {
 }
 
 func (pm *ProtocolManager) newPeer(pv int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
-	return newPeer(pv, p, newMeteredMsgWriter(rw))
+	peer := newPeer(pv, p, newMeteredMsgWriter(rw))
+	peer.maxMsgSizes = pm.maxMsgSizes
+	if pm.bloomKnownTxs {
+		peer.knownTxs = newBloomKnownTxs(maxKnownTxs)
+	}
+	if pm.handshakeTimeout > 0 {
+		peer.handshakeTimeout = pm.handshakeTimeout
+	}
+	peer.handshakeRetry = pm.handshakeRetry
+	if pm.txBatchBytes > 0 {
+		peer.txBatchBytes = pm.txBatchBytes
+	}
+	peer.streamedBodiesSink = func(bodies []*blockBody) {
+		pm.deliverBodies(peer, bodies)
+	}
+	return peer
+}
+
+// deliverBodies hands a batch of block bodies to the fetcher, if it requested
+// them explicitly, and the rest to the downloader for queuing.
+func (pm *ProtocolManager) deliverBodies(p *peer, bodies []*blockBody) {
+	transactions := make([][]*types.Transaction, len(bodies))
+
+	for i, body := range bodies {
+		transactions[i] = body.Transactions
+	}
+	// Filter out any explicitly requested bodies, deliver the rest to the downloader
+	filter := len(transactions) > 0
+	if filter {
+		transactions = pm.fetcher.FilterBodies(p.id, transactions, time.Now())
+	}
+	if len(transactions) > 0 || !filter {
+		err := pm.downloader.DeliverBodies(p.id, transactions)
+		if err != nil {
+			log.Debug("Failed to deliver bodies", "err", err)
+		}
+	}
 }
 
 // handle is the callback invoked to manage the life cycle of an eth peer. When
@@ -334,6 +400,10 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 	// Register the peer locally
 	if err := pm.peers.Register(p, pm.removePeer); err != nil {
+		if err == errTooManyPeersFromIP {
+			p.Log().Debug("Too many peers from the same IP", "ip", p.ip)
+			return p2p.DiscTooManyPeers
+		}
 		p.Log().Error("Ethereum peer registration failed", "err", err)
 		return err
 	}
@@ -348,6 +418,14 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	if p.IsConsensus() {
 		pm.syncTransactionHashes(p)
 	}
+	// Until a validator set is known, ask the validators met for the one at our head
+	if _, ok := pm.engine.(consensus.Istanbul); ok && p.IsConsensus() && p.version >= platoneV2 {
+		if _, validators := pm.ValidatorSet(); validators == nil {
+			if err := p.RequestValidatorSet(pm.blockchain.CurrentHeader().Number.Uint64()); err != nil {
+				p.Log().Debug("Failed to request the validator set", "err", err)
+			}
+		}
+	}
 
 	// main loop. handle incoming messages.
 	for {
@@ -367,8 +445,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		p.Log().Error("read peer message error", "err", err)
 		return err
 	}
-	if msg.Size > ProtocolMaxMsgSize {
-		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	if limit := maxMsgSize(p.maxMsgSizes, msg.Code); msg.Size > limit {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, limit)
 	}
 	defer msg.Discard()
 
@@ -386,13 +464,18 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	// Handle the message depending on its contents
 	switch {
 	case msg.Code == StatusMsg:
-		// Status messages should never arrive after the handshake
+		// Status messages should never arrive after the handshake, except once
+		// from a peer which retried its handshake after we answered too slowly
+		if !p.repeatedStatus {
+			p.repeatedStatus = true
+			return nil
+		}
 		return errResp(ErrExtraStatusMsg, "uncontrolled status message")
 
 	// Block header query, collect the requested headers and reply
 	case msg.Code == GetBlockHeadersMsg:
 		if pm.isUnNormalBootNodesAtPeer(p) {
-			log.Warn("the bootNode is not a normal node. cancel exemption", "bootNode id: ", pm.peers.Peer(p.id).Peer.ID().String())
+			log.Warn("the bootNode is not a normal node. cancel exemption", "bootNode id: ", p.ID().String())
 			break
 		}
 		// Decode the complex header query
@@ -479,7 +562,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += query.Skip + 1
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		p.AsyncSendBlockHeaders(headers)
+		return nil
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
@@ -534,23 +618,11 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&request); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
-		// Deliver them all to the downloader for queuing
-		transactions := make([][]*types.Transaction, len(request))
-
-		for i, body := range request {
-			transactions[i] = body.Transactions
-		}
-		// Filter out any explicitly requested bodies, deliver the rest to the downloader
-		filter := len(transactions) > 0
-		if filter {
-			transactions = pm.fetcher.FilterBodies(p.id, transactions, time.Now())
-		}
-		if len(transactions) > 0 || !filter {
-			err := pm.downloader.DeliverBodies(p.id, transactions)
-			if err != nil {
-				log.Debug("Failed to deliver bodies", "err", err)
-			}
+		// Chunks of a body stream are collected by the stream
+		if p.deliverStreamedBodies(request) {
+			return nil
 		}
+		pm.deliverBodies(p, request)
 
 	case msg.Code == GetNodeDataMsg:
 		// Decode the retrieval message
@@ -797,6 +869,144 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			log.Warn("Block already in blockchain,discard this msg", "err", err)
 			return nil
 		}
+		if pm.blockChainCache != nil {
+			pm.blockChainCache.Preload(request.Block)
+		}
+
+	case msg.Code == PrepareBlockWithValidatorsMsg:
+		// A validator proposed a block to us as an observer, only trust it if the
+		// listed validators match the snapshot of our own chain and hold the proposer
+		var request prepareBlockWithValidatorsData
+		if err := msg.Decode(&request); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		if request.Block == nil || request.Block.NumberU64() == 0 {
+			return errResp(ErrDecode, "%v: invalid block", msg)
+		}
+		log.Debug("Received a broadcast message[PrepareBlockWithValidatorsMsg]", "peerId", p.id, "hash", request.Block.Hash(), "number", request.Block.NumberU64(), "validators", len(request.Validators))
+
+		istanbul, ok := pm.engine.(consensus.Istanbul)
+		if !ok {
+			break
+		}
+		validators, err := istanbul.ValidatorsAt(pm.blockchain, request.Block.NumberU64()-1)
+		if err != nil {
+			log.Debug("Failed to resolve the validators in PrepareBlockWithValidatorsMsg,discard this msg", "peerId", p.id, "number", request.Block.NumberU64(), "err", err)
+			return nil
+		}
+		if !sameValidators(validators, request.Validators) {
+			log.Warn("Validator set mismatch in PrepareBlockWithValidatorsMsg,discard this msg", "peerId", p.id, "number", request.Block.NumberU64())
+			return nil
+		}
+		proposer, err := pm.engine.Author(request.Block.Header())
+		if err != nil {
+			log.Error("Failed to recover the proposer in PrepareBlockWithValidatorsMsg,discard this msg", "err", err)
+			return nil
+		}
+		if !containsAddress(validators, proposer) {
+			log.Warn("Proposer not among the validators in PrepareBlockWithValidatorsMsg,discard this msg", "peerId", p.id, "proposer", proposer)
+			return nil
+		}
+		request.Block.ReceivedAt = msg.ReceivedAt
+		request.Block.ReceivedFrom = p
+
+		if err := pm.engine.VerifyHeader(pm.blockchain, request.Block.Header(), true); err != nil {
+			log.Error("Failed to VerifyHeader in PrepareBlockWithValidatorsMsg,discard this msg", "err", err)
+			return nil
+		}
+		if pm.blockchain.HasBlock(request.Block.Hash(), request.Block.NumberU64()) {
+			return nil
+		}
+		if pm.blockChainCache != nil {
+			pm.blockChainCache.Preload(request.Block)
+		}
+
+	case msg.Code == ValidatorSetMsg:
+		// A validator announced a new validator set, only trust it if it matches
+		// the snapshot of our own chain at that height
+		var request validatorSetData
+		if err := msg.Decode(&request); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+		istanbul, ok := pm.engine.(consensus.Istanbul)
+		if !ok {
+			break
+		}
+		validators, err := istanbul.ValidatorsAt(pm.blockchain, request.Number)
+		if err != nil {
+			log.Debug("Failed to verify the validator set in ValidatorSetMsg,discard this msg", "peerId", p.id, "number", request.Number, "err", err)
+			return nil
+		}
+		if !sameValidators(validators, request.Validators) {
+			log.Warn("Validator set mismatch in ValidatorSetMsg,discard this msg", "peerId", p.id, "number", request.Number)
+			return nil
+		}
+		if pm.updateValidatorSet(request.Number, validators) {
+			log.Debug("Updated the validator set", "peerId", p.id, "number", request.Number, "validators", len(validators))
+		}
+
+	case msg.Code == GetValidatorSetMsg:
+		// An observer asked for the validator set of a block, answer from the
+		// snapshot of our own chain
+		var number uint64
+		if err := msg.Decode(&number); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		reply := &validatorSetReplyData{Number: number, Code: validatorSetUnknown}
+		if istanbul, ok := pm.engine.(consensus.Istanbul); ok && number <= pm.blockchain.CurrentHeader().Number.Uint64() {
+			if validators, err := istanbul.ValidatorsAt(pm.blockchain, number); err == nil {
+				reply.Code, reply.Validators = validatorSetOK, validators
+			} else {
+				log.Debug("Failed to resolve the requested validator set", "peerId", p.id, "number", number, "err", err)
+				reply.Code = validatorSetPruned
+			}
+		}
+		return p.SendValidatorSetReply(reply)
+
+	case msg.Code == ValidatorSetReplyMsg:
+		var reply validatorSetReplyData
+		if err := msg.Decode(&reply); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if !p.takeValidatorSetRequest(reply.Number) {
+			log.Debug("Unrequested validator set reply,discard this msg", "peerId", p.id, "number", reply.Number)
+			return nil
+		}
+		if reply.Code != validatorSetOK {
+			log.Debug("Peer could not resolve the requested validator set", "peerId", p.id, "number", reply.Number, "code", reply.Code)
+			return nil
+		}
+		if len(reply.Validators) == 0 {
+			log.Debug("Empty validator set in ValidatorSetReplyMsg,discard this msg", "peerId", p.id, "number", reply.Number)
+			return nil
+		}
+		// Check the set against our own chain if it can resolve it, otherwise
+		// trust the validator it was requested from
+		if istanbul, ok := pm.engine.(consensus.Istanbul); ok {
+			if validators, err := istanbul.ValidatorsAt(pm.blockchain, reply.Number); err == nil && !sameValidators(validators, reply.Validators) {
+				log.Warn("Validator set mismatch in ValidatorSetReplyMsg,discard this msg", "peerId", p.id, "number", reply.Number)
+				return nil
+			}
+		}
+		if pm.updateValidatorSet(reply.Number, reply.Validators) {
+			log.Debug("Updated the validator set", "peerId", p.id, "number", reply.Number, "validators", len(reply.Validators))
+		}
+
+	case msg.Code == PingMsg:
+		// Latency probe, answer with the same nonce
+		var nonce uint64
+		if err := msg.Decode(&nonce); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		return p2p.Send(p.rw, PongMsg, nonce)
+
+	case msg.Code == PongMsg:
+		var nonce uint64
+		if err := msg.Decode(&nonce); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		p.deliverPong(nonce)
+
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
 	}
@@ -811,36 +1021,22 @@ func (pm *ProtocolManager) Enqueue(id string, block *types.Block) {
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 	hash := block.Hash()
-	peers := pm.peers.PeersWithoutBlock(hash)
-	//var peers []*peer
-	//if _, ok := pm.engine.(consensus.Bft); ok {
-	//	peers = pm.peers.PeersWithoutConsensus(pm.engine)
-	//} else {
-	//	peers = pm.peers.PeersWithoutBlock(hash)
-	//}
 
 	// If propagation is requested, send to a subset of the peer
 	if propagate {
 		// Calculate the TD of the block (it's not imported yet, so block.Td is not valid)
-		if parent := pm.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1); parent != nil {
-		} else {
+		if parent := pm.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1); parent == nil {
 			log.Warn("Propagating dangling block", "number", block.Number(), "hash", hash)
 			return
 		}
-		// Send the block to a subset of our peers
-		transfer := peers[:int(math.Sqrt(float64(len(peers))))]
-		for _, peer := range transfer {
-			peer.AsyncSendNewBlock(block)
-		}
-		log.Trace("Propagated block", "hash", fmt.Sprintf("%x", hash[:log.LogHashLen]), "blockNumber", block.Number(), "recipients", len(transfer), "duration", common.PrettyDuration(time.Since(block.ReceivedAt)))
+		pm.peers.BroadcastBlock(block, true)
+		log.Trace("Propagated block", "hash", fmt.Sprintf("%x", hash[:log.LogHashLen]), "blockNumber", block.Number(), "duration", common.PrettyDuration(time.Since(block.ReceivedAt)))
 		return
 	}
 	// Otherwise if the block is indeed in out own chain, announce it
 	if pm.blockchain.HasBlock(hash, block.NumberU64()) {
-		for _, peer := range peers {
-			peer.AsyncSendNewBlockHash(block)
-		}
-		log.Trace("Announced block", "hash", fmt.Sprintf("%x", hash[:log.LogHashLen]), "blockNumber", block.Number(), "recipients", len(peers), "duration", common.PrettyDuration(time.Since(block.ReceivedAt)))
+		pm.peers.BroadcastBlock(block, false)
+		log.Trace("Announced block", "hash", fmt.Sprintf("%x", hash[:log.LogHashLen]), "blockNumber", block.Number(), "duration", common.PrettyDuration(time.Since(block.ReceivedAt)))
 	}
 }
 
@@ -857,6 +1053,27 @@ func (pm *ProtocolManager) MulticastConsensus(a interface{}) {
 				"peerId", peer.id, "Hash", block.Hash(), "Number", block.Number())
 			peer.AsyncSendPrepareBlock(block)
 		}
+		pm.proposeToObservers(block)
+	}
+}
+
+// proposeToObservers propagates a proposed block to the peers not taking part in
+// the consensus, along with the validators it was proposed for.
+func (pm *ProtocolManager) proposeToObservers(block *types.Block) {
+	istanbul, ok := pm.engine.(consensus.Istanbul)
+	if !ok || block.NumberU64() == 0 {
+		return
+	}
+	validators, err := istanbul.ValidatorsAt(pm.blockchain, block.NumberU64()-1)
+	if err != nil {
+		log.Debug("Failed to resolve the validators of a proposed block", "number", block.NumberU64(), "err", err)
+		return
+	}
+	for _, peer := range pm.peers.PeersWithoutConsensus(validators) {
+		if peer.version < platoneV2 || peer.IsConsensus() {
+			continue
+		}
+		peer.AsyncSendPrepareBlockWithValidators(block, validators)
 	}
 }
 
@@ -897,6 +1114,83 @@ func (pm *ProtocolManager) BroadcastTxs(txs types.Transactions) {
 	}
 }
 
+// validatorSetBroadcastLoop caches the validator set changes reported by the
+// consensus engine. Validators also announce them to the peers not taking part
+// in the consensus, everyone else learns them from their own chain.
+func (pm *ProtocolManager) validatorSetBroadcastLoop() {
+	for {
+		select {
+		case ev := <-pm.validatorSetCh:
+			if !pm.updateValidatorSet(ev.Number, ev.Validators) || !ev.Local {
+				break
+			}
+			_, validators := pm.ValidatorSet()
+			for _, p := range pm.peers.PeersWithoutConsensus(validators) {
+				if p.version < platoneV2 {
+					continue
+				}
+				if err := p.SendValidatorSet(ev.Number, ev.Validators); err != nil {
+					p.Log().Debug("Failed to announce the validator set", "number", ev.Number, "err", err)
+				}
+			}
+
+		// Err() channel will be closed when unsubscribing.
+		case <-pm.validatorSetSub.Err():
+			return
+		}
+	}
+}
+
+// ValidatorSet returns the latest validator set known to be announced and the
+// number of the block switching to it, nil if none was seen yet.
+func (pm *ProtocolManager) ValidatorSet() (uint64, []common.Address) {
+	pm.validatorSetMu.RLock()
+	defer pm.validatorSetMu.RUnlock()
+
+	return pm.validatorSetNumber, pm.validatorSet
+}
+
+// updateValidatorSet caches validators as the set of the block at number unless
+// a set of a later block is cached already, reporting whether it was.
+func (pm *ProtocolManager) updateValidatorSet(number uint64, validators []common.Address) bool {
+	pm.validatorSetMu.Lock()
+	defer pm.validatorSetMu.Unlock()
+
+	if pm.validatorSet != nil && number <= pm.validatorSetNumber {
+		return false
+	}
+	pm.validatorSet, pm.validatorSetNumber = validators, number
+	return true
+}
+
+// containsAddress reports whether addr is among addrs.
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValidators reports whether a and b hold the same addresses, in any order.
+func sameValidators(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[common.Address]int, len(a))
+	for _, addr := range a {
+		set[addr]++
+	}
+	for _, addr := range b {
+		if set[addr] == 0 {
+			return false
+		}
+		set[addr]--
+	}
+	return true
+}
+
 // Mined broadcast loop
 func (pm *ProtocolManager) minedBroadcastLoop() {
 	// automatically stops if unsubscribe
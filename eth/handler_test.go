@@ -17,24 +17,24 @@
 package eth
 
 import (
+	"errors"
 	"math"
 	"math/big"
 	"math/rand"
+	"net"
 	"testing"
 	"time"
 
-	"github.com/jpmorganchase/quorum/consensus/ethash"
-
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/consensus"
 	"github.com/Venachain/Venachain/core"
 	"github.com/Venachain/Venachain/core/state"
 	"github.com/Venachain/Venachain/core/types"
-	"github.com/Venachain/Venachain/core/vm"
 	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/eth/downloader"
 	"github.com/Venachain/Venachain/ethdb"
-	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/p2p"
+	"github.com/Venachain/Venachain/p2p/discover"
 	"github.com/Venachain/Venachain/params"
 )
 
@@ -47,7 +47,7 @@ func TestProtocolCompatibility(t *testing.T) {
 		compatible bool
 	}{
 		{61, downloader.FullSync, true}, {62, downloader.FullSync, true}, {63, downloader.FullSync, true},
-		{61, downloader.FastSync, false}, {62, downloader.FastSync, false}, {63, downloader.FastSync, true},
+		{61, downloader.FastSync, true}, {62, downloader.FastSync, true}, {63, downloader.FastSync, true},
 	}
 	// Make sure anything we screw up is restored
 	backup := ProtocolVersions
@@ -226,6 +226,436 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that queued header responses are bounded and silently dropped once the
+// queue is full, without blocking the caller.
+func TestAsyncSendBlockHeadersBounded(t *testing.T) {
+	_, net := p2p.MsgPipe()
+	defer net.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "async", nil), net)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*maxQueuedHeaders; i++ {
+			p.AsyncSendBlockHeaders([]*types.Header{{Number: big.NewInt(int64(i))}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("async header send blocked on a full queue")
+	}
+	if have := len(p.queuedHeaders); have != maxQueuedHeaders {
+		t.Fatalf("queued header responses mismatch: have %d, want %d", have, maxQueuedHeaders)
+	}
+	// The oldest responses must be kept, the overflowing ones dropped
+	for i := 0; i < maxQueuedHeaders; i++ {
+		if number := (<-p.queuedHeaders)[0].Number.Int64(); number != int64(i) {
+			t.Errorf("queued response %d mismatch: have header %d", i, number)
+		}
+	}
+}
+
+// Tests that broadcasting a block sends it in full to the square root of the
+// peers and announces it to the rest, skipping peers already knowing it.
+func TestPeerSetBroadcastBlock(t *testing.T) {
+	ps := newPeerSet()
+	for i := 0; i < 26; i++ {
+		_, net := p2p.MsgPipe()
+		defer net.Close()
+
+		var id discover.NodeID
+		rand.Read(id[:])
+		p := newPeer(63, p2p.NewPeer(id, "broadcast", nil), net)
+		ps.peers[p.id] = p
+	}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)})
+
+	// Mark one peer as already knowing the block, leaving 25 recipients
+	for _, p := range ps.peers {
+		p.knownBlocks.Add(block.Hash())
+		break
+	}
+	ps.BroadcastBlock(block, true)
+
+	var full, hashes, skipped int
+	for _, p := range ps.peers {
+		props, anns := len(p.queuedProps), len(p.queuedAnns)
+		switch {
+		case props == 1 && anns == 0:
+			full++
+		case props == 0 && anns == 1:
+			hashes++
+		case props == 0 && anns == 0:
+			skipped++
+		default:
+			t.Errorf("peer %s received %d blocks and %d announcements", p.id, props, anns)
+		}
+	}
+	if full != 5 || hashes != 20 || skipped != 1 {
+		t.Errorf("broadcast mismatch: have %d full, %d hashes, %d skipped, want 5, 20, 1", full, hashes, skipped)
+	}
+}
+
+// Tests that the peer set rejects peers beyond the per-IP limit, while peers
+// from other IPs or without a known IP are still accepted.
+func TestPeerSetMaxPerIP(t *testing.T) {
+	ps := newPeerSet()
+	ps.maxPerIP = 2
+	defer ps.Close()
+
+	register := func(ip net.IP) error {
+		_, rw := p2p.MsgPipe()
+		defer rw.Close()
+
+		var id discover.NodeID
+		rand.Read(id[:])
+		p := newPeer(63, p2p.NewPeer(id, "limited", nil), rw)
+		p.ip = ip
+		return ps.Register(p, func(string) {})
+	}
+	shared, other := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	tests := []struct {
+		ip  net.IP
+		err error
+	}{
+		{shared, nil},
+		{shared, nil},
+		{shared, errTooManyPeersFromIP},
+		{other, nil},
+		{nil, nil},
+		{nil, nil},
+		{nil, nil},
+	}
+	for i, tt := range tests {
+		if err := register(tt.ip); err != tt.err {
+			t.Errorf("peer %d (%v): registration error mismatch: have %v, want %v", i, tt.ip, err, tt.err)
+		}
+	}
+	if have := ps.Len(); have != 6 {
+		t.Errorf("peer count mismatch: have %d, want %d", have, 6)
+	}
+}
+
+// Tests that announced validator sets are compared regardless of order and only
+// replace the cached set if they belong to a later block.
+func TestValidatorSetUpdate(t *testing.T) {
+	var (
+		a, b, c = common.Address{1}, common.Address{2}, common.Address{3}
+		pm      = &ProtocolManager{}
+	)
+	if !sameValidators([]common.Address{a, b}, []common.Address{b, a}) {
+		t.Errorf("reordered validator sets reported different")
+	}
+	if sameValidators([]common.Address{a, a}, []common.Address{a, b}) {
+		t.Errorf("different validator sets reported same")
+	}
+	tests := []struct {
+		number     uint64
+		validators []common.Address
+		updated    bool
+		want       uint64
+	}{
+		{10, []common.Address{a, b}, true, 10},
+		{10, []common.Address{a, c}, false, 10},
+		{5, []common.Address{c}, false, 10},
+		{20, []common.Address{b, c}, true, 20},
+	}
+	for i, tt := range tests {
+		if updated := pm.updateValidatorSet(tt.number, tt.validators); updated != tt.updated {
+			t.Errorf("test %d: update mismatch: have %v, want %v", i, updated, tt.updated)
+		}
+		if number, _ := pm.ValidatorSet(); number != tt.want {
+			t.Errorf("test %d: cached number mismatch: have %d, want %d", i, number, tt.want)
+		}
+	}
+	if _, validators := pm.ValidatorSet(); !sameValidators(validators, []common.Address{b, c}) {
+		t.Errorf("cached validator set mismatch: have %v, want %v", validators, []common.Address{b, c})
+	}
+}
+
+// Tests that the peers not taking part in the consensus are told apart by the
+// validator set, whatever the handshake reported.
+func TestPeersWithoutConsensus(t *testing.T) {
+	ps := newPeerSet()
+	var validators []common.Address
+	for i := 0; i < 4; i++ {
+		_, net := p2p.MsgPipe()
+		defer net.Close()
+
+		key, _ := crypto.GenerateKey()
+		p := newPeer(63, p2p.NewPeer(discover.PubkeyID(&key.PublicKey), "peer", nil), net)
+		ps.peers[p.id] = p
+		if i < 3 {
+			validators = append(validators, crypto.PubkeyToAddress(key.PublicKey))
+		}
+	}
+	if have := len(ps.PeersWithoutConsensus(nil)); have != 4 {
+		t.Errorf("peer count without validator set mismatch: have %d, want %d", have, 4)
+	}
+	observers := ps.PeersWithoutConsensus(validators)
+	if len(observers) != 1 {
+		t.Fatalf("observer count mismatch: have %d, want %d", len(observers), 1)
+	}
+	pubKey, _ := observers[0].ID().Pubkey()
+	for _, addr := range validators {
+		if addr == crypto.PubkeyToAddress(*pubKey) {
+			t.Errorf("validator %x reported as observer", addr)
+		}
+	}
+}
+
+// Tests that a validator set announcement arrives intact at the remote peer.
+func TestSendValidatorSet(t *testing.T) {
+	app, rw := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "observer", nil), rw)
+
+	validators := []common.Address{{1}, {2}}
+	go p.SendValidatorSet(42, validators)
+
+	msg, err := app.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read announcement: %v", err)
+	}
+	if msg.Code != ValidatorSetMsg {
+		t.Fatalf("message code mismatch: have %d, want %d", msg.Code, ValidatorSetMsg)
+	}
+	var data validatorSetData
+	if err := msg.Decode(&data); err != nil {
+		t.Fatalf("failed to decode announcement: %v", err)
+	}
+	if data.Number != 42 || !sameValidators(data.Validators, validators) {
+		t.Errorf("announcement mismatch: have %d %v, want %d %v", data.Number, data.Validators, 42, validators)
+	}
+}
+
+// validatorSetEngine is an Istanbul engine only resolving the validator sets
+// it holds and taking the coinbase of a header as its proposer.
+type validatorSetEngine struct {
+	consensus.Istanbul
+	sets     map[uint64][]common.Address
+	verified []common.Hash // Headers passed to VerifyHeader
+}
+
+func (e *validatorSetEngine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+func (e *validatorSetEngine) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	e.verified = append(e.verified, header.Hash())
+	return nil
+}
+
+func (e *validatorSetEngine) ValidatorsAt(chain consensus.ChainReader, number uint64) ([]common.Address, error) {
+	if validators, ok := e.sets[number]; ok {
+		return validators, nil
+	}
+	return nil, errors.New("unknown block")
+}
+
+// Tests that observers only accept proposed blocks whose validators match their
+// own snapshot and hold the proposer.
+func TestHandlePrepareBlockWithValidators(t *testing.T) {
+	base, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer base.Stop()
+
+	validators := []common.Address{{1}, {2}, {3}}
+	engine := &validatorSetEngine{sets: map[uint64][]common.Address{4: validators}}
+	pm := &ProtocolManager{engine: engine, blockchain: base.blockchain}
+
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "validator", nil), net)
+
+	tests := []struct {
+		number     int64
+		proposer   common.Address
+		validators []common.Address
+		accepted   bool
+	}{
+		{5, validators[0], validators, true},                      // Matching set holding the proposer
+		{5, validators[1], []common.Address{{3}, {2}, {1}}, true}, // Same set in another order
+		{5, validators[0], validators[:2], false},                 // Set not matching the snapshot
+		{5, common.Address{4}, validators, false},                 // Proposer not among the validators
+		{6, validators[0], validators, false},                     // Parent set unknown locally
+	}
+	for i, tt := range tests {
+		block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(tt.number), Coinbase: tt.proposer})
+		go p2p.Send(app, PrepareBlockWithValidatorsMsg, &prepareBlockWithValidatorsData{Block: block, Validators: tt.validators})
+
+		verified := len(engine.verified)
+		if err := pm.handleMsg(p); err != nil {
+			t.Fatalf("test %d: failed to handle proposed block: %v", i, err)
+		}
+		if accepted := len(engine.verified) > verified; accepted != tt.accepted {
+			t.Errorf("test %d: acceptance mismatch: have %v, want %v", i, accepted, tt.accepted)
+		}
+	}
+}
+
+// Tests that an observer can request the validator set of a block from a peer
+// able to resolve it.
+func TestRequestValidatorSet(t *testing.T) {
+	base, _ := newTestProtocolManagerMust(t, downloader.FullSync, 50, nil, nil)
+	defer base.Stop()
+
+	validators := []common.Address{{1}, {2}, {3}}
+	var (
+		server = &ProtocolManager{engine: &validatorSetEngine{sets: map[uint64][]common.Address{42: validators}}, blockchain: base.blockchain}
+		client = &ProtocolManager{engine: &validatorSetEngine{}, blockchain: base.blockchain}
+	)
+	clientRW, serverRW := p2p.MsgPipe()
+	defer clientRW.Close()
+
+	var clientID, serverID discover.NodeID
+	rand.Read(clientID[:])
+	rand.Read(serverID[:])
+	toServer := newPeer(63, p2p.NewPeer(serverID, "validator", nil), clientRW)
+	toClient := newPeer(63, p2p.NewPeer(clientID, "observer", nil), serverRW)
+
+	roundTrip := func(number uint64) {
+		errc := make(chan error, 2)
+		go func() { errc <- toServer.RequestValidatorSet(number) }()
+		go func() { errc <- server.handleMsg(toClient) }()
+		if err := client.handleMsg(toServer); err != nil {
+			t.Fatalf("failed to handle reply for block %d: %v", number, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := <-errc; err != nil {
+				t.Fatalf("failed to serve request for block %d: %v", number, err)
+			}
+		}
+	}
+	roundTrip(42)
+	if number, have := client.ValidatorSet(); number != 42 || !sameValidators(have, validators) {
+		t.Fatalf("validator set mismatch: have %d %v, want %d %v", number, have, 42, validators)
+	}
+	// Blocks the server cannot resolve, pruned or unknown, leave the set alone
+	roundTrip(7)
+	roundTrip(100)
+	if number, _ := client.ValidatorSet(); number != 42 {
+		t.Fatalf("validator set number mismatch: have %d, want %d", number, 42)
+	}
+	if len(toServer.validatorSetReqs) != 0 {
+		t.Fatalf("answered requests still pending: %v", toServer.validatorSetReqs)
+	}
+	// Unrequested replies are ignored
+	go toClient.SendValidatorSetReply(&validatorSetReplyData{Number: 50, Code: validatorSetOK, Validators: validators[:1]})
+	if err := client.handleMsg(toServer); err != nil {
+		t.Fatalf("failed to handle unrequested reply: %v", err)
+	}
+	if number, _ := client.ValidatorSet(); number != 42 {
+		t.Fatalf("unrequested reply accepted: have number %d, want %d", number, 42)
+	}
+}
+
+// Tests that validator set requests are answered with a result code telling
+// pruned snapshots apart from blocks beyond the head.
+func TestValidatorSetReplyCodes(t *testing.T) {
+	base, _ := newTestProtocolManagerMust(t, downloader.FullSync, 50, nil, nil)
+	defer base.Stop()
+
+	validators := []common.Address{{1}, {2}, {3}}
+	pm := &ProtocolManager{engine: &validatorSetEngine{sets: map[uint64][]common.Address{42: validators}}, blockchain: base.blockchain}
+
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "observer", nil), net)
+
+	tests := []struct {
+		number     uint64
+		code       uint64
+		validators []common.Address
+	}{
+		{42, validatorSetOK, validators}, // Resolvable snapshot
+		{7, validatorSetPruned, nil},     // Known block without a snapshot
+		{51, validatorSetUnknown, nil},   // Block beyond the head
+	}
+	for i, tt := range tests {
+		go p2p.Send(app, GetValidatorSetMsg, tt.number)
+		errc := make(chan error, 1)
+		go func() { errc <- pm.handleMsg(p) }()
+
+		msg, err := app.ReadMsg()
+		if err != nil {
+			t.Fatalf("test %d: failed to read reply: %v", i, err)
+		}
+		if msg.Code != ValidatorSetReplyMsg {
+			t.Fatalf("test %d: message code mismatch: have %d, want %d", i, msg.Code, ValidatorSetReplyMsg)
+		}
+		var reply validatorSetReplyData
+		if err := msg.Decode(&reply); err != nil {
+			t.Fatalf("test %d: failed to decode reply: %v", i, err)
+		}
+		if reply.Number != tt.number || reply.Code != tt.code || !sameValidators(reply.Validators, tt.validators) {
+			t.Errorf("test %d: reply mismatch: have %d %d %v, want %d %d %v", i, reply.Number, reply.Code, reply.Validators, tt.number, tt.code, tt.validators)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("test %d: failed to serve request: %v", i, err)
+		}
+	}
+}
+
+// Tests that the unanswered validator set requests to a peer are bounded and
+// forgotten once they expire.
+func TestValidatorSetRequestExpiry(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	go func() {
+		for {
+			msg, err := app.ReadMsg()
+			if err != nil {
+				return
+			}
+			msg.Discard()
+		}
+	}()
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "validator", nil), net)
+
+	for i := 0; i < maxValidatorSetRequests; i++ {
+		if err := p.RequestValidatorSet(uint64(i)); err != nil {
+			t.Fatalf("request %d: failed to request validator set: %v", i, err)
+		}
+	}
+	if err := p.RequestValidatorSet(maxValidatorSetRequests); err != errTooManyValidatorSetRequests {
+		t.Fatalf("request beyond the limit: error mismatch: have %v, want %v", err, errTooManyValidatorSetRequests)
+	}
+	// Repeating a pending request doesn't take another slot
+	if err := p.RequestValidatorSet(0); err != nil {
+		t.Fatalf("repeated request: failed to request validator set: %v", err)
+	}
+	// Backdate a request past the timeout, its slot frees and its reply is dropped
+	p.validatorSetReqsMu.Lock()
+	p.validatorSetReqs[1] = time.Now().Add(-validatorSetRequestTimeout - time.Second)
+	p.validatorSetReqsMu.Unlock()
+
+	if err := p.RequestValidatorSet(maxValidatorSetRequests); err != nil {
+		t.Fatalf("request after expiry: failed to request validator set: %v", err)
+	}
+	if p.takeValidatorSetRequest(1) {
+		t.Errorf("expired request still awaited")
+	}
+	if !p.takeValidatorSetRequest(maxValidatorSetRequests) {
+		t.Errorf("pending request not awaited")
+	}
+}
+
 // Tests that block contents can be retrieved from a remote chain based on their hashes.
 func TestGetBlockBodies62(t *testing.T) { testGetBlockBodies(t, 62) }
 func TestGetBlockBodies63(t *testing.T) { testGetBlockBodies(t, 63) }
@@ -444,76 +874,3 @@ func testGetReceipt(t *testing.T, protocol int) {
 		t.Errorf("receipts mismatch: %v", err)
 	}
 }
-
-// Tests that post eth protocol handshake, DAO fork-enabled clients also execute
-// a DAO "challenge" verifying each others' DAO fork headers to ensure they're on
-// compatible chains.
-func TestDAOChallengeNoVsNo(t *testing.T)       { testDAOChallenge(t, false, false, false) }
-func TestDAOChallengeNoVsPro(t *testing.T)      { testDAOChallenge(t, false, true, false) }
-func TestDAOChallengeProVsNo(t *testing.T)      { testDAOChallenge(t, true, false, false) }
-func TestDAOChallengeProVsPro(t *testing.T)     { testDAOChallenge(t, true, true, false) }
-func TestDAOChallengeNoVsTimeout(t *testing.T)  { testDAOChallenge(t, false, false, true) }
-func TestDAOChallengeProVsTimeout(t *testing.T) { testDAOChallenge(t, true, true, true) }
-
-func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool) {
-	// Reduce the DAO handshake challenge timeout
-	if timeout {
-		defer func(old time.Duration) { daoChallengeTimeout = old }(daoChallengeTimeout)
-		daoChallengeTimeout = 500 * time.Millisecond
-	}
-	// Create a DAO aware protocol manager
-	var (
-		evmux         = new(event.TypeMux)
-		pow           = ethash.NewFaker()
-		db            = ethdb.NewMemDatabase()
-		config        = &params.ChainConfig{DAOForkBlock: big.NewInt(1), DAOForkSupport: localForked}
-		gspec         = &core.Genesis{Config: config}
-		genesis       = gspec.MustCommit(db)
-		blockchain, _ = core.NewBlockChain(db, nil, config, pow, vm.Config{}, nil)
-	)
-	pm, err := NewProtocolManager(config, downloader.FullSync, DefaultConfig.NetworkId, evmux, new(testTxPool), pow, blockchain, db)
-	if err != nil {
-		t.Fatalf("failed to start test protocol manager: %v", err)
-	}
-	pm.Start(1000)
-	defer pm.Stop()
-
-	// Connect a new peer and check that we receive the DAO challenge
-	peer, _ := newTestPeer("peer", platoneV1, pm, true)
-	defer peer.close()
-
-	challenge := &getBlockHeadersData{
-		Origin:  hashOrNumber{Number: config.DAOForkBlock.Uint64()},
-		Amount:  1,
-		Skip:    0,
-		Reverse: false,
-	}
-	if err := p2p.ExpectMsg(peer.app, GetBlockHeadersMsg, challenge); err != nil {
-		t.Fatalf("challenge mismatch: %v", err)
-	}
-	// Create a block to reply to the challenge if no timeout is simulated
-	if !timeout {
-		blocks, _ := core.GenerateChain(&params.ChainConfig{}, genesis, ethash.NewFaker(), db, 1, func(i int, block *core.BlockGen) {
-			if remoteForked {
-				block.SetExtra(params.DAOForkBlockExtra)
-			}
-		})
-		if err := p2p.Send(peer.app, BlockHeadersMsg, []*types.Header{blocks[0].Header()}); err != nil {
-			t.Fatalf("failed to answer challenge: %v", err)
-		}
-		time.Sleep(100 * time.Millisecond) // Sleep to avoid the verification racing with the drops
-	} else {
-		// Otherwise wait until the test timeout passes
-		time.Sleep(daoChallengeTimeout + 500*time.Millisecond)
-	}
-	// Verify that depending on fork side, the remote peer is maintained or dropped
-	if localForked == remoteForked && !timeout {
-		if peers := pm.peers.Len(); peers != 1 {
-			t.Fatalf("peer count mismatch: have %d, want %d", peers, 1)
-		}
-	} else {
-		if peers := pm.peers.Len(); peers != 0 {
-			t.Fatalf("peer count mismatch: have %d, want %d", peers, 0)
-		}
-	}
-}
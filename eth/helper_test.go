@@ -22,18 +22,26 @@ package eth
 import (
 	"crypto/ecdsa"
 	"crypto/rand"
+	"encoding/json"
 	"math/big"
 	"sort"
 	"sync"
 	"testing"
 
 	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/consensus"
 	"github.com/Venachain/Venachain/core"
+	"github.com/Venachain/Venachain/core/state"
 	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/core/vm"
 	"github.com/Venachain/Venachain/crypto"
+	"github.com/Venachain/Venachain/eth/downloader"
+	"github.com/Venachain/Venachain/ethdb"
 	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/p2p"
 	"github.com/Venachain/Venachain/p2p/discover"
+	"github.com/Venachain/Venachain/params"
+	"github.com/Venachain/Venachain/rpc"
 )
 
 var (
@@ -41,15 +49,129 @@ var (
 	testBank       = crypto.PubkeyToAddress(testBankKey.PublicKey)
 )
 
+func init() {
+	common.SysCfg.ReplayParam = &common.ReplayParam{
+		Pivot:           0,
+		OldSysContracts: make(map[common.Address]string),
+		OldSuperAdmin:   common.NullAddress,
+	}
+}
+
+// testEngine is a fake consensus engine accepting any header and finalizing
+// blocks without rewards.
+type testEngine struct{}
+
+func (e testEngine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+func (e testEngine) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return nil
+}
+
+func (e testEngine) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+	for range headers {
+		results <- nil
+	}
+	return abort, results
+}
+
+func (e testEngine) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return nil
+}
+
+func (e testEngine) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	return nil
+}
+
+func (e testEngine) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = state.IntermediateRoot(true)
+	return types.NewBlock(header, txs, receipts), nil
+}
+
+func (e testEngine) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	return block, nil
+}
+
+func (e testEngine) SealHash(header *types.Header) common.Hash {
+	return header.Hash()
+}
+
+func (e testEngine) APIs(chain consensus.ChainReader) []rpc.API { return nil }
+func (e testEngine) Close() error                               { return nil }
+
+// newTestProtocolManager creates a new protocol manager for testing purposes,
+// with the given number of blocks already known, and potential notification
+// channels for different events.
+func newTestProtocolManager(mode downloader.SyncMode, blocks int, generator func(int, *core.BlockGen), newtx chan<- []*types.Transaction) (*ProtocolManager, *ethdb.MemDatabase, error) {
+	var (
+		evmux  = new(event.TypeMux)
+		engine = testEngine{}
+		db     = ethdb.NewMemDatabase()
+		gspec  = &core.Genesis{
+			Config:    params.TestChainConfig,
+			Timestamp: 1, // A zero timestamp is replaced by the current time
+			Alloc:     core.GenesisAlloc{testBank: {Balance: big.NewInt(1000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blockchain, _, err := core.NewBlockChain(db, nil, nil, gspec.Config, engine, vm.Config{}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, _ := core.GenerateChain(gspec.Config, genesis, engine, db, blocks, generator)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		return nil, nil, err
+	}
+	pm, err := NewProtocolManager(gspec.Config, mode, DefaultConfig.NetworkId, evmux, &testTxPool{added: newtx, db: db}, engine, blockchain, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	pm.Start(1000)
+	return pm, db, nil
+}
+
+// newTestProtocolManagerMust creates a new protocol manager for testing purposes,
+// with the given number of blocks already known, and potential notification
+// channels for different events. In case of an error, the constructor force-
+// fails the test.
+func newTestProtocolManagerMust(t *testing.T, mode downloader.SyncMode, blocks int, generator func(int, *core.BlockGen), newtx chan<- []*types.Transaction) (*ProtocolManager, *ethdb.MemDatabase) {
+	pm, db, err := newTestProtocolManager(mode, blocks, generator, newtx)
+	if err != nil {
+		t.Fatalf("Failed to create protocol manager: %v", err)
+	}
+	return pm, db
+}
+
 // testTxPool is a fake, helper transaction pool for testing purposes
 type testTxPool struct {
 	txFeed event.Feed
 	pool   []*types.Transaction        // Collection of all transactions
 	added  chan<- []*types.Transaction // Notification channel for new transactions
+	db     ethdb.Database              // Extended database handed out to the handler
 
 	lock sync.RWMutex // Protects the transaction pool
 }
 
+// Has returns an indicator whether the pool holds a transaction with the given hash.
+func (p *testTxPool) Has(hash common.Hash) bool {
+	return p.Get(hash) != nil
+}
+
+// Get retrieves the transaction with the given hash from the pool.
+func (p *testTxPool) Get(hash common.Hash) *types.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for _, tx := range p.pool {
+		if tx.Hash() == hash {
+			return tx
+		}
+	}
+	return nil
+}
+
 // AddRemotes appends a batch of transactions to the pool, and notifies any
 // listeners if the addition channel is non nil
 func (p *testTxPool) AddRemotes(txs []*types.Transaction) []error {
@@ -83,6 +205,10 @@ func (p *testTxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subs
 	return p.txFeed.Subscribe(ch)
 }
 
+func (p *testTxPool) ExtendedDb() ethdb.Database {
+	return p.db
+}
+
 // newTestTransaction create a new dummy transaction.
 func newTestTransaction(from *ecdsa.PrivateKey, nonce uint64, datasize int) *types.Transaction {
 	tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 100000, big.NewInt(0), make([]byte, datasize))
@@ -99,6 +225,12 @@ type testPeer struct {
 
 // newTestPeer creates a new peer registered at the given protocol manager.
 func newTestPeer(name string, version int, pm *ProtocolManager, shake bool) (*testPeer, <-chan error) {
+	return newTestPeerWithTypes(name, version, pm, shake, 0)
+}
+
+// newTestPeerWithTypes creates a new peer of the given node types registered at
+// the given protocol manager.
+func newTestPeerWithTypes(name string, version int, pm *ProtocolManager, shake bool, nodeTypes int32) (*testPeer, <-chan error) {
 	// Create a message pipe to communicate through
 	app, net := p2p.MsgPipe()
 
@@ -107,6 +239,7 @@ func newTestPeer(name string, version int, pm *ProtocolManager, shake bool) (*te
 	rand.Read(id[:])
 
 	peer := pm.newPeer(version, p2p.NewPeer(id, name, nil), net)
+	peer.setTypes(nodeTypes)
 
 	// Start the peer on a new thread
 	errc := make(chan error, 1)
@@ -124,23 +257,16 @@ func newTestPeer(name string, version int, pm *ProtocolManager, shake bool) (*te
 		var (
 			genesis = pm.blockchain.Genesis()
 			head    = pm.blockchain.CurrentHeader()
-			td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
 		)
-		tp.handshake(nil, td, head.Hash(), genesis.Hash())
+		tp.handshake(nil, head.Number, head.Hash(), genesis.Hash())
 	}
 	return tp, errc
 }
 
 // handshake simulates a trivial handshake that expects the same state from the
 // remote side as we are simulating locally.
-func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesis common.Hash) {
-	msg := &statusData{
-		ProtocolVersion: uint32(p.version),
-		NetworkId:       DefaultConfig.NetworkId,
-		TD:              td,
-		CurrentBlock:    head,
-		GenesisBlock:    genesis,
-	}
+func (p *testPeer) handshake(t *testing.T, bn *big.Int, head common.Hash, genesis common.Hash) {
+	msg := newTestStatus(uint32(p.version), DefaultConfig.NetworkId, bn, head, genesis)
 	if err := p2p.ExpectMsg(p.app, StatusMsg, msg); err != nil {
 		t.Fatalf("status recv: %v", err)
 	}
@@ -149,6 +275,22 @@ func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesi
 	}
 }
 
+// newTestStatus assembles a status packet carrying the local replay parameters.
+func newTestStatus(version uint32, networkID uint64, bn *big.Int, head common.Hash, genesis common.Hash) *statusData {
+	replay := common.SysCfg.ReplayParam
+	sysContracts, _ := json.Marshal(replay.OldSysContracts)
+	return &statusData{
+		ProtocolVersion:       version,
+		NetworkId:             networkID,
+		BN:                    bn,
+		CurrentBlock:          head,
+		GenesisBlock:          genesis,
+		ReplayPovit:           replay.Pivot,
+		ReplayOldSuperAdmin:   replay.OldSuperAdmin,
+		ReplayOldSysContracts: sysContracts,
+	}
+}
+
 // close terminates the local side of the peer, notifying the remote protocol
 // manager of termination.
 func (p *testPeer) close() {
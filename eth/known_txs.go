@@ -0,0 +1,156 @@
+package eth
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/Venachain/Venachain/common"
+	mapset "github.com/deckarep/golang-set"
+)
+
+const (
+	// knownTxsBloomBits is the number of filter bits per transaction hash of a
+	// bloom generation, giving a false positive rate below 1% when full.
+	knownTxsBloomBits = 10
+
+	// knownTxsBloomHashes is the number of bits set per transaction hash.
+	knownTxsBloomHashes = 7
+)
+
+// knownTxs tracks the transaction hashes known to be known by a peer.
+type knownTxs interface {
+	// Add marks a hash as known, possibly forgetting older ones.
+	Add(hash common.Hash)
+
+	// Contains reports whether a hash is known.
+	Contains(hash common.Hash) bool
+
+	// Cardinality returns the number of hashes tracked.
+	Cardinality() int
+}
+
+// setKnownTxs tracks known transactions exactly, evicting random hashes once
+// the capacity is reached.
+type setKnownTxs struct {
+	set      mapset.Set
+	capacity int
+}
+
+func newSetKnownTxs(capacity int) *setKnownTxs {
+	return &setKnownTxs{set: mapset.NewSet(), capacity: capacity}
+}
+
+func (s *setKnownTxs) Add(hash common.Hash) {
+	for s.set.Cardinality() >= s.capacity {
+		s.set.Pop()
+	}
+	s.set.Add(hash)
+}
+
+func (s *setKnownTxs) Contains(hash common.Hash) bool {
+	return s.set.Contains(hash)
+}
+
+func (s *setKnownTxs) Cardinality() int {
+	return s.set.Cardinality()
+}
+
+// bloomFilter is a fixed size bloom filter of transaction hashes.
+type bloomFilter struct {
+	bits     []uint64
+	count    int
+	capacity int
+}
+
+func newBloomFilter(capacity int) *bloomFilter {
+	return &bloomFilter{
+		bits:     make([]uint64, (capacity*knownTxsBloomBits+63)/64),
+		capacity: capacity,
+	}
+}
+
+// positions calls fn with the filter bits of hash. The hash is uniformly
+// distributed already, so its words serve for double hashing.
+func (f *bloomFilter) positions(hash common.Hash, fn func(word int, mask uint64) bool) bool {
+	var (
+		size = uint64(len(f.bits) * 64)
+		h1   = binary.BigEndian.Uint64(hash[0:8])
+		h2   = binary.BigEndian.Uint64(hash[8:16]) | 1
+	)
+	for i := uint64(0); i < knownTxsBloomHashes; i++ {
+		bit := (h1 + i*h2) % size
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(hash common.Hash) {
+	f.positions(hash, func(word int, mask uint64) bool {
+		f.bits[word] |= mask
+		return true
+	})
+	f.count++
+}
+
+func (f *bloomFilter) contains(hash common.Hash) bool {
+	return f.positions(hash, func(word int, mask uint64) bool {
+		return f.bits[word]&mask != 0
+	})
+}
+
+func (f *bloomFilter) reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+	f.count = 0
+}
+
+// bloomKnownTxs tracks known transactions in two bloom filter generations of
+// half the capacity each. Once the current generation is full, the previous one
+// is cleared and takes its place, so at least the last half of the capacity
+// hashes are always remembered.
+//
+// False positives only suppress a redundant transfer, in exchange for a small
+// fraction of the memory of an exact set.
+type bloomKnownTxs struct {
+	current  *bloomFilter
+	previous *bloomFilter
+	lock     sync.RWMutex
+}
+
+func newBloomKnownTxs(capacity int) *bloomKnownTxs {
+	return &bloomKnownTxs{
+		current:  newBloomFilter(capacity / 2),
+		previous: newBloomFilter(capacity / 2),
+	}
+}
+
+func (b *bloomKnownTxs) Add(hash common.Hash) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.current.contains(hash) {
+		return
+	}
+	if b.current.count >= b.current.capacity {
+		b.previous.reset()
+		b.current, b.previous = b.previous, b.current
+	}
+	b.current.add(hash)
+}
+
+func (b *bloomKnownTxs) Contains(hash common.Hash) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.current.contains(hash) || b.previous.contains(hash)
+}
+
+func (b *bloomKnownTxs) Cardinality() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.current.count + b.previous.count
+}
@@ -0,0 +1,93 @@
+package eth
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/crypto"
+)
+
+// knownTxsTestHash returns the i-th distinct test hash.
+func knownTxsTestHash(i int) common.Hash {
+	return crypto.Keccak256Hash(big.NewInt(int64(i)).Bytes())
+}
+
+// Tests that the bloom filter tracking keeps the recent hashes and rotates out
+// the old ones.
+func TestBloomKnownTxsRotation(t *testing.T) {
+	known := newBloomKnownTxs(maxKnownTxs)
+	for i := 0; i < maxKnownTxs; i++ {
+		known.Add(knownTxsTestHash(i))
+	}
+	// Hashes already matched by the current generation are not counted twice
+	if count := known.Cardinality(); count > maxKnownTxs || count < maxKnownTxs*99/100 {
+		t.Fatalf("cardinality mismatch: have %d, want about %d", count, maxKnownTxs)
+	}
+	for i := 0; i < maxKnownTxs; i++ {
+		if !known.Contains(knownTxsTestHash(i)) {
+			t.Fatalf("hash %d forgotten within capacity", i)
+		}
+	}
+	// Filling another generation evicts the oldest one
+	for i := maxKnownTxs; i < maxKnownTxs*3/2; i++ {
+		known.Add(knownTxsTestHash(i))
+	}
+	forgotten := 0
+	for i := 0; i < maxKnownTxs/2; i++ {
+		if !known.Contains(knownTxsTestHash(i)) {
+			forgotten++
+		}
+	}
+	if forgotten < maxKnownTxs/2*9/10 {
+		t.Errorf("oldest generation kept: only %d of %d hashes forgotten", forgotten, maxKnownTxs/2)
+	}
+}
+
+// Tests the false positive rate of the bloom filter tracking filled to capacity.
+func TestBloomKnownTxsFalsePositives(t *testing.T) {
+	known := newBloomKnownTxs(maxKnownTxs)
+	for i := 0; i < maxKnownTxs; i++ {
+		known.Add(knownTxsTestHash(i))
+	}
+	const queries = 100000
+
+	positives := 0
+	for i := maxKnownTxs; i < maxKnownTxs+queries; i++ {
+		if known.Contains(knownTxsTestHash(i)) {
+			positives++
+		}
+	}
+	// Each full generation errs in about 0.8% of the queries
+	rate := float64(positives) / queries
+	t.Logf("false positive rate at capacity: %.3f%%", rate*100)
+	if rate > 0.025 {
+		t.Errorf("false positive rate too high: have %.3f%%, want at most 2.5%%", rate*100)
+	}
+}
+
+func BenchmarkKnownTxsSet(b *testing.B) {
+	benchmarkKnownTxs(b, func() knownTxs { return newSetKnownTxs(maxKnownTxs) })
+}
+
+func BenchmarkKnownTxsBloom(b *testing.B) {
+	benchmarkKnownTxs(b, func() knownTxs { return newBloomKnownTxs(maxKnownTxs) })
+}
+
+// benchmarkKnownTxs measures the memory allocated to track a full peer worth
+// of known transactions.
+func benchmarkKnownTxs(b *testing.B, newKnown func() knownTxs) {
+	hashes := make([]common.Hash, maxKnownTxs)
+	for i := range hashes {
+		hashes[i] = knownTxsTestHash(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		known := newKnown()
+		for _, hash := range hashes {
+			known.Add(hash)
+		}
+	}
+}
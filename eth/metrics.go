@@ -118,30 +118,47 @@ func (rw *meteredMsgReadWriter) ReadMsg() (p2p.Msg, error) {
 
 func (rw *meteredMsgReadWriter) WriteMsg(msg p2p.Msg) error {
 	// Account for the data traffic
+	rw.markOut(msg.Code, msg.Size)
+
+	// Send the packet to the p2p layer
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+// WriteMany implements p2p.MsgManyWriter, metering the scattered message like
+// any other before passing it on to the p2p layer.
+func (rw *meteredMsgReadWriter) WriteMany(code uint64, payloads [][]byte) error {
+	var size int
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+	rw.markOut(code, uint32(size))
+
+	return p2p.SendMany(rw.MsgReadWriter, code, payloads)
+}
+
+// markOut accounts for an outgoing message of the given code and size.
+func (rw *meteredMsgReadWriter) markOut(code uint64, size uint32) {
 	packets, traffic := miscOutPacketsMeter, miscOutTrafficMeter
 	switch {
-	case msg.Code == BlockHeadersMsg:
+	case code == BlockHeadersMsg:
 		packets, traffic = reqHeaderOutPacketsMeter, reqHeaderOutTrafficMeter
-	case msg.Code == BlockBodiesMsg:
+	case code == BlockBodiesMsg:
 		packets, traffic = reqBodyOutPacketsMeter, reqBodyOutTrafficMeter
 
-	case msg.Code == NodeDataMsg:
+	case code == NodeDataMsg:
 		packets, traffic = reqStateOutPacketsMeter, reqStateOutTrafficMeter
-	case msg.Code == ReceiptsMsg:
+	case code == ReceiptsMsg:
 		packets, traffic = reqReceiptOutPacketsMeter, reqReceiptOutTrafficMeter
 
-	case msg.Code == NewBlockHashesMsg:
+	case code == NewBlockHashesMsg:
 		packets, traffic = propHashOutPacketsMeter, propHashOutTrafficMeter
-	case msg.Code == NewBlockMsg:
+	case code == NewBlockMsg:
 		packets, traffic = propBlockOutPacketsMeter, propBlockOutTrafficMeter
-	case msg.Code == TxMsg || msg.Code == PooledTxMsg:
+	case code == TxMsg || code == PooledTxMsg:
 		packets, traffic = propTxnOutPacketsMeter, propTxnOutTrafficMeter
-	case msg.Code == TxHashesMsg || msg.Code == GetPooledTxMsg:
+	case code == TxHashesMsg || code == GetPooledTxMsg:
 		packets, traffic = propTxHashOutPacketsMeter, propTxHashOutTrafficMeter
 	}
 	packets.Mark(1)
-	traffic.Mark(int64(msg.Size))
-
-	// Send the packet to the p2p layer
-	return rw.MsgReadWriter.WriteMsg(msg)
+	traffic.Mark(int64(size))
 }
@@ -20,7 +20,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"math/rand"
+	"net"
 	"sync"
 	"time"
 
@@ -28,15 +31,21 @@ import (
 
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/p2p"
 	"github.com/Venachain/Venachain/rlp"
 	mapset "github.com/deckarep/golang-set"
 )
 
 var (
-	errClosed            = errors.New("peer set is closed")
-	errAlreadyRegistered = errors.New("peer is already registered")
-	errNotRegistered     = errors.New("peer is not registered")
+	errClosed             = errors.New("peer set is closed")
+	errAlreadyRegistered  = errors.New("peer is already registered")
+	errNotRegistered      = errors.New("peer is not registered")
+	errPingTimeout        = errors.New("ping timed out")
+	errPingUnsupported    = errors.New("peer protocol predates latency probes")
+	errTooManyPeersFromIP = errors.New("too many peers from the same IP")
+
+	errTooManyValidatorSetRequests = errors.New("too many pending validator set requests")
 )
 
 const (
@@ -50,6 +59,14 @@ const (
 
 	maxQueuedTxHashes = 128
 
+	// defaultTxBroadcastBytes is the default size up to which queued transaction
+	// broadcasts are coalesced into a single message.
+	defaultTxBroadcastBytes = 512 * 1024
+
+	// txBatchOverhead is the room left in a transaction message for the header of
+	// the RLP list wrapping the transactions.
+	txBatchOverhead = 9
+
 	// maxQueuedProps is the maximum number of block propagations to queue up before
 	// dropping broadcasts. There's not much point in queueing stale blocks, so a few
 	// that might cover uncles should be enough.
@@ -58,12 +75,24 @@ const (
 	maxQueuedPreBlock  = 4
 	maxQueuedSignature = 4
 
+	// maxQueuedHeaders is the maximum number of header responses to queue up before
+	// dropping them. A single response may carry up to MaxHeaderFetch headers, so
+	// only a few are kept to bound memory usage.
+	maxQueuedHeaders = 4
+
 	// maxQueuedAnns is the maximum number of block announcements to queue up before
 	// dropping broadcasts. Similarly to block propagations, there's no point to queue
 	// above some healthy uncle limit, so use that.
 	maxQueuedAnns = 4
 
-	handshakeTimeout = 5 * time.Second
+	defaultHandshakeTimeout = 5 * time.Second
+
+	pingTimeout  = 2 * time.Second  // Maximum time to wait for the pong of a latency probe
+	pingInterval = 15 * time.Second // Interval between periodic latency probes
+	pingImpact   = 0.1              // Impact a single ping has on the latency estimate
+
+	maxValidatorSetRequests    = 16               // Maximum number of unanswered validator set requests to a peer
+	validatorSetRequestTimeout = 10 * time.Second // Time after which an unanswered validator set request is forgotten
 )
 
 // max is a helper function which returns the larger of the two given integers.
@@ -82,6 +111,43 @@ type PeerInfo struct {
 	Head    string   `json:"head"`    // SHA3 hash of the peer's best owned block
 }
 
+// PeerQueueStats is the number of entries waiting in the broadcast queues of a
+// peer, to tell peers falling behind the broadcasts apart.
+type PeerQueueStats struct {
+	QueuedTxs       int `json:"queuedTxs"`       // Transaction lists waiting to be broadcast
+	QueuedAnns      int `json:"queuedAnns"`      // Blocks waiting to be announced
+	QueuedProps     int `json:"queuedProps"`     // Blocks waiting to be propagated
+	QueuedPreBlocks int `json:"queuedPreBlocks"` // Prepare blocks waiting to be propagated
+	QueuedHashes    int `json:"queuedHashes"`    // Transaction hash lists waiting to be announced
+}
+
+// TxBroadcastPolicy decides how transactions are broadcast to each peer: in
+// full, or announced by hash for the peer to fetch them if still unknown.
+type TxBroadcastPolicy interface {
+	// ShouldSendFull reports whether tx is sent to peer in full rather than
+	// announced by its hash.
+	ShouldSendFull(peer *peer, tx *types.Transaction) bool
+}
+
+// AllFullBroadcastPolicy sends all transactions in full to every peer.
+type AllFullBroadcastPolicy struct{}
+
+// ShouldSendFull implements TxBroadcastPolicy, always sending in full.
+func (AllFullBroadcastPolicy) ShouldSendFull(peer *peer, tx *types.Transaction) bool {
+	return true
+}
+
+// HashFirstPolicy sends transactions in full to the consensus peers, which need
+// them to seal blocks, and announces them by hash to the observers, sparing the
+// bandwidth of relay nodes serving many of them.
+type HashFirstPolicy struct{}
+
+// ShouldSendFull implements TxBroadcastPolicy, sending in full to consensus
+// peers only.
+func (HashFirstPolicy) ShouldSendFull(peer *peer, tx *types.Transaction) bool {
+	return peer.IsConsensus()
+}
+
 // propEvent is a block propagation, waiting for its turn in the broadcast queue.
 type propEvent struct {
 	block *types.Block
@@ -92,6 +158,7 @@ type peer struct {
 
 	*p2p.Peer
 	rw p2p.MsgReadWriter
+	ip net.IP // Remote IP address, nil if not connected over TCP
 
 	version  int         // Protocol version negotiated
 	forkDrop *time.Timer // Timed connection dropper if forks aren't validated in time
@@ -100,34 +167,68 @@ type peer struct {
 	bn   *big.Int
 	lock sync.RWMutex
 
-	knownTxs           mapset.Set                // Set of transaction hashes known to be known by this peer
+	knownTxs           knownTxs                  // Set of transaction hashes known to be known by this peer
 	knownBlocks        mapset.Set                // Set of block hashes known to be known by this peer
 	knownPrepareBlocks mapset.Set                // Set of prepareblock hashes known to be known by this peer
 	queuedTxs          chan []*types.Transaction // Queue of transactions to broadcast to the peer
 	queuedHashes       chan []common.Hash        // Queue of transaction hashes to broadcast to the peer
 	queuedProps        chan *propEvent           // Queue of blocks to broadcast to the peer
 	queuedAnns         chan *types.Block         // Queue of blocks to announce to the peer
+	queuedHeaders      chan []*types.Header      // Queue of header responses to send to the peer
 	term               chan struct{}             // Termination channel to stop the broadcaster
 	queuedPreBlock     chan *preBlockEvent
 	types              int32 // remote node's types   consensus(1) / observer(0)
 	replayParam        common.ReplayParam
+
+	maxMsgSizes  map[uint64]uint32 // Size caps of the protocol messages by code, nil for the global cap
+	txBatchBytes int               // Size up to which queued transaction broadcasts are coalesced
+	txPolicy     TxBroadcastPolicy // Choice between sending transactions in full or by hash
+
+	handshakeTimeout time.Duration // Time allowed for each attempt of the status exchange
+	handshakeRetry   bool          // Whether a timed out status exchange is attempted once more
+	repeatedStatus   bool          // Whether the peer repeated its status after the handshake
+
+	latency time.Duration          // Moving average of the measured round trip times
+	pings   map[uint64]chan uint64 // Pending latency probes waiting for their pong
+	pingMu  sync.Mutex
+
+	validatorSetReqs   map[uint64]time.Time // Block numbers of the validator sets requested and not yet answered
+	validatorSetReqsMu sync.Mutex
+
+	bodyStream         *bodyStream               // Pending chunked body request, if any
+	bodyRequests       []*bodyStream             // Stream of every body request in flight in the order sent, nil for plain ones
+	bodyStreamMu       sync.Mutex                // Lock protecting the pending body stream and the requests in flight
+	bodySendMu         sync.Mutex                // Lock keeping the requests in flight in the order sent
+	streamedBodiesSink func(bodies []*blockBody) // Receiver of the bodies streamed by RequestBodiesSized
 }
 
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
+	var ip net.IP
+	if addr, ok := p.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
 	return &peer{
 		Peer:           p,
 		rw:             rw,
+		ip:             ip,
 		version:        version,
 		id:             fmt.Sprintf("%x", p.ID().Bytes()[:8]),
-		knownTxs:       mapset.NewSet(),
+		knownTxs:       newSetKnownTxs(maxKnownTxs),
 		knownBlocks:    mapset.NewSet(),
 		queuedTxs:      make(chan []*types.Transaction, maxQueuedTxs),
 		queuedHashes:   make(chan []common.Hash, maxQueuedTxHashes),
 		queuedProps:    make(chan *propEvent, maxQueuedProps),
 		queuedAnns:     make(chan *types.Block, maxQueuedAnns),
+		queuedHeaders:  make(chan []*types.Header, maxQueuedHeaders),
 		term:           make(chan struct{}),
 		queuedPreBlock: make(chan *preBlockEvent, maxQueuedPreBlock),
 		types:          common.SysCfg.GetNodeTypes(p.ID().String()),
+		pings:          make(map[uint64]chan uint64),
+
+		validatorSetReqs: make(map[uint64]time.Time),
+		handshakeTimeout: defaultHandshakeTimeout,
+		txBatchBytes:     defaultTxBroadcastBytes,
+		txPolicy:         AllFullBroadcastPolicy{},
 	}
 }
 
@@ -155,13 +256,27 @@ func (p *peer) broadcast(removePeer func(string)) {
 				p.Log().Trace("Announced block", "number", block.Number(), "hash", block.Hash())
 
 			case prop := <-p.queuedPreBlock:
-				if err := p.SendPrepareBlock(prop.block); err != nil {
+				var err error
+				if prop.validators != nil {
+					err = p.SendPrepareBlockWithValidators(prop.block, prop.validators)
+				} else {
+					err = p.SendPrepareBlock(prop.block)
+				}
+				if err != nil {
 					p.Log().Error("Propagated prepare block", "number", prop.block.Number(), "hash", prop.block.Hash(), "err", err)
 					removePeer(p.id)
 					return
 				}
 				p.Log().Trace("Propagated prepare block", "number", prop.block.Number(), "hash", prop.block.Hash())
 
+			case headers := <-p.queuedHeaders:
+				if err := p.SendBlockHeaders(headers); err != nil {
+					p.Log().Error("Sent block headers", "count", len(headers), "err", err)
+					removePeer(p.id)
+					return
+				}
+				p.Log().Trace("Sent block headers", "count", len(headers))
+
 			case <-p.term:
 				return
 			}
@@ -172,7 +287,8 @@ func (p *peer) broadcast(removePeer func(string)) {
 		for {
 			select {
 			case txs := <-p.queuedTxs:
-				if err := p.SendTransactions(txs); err != nil {
+				txs = p.coalesceTxs(txs)
+				if err := p.sendTransactionBatches(txs); err != nil {
 					p.Log().Error("Broadcast transactions err", "err", err)
 					removePeer(p.id)
 					return
@@ -202,6 +318,28 @@ func (p *peer) broadcast(removePeer func(string)) {
 		}
 	}()
 
+	// Peers of older protocol versions do not know about latency probes
+	if p.version < platoneV2 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if rtt, err := p.Ping(); err != nil {
+					p.Log().Debug("Latency probe failed", "err", err)
+				} else {
+					p.Log().Trace("Latency probe", "rtt", rtt, "ewma", p.LatencyEWMA())
+				}
+
+			case <-p.term:
+				return
+			}
+		}
+	}()
 }
 
 // close signals the broadcast goroutine to terminate.
@@ -209,6 +347,77 @@ func (p *peer) close() {
 	close(p.term)
 }
 
+// Ping sends a latency probe to the remote peer and waits for the matching pong,
+// returning the measured round trip time. The result is folded into the moving
+// average reported by LatencyEWMA.
+func (p *peer) Ping() (time.Duration, error) {
+	if p.version < platoneV2 {
+		return 0, errPingUnsupported
+	}
+	nonce := rand.Uint64()
+	pong := make(chan uint64, 1)
+
+	p.pingMu.Lock()
+	p.pings[nonce] = pong
+	p.pingMu.Unlock()
+
+	defer func() {
+		p.pingMu.Lock()
+		delete(p.pings, nonce)
+		p.pingMu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := p2p.Send(p.rw, PingMsg, nonce); err != nil {
+		return 0, err
+	}
+	timeout := time.NewTimer(pingTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-pong:
+		rtt := time.Since(start)
+
+		p.lock.Lock()
+		if p.latency == 0 {
+			p.latency = rtt
+		} else {
+			p.latency = time.Duration((1-pingImpact)*float64(p.latency) + pingImpact*float64(rtt))
+		}
+		p.lock.Unlock()
+		return rtt, nil
+
+	case <-timeout.C:
+		return 0, errPingTimeout
+
+	case <-p.term:
+		return 0, errClosed
+	}
+}
+
+// deliverPong hands a received pong to the latency probe waiting for it. Pongs
+// without a matching probe are ignored.
+func (p *peer) deliverPong(nonce uint64) {
+	p.pingMu.Lock()
+	defer p.pingMu.Unlock()
+
+	if pong, ok := p.pings[nonce]; ok {
+		select {
+		case pong <- nonce:
+		default:
+		}
+	}
+}
+
+// LatencyEWMA returns the exponentially weighted moving average of the round
+// trip times measured by Ping, or 0 if no probe has completed yet.
+func (p *peer) LatencyEWMA() time.Duration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.latency
+}
+
 func (p *peer) setTypes(types int32) {
 	p.types = types
 }
@@ -228,6 +437,17 @@ func (p *peer) Info() *PeerInfo {
 	}
 }
 
+// QueueStats returns the current depths of the broadcast queues of the peer.
+func (p *peer) QueueStats() PeerQueueStats {
+	return PeerQueueStats{
+		QueuedTxs:       len(p.queuedTxs),
+		QueuedAnns:      len(p.queuedAnns),
+		QueuedProps:     len(p.queuedProps),
+		QueuedPreBlocks: len(p.queuedPreBlock),
+		QueuedHashes:    len(p.queuedHashes),
+	}
+}
+
 // Head retrieves a copy of the current head hash and total difficulty of the
 // peer.
 func (p *peer) Head() (hash common.Hash, bn *big.Int) {
@@ -260,6 +480,24 @@ func (p *peer) GetReplayParam() common.ReplayParam {
 	return p.replayParam
 }
 
+// ReplayParamCompatible reports whether the replay parameters announced by the
+// peer match the local ones, i.e. whether both sides replay the same history.
+func (p *peer) ReplayParamCompatible(local common.ReplayParam) bool {
+	remote := p.GetReplayParam()
+	if remote.Pivot != local.Pivot || remote.OldSuperAdmin != local.OldSuperAdmin {
+		return false
+	}
+	if len(remote.OldSysContracts) != len(local.OldSysContracts) {
+		return false
+	}
+	for addr, name := range local.OldSysContracts {
+		if remoteName, ok := remote.OldSysContracts[addr]; !ok || remoteName != name {
+			return false
+		}
+	}
+	return true
+}
+
 // MarkBlock marks a block as known for the peer, ensuring that the block will
 // never be propagated to this particular peer.
 func (p *peer) MarkBlock(hash common.Hash) {
@@ -273,10 +511,6 @@ func (p *peer) MarkBlock(hash common.Hash) {
 // MarkTransaction marks a transaction as known for the peer, ensuring that it
 // will never be propagated to this particular peer.
 func (p *peer) MarkTransaction(hash common.Hash) {
-	// If we reached the memory allowance, drop a previously known transaction hash
-	for p.knownTxs.Cardinality() >= maxKnownTxs {
-		p.knownTxs.Pop()
-	}
 	p.knownTxs.Add(hash)
 }
 
@@ -295,9 +529,103 @@ func (p *peer) SendTransactions(txs types.Transactions) error {
 	return p2p.Send(p.rw, TxMsg, txs)
 }
 
+// txBatchLimit returns the size up to which transactions are batched into a
+// single message, bounded by the size cap of transaction messages.
+func (p *peer) txBatchLimit() int {
+	limit := p.txBatchBytes
+	if limit <= 0 {
+		limit = defaultTxBroadcastBytes
+	}
+	if max := int(maxMsgSize(p.maxMsgSizes, TxMsg)) - txBatchOverhead; limit > max {
+		limit = max
+	}
+	return limit
+}
+
+// coalesceTxs appends the transaction lists waiting in the broadcast queue to
+// txs until their size reaches the batch limit. The given list is shared with
+// the other peers and is not modified.
+func (p *peer) coalesceTxs(txs []*types.Transaction) []*types.Transaction {
+	var (
+		limit = p.txBatchLimit()
+		size  int
+	)
+	for _, tx := range txs {
+		size += int(tx.Size())
+	}
+	for size < limit {
+		select {
+		case more := <-p.queuedTxs:
+			txs = append(txs[:len(txs):len(txs)], more...)
+			for _, tx := range more {
+				size += int(tx.Size())
+			}
+		default:
+			return txs
+		}
+	}
+	return txs
+}
+
+// sendTransactionBatches sends transactions to the peer in as few messages as
+// the batch limit allows. A transaction larger than the limit on its own is
+// sent in a message of its own.
+func (p *peer) sendTransactionBatches(txs []*types.Transaction) error {
+	for _, batch := range splitTxBatches(txs, p.txBatchLimit()) {
+		if err := p.SendTransactions(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitTxBatches splits transactions into consecutive batches whose encoded
+// transactions add up to at most limit bytes, keeping their order.
+func splitTxBatches(txs []*types.Transaction, limit int) []types.Transactions {
+	var (
+		batches []types.Transactions
+		start   int
+		size    int
+	)
+	for i, tx := range txs {
+		txSize := int(tx.Size())
+		if i > start && size+txSize > limit {
+			batches = append(batches, txs[start:i])
+			start, size = i, 0
+		}
+		size += txSize
+	}
+	if start < len(txs) {
+		batches = append(batches, txs[start:])
+	}
+	return batches
+}
+
 // AsyncSendTransactions queues list of transactions propagation to a remote
 // peer. If the peer's broadcast queue is full, the event is silently dropped.
+// The transactions the broadcast policy of the peer does not send in full are
+// announced by hash instead.
 func (p *peer) AsyncSendTransactions(txs []*types.Transaction) {
+	if p.txPolicy != nil {
+		var (
+			full   = make([]*types.Transaction, 0, len(txs))
+			hashes []common.Hash
+		)
+		for _, tx := range txs {
+			if p.txPolicy.ShouldSendFull(p, tx) {
+				full = append(full, tx)
+			} else {
+				hashes = append(hashes, tx.Hash())
+			}
+		}
+		if len(hashes) > 0 {
+			p.AsyncSendPooledTransactionHashes(hashes)
+		}
+		if len(full) == 0 {
+			return
+		}
+		txs = full
+	}
 	select {
 	case p.queuedTxs <- txs:
 		for _, tx := range txs {
@@ -324,8 +652,21 @@ func (p *peer) sendPooledTransactionHashes(hashes []common.Hash) error {
 
 // AsyncSendPooledTransactionHashes queues a list of transactions hashes to eventually
 // announce to a remote peer.  The number of pending sends are capped (new ones
-// will force old sends to be dropped)
+// will force old sends to be dropped). Hashes already known by the peer are not
+// announced again, sparing the churn of re-adding them to its known set.
 func (p *peer) AsyncSendPooledTransactionHashes(hashes []common.Hash) {
+	unknown := make([]common.Hash, 0, len(hashes))
+	for _, hash := range hashes {
+		if !p.knownTxs.Contains(hash) {
+			unknown = append(unknown, hash)
+		}
+	}
+	if len(unknown) == 0 {
+		p.Log().Trace("Skipping known transaction hashes", "count", len(hashes))
+		return
+	}
+	hashes = unknown
+
 	select {
 	case p.queuedHashes <- hashes:
 		for _, hash := range hashes {
@@ -384,6 +725,16 @@ func (p *peer) SendBlockHeaders(headers []*types.Header) error {
 	return p2p.Send(p.rw, BlockHeadersMsg, headers)
 }
 
+// AsyncSendBlockHeaders queues a batch of block headers for sending to the remote
+// peer. If the peer's header queue is full, the response is silently dropped.
+func (p *peer) AsyncSendBlockHeaders(headers []*types.Header) {
+	select {
+	case p.queuedHeaders <- headers:
+	default:
+		p.Log().Debug("Dropping block headers response", "count", len(headers))
+	}
+}
+
 // SendBlockBodies sends a batch of block contents to the remote peer.
 func (p *peer) SendBlockBodies(bodies []*blockBody) error {
 	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
@@ -392,7 +743,22 @@ func (p *peer) SendBlockBodies(bodies []*blockBody) error {
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
 // an already RLP encoded format.
 func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+	return p2p.SendMany(p.rw, BlockBodiesMsg, blockBodiesPayloads(bodies))
+}
+
+// blockBodiesPayloads returns the RLP list of the encoded bodies as the list
+// header followed by the bodies, without copying them.
+func blockBodiesPayloads(bodies []rlp.RawValue) [][]byte {
+	var size uint64
+	for _, body := range bodies {
+		size += uint64(len(body))
+	}
+	payloads := make([][]byte, 0, len(bodies)+1)
+	payloads = append(payloads, rlp.ListHeader(size))
+	for _, body := range bodies {
+		payloads = append(payloads, body)
+	}
+	return payloads
 }
 
 // SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
@@ -413,10 +779,7 @@ func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
 // Note, the method assumes the hashes are correct and correspond to the list of
 // transactions being sent.
 func (p *peer) SendPooledTransactionsRLP(hashes []common.Hash, txs []rlp.RawValue) error {
-	// Mark all the transactions as known, but ensure we don't overflow our limits
-	for p.knownTxs.Cardinality() > max(0, maxKnownTxs-len(hashes)) {
-		p.knownTxs.Pop()
-	}
+	// Mark all the transactions as known, the set evicts old ones when full
 	for _, hash := range hashes {
 		p.knownTxs.Add(hash)
 	}
@@ -448,7 +811,16 @@ func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, rever
 // specified.
 func (p *peer) RequestBodies(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+
+	p.bodySendMu.Lock()
+	defer p.bodySendMu.Unlock()
+
+	p.trackBodyRequests(nil, 1)
+	if err := p2p.Send(p.rw, GetBlockBodiesMsg, hashes); err != nil {
+		p.untrackBodyRequests(nil, 1)
+		return err
+	}
+	return nil
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
@@ -472,42 +844,48 @@ func (p *peer) RequestTxs(hashes []common.Hash) error {
 
 // Handshake executes the eth protocol handshake, negotiating version number,
 // network IDs, difficulties, head and genesis blocks.
+//
+// If the peer does not answer in time and retrying is enabled, our status is sent
+// once more and the peer gets another timeout period to answer.
 func (p *peer) Handshake(network uint64, bn *big.Int, head common.Hash, genesis common.Hash) error {
-	// Send out own handshake in a new thread
-	errc := make(chan error, 2)
-	var status statusData // safe to read after two values have been received from errc
+	scb, err := json.Marshal(common.SysCfg.ReplayParam.OldSysContracts)
+	if err != nil {
+		return err
+	}
+	ours := &statusData{
+		ProtocolVersion:       uint32(p.version),
+		NetworkId:             network,
+		BN:                    bn,
+		CurrentBlock:          head,
+		GenesisBlock:          genesis,
+		ReplayPovit:           common.SysCfg.ReplayParam.Pivot,
+		ReplayOldSuperAdmin:   common.SysCfg.ReplayParam.OldSuperAdmin,
+		ReplayOldSysContracts: scb,
+	}
+	// Read their status in a single thread across the attempts: a second reader
+	// left behind would swallow the first message following the handshake.
+	readc := make(chan error, 1)
+	var (
+		status statusData // safe to read after a value has been received from readc
+		read   bool       // whether their status was received in an earlier attempt
+	)
 
 	go func() {
-		scb, err := json.Marshal(common.SysCfg.ReplayParam.OldSysContracts)
-		if err != nil {
-			errc <- err
-			return
-		}
-		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
-			ProtocolVersion:       uint32(p.version),
-			NetworkId:             network,
-			BN:                    bn,
-			CurrentBlock:          head,
-			GenesisBlock:          genesis,
-			ReplayPovit:           common.SysCfg.ReplayParam.Pivot,
-			ReplayOldSuperAdmin:   common.SysCfg.ReplayParam.OldSuperAdmin,
-			ReplayOldSysContracts: scb,
-		})
+		readc <- p.readStatus(network, &status, genesis)
 	}()
-	go func() {
-		errc <- p.readStatus(network, &status, genesis)
-	}()
-	timeout := time.NewTimer(handshakeTimeout)
-	defer timeout.Stop()
-	for i := 0; i < 2; i++ {
-		select {
-		case err := <-errc:
-			if err != nil {
-				return err
-			}
-		case <-timeout.C:
-			return p2p.DiscReadTimeout
+	attempts := 1
+	if p.handshakeRetry {
+		attempts = 2
+	}
+	for attempt := 1; ; attempt++ {
+		err := p.exchangeStatus(ours, readc, &read)
+		if err == nil {
+			break
 		}
+		if err != p2p.DiscReadTimeout || attempt == attempts {
+			return err
+		}
+		p.Log().Debug("Ethereum handshake timed out, retrying", "timeout", p.handshakeTimeout)
 	}
 	p.bn, p.head = status.BN, status.CurrentBlock
 	p.replayParam.Pivot = status.ReplayPovit
@@ -521,6 +899,37 @@ func (p *peer) Handshake(network uint64, bn *big.Int, head common.Hash, genesis
 	return nil
 }
 
+// exchangeStatus sends our status out in a new thread and waits for both the
+// send and the read of their status, unless read already, to complete within
+// the handshake timeout. Each attempt sends on its own channel, so a send left
+// over from a timed out attempt cannot complete a later one.
+func (p *peer) exchangeStatus(ours *statusData, readc <-chan error, read *bool) error {
+	sendc := make(chan error, 1)
+	go func() {
+		sendc <- p2p.Send(p.rw, StatusMsg, ours)
+	}()
+	timeout := time.NewTimer(p.handshakeTimeout)
+	defer timeout.Stop()
+
+	for sent := false; !sent || !*read; {
+		select {
+		case err := <-sendc:
+			if err != nil {
+				return err
+			}
+			sent = true
+		case err := <-readc:
+			if err != nil {
+				return err
+			}
+			*read = true
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+	return nil
+}
+
 func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
@@ -529,8 +938,8 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	if msg.Code != StatusMsg {
 		return errResp(ErrNoStatusMsg, "first msg has code %x (!= %x)", msg.Code, StatusMsg)
 	}
-	if msg.Size > ProtocolMaxMsgSize {
-		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	if limit := maxMsgSize(p.maxMsgSizes, msg.Code); msg.Size > limit {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, limit)
 	}
 	// Decode the handshake and make sure everything matches
 	if err := msg.Decode(&status); err != nil {
@@ -561,6 +970,9 @@ type peerSet struct {
 	peers  map[string]*peer
 	lock   sync.RWMutex
 	closed bool
+
+	maxPerIP int               // Maximum number of peers sharing a remote IP, 0 for no limit
+	txPolicy TxBroadcastPolicy // Transaction broadcast policy of the registered peers, nil for all full
 }
 
 // newPeerSet creates a new peer set to track the active participants.
@@ -571,8 +983,8 @@ func newPeerSet() *peerSet {
 }
 
 // Register injects a new peer into the working set, or returns an error if the
-// peer is already known. If a new peer it registered, its broadcast loop is also
-// started.
+// peer is already known or too many peers share its IP. If a new peer it
+// registered, its broadcast loop is also started.
 func (ps *peerSet) Register(p *peer, removePeer func(string)) error {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
@@ -583,6 +995,20 @@ func (ps *peerSet) Register(p *peer, removePeer func(string)) error {
 	if _, ok := ps.peers[p.id]; ok {
 		return errAlreadyRegistered
 	}
+	if ps.maxPerIP > 0 && p.ip != nil {
+		count := 0
+		for _, other := range ps.peers {
+			if p.ip.Equal(other.ip) {
+				count++
+			}
+		}
+		if count >= ps.maxPerIP {
+			return errTooManyPeersFromIP
+		}
+	}
+	if ps.txPolicy != nil {
+		p.txPolicy = ps.txPolicy
+	}
 	ps.peers[p.id] = p
 	go p.broadcast(removePeer)
 
@@ -617,6 +1043,18 @@ func (ps *peerSet) Peers() map[string]*peer {
 	return set
 }
 
+// QueueStats returns the broadcast queue depths of all registered peers by id.
+func (ps *peerSet) QueueStats() map[string]PeerQueueStats {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	stats := make(map[string]PeerQueueStats, len(ps.peers))
+	for id, p := range ps.peers {
+		stats[id] = p.QueueStats()
+	}
+	return stats
+}
+
 // Peer retrieves the registered peer with the given id.
 func (ps *peerSet) Peer(id string) *peer {
 	ps.lock.RLock()
@@ -648,6 +1086,25 @@ func (ps *peerSet) PeersWithoutBlock(hash common.Hash) []*peer {
 	return list
 }
 
+// BroadcastBlock sends a block to the peers that do not know about it yet. If
+// propagateFull is set, the entire block is sent to the square root of those
+// peers and only the hash is announced to the rest; otherwise all of them just
+// receive the announcement.
+func (ps *peerSet) BroadcastBlock(block *types.Block, propagateFull bool) {
+	peers := ps.PeersWithoutBlock(block.Hash())
+
+	var transfer []*peer
+	if propagateFull {
+		transfer = peers[:int(math.Sqrt(float64(len(peers))))]
+	}
+	for _, p := range transfer {
+		p.AsyncSendNewBlock(block)
+	}
+	for _, p := range peers[len(transfer):] {
+		p.AsyncSendNewBlockHash(block)
+	}
+}
+
 // PeersWithoutTx retrieves a list of peers that do not have a given transaction
 // in their set of known hashes.
 func (ps *peerSet) PeersWithoutTx(hash common.Hash) []*peer {
@@ -710,6 +1167,28 @@ func (ps *peerSet) BestPeer() *peer {
 	return bestPeer
 }
 
+// BestCompatiblePeer retrieves the known peer with the currently highest block
+// number among those whose replay parameters match the local ones.
+func (ps *peerSet) BestCompatiblePeer(local common.ReplayParam) *peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	var (
+		bestPeer *peer
+		bestBn   *big.Int
+	)
+	for _, p := range ps.peers {
+		if !p.ReplayParamCompatible(local) {
+			p.Log().Debug("Skipping sync peer with incompatible replay params", "pivot", p.GetReplayParam().Pivot, "local", local.Pivot)
+			continue
+		}
+		if _, bn := p.Head(); bestPeer == nil || bn.Cmp(bestBn) > 0 {
+			bestPeer, bestBn = p, bn
+		}
+	}
+	return bestPeer
+}
+
 // Close disconnects all peers.
 // No new peers can be registered after Close has returned.
 func (ps *peerSet) Close() {
@@ -729,23 +1208,32 @@ func (ps *peerSet) PeersWithConsensus(engine consensus.Engine) []*peer {
 	return nil
 }
 
-func (ps *peerSet) PeersWithoutConsensus(engine consensus.Engine) []*peer {
+// PeersWithoutConsensus retrieves the peers which are not among the given
+// validators.
+func (ps *peerSet) PeersWithoutConsensus(validators []common.Address) []*peer {
 	ps.lock.RLock()
 	defer ps.lock.RUnlock()
 
-	consensusNodeMap := make(map[string]string)
+	consensusNodeMap := make(map[common.Address]struct{}, len(validators))
+	for _, addr := range validators {
+		consensusNodeMap[addr] = struct{}{}
+	}
 	list := make([]*peer, 0, len(ps.peers))
-	for nodeId, peer := range ps.peers {
-		if _, ok := consensusNodeMap[nodeId]; !ok {
-			list = append(list, peer)
+	for _, peer := range ps.peers {
+		if pubKey, err := peer.ID().Pubkey(); err == nil {
+			if _, ok := consensusNodeMap[crypto.PubkeyToAddress(*pubKey)]; ok {
+				continue
+			}
 		}
+		list = append(list, peer)
 	}
 
 	return list
 }
 
 type preBlockEvent struct {
-	block *types.Block
+	block      *types.Block
+	validators []common.Address // Validators of the block, only set for observers
 }
 
 type signatureEvent struct {
@@ -760,6 +1248,72 @@ func (p *peer) SendPrepareBlock(block *types.Block) error {
 	return p2p.Send(p.rw, PrepareBlockMsg, []interface{}{block})
 }
 
+// SendPrepareBlockWithValidators propagates a proposed block along with the
+// validators it was proposed for, letting observer nodes check the proposer.
+// Consensus peers know the validators already and get a plain PrepareBlockMsg.
+func (p *peer) SendPrepareBlockWithValidators(block *types.Block, validators []common.Address) error {
+	if p.IsConsensus() {
+		return p.SendPrepareBlock(block)
+	}
+	return p2p.Send(p.rw, PrepareBlockWithValidatorsMsg, &prepareBlockWithValidatorsData{Block: block, Validators: validators})
+}
+
+// SendValidatorSet announces the validator set that the block at number switched
+// to.
+func (p *peer) SendValidatorSet(number uint64, validators []common.Address) error {
+	return p2p.Send(p.rw, ValidatorSetMsg, &validatorSetData{Number: number, Validators: validators})
+}
+
+// RequestValidatorSet asks the remote peer for the validator set of the block at
+// number. Requests left unanswered for validatorSetRequestTimeout are forgotten.
+func (p *peer) RequestValidatorSet(number uint64) error {
+	p.validatorSetReqsMu.Lock()
+	p.expireValidatorSetRequests(time.Now())
+	if _, ok := p.validatorSetReqs[number]; !ok && len(p.validatorSetReqs) >= maxValidatorSetRequests {
+		p.validatorSetReqsMu.Unlock()
+		return errTooManyValidatorSetRequests
+	}
+	p.validatorSetReqs[number] = time.Now()
+	p.validatorSetReqsMu.Unlock()
+
+	p.Log().Debug("Fetching validator set", "number", number)
+	if err := p2p.Send(p.rw, GetValidatorSetMsg, number); err != nil {
+		p.takeValidatorSetRequest(number)
+		return err
+	}
+	return nil
+}
+
+// SendValidatorSetReply answers a validator set request.
+func (p *peer) SendValidatorSetReply(reply *validatorSetReplyData) error {
+	return p2p.Send(p.rw, ValidatorSetReplyMsg, reply)
+}
+
+// takeValidatorSetRequest reports whether the validator set of the block at
+// number was requested from the peer and is still awaited, marking the request
+// answered.
+func (p *peer) takeValidatorSetRequest(number uint64) bool {
+	p.validatorSetReqsMu.Lock()
+	defer p.validatorSetReqsMu.Unlock()
+
+	p.expireValidatorSetRequests(time.Now())
+	if _, ok := p.validatorSetReqs[number]; !ok {
+		return false
+	}
+	delete(p.validatorSetReqs, number)
+	return true
+}
+
+// expireValidatorSetRequests forgets the validator set requests sent longer than
+// validatorSetRequestTimeout before now. The caller must hold validatorSetReqsMu.
+func (p *peer) expireValidatorSetRequests(now time.Time) {
+	for number, sent := range p.validatorSetReqs {
+		if now.Sub(sent) > validatorSetRequestTimeout {
+			delete(p.validatorSetReqs, number)
+		}
+	}
+}
+
 func (p *peer) AsyncSendPrepareBlock(block *types.Block) {
 	select {
 	case p.queuedPreBlock <- &preBlockEvent{block: block}:
@@ -768,3 +1322,15 @@ func (p *peer) AsyncSendPrepareBlock(block *types.Block) {
 		p.Log().Debug("Dropping prepare block propagation", "number", block.NumberU64(), "hash", block.Hash())
 	}
 }
+
+// AsyncSendPrepareBlockWithValidators queues a proposed block along with its
+// validators for propagation to a remote peer. If the peer's broadcast queue is
+// full, the event is silently dropped.
+func (p *peer) AsyncSendPrepareBlockWithValidators(block *types.Block, validators []common.Address) {
+	select {
+	case p.queuedPreBlock <- &preBlockEvent{block: block, validators: validators}:
+		p.Log().Debug("Send prepare block propagation", "number", block.NumberU64(), "hash", block.Hash(), "validators", len(validators))
+	default:
+		p.Log().Debug("Dropping prepare block propagation", "number", block.NumberU64(), "hash", block.Hash())
+	}
+}
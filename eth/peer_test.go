@@ -0,0 +1,372 @@
+package eth
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/core/types"
+	"github.com/Venachain/Venachain/p2p"
+	"github.com/Venachain/Venachain/p2p/discover"
+	"github.com/Venachain/Venachain/rlp"
+)
+
+// Tests that transaction broadcasts larger than the batch limit are split into
+// several messages, each under the limit and together holding all transactions
+// in order, while a single oversized transaction travels on its own.
+func TestTransactionBatches(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "broadcaster", nil), net)
+
+	var txs []*types.Transaction
+	for i := 0; i < 10; i++ {
+		txs = append(txs, types.NewTransaction(uint64(i), common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), make([]byte, 100)))
+	}
+	p.txBatchBytes = 3*int(txs[0].Size()) + 1
+	txs = append(txs, types.NewTransaction(10, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), make([]byte, p.txBatchBytes)))
+
+	// Queue the transactions in several lists to be coalesced
+	p.AsyncSendTransactions(txs[:2])
+	p.AsyncSendTransactions(txs[2:])
+	queued := p.coalesceTxs(<-p.queuedTxs)
+	if len(queued) != len(txs) {
+		t.Fatalf("coalesced transaction count mismatch: have %d, want %d", len(queued), len(txs))
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- p.sendTransactionBatches(queued) }()
+
+	var (
+		received []*types.Transaction
+		sizes    []int
+	)
+	for len(received) < len(txs) {
+		msg, err := app.ReadMsg()
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		if msg.Code != TxMsg {
+			t.Fatalf("message code mismatch: have %d, want %d", msg.Code, TxMsg)
+		}
+		var batch []*types.Transaction
+		if err := msg.Decode(&batch); err != nil {
+			t.Fatalf("failed to decode batch: %v", err)
+		}
+		size := 0
+		for _, tx := range batch {
+			size += int(tx.Size())
+		}
+		if size > p.txBatchBytes && len(batch) > 1 {
+			t.Errorf("batch of %d transactions over the limit: %d > %d bytes", len(batch), size, p.txBatchBytes)
+		}
+		received = append(received, batch...)
+		sizes = append(sizes, len(batch))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to send batches: %v", err)
+	}
+	if want := []int{3, 3, 3, 1, 1}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("batch sizes mismatch: have %v, want %v", sizes, want)
+	}
+	for i, tx := range received {
+		if tx.Hash() != txs[i].Hash() {
+			t.Fatalf("transaction %d mismatch: have %x, want %x", i, tx.Hash(), txs[i].Hash())
+		}
+	}
+}
+
+// Tests that the queue stats of a peer follow the occupancy of its broadcast
+// queues, capped at their capacity as further sends are dropped.
+func TestPeerQueueStats(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "monitored", nil), net)
+
+	if stats := p.QueueStats(); stats != (PeerQueueStats{}) {
+		t.Fatalf("stats of idle peer mismatch: have %+v, want empty", stats)
+	}
+	// Queue some entries without the broadcast loop draining them
+	for i := 0; i < 3; i++ {
+		p.AsyncSendTransactions([]*types.Transaction{types.NewTransaction(uint64(i), common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil)})
+	}
+	p.AsyncSendPooledTransactionHashes([]common.Hash{{0x01}})
+	p.AsyncSendPooledTransactionHashes([]common.Hash{{0x02}})
+	for i := 0; i < maxQueuedAnns+2; i++ {
+		p.AsyncSendNewBlockHash(types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i))}))
+	}
+	p.AsyncSendNewBlock(types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)}))
+	p.AsyncSendPrepareBlock(types.NewBlockWithHeader(&types.Header{Number: big.NewInt(2)}))
+
+	want := PeerQueueStats{
+		QueuedTxs:       3,
+		QueuedAnns:      maxQueuedAnns,
+		QueuedProps:     1,
+		QueuedPreBlocks: 1,
+		QueuedHashes:    2,
+	}
+	if stats := p.QueueStats(); stats != want {
+		t.Errorf("stats mismatch: have %+v, want %+v", stats, want)
+	}
+	// Draining a queue is reflected in the stats
+	<-p.queuedTxs
+	want.QueuedTxs--
+	if stats := p.QueueStats(); stats != want {
+		t.Errorf("stats after drain mismatch: have %+v, want %+v", stats, want)
+	}
+	// The peer set reports the stats of its peers by id
+	ps := newPeerSet()
+	ps.peers[p.id] = p
+	if stats := ps.QueueStats(); !reflect.DeepEqual(stats, map[string]PeerQueueStats{p.id: want}) {
+		t.Errorf("peer set stats mismatch: have %+v, want %+v", stats, want)
+	}
+}
+
+// Tests that transaction hashes keep being announced to a peer whose known set
+// is full, skipping only the hashes the peer already knows.
+func TestAnnounceSaturatedPeer(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "saturated", nil), net)
+	for i := 0; i < maxKnownTxs; i++ {
+		p.knownTxs.Add(common.BigToHash(big.NewInt(int64(i))))
+	}
+	known, fresh := common.BigToHash(big.NewInt(maxKnownTxs-1)), common.BigToHash(big.NewInt(maxKnownTxs))
+
+	p.AsyncSendPooledTransactionHashes([]common.Hash{known, fresh})
+	if hashes := <-p.queuedHashes; !reflect.DeepEqual(hashes, []common.Hash{fresh}) {
+		t.Errorf("announced hashes mismatch: have %x, want %x", hashes, fresh)
+	}
+	// Nothing is queued once all hashes are known
+	p.AsyncSendPooledTransactionHashes([]common.Hash{known, fresh})
+	if queued := len(p.queuedHashes); queued != 0 {
+		t.Errorf("queued announcements mismatch: have %d, want 0", queued)
+	}
+}
+
+// Tests that the hash-first broadcast policy sends transactions in full to
+// consensus peers only, announcing them by hash to observers.
+func TestHashFirstPolicy(t *testing.T) {
+	txs := []*types.Transaction{
+		types.NewTransaction(0, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil),
+		types.NewTransaction(1, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil),
+	}
+	for _, consensus := range []bool{false, true} {
+		app, net := p2p.MsgPipe()
+
+		var id discover.NodeID
+		rand.Read(id[:])
+		p := newPeer(63, p2p.NewPeer(id, "policy", nil), net)
+		p.txPolicy = HashFirstPolicy{}
+		if consensus {
+			p.setTypes(1)
+		} else {
+			p.setTypes(0)
+		}
+		p.AsyncSendTransactions(txs)
+
+		want := PeerQueueStats{QueuedHashes: 1}
+		if consensus {
+			want = PeerQueueStats{QueuedTxs: 1}
+		}
+		if stats := p.QueueStats(); stats != want {
+			t.Errorf("consensus %v: queued broadcasts mismatch: have %+v, want %+v", consensus, stats, want)
+		}
+		if !consensus {
+			if hashes := <-p.queuedHashes; !reflect.DeepEqual(hashes, []common.Hash{txs[0].Hash(), txs[1].Hash()}) {
+				t.Errorf("announced hashes mismatch: have %x, want %x and %x", hashes, txs[0].Hash(), txs[1].Hash())
+			}
+		}
+		app.Close()
+	}
+}
+
+// Tests that the hash-first broadcast policy keeps announcing new transactions
+// to an observer whose known set is full.
+func TestHashFirstPolicySaturatedObserver(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "observer", nil), net)
+	p.txPolicy = HashFirstPolicy{}
+	p.setTypes(0)
+	for i := 0; i < maxKnownTxs; i++ {
+		p.knownTxs.Add(common.BigToHash(big.NewInt(int64(i))))
+	}
+	tx := types.NewTransaction(0, common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil)
+	p.AsyncSendTransactions([]*types.Transaction{tx})
+
+	if stats := p.QueueStats(); stats != (PeerQueueStats{QueuedHashes: 1}) {
+		t.Fatalf("queued broadcasts mismatch: have %+v, want %+v", stats, PeerQueueStats{QueuedHashes: 1})
+	}
+	if hashes := <-p.queuedHashes; !reflect.DeepEqual(hashes, []common.Hash{tx.Hash()}) {
+		t.Errorf("announced hashes mismatch: have %x, want %x", hashes, tx.Hash())
+	}
+}
+
+func BenchmarkTxBroadcastAllFull(b *testing.B)   { benchmarkTxBroadcast(b, AllFullBroadcastPolicy{}) }
+func BenchmarkTxBroadcastHashFirst(b *testing.B) { benchmarkTxBroadcast(b, HashFirstPolicy{}) }
+
+// benchmarkTxBroadcast measures the transaction broadcast throughput to an
+// observer peer under the given policy, reporting the bytes queued for it.
+func benchmarkTxBroadcast(b *testing.B, policy TxBroadcastPolicy) {
+	_, net := p2p.MsgPipe()
+	defer net.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "bench", nil), net)
+	p.setTypes(0)
+	p.txPolicy = policy
+	// Keep announcing throughout, a saturated known set would skip them
+	p.knownTxs = newBloomKnownTxs(maxKnownTxs)
+
+	// Drain the broadcast queues in place of the broadcast loop
+	var queued uint64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case txs := <-p.queuedTxs:
+				for _, tx := range txs {
+					queued += uint64(tx.Size())
+				}
+			case hashes := <-p.queuedHashes:
+				queued += uint64(len(hashes) * common.HashLength)
+			case <-p.term:
+				return
+			}
+		}
+	}()
+	txs := make([]*types.Transaction, 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range txs {
+			txs[j] = types.NewTransaction(uint64(i*len(txs)+j), common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), make([]byte, 128))
+		}
+		p.AsyncSendTransactions(txs)
+	}
+	b.StopTimer()
+	p.close()
+	<-done
+	b.ReportMetric(float64(queued)/float64(b.N), "queued-bytes/op")
+}
+
+// Tests that block bodies sent from scattered payloads carry exactly the bytes
+// of the same bodies encoded as a single list.
+func TestBlockBodiesPayloads(t *testing.T) {
+	for _, n := range []int{0, 1, 128} {
+		bodies := testBlockBodies(n)
+
+		app, net := p2p.MsgPipe()
+		go func() {
+			if err := p2p.Send(app, BlockBodiesMsg, bodies); err != nil {
+				t.Errorf("bodies %d: send error: %v", n, err)
+			}
+			if err := p2p.SendMany(app, BlockBodiesMsg, blockBodiesPayloads(bodies)); err != nil {
+				t.Errorf("bodies %d: scattered send error: %v", n, err)
+			}
+		}()
+		var payloads [2][]byte
+		for i := range payloads {
+			msg, err := net.ReadMsg()
+			if err != nil {
+				t.Fatalf("bodies %d: read error: %v", n, err)
+			}
+			if msg.Code != BlockBodiesMsg {
+				t.Fatalf("bodies %d: message code mismatch: have %d, want %d", n, msg.Code, BlockBodiesMsg)
+			}
+			if payloads[i], err = ioutil.ReadAll(msg.Payload); err != nil {
+				t.Fatalf("bodies %d: payload read error: %v", n, err)
+			}
+			if len(payloads[i]) != int(msg.Size) {
+				t.Fatalf("bodies %d: payload size mismatch: have %d, want %d", n, len(payloads[i]), msg.Size)
+			}
+		}
+		if !bytes.Equal(payloads[0], payloads[1]) {
+			t.Errorf("bodies %d: payload mismatch:\n  have %x\n  want %x", n, payloads[1], payloads[0])
+		}
+		app.Close()
+	}
+}
+
+// testBlockBodies creates n encoded block bodies of a few transactions each.
+func testBlockBodies(n int) []rlp.RawValue {
+	bodies := make([]rlp.RawValue, n)
+	for i := range bodies {
+		txs := make([]*types.Transaction, 4)
+		for j := range txs {
+			txs[j] = types.NewTransaction(uint64(i*len(txs)+j), common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), make([]byte, 256))
+		}
+		enc, err := rlp.EncodeToBytes(&blockBody{Transactions: txs})
+		if err != nil {
+			panic(err)
+		}
+		bodies[i] = enc
+	}
+	return bodies
+}
+
+// discardMsgWriter drains every message it is given, like a transport would.
+type discardMsgWriter struct{}
+
+func (discardMsgWriter) WriteMsg(msg p2p.Msg) error {
+	_, err := io.Copy(ioutil.Discard, msg.Payload)
+	return err
+}
+
+// discardManyWriter additionally accepts scattered payloads, like the RLPx
+// transport does.
+type discardManyWriter struct{ discardMsgWriter }
+
+func (discardManyWriter) WriteMany(code uint64, payloads [][]byte) error {
+	for _, payload := range payloads {
+		if _, err := ioutil.Discard.Write(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func BenchmarkSendBlockBodiesRLP128(b *testing.B) { benchmarkSendBlockBodiesRLP(b, 128) }
+func BenchmarkSendBlockBodiesRLP256(b *testing.B) { benchmarkSendBlockBodiesRLP(b, 256) }
+func BenchmarkSendBlockBodiesRLP512(b *testing.B) { benchmarkSendBlockBodiesRLP(b, 512) }
+
+// benchmarkSendBlockBodiesRLP compares the allocations of sending n bodies
+// encoded into one buffer against sending them as scattered payloads.
+func benchmarkSendBlockBodiesRLP(b *testing.B, n int) {
+	bodies := testBlockBodies(n)
+
+	b.Run("copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := p2p.Send(discardMsgWriter{}, BlockBodiesMsg, bodies); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("zerocopy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := p2p.SendMany(discardManyWriter{}, BlockBodiesMsg, blockBodiesPayloads(bodies)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
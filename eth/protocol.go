@@ -32,21 +32,61 @@ import (
 // Constants to match up protocol versions and messages
 const (
 	platoneV1 = 1
+	platoneV2 = 2
 )
 
 // ProtocolName is the official short name of the protocol used during capability negotiation.
 
-var ProtocolNameArr = []string{"vena"}
+var ProtocolNameArr = []string{"vena", "vena"}
 
 // ProtocolVersions are the upported versions of the eth protocol (first is primary).
-var ProtocolVersions = []uint{platoneV1}
+var ProtocolVersions = []uint{platoneV2, platoneV1}
 
 // ProtocolLengths are the number of implemented message corresponding to different protocol versions.
 //var ProtocolLengths = []uint64{17, 8}
-var ProtocolLengths = []uint64{21}
+var ProtocolLengths = []uint64{27, 21}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
+// DefaultMaxMsgSizeByCode are the default size caps of the individual protocol
+// messages. Requests and announcements are tiny compared to responses carrying
+// blocks or state, so they get much tighter limits. Codes without an entry fall
+// back to ProtocolMaxMsgSize.
+var DefaultMaxMsgSizeByCode = map[uint64]uint32{
+	StatusMsg:          64 * 1024,
+	NewBlockHashesMsg:  1024 * 1024,
+	TxMsg:              ProtocolMaxMsgSize,
+	GetBlockHeadersMsg: 1024,
+	BlockHeadersMsg:    ProtocolMaxMsgSize,
+	GetBlockBodiesMsg:  1024 * 1024,
+	BlockBodiesMsg:     ProtocolMaxMsgSize,
+	NewBlockMsg:        ProtocolMaxMsgSize,
+	PrepareBlockMsg:    ProtocolMaxMsgSize,
+	GetNodeDataMsg:     1024 * 1024,
+	NodeDataMsg:        ProtocolMaxMsgSize,
+	GetReceiptsMsg:     1024 * 1024,
+	ReceiptsMsg:        ProtocolMaxMsgSize,
+	IstanbulMsg:        ProtocolMaxMsgSize,
+	GetPooledTxMsg:     1024 * 1024,
+	PooledTxMsg:        ProtocolMaxMsgSize,
+	TxHashesMsg:        1024 * 1024,
+	PingMsg:            64,
+	PongMsg:            64,
+
+	ValidatorSetMsg:               64 * 1024,
+	PrepareBlockWithValidatorsMsg: ProtocolMaxMsgSize,
+	GetValidatorSetMsg:            64,
+	ValidatorSetReplyMsg:          64 * 1024,
+}
+
+// maxMsgSize returns the size cap of the message with the given code.
+func maxMsgSize(limits map[uint64]uint32, code uint64) uint32 {
+	if limit, ok := limits[code]; ok {
+		return limit
+	}
+	return ProtocolMaxMsgSize
+}
+
 // eth protocol message codes
 const (
 	// Protocol messages belonging to eth/62
@@ -71,6 +111,18 @@ const (
 	GetPooledTxMsg = 0x12
 	PooledTxMsg    = 0x13
 	TxHashesMsg    = 0x14
+
+	// Protocol messages belonging to platone/2
+	// protocol messages for peer latency probing
+	PingMsg = 0x15
+	PongMsg = 0x16
+	// protocol message announcing validator set changes to observers
+	ValidatorSetMsg = 0x17
+	// protocol message proposing a block to observers along with its validators
+	PrepareBlockWithValidatorsMsg = 0x18
+	// protocol messages for observers querying the validator set
+	GetValidatorSetMsg   = 0x19
+	ValidatorSetReplyMsg = 0x1a
 )
 
 type errCode int
@@ -199,6 +251,35 @@ type prepareBlockData struct {
 	Block *types.Block
 }
 
+// prepareBlockWithValidatorsData is the network packet for proposed blocks sent
+// to observer nodes, carrying the validators the proposer must belong to.
+type prepareBlockWithValidatorsData struct {
+	Block      *types.Block
+	Validators []common.Address
+}
+
+// validatorSetData is the network packet announcing the validator set that the
+// block at Number switched to.
+type validatorSetData struct {
+	Number     uint64
+	Validators []common.Address
+}
+
+// Result codes of a validator set reply.
+const (
+	validatorSetOK      = iota // The validators of the requested block follow
+	validatorSetUnknown        // The block is beyond the head of the chain
+	validatorSetPruned         // The block is known but its snapshot can't be resolved
+)
+
+// validatorSetReplyData is the network packet answering a GetValidatorSetMsg
+// with the validator set of the block at Number.
+type validatorSetReplyData struct {
+	Number     uint64
+	Code       uint64
+	Validators []common.Address
+}
+
 type blockSignature struct {
 	SignHash  common.Hash // signature hash，header[0:32]
 	Hash      common.Hash // blokc hash，header[:]
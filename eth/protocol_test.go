@@ -18,6 +18,10 @@ package eth
 
 import (
 	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -27,6 +31,7 @@ import (
 	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/eth/downloader"
 	"github.com/Venachain/Venachain/p2p"
+	"github.com/Venachain/Venachain/p2p/discover"
 	"github.com/Venachain/Venachain/rlp"
 )
 
@@ -45,7 +50,6 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 	var (
 		genesis = pm.blockchain.Genesis()
 		head    = pm.blockchain.CurrentHeader()
-		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
 	)
 	defer pm.Stop()
 
@@ -59,15 +63,15 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 			wantError: errResp(ErrNoStatusMsg, "first msg has code 2 (!= 0)"),
 		},
 		{
-			code: StatusMsg, data: statusData{10, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash()},
+			code: StatusMsg, data: newTestStatus(10, DefaultConfig.NetworkId, head.Number, head.Hash(), genesis.Hash()),
 			wantError: errResp(ErrProtocolVersionMismatch, "10 (!= %d)", protocol),
 		},
 		{
-			code: StatusMsg, data: statusData{uint32(protocol), 999, td, head.Hash(), genesis.Hash()},
+			code: StatusMsg, data: newTestStatus(uint32(protocol), 999, head.Number, head.Hash(), genesis.Hash()),
 			wantError: errResp(ErrNetworkIdMismatch, "999 (!= 1)"),
 		},
 		{
-			code: StatusMsg, data: statusData{uint32(protocol), DefaultConfig.NetworkId, td, head.Hash(), common.Hash{3}},
+			code: StatusMsg, data: newTestStatus(uint32(protocol), DefaultConfig.NetworkId, head.Number, head.Hash(), common.Hash{3}),
 			wantError: errResp(ErrGenesisBlockMismatch, "0300000000000000 (!= %x)", genesis.Hash().Bytes()[:8]),
 		},
 	}
@@ -120,7 +124,7 @@ func testRecvTransactions(t *testing.T, protocol int) {
 	}
 }
 
-// This test checks that pending transactions are sent.
+// This test checks that pending transactions are announced to consensus peers.
 func TestSendTransactions62(t *testing.T) { testSendTransactions(t, 62) }
 func TestSendTransactions63(t *testing.T) { testSendTransactions(t, 63) }
 
@@ -136,7 +140,7 @@ func testSendTransactions(t *testing.T, protocol int) {
 	}
 	pm.txpool.AddRemotes(alltxs)
 
-	// Connect several peers. They should all receive the pending transactions.
+	// Connect several consensus peers. They should all receive the pending transaction hashes.
 	var wg sync.WaitGroup
 	checktxs := func(p *testPeer) {
 		defer wg.Done()
@@ -146,18 +150,24 @@ func testSendTransactions(t *testing.T, protocol int) {
 			seen[tx.Hash()] = false
 		}
 		for n := 0; n < len(alltxs) && !t.Failed(); {
-			var txs []*types.Transaction
+			var hashes []common.Hash
 			msg, err := p.app.ReadMsg()
 			if err != nil {
 				t.Errorf("%v: read error: %v", p.Peer, err)
-			} else if msg.Code != TxMsg {
-				t.Errorf("%v: got code %d, want TxMsg", p.Peer, msg.Code)
+				return
 			}
-			if err := msg.Decode(&txs); err != nil {
+			// Skip the header requests of the chain sync started by the new peer
+			if msg.Code == GetBlockHeadersMsg {
+				msg.Discard()
+				continue
+			}
+			if msg.Code != TxHashesMsg {
+				t.Errorf("%v: got code %d, want TxHashesMsg", p.Peer, msg.Code)
+			}
+			if err := msg.Decode(&hashes); err != nil {
 				t.Errorf("%v: %v", p.Peer, err)
 			}
-			for _, tx := range txs {
-				hash := tx.Hash()
+			for _, hash := range hashes {
 				seentx, want := seen[hash]
 				if seentx {
 					t.Errorf("%v: got tx more than once: %x", p.Peer, hash)
@@ -171,13 +181,190 @@ func testSendTransactions(t *testing.T, protocol int) {
 		}
 	}
 	for i := 0; i < 3; i++ {
-		p, _ := newTestPeer(fmt.Sprintf("peer #%d", i), protocol, pm, true)
+		p, _ := newTestPeerWithTypes(fmt.Sprintf("peer #%d", i), protocol, pm, true, 1)
 		wg.Add(1)
 		go checktxs(p)
 	}
 	wg.Wait()
 }
 
+// Tests that the protocol handler answers latency probes with a matching pong.
+func TestPingReply(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	p, _ := newTestPeer("peer", 63, pm, true)
+	defer p.close()
+
+	if err := p2p.Send(p.app, PingMsg, uint64(42)); err != nil {
+		t.Fatalf("failed to send ping: %v", err)
+	}
+	if err := p2p.ExpectMsg(p.app, PongMsg, uint64(42)); err != nil {
+		t.Errorf("pong mismatch: %v", err)
+	}
+}
+
+// Tests that a latency probe measures the round trip time over a pipe and feeds
+// it into the moving average.
+func TestPingLatency(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(63, p2p.NewPeer(id, "ping", nil), net)
+
+	const delay = 20 * time.Millisecond
+	go func() {
+		// Remote side: answer the ping after a delay
+		msg, err := app.ReadMsg()
+		if err != nil || msg.Code != PingMsg {
+			return
+		}
+		var nonce uint64
+		msg.Decode(&nonce)
+		time.Sleep(delay)
+		p2p.Send(app, PongMsg, nonce)
+	}()
+	go func() {
+		// Local side: deliver the pong like the protocol handler does
+		msg, err := net.ReadMsg()
+		if err != nil || msg.Code != PongMsg {
+			return
+		}
+		var nonce uint64
+		msg.Decode(&nonce)
+		p.deliverPong(nonce)
+	}()
+
+	rtt, err := p.Ping()
+	if err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	if rtt < delay || rtt > pingTimeout {
+		t.Errorf("round trip time out of range: have %v, want >= %v", rtt, delay)
+	}
+	if ewma := p.LatencyEWMA(); ewma != rtt {
+		t.Errorf("latency average mismatch: have %v, want %v", ewma, rtt)
+	}
+}
+
+// Tests that peers of protocol versions predating latency probes are not sent
+// any ping.
+func TestPingUnsupported(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id discover.NodeID
+	rand.Read(id[:])
+	p := newPeer(platoneV1, p2p.NewPeer(id, "ping", nil), net)
+
+	if _, err := p.Ping(); err != errPingUnsupported {
+		t.Fatalf("error mismatch: have %v, want %v", err, errPingUnsupported)
+	}
+	net.Close()
+	if msg, err := app.ReadMsg(); err == nil {
+		t.Errorf("unexpected message sent: code %d", msg.Code)
+	}
+}
+
+// Tests that proposed blocks only carry the validators when sent to observer
+// nodes.
+func TestSendPrepareBlockWithValidators(t *testing.T) {
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)})
+	validators := []common.Address{{0x01}, {0x02}}
+
+	for _, consensus := range []bool{false, true} {
+		app, net := p2p.MsgPipe()
+
+		var id discover.NodeID
+		rand.Read(id[:])
+		p := newPeer(63, p2p.NewPeer(id, "prepare", nil), net)
+		p.setTypes(0)
+		if consensus {
+			p.setTypes(1)
+		}
+		go p.SendPrepareBlockWithValidators(block, validators)
+
+		msg, err := app.ReadMsg()
+		if err != nil {
+			t.Fatalf("consensus %v: failed to read message: %v", consensus, err)
+		}
+		if consensus {
+			if msg.Code != PrepareBlockMsg {
+				t.Errorf("consensus peer: message code mismatch: have %d, want %d", msg.Code, PrepareBlockMsg)
+			}
+		} else {
+			if msg.Code != PrepareBlockWithValidatorsMsg {
+				t.Fatalf("observer peer: message code mismatch: have %d, want %d", msg.Code, PrepareBlockWithValidatorsMsg)
+			}
+			var data prepareBlockWithValidatorsData
+			if err := msg.Decode(&data); err != nil {
+				t.Fatalf("observer peer: failed to decode message: %v", err)
+			}
+			if data.Block.Hash() != block.Hash() {
+				t.Errorf("observer peer: block mismatch: have %x, want %x", data.Block.Hash(), block.Hash())
+			}
+			if !reflect.DeepEqual(data.Validators, validators) {
+				t.Errorf("observer peer: validators mismatch: have %v, want %v", data.Validators, validators)
+			}
+		}
+		msg.Discard()
+		app.Close()
+	}
+}
+
+// oversizedMsgRW is a message reader returning a single message of a given code
+// and size whose payload must never be touched.
+type oversizedMsgRW struct {
+	code uint64
+	size uint32
+	read bool
+}
+
+func (rw *oversizedMsgRW) ReadMsg() (p2p.Msg, error) {
+	return p2p.Msg{Code: rw.code, Size: rw.size, Payload: rw}, nil
+}
+
+func (rw *oversizedMsgRW) WriteMsg(p2p.Msg) error { return nil }
+
+func (rw *oversizedMsgRW) Read(b []byte) (int, error) {
+	rw.read = true
+	return 0, io.EOF
+}
+
+// Tests that messages exceeding their per code size cap are rejected before any
+// of their payload is decoded, for random codes and sizes.
+func TestOversizedMsgRejected(t *testing.T) {
+	pm := &ProtocolManager{maxMsgSizes: DefaultMaxMsgSizeByCode}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for i := 0; i < 1000; i++ {
+		code := uint64(rnd.Intn(int(ProtocolLengths[0]) + 4))
+		limit := maxMsgSize(DefaultMaxMsgSizeByCode, code)
+		rw := &oversizedMsgRW{code: code, size: limit + 1 + uint32(rnd.Intn(1024*1024))}
+
+		var id discover.NodeID
+		rnd.Read(id[:])
+		p := newPeer(63, p2p.NewPeer(id, "oversized", nil), rw)
+		p.maxMsgSizes = pm.maxMsgSizes
+
+		var err error
+		if code == StatusMsg {
+			err = p.readStatus(DefaultConfig.NetworkId, new(statusData), common.Hash{})
+		} else {
+			err = pm.handleMsg(p)
+		}
+		want := errResp(ErrMsgTooLarge, "%v > %v", rw.size, limit)
+		if err == nil || err.Error() != want.Error() {
+			t.Fatalf("code %d, size %d: error mismatch: have %v, want %v", code, rw.size, err, want)
+		}
+		if rw.read {
+			t.Fatalf("code %d, size %d: payload read before rejection", code, rw.size)
+		}
+	}
+}
+
 // Tests that the custom union field encoder and decoder works correctly.
 func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 	// Create a "random" hash for testing
@@ -221,3 +408,49 @@ func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+// Tests that a peer answering the status exchange only after a timeout gets
+// our status again and completes the handshake on the second attempt.
+func TestHandshakeRetry(t *testing.T) {
+	genesis := common.Hash{0x01}
+	theirs := &statusData{
+		ProtocolVersion:       63,
+		NetworkId:             DefaultConfig.NetworkId,
+		BN:                    big.NewInt(5),
+		CurrentBlock:          common.Hash{0x05},
+		GenesisBlock:          genesis,
+		ReplayOldSysContracts: []byte("{}"),
+	}
+	for _, retry := range []bool{false, true} {
+		app, net := p2p.MsgPipe()
+
+		var id discover.NodeID
+		rand.Read(id[:])
+		p := newPeer(63, p2p.NewPeer(id, "slow", nil), net)
+		p.handshakeTimeout = 100 * time.Millisecond
+		p.handshakeRetry = retry
+
+		// The slow peer only answers our second status
+		go func() {
+			for i := 0; i < 2; i++ {
+				msg, err := app.ReadMsg()
+				if err != nil {
+					return
+				}
+				msg.Discard()
+			}
+			p2p.Send(app, StatusMsg, theirs)
+		}()
+		err := p.Handshake(DefaultConfig.NetworkId, big.NewInt(1), common.Hash{0x02}, genesis)
+		if !retry {
+			if err != p2p.DiscReadTimeout {
+				t.Errorf("without retry: error mismatch: have %v, want %v", err, p2p.DiscReadTimeout)
+			}
+		} else if err != nil {
+			t.Errorf("with retry: handshake failed: %v", err)
+		} else if p.head != theirs.CurrentBlock || p.bn.Cmp(theirs.BN) != 0 {
+			t.Errorf("with retry: head mismatch: have %x/%v, want %x/%v", p.head, p.bn, theirs.CurrentBlock, theirs.BN)
+		}
+		app.Close()
+	}
+}
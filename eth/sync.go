@@ -167,11 +167,11 @@ func (pm *ProtocolManager) syncer() {
 			if pm.peers.Len() < minDesiredPeerCount {
 				break
 			}
-			go pm.synchronise(pm.peers.BestPeer())
+			go pm.synchronise(pm.peers.BestCompatiblePeer(localReplayParam()))
 
 		case <-forceSync.C:
 			// Force a sync even if not enough peers are present
-			go pm.synchronise(pm.peers.BestPeer())
+			go pm.synchronise(pm.peers.BestCompatiblePeer(localReplayParam()))
 
 		case <-pm.noMorePeers:
 			return
@@ -196,7 +196,7 @@ func (pm *ProtocolManager) isUnNormalBootNodesAtPeer(peer *peer) bool {
 	if p2p.BootNodesNotExempt {
 		return false
 	}
-	if key := pm.peers.Peer(peer.id).Peer.Info().ID; p2p.IsNodeInBootNodes(key) &&
+	if key := peer.ID().String(); p2p.IsNodeInBootNodes(key) &&
 		peer.bn.Uint64() <= pm.blockchain.CurrentBlock().NumberU64() && !common.SysCfg.IsValidJoinNode(key) {
 		p2p.BootNodesNotExempt = true
 		return true
@@ -204,12 +204,25 @@ func (pm *ProtocolManager) isUnNormalBootNodesAtPeer(peer *peer) bool {
 	return false
 }
 
+// localReplayParam returns the replay parameters of the local node.
+func localReplayParam() common.ReplayParam {
+	if common.SysCfg.ReplayParam == nil {
+		return common.ReplayParam{}
+	}
+	return *common.SysCfg.ReplayParam
+}
+
 // synchronise tries to sync up our local block chain with a remote peer.
 func (pm *ProtocolManager) synchronise(peer *peer) {
 	// Short circuit if no peers are available
 	if peer == nil {
 		return
 	}
+	// Never sync from a peer replaying a different history
+	if !peer.ReplayParamCompatible(localReplayParam()) {
+		log.Debug("Skipping sync from peer with incompatible replay parameters", "peer", peer.id)
+		return
+	}
 	// Make sure the peer's TD is higher than our own
 	currentBlock := pm.blockchain.CurrentBlock()
 	if pm.isUnNormalBootNodes() {
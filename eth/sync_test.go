@@ -17,10 +17,12 @@
 package eth
 
 import (
+	"math/big"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/eth/downloader"
 	"github.com/Venachain/Venachain/p2p"
 	"github.com/Venachain/Venachain/p2p/discover"
@@ -53,3 +55,99 @@ func TestFastSyncDisabling(t *testing.T) {
 		t.Fatalf("fast sync not disabled after successful synchronisation")
 	}
 }
+
+// Tests that sync peer selection skips peers whose replay parameters differ from
+// the local ones, even if they advertise a higher head.
+func TestSyncPeerReplayParamCompatibility(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	local := common.ReplayParam{
+		Pivot:           100,
+		OldSysContracts: map[common.Address]string{common.HexToAddress("0x01"): "__sys_UserManager"},
+		OldSuperAdmin:   common.HexToAddress("0xaa"),
+	}
+	otherContracts := common.ReplayParam{
+		Pivot:           local.Pivot,
+		OldSysContracts: map[common.Address]string{common.HexToAddress("0x01"): "__sys_NodeManager"},
+		OldSuperAdmin:   local.OldSuperAdmin,
+	}
+	otherPivot := local
+	otherPivot.Pivot = 200
+
+	peers := []struct {
+		name   string
+		param  common.ReplayParam
+		number int64
+		ok     bool
+	}{
+		{"compatible-low", local, 10, true},
+		{"compatible-high", local, 20, true},
+		{"other-pivot", otherPivot, 30, false},
+		{"other-contracts", otherContracts, 40, false},
+		{"other-admin", common.ReplayParam{Pivot: local.Pivot, OldSysContracts: local.OldSysContracts}, 50, false},
+	}
+	tps := make([]*testPeer, len(peers))
+	for i, tt := range peers {
+		tps[i], _ = newTestPeer(tt.name, 63, pm, true)
+		defer tps[i].close()
+	}
+	// Wait for the peers to get registered, their handshakes done
+	for i := 0; i < 100 && pm.peers.Len() < len(peers); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i, tt := range peers {
+		p := tps[i]
+		p.SetReplayParam(tt.param)
+		p.SetHead(common.Hash{}, big.NewInt(tt.number))
+		if compatible := p.ReplayParamCompatible(local); compatible != tt.ok {
+			t.Errorf("peer %s: compatibility mismatch: have %v, want %v", tt.name, compatible, tt.ok)
+		}
+	}
+	best := pm.peers.BestCompatiblePeer(local)
+	if best == nil {
+		t.Fatalf("no compatible sync peer found")
+	}
+	if _, bn := best.Head(); bn.Int64() != 20 {
+		t.Errorf("sync peer mismatch: have head %d, want %d", bn, 20)
+	}
+	if best := pm.peers.BestCompatiblePeer(common.ReplayParam{Pivot: 1}); best != nil {
+		t.Errorf("unexpected sync peer %s for unmatched replay params", best.id)
+	}
+}
+
+// Tests that synchronising with a peer replaying a different history is
+// refused, even when the peer is handed over directly.
+func TestSynchroniseReplayParamIncompatible(t *testing.T) {
+	pmEmpty, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pmEmpty.Stop()
+	pmFull, _ := newTestProtocolManagerMust(t, downloader.FullSync, 16, nil, nil)
+	defer pmFull.Stop()
+
+	io1, io2 := p2p.MsgPipe()
+
+	go pmFull.handle(pmFull.newPeer(63, p2p.NewPeer(discover.NodeID{}, "empty", nil), io2))
+	go pmEmpty.handle(pmEmpty.newPeer(63, p2p.NewPeer(discover.NodeID{}, "full", nil), io1))
+
+	for i := 0; i < 100 && pmEmpty.peers.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	peer := pmEmpty.peers.BestPeer()
+	if peer == nil {
+		t.Fatalf("peer not registered")
+	}
+	other := localReplayParam()
+	other.Pivot++
+	peer.SetReplayParam(other)
+
+	pmEmpty.synchronise(peer)
+	if head := pmEmpty.blockchain.CurrentBlock().NumberU64(); head != 0 {
+		t.Fatalf("synchronised with incompatible peer: have head %d, want %d", head, 0)
+	}
+	peer.SetReplayParam(localReplayParam())
+
+	pmEmpty.synchronise(peer)
+	if head := pmEmpty.blockchain.CurrentBlock().NumberU64(); head != 16 {
+		t.Fatalf("compatible peer not synchronised: have head %d, want %d", head, 16)
+	}
+}
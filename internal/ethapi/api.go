@@ -35,6 +35,7 @@ import (
 	"github.com/Venachain/Venachain/common/math"
 	"github.com/Venachain/Venachain/core"
 	"github.com/Venachain/Venachain/core/rawdb"
+	"github.com/Venachain/Venachain/core/state"
 	"github.com/Venachain/Venachain/core/types"
 	"github.com/Venachain/Venachain/core/vm"
 	"github.com/Venachain/Venachain/crypto"
@@ -582,6 +583,63 @@ func (s *PublicBlockChainAPI) GetAccountBaseInfo(ctx context.Context, address co
 	return acc, state.Error()
 }
 
+// maxEnumerateAccounts is the maximum number of accounts returned by a single
+// EnumerateAccounts call.
+const maxEnumerateAccounts = 1000
+
+// EnumeratedAccount is an account returned by EnumerateAccounts.
+type EnumeratedAccount struct {
+	Address     common.Address `json:"address"`
+	Balance     *hexutil.Big   `json:"balance"`
+	Nonce       hexutil.Uint64 `json:"nonce"`
+	CodeHash    common.Hash    `json:"codeHash"`
+	StorageRoot common.Hash    `json:"storageRoot"`
+}
+
+// AccountPage is a page of accounts returned by EnumerateAccounts. Next is the
+// afterAddress of the following page, nil on the last page.
+type AccountPage struct {
+	Accounts []EnumeratedAccount `json:"accounts"`
+	Next     *common.Address     `json:"next"`
+}
+
+// EnumerateAccounts returns up to maxCount accounts of the state at the given
+// block, in the order of their address hashes. Passing the Next address of a
+// page as afterAddress returns the following page.
+func (s *PublicBlockChainAPI) EnumerateAccounts(ctx context.Context, blockNr rpc.BlockNumber, maxCount int, afterAddress *common.Address) (*AccountPage, error) {
+	if maxCount <= 0 || maxCount > maxEnumerateAccounts {
+		maxCount = maxEnumerateAccounts
+	}
+	statedb, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	var start common.Hash
+	if afterAddress != nil {
+		start = crypto.Keccak256Hash(afterAddress[:])
+	}
+	page := &AccountPage{Accounts: make([]EnumeratedAccount, 0)}
+	statedb.ForEachAccountFrom(start, func(addr common.Address, acc state.AccountInfo) bool {
+		if afterAddress != nil && addr == *afterAddress {
+			return true
+		}
+		if len(page.Accounts) == maxCount {
+			next := page.Accounts[maxCount-1].Address
+			page.Next = &next
+			return false
+		}
+		page.Accounts = append(page.Accounts, EnumeratedAccount{
+			Address:     addr,
+			Balance:     (*hexutil.Big)(acc.Balance),
+			Nonce:       hexutil.Uint64(acc.Nonce),
+			CodeHash:    acc.CodeHash,
+			StorageRoot: acc.StorageRoot,
+		})
+		return true
+	})
+	return page, statedb.Error()
+}
+
 // GetBlockByNumber returns the requested block. When blockNr is -1 the chain head is returned. When fullTx is true all
 // transactions in the block are returned in full detail, otherwise only the transaction hash is returned.
 func (s *PublicBlockChainAPI) GetBlockByNumber(ctx context.Context, blockNr rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
@@ -170,6 +170,10 @@ web3._extend({
 			name: 'stopWS',
 			call: 'admin_stopWS'
 		}),
+		new web3._extend.Method({
+			name: 'getPeerQueueStats',
+			call: 'admin_getPeerQueueStats'
+		}),
 	],
 	properties: [
 		new web3._extend.Property({
@@ -396,6 +400,17 @@ web3._extend({
 			params: 2,
 			inputFormatter:[null, null],
 		}),
+		new web3._extend.Method({
+			name: 'replayBlock',
+			call: 'debug_replayBlock',
+			params: 1,
+		}),
+		new web3._extend.Method({
+			name: 'computeStateRoot',
+			call: 'debug_computeStateRoot',
+			params: 2,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter],
+		}),
 	],
 	properties: []
 });
@@ -445,6 +460,12 @@ web3._extend({
 			call: 'eth_getRawTransactionByHash',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'enumerateAccounts',
+			call: 'eth_enumerateAccounts',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter, null, null]
+		}),
 		new web3._extend.Method({
 			name: 'getRawTransactionFromBlock',
 			call: function(args) {
@@ -511,6 +532,30 @@ web3._extend({
 			name: 'getHashrate',
 			call: 'miner_getHashrate'
 		}),
+		new web3._extend.Method({
+			name: 'health',
+			call: 'miner_health'
+		}),
+		new web3._extend.Method({
+			name: 'droppedTransactions',
+			call: 'miner_droppedTransactions'
+		}),
+		new web3._extend.Method({
+			name: 'gasGriefingSenders',
+			call: 'miner_gasGriefingSenders'
+		}),
+		new web3._extend.Method({
+			name: 'pendingTxOrder',
+			call: 'miner_pendingTxOrder'
+		}),
+		new web3._extend.Method({
+			name: 'pauseProposing',
+			call: 'miner_pauseProposing'
+		}),
+		new web3._extend.Method({
+			name: 'resumeProposing',
+			call: 'miner_resumeProposing'
+		}),
 	],
 	properties: []
 });
@@ -673,6 +718,42 @@ web3._extend({
 			call: 'istanbul_candidates',
 			params: 0
 		}),
+		new web3._extend.Method({
+			name: 'getProposerForBlock',
+			call: 'istanbul_getProposerForBlock',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getValidatorChanges',
+			call: 'istanbul_getValidatorChanges',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'validatorParticipation',
+			call: 'istanbul_validatorParticipation',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'roundChangeStats',
+			call: 'istanbul_roundChangeStats',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getPendingRound',
+			call: 'istanbul_getPendingRound',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'committedSealCount',
+			call: 'istanbul_committedSealCount',
+			params: 1,
+			inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
+		}),
+		new web3._extend.Method({
+			name: 'purgeSnapshotCache',
+			call: 'istanbul_purgeSnapshotCache',
+			params: 0
+		}),
 	],
 	properties:
 	[]
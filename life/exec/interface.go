@@ -23,6 +23,8 @@ type StateDB interface {
 	AddLog(address common.Address, topics []common.Hash, data []byte, bn uint64)
 	SetState(key []byte, value []byte)
 	GetState(key []byte) []byte
+	DebugEnabled() bool
+	DebugLog(level uint32, msg []byte)
 
 	GetCallerNonce() int64
 	Transfer(addr common.Address, value *big.Int) (ret []byte, leftOverGas uint64, err error)
@@ -96,6 +96,7 @@ type VMConfig struct {
 	DefaultTableSize   int
 	GasLimit           uint64
 	DisableFree        bool
+	Debug              bool // Enables the host functions only used for debugging, e.g. debug_log
 }
 
 type VMContext struct {
@@ -184,6 +184,7 @@ func newCfcSet() map[string]map[string]*exec.FunctionImport {
 			"getState":     &exec.FunctionImport{Execute: envGetState, GasCost: envGetStateGasCost},
 			"getStateSize": &exec.FunctionImport{Execute: envGetStateSize, GasCost: envGetStateSizeGasCost},
 			"ecrecover":    &exec.FunctionImport{Execute: envEcrecover, GasCost: envEcrecoverGasCost},
+			"debug_log":    &exec.FunctionImport{Execute: envDebugLog, GasCost: envDebugLogGasCost},
 
 			// support for vc
 			//Temporarily comment the following code to prepare for cross platform
@@ -1121,6 +1122,28 @@ func envEmitEventGasCost(vm *exec.VirtualMachine) (uint64, error) {
 	return 300000, nil
 }
 
+//void debug_log(uint32_t level, const char *msg, size_t msgLen);
+func envDebugLog(vm *exec.VirtualMachine) int64 {
+	if !vm.Context.Config.Debug || !vm.Context.StateDB.DebugEnabled() {
+		return 0
+	}
+	level := uint32(vm.GetCurrentFrame().Locals[0])
+	msg := int(int32(vm.GetCurrentFrame().Locals[1]))
+	msgLen := int(int32(vm.GetCurrentFrame().Locals[2]))
+	if msg < 0 || msgLen < 0 || msg+msgLen > len(vm.Memory.Memory) {
+		return 0
+	}
+
+	m := make([]byte, msgLen)
+	copy(m, vm.Memory.Memory[msg:msg+msgLen])
+	vm.Context.StateDB.DebugLog(level, m)
+	return 0
+}
+
+func envDebugLogGasCost(vm *exec.VirtualMachine) (uint64, error) {
+	return 1000, nil
+}
+
 func envSetState(vm *exec.VirtualMachine) int64 {
 	key := int(int32(vm.GetCurrentFrame().Locals[0]))
 	keyLen := int(int32(vm.GetCurrentFrame().Locals[1]))
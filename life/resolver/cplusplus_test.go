@@ -3,6 +3,8 @@ package resolver
 import (
 	"fmt"
 	"testing"
+
+	"github.com/Venachain/Venachain/life/exec"
 )
 
 func TestCfcSet(t *testing.T) {
@@ -14,3 +16,55 @@ func TestCfcSet(t *testing.T) {
 		}
 	}
 }
+
+type debugStateDB struct {
+	exec.StateDB
+	enabled bool
+	levels  []uint32
+	msgs    []string
+}
+
+func (db *debugStateDB) DebugEnabled() bool { return db.enabled }
+func (db *debugStateDB) DebugLog(level uint32, msg []byte) {
+	db.levels = append(db.levels, level)
+	db.msgs = append(db.msgs, string(msg))
+}
+
+func TestEnvDebugLog(t *testing.T) {
+	db := &debugStateDB{}
+	mem := make([]byte, 64)
+	copy(mem[16:], "hello")
+	vm := &exec.VirtualMachine{
+		Context:   &exec.VMContext{StateDB: db},
+		CallStack: []exec.Frame{{Locals: []int64{2, 16, 5}}},
+		Memory:    &exec.Memory{Memory: mem},
+	}
+
+	// Debug disabled, the call must neither allocate nor reach the state db
+	if allocs := testing.AllocsPerRun(100, func() { envDebugLog(vm) }); allocs != 0 {
+		t.Errorf("disabled debug_log allocates: %v", allocs)
+	}
+	if len(db.msgs) != 0 {
+		t.Fatalf("expected no logs, got %d", len(db.msgs))
+	}
+
+	// A tracer without the VM debug flag does not enable it either
+	db.enabled = true
+	envDebugLog(vm)
+	if len(db.msgs) != 0 {
+		t.Fatalf("expected no logs without the debug flag, got %d", len(db.msgs))
+	}
+
+	vm.Context.Config.Debug = true
+	envDebugLog(vm)
+	if len(db.msgs) != 1 || db.levels[0] != 2 || db.msgs[0] != "hello" {
+		t.Errorf("unexpected logs: levels %v, msgs %v", db.levels, db.msgs)
+	}
+
+	// Messages outside the memory are ignored
+	vm.CallStack[0].Locals = []int64{2, 60, 5}
+	envDebugLog(vm)
+	if len(db.msgs) != 1 {
+		t.Errorf("out of range message logged: %v", db.msgs)
+	}
+}
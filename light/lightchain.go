@@ -438,6 +438,12 @@ func (self *LightChain) GetHeaderByNumber(number uint64) *types.Header {
 	return self.hc.GetHeaderByNumber(number)
 }
 
+// GetHeaderRangeByNumber retrieves the canonical headers numbered from to to,
+// both inclusive, in a single database scan, caching them if found.
+func (self *LightChain) GetHeaderRangeByNumber(from, to uint64) []*types.Header {
+	return self.hc.GetHeaderRangeByNumber(from, to)
+}
+
 // GetHeaderByNumberOdr retrieves a block header from the database or network
 // by number, caching it (associated with its hash) if found.
 func (self *LightChain) GetHeaderByNumberOdr(ctx context.Context, number uint64) (*types.Header, error) {
@@ -119,6 +119,16 @@ func (t *odrTrie) TryGet(key []byte) ([]byte, error) {
 	return res, err
 }
 
+func (t *odrTrie) TryHas(key []byte) (bool, error) {
+	key = crypto.Keccak256(key)
+	var ok bool
+	err := t.do(key, func() (err error) {
+		ok, err = t.trie.TryHas(key)
+		return err
+	})
+	return ok, err
+}
+
 func (t *odrTrie) TryUpdate(key, value []byte) error {
 	key = crypto.Keccak256(key)
 	return t.do(key, func() error {
@@ -0,0 +1,97 @@
+package miner
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// fullnessSamples is the number of recent blocks the average block fullness
+	// is taken over.
+	fullnessSamples = 32
+
+	// fullnessHigh is the average block fullness above which blocks are deemed
+	// consistently full and the recommit interval is shortened.
+	fullnessHigh = 0.9
+
+	// fullnessLow is the average block fullness below which blocks are deemed
+	// consistently empty and the recommit interval is lengthened.
+	fullnessLow = 0.1
+
+	// fullnessBias is the fraction by which the recommit interval is shortened
+	// or lengthened for consistently full or empty blocks.
+	fullnessBias = 0.25
+)
+
+// fullnessSample is a fixed size window over the fullness, the ratio of gas
+// used to gas limit, of the most recent blocks.
+type fullnessSample struct {
+	values []float64 // Fullness of the blocks, used as a ring once full
+	next   int       // Slot overwritten by the next sample once full
+	lock   sync.Mutex
+}
+
+// newFullnessSample creates a fullness sample keeping the last size values.
+func newFullnessSample(size int) *fullnessSample {
+	return &fullnessSample{values: make([]float64, 0, size)}
+}
+
+// Add records the fullness of a block, evicting the oldest one if the window
+// is full.
+func (s *fullnessSample) Add(gasUsed, gasLimit uint64) {
+	if gasLimit == 0 {
+		return
+	}
+	fullness := float64(gasUsed) / float64(gasLimit)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.values) < cap(s.values) {
+		s.values = append(s.values, fullness)
+		return
+	}
+	s.values[s.next] = fullness
+	s.next = (s.next + 1) % len(s.values)
+}
+
+// Average returns the average fullness of the recorded blocks and how many
+// there are.
+func (s *fullnessSample) Average() (float64, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range s.values {
+		sum += v
+	}
+	return sum / float64(len(s.values)), len(s.values)
+}
+
+// biasRecommit shortens the recommit interval when the recent blocks were
+// consistently full, to pull in transactions sooner, and lengthens it when they
+// were consistently empty. No bias is applied until half the window is filled.
+// Like transaction bursts, the bias may resubmit sooner than the configured
+// interval, but never under minRecommitInterval or over maxRecommitInterval.
+func biasRecommit(recommit time.Duration, fullness float64, samples int) time.Duration {
+	if samples < fullnessSamples/2 {
+		return recommit
+	}
+	biased := recommit
+	switch {
+	case fullness >= fullnessHigh:
+		biased = time.Duration(float64(recommit) * (1 - fullnessBias))
+		if biased < minRecommitInterval {
+			biased = minRecommitInterval
+		}
+	case fullness <= fullnessLow:
+		biased = time.Duration(float64(recommit) * (1 + fullnessBias))
+		if biased > maxRecommitInterval {
+			biased = maxRecommitInterval
+		}
+	}
+	return biased
+}
@@ -0,0 +1,51 @@
+package miner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFullnessSampleAverage(t *testing.T) {
+	s := newFullnessSample(4)
+	if avg, n := s.Average(); avg != 0 || n != 0 {
+		t.Errorf("empty sample mismatch: have %v over %d, want 0 over 0", avg, n)
+	}
+	s.Add(100, 100)
+	s.Add(0, 100)
+	s.Add(50, 0) // ignored, no gas limit
+	if avg, n := s.Average(); avg != 0.5 || n != 2 {
+		t.Errorf("average mismatch: have %v over %d, want 0.5 over 2", avg, n)
+	}
+	// Once full, new blocks replace the oldest ones
+	for i := 0; i < 4; i++ {
+		s.Add(25, 100)
+	}
+	if avg, n := s.Average(); avg != 0.25 || n != 4 {
+		t.Errorf("window average mismatch: have %v over %d, want 0.25 over 4", avg, n)
+	}
+}
+
+func TestBiasRecommit(t *testing.T) {
+	tests := []struct {
+		recommit time.Duration
+		fullness float64
+		samples  int
+		want     time.Duration
+	}{
+		// Too few blocks observed
+		{4 * time.Second, 1, fullnessSamples/2 - 1, 4 * time.Second},
+		// Consistently full blocks shorten the interval
+		{4 * time.Second, 0.95, fullnessSamples, 3 * time.Second},
+		{minRecommitInterval, 1, fullnessSamples, minRecommitInterval},
+		// Consistently empty blocks lengthen it
+		{4 * time.Second, 0.05, fullnessSamples, 5 * time.Second},
+		{maxRecommitInterval, 0, fullnessSamples, maxRecommitInterval},
+		// Anything in between leaves it alone
+		{4 * time.Second, 0.5, fullnessSamples, 4 * time.Second},
+	}
+	for i, tt := range tests {
+		if have := biasRecommit(tt.recommit, tt.fullness, tt.samples); have != tt.want {
+			t.Errorf("test %d: interval mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package miner
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/Venachain/Venachain/common"
+)
+
+// gasGriefThreshold is the number of transactions of a sender that may exceed
+// the gas left in the block being assembled before the rest of the sender's
+// transactions are skipped for that block.
+const gasGriefThreshold = 3
+
+// gasGriefing counts, per sender, the transactions that did not fit into the
+// remaining gas of the block being assembled. The counts are kept across the
+// recommits of the same block, as a sender is skipped for the rest of a single
+// commit after its first such transaction anyway.
+type gasGriefing struct {
+	number uint64                 // Number of the block the hits are counted for
+	hits   map[common.Address]int // Transactions exceeding the remaining gas per sender
+	lock   sync.RWMutex
+}
+
+// newGasGriefing creates an empty griefing tracker.
+func newGasGriefing() *gasGriefing {
+	return &gasGriefing{hits: make(map[common.Address]int)}
+}
+
+// reset starts counting for the block with the given number, forgetting the
+// hits of any other block.
+func (g *gasGriefing) reset(number uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.number != number {
+		g.number = number
+		g.hits = make(map[common.Address]int)
+	}
+}
+
+// hit records a transaction of sender exceeding the remaining gas and reports
+// whether the sender is now deemed to be griefing.
+func (g *gasGriefing) hit(sender common.Address) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.hits[sender]++
+	return g.hits[sender] >= gasGriefThreshold
+}
+
+// griefing reports whether the transactions of sender are skipped for the rest
+// of the block.
+func (g *gasGriefing) griefing(sender common.Address) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	return g.hits[sender] >= gasGriefThreshold
+}
+
+// Senders returns the senders whose transactions are skipped for the block
+// assembled last, in address order.
+func (g *gasGriefing) Senders() []common.Address {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	var senders []common.Address
+	for sender, hits := range g.hits {
+		if hits >= gasGriefThreshold {
+			senders = append(senders, sender)
+		}
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		return bytes.Compare(senders[i][:], senders[j][:]) < 0
+	})
+	return senders
+}
@@ -0,0 +1,79 @@
+package miner
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeatInterval is how often each worker goroutine reports it is alive.
+	heartbeatInterval = time.Second
+
+	// missedHeartbeats is the number of consecutive heartbeats a goroutine may
+	// miss before it is reported dead.
+	missedHeartbeats = 3
+)
+
+// Names of the worker goroutines reported by the health check.
+const (
+	mainLoopName    = "mainLoop"
+	newWorkLoopName = "newWorkLoop"
+	resultLoopName  = "resultLoop"
+	taskLoopName    = "taskLoop"
+)
+
+// heartbeats tracks the liveness of a fixed set of goroutines, each sending its
+// heartbeats on a dedicated channel.
+type heartbeats struct {
+	chans map[string]chan time.Time // Latest unread heartbeat of every goroutine
+	last  map[string]time.Time      // Latest heartbeat read of every goroutine
+	lock  sync.Mutex                // Protects last
+}
+
+// newHeartbeats creates a tracker for the named goroutines, counting them alive
+// from now on.
+func newHeartbeats(names ...string) *heartbeats {
+	h := &heartbeats{
+		chans: make(map[string]chan time.Time, len(names)),
+		last:  make(map[string]time.Time, len(names)),
+	}
+	now := time.Now()
+	for _, name := range names {
+		h.chans[name] = make(chan time.Time, 1)
+		h.last[name] = now
+	}
+	return h
+}
+
+// beat reports the goroutine name alive at now. It never blocks, an unread
+// heartbeat is replaced by the new one.
+func (h *heartbeats) beat(name string, now time.Time) {
+	ch := h.chans[name]
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- now:
+	default:
+	}
+}
+
+// check returns whether each goroutine sent a heartbeat recently enough at now.
+func (h *heartbeats) check(now time.Time) map[string]bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	alive := make(map[string]bool, len(h.chans))
+	for name, ch := range h.chans {
+		select {
+		case beat := <-ch:
+			if beat.After(h.last[name]) {
+				h.last[name] = beat
+			}
+		default:
+		}
+		alive[name] = now.Sub(h.last[name]) <= missedHeartbeats*heartbeatInterval
+	}
+	return alive
+}
@@ -0,0 +1,30 @@
+package miner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeats(t *testing.T) {
+	h := newHeartbeats(mainLoopName, taskLoopName)
+	start := time.Now()
+
+	// Both goroutines beat for a while, then the task loop stops. None of the
+	// heartbeats is read, only the latest ones must count.
+	for i := 1; i <= 5; i++ {
+		now := start.Add(time.Duration(i) * heartbeatInterval)
+		h.beat(mainLoopName, now)
+		if i <= 2 {
+			h.beat(taskLoopName, now)
+		}
+	}
+	// Three missed heartbeats are tolerated, not a fourth one
+	alive := h.check(start.Add(5 * heartbeatInterval))
+	if !alive[mainLoopName] || !alive[taskLoopName] {
+		t.Errorf("liveness mismatch after 3 missed beats: have %v, want both alive", alive)
+	}
+	alive = h.check(start.Add(6 * heartbeatInterval))
+	if !alive[mainLoopName] || alive[taskLoopName] {
+		t.Errorf("liveness mismatch after 4 missed beats: have %v, want only %s alive", alive, mainLoopName)
+	}
+}
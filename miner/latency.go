@@ -0,0 +1,53 @@
+package miner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Venachain/Venachain/metrics"
+)
+
+// inclusionLatencySamples is the number of recent transaction inclusion
+// latencies the worker keeps for its percentile summary.
+const inclusionLatencySamples = 4096
+
+// latencySample is a fixed size window over the most recent latencies.
+type latencySample struct {
+	values []int64 // Latencies in nanoseconds, used as a ring once full
+	next   int     // Slot overwritten by the next sample once full
+	lock   sync.Mutex
+}
+
+// newLatencySample creates a latency sample keeping the last size values.
+func newLatencySample(size int) *latencySample {
+	return &latencySample{values: make([]int64, 0, size)}
+}
+
+// Add records a latency, evicting the oldest one if the window is full.
+func (s *latencySample) Add(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.values) < cap(s.values) {
+		s.values = append(s.values, int64(d))
+		return
+	}
+	s.values[s.next] = int64(d)
+	s.next = (s.next + 1) % len(s.values)
+}
+
+// Percentiles returns the requested percentiles of the recorded latencies, or
+// zeros if nothing was recorded yet.
+func (s *latencySample) Percentiles(ps ...float64) []time.Duration {
+	s.lock.Lock()
+	values := make([]int64, len(s.values))
+	copy(values, s.values)
+	s.lock.Unlock()
+
+	scores := metrics.SamplePercentiles(values, ps)
+	res := make([]time.Duration, len(scores))
+	for i, score := range scores {
+		res[i] = time.Duration(score)
+	}
+	return res
+}
@@ -0,0 +1,31 @@
+package miner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencySamplePercentiles(t *testing.T) {
+	s := newLatencySample(100)
+	if ps := s.Percentiles(0.5); ps[0] != 0 {
+		t.Errorf("empty sample percentile mismatch: have %v, want 0", ps[0])
+	}
+	for i := 1; i <= 100; i++ {
+		s.Add(time.Duration(i) * time.Millisecond)
+	}
+	ps := s.Percentiles(0.5, 0.99)
+	if ps[0] < 50*time.Millisecond || ps[0] > 51*time.Millisecond {
+		t.Errorf("p50 mismatch: have %v, want ~50ms", ps[0])
+	}
+	if ps[1] < 99*time.Millisecond {
+		t.Errorf("p99 mismatch: have %v, want ~100ms", ps[1])
+	}
+
+	// Once full, new samples replace the oldest ones
+	for i := 0; i < 100; i++ {
+		s.Add(time.Second)
+	}
+	if ps := s.Percentiles(0.01); ps[0] != time.Second {
+		t.Errorf("old samples not evicted: p1 %v, want %v", ps[0], time.Second)
+	}
+}
@@ -18,7 +18,9 @@
 package miner
 
 import (
+	"context"
 	"fmt"
+	"math/big"
 	"sync/atomic"
 	"time"
 
@@ -54,13 +56,13 @@ type Miner struct {
 	shouldStart int32 // should start indicates whether we should start after sync
 }
 
-func New(eth Backend, config *params.ChainConfig, mux *event.TypeMux, engine consensus.Engine, recommit time.Duration, gasFloor, gasCeil uint64, isLocalBlock func(block *types.Block) bool, highestLogicalBlockCh chan *types.Block, blockChainCache *core.BlockChainCache) *Miner {
+func New(eth Backend, config *params.ChainConfig, mux *event.TypeMux, engine consensus.Engine, recommit time.Duration, commitRatio float64, gasFloor, gasCeil uint64, isLocalBlock func(block *types.Block) bool, highestLogicalBlockCh chan *types.Block, blockChainCache *core.BlockChainCache) *Miner {
 	miner := &Miner{
 		eth:      eth,
 		mux:      mux,
 		engine:   engine,
 		exitCh:   make(chan struct{}),
-		worker:   newWorker(config, engine, eth, mux, recommit, gasFloor, gasCeil, isLocalBlock, highestLogicalBlockCh, blockChainCache),
+		worker:   newWorker(config, engine, eth, mux, recommit, commitRatio, gasFloor, gasCeil, isLocalBlock, highestLogicalBlockCh, blockChainCache),
 		canStart: 1,
 	}
 	go miner.update()
@@ -136,8 +138,18 @@ func (self *Miner) SetExtra(extra []byte) error {
 	if uint64(len(extra)) > params.MaximumExtraDataSize {
 		return fmt.Errorf("Extra exceeds max length. %d > %v", len(extra), params.MaximumExtraDataSize)
 	}
-	self.worker.setExtra(extra)
-	return nil
+	return self.worker.setExtra(extra)
+}
+
+// PauseProposing stops proposing new blocks while still taking part in the
+// consensus on the blocks of the other validators.
+func (self *Miner) PauseProposing() {
+	self.worker.PauseProposing()
+}
+
+// ResumeProposing restarts proposing new blocks after PauseProposing.
+func (self *Miner) ResumeProposing() {
+	self.worker.ResumeProposing()
 }
 
 // SetRecommitInterval sets the interval for sealing work resubmitting.
@@ -159,7 +171,181 @@ func (self *Miner) PendingBlock() *types.Block {
 	return self.worker.pendingBlock()
 }
 
+// SetGasLimitOverride pins the gas limit of new blocks, 0 restores the dynamic
+// calculation.
+func (self *Miner) SetGasLimitOverride(limit uint64) {
+	self.worker.SetGasLimitOverride(limit)
+}
+
+// SetMinGasPrice sets the lowest gas price of the transactions included in new
+// blocks, nil includes any.
+func (self *Miner) SetMinGasPrice(price *big.Int) {
+	self.worker.SetMinGasPrice(price)
+}
+
+// SetEmptyBlockDelay sets how long no transaction must have arrived before an
+// empty block is produced, 0 produces them right away.
+func (self *Miner) SetEmptyBlockDelay(delay time.Duration) {
+	self.worker.SetEmptyBlockDelay(delay)
+}
+
+// SetStateBatchSize sets the number of sealed blocks whose states are flushed to
+// disk together, 1 flushes the state of every block.
+func (self *Miner) SetStateBatchSize(size int) {
+	self.worker.SetStateBatchSize(size)
+}
+
+// SetPendingFetchLimit sets the maximum number of pending transactions fetched
+// for each new block, 0 fetches as many as the pool hands out.
+func (self *Miner) SetPendingFetchLimit(n int) {
+	self.worker.SetPendingFetchLimit(n)
+}
+
+// SetTxBatchSize sets the number of transactions whose accounts are loaded
+// together ahead of their execution, 1 loads each account when needed.
+func (self *Miner) SetTxBatchSize(size int) {
+	self.worker.SetTxBatchSize(size)
+}
+
+// SetMaxTxsPerAccount sets the maximum number of transactions of a single
+// sender included in a block, 0 for no limit.
+func (self *Miner) SetMaxTxsPerAccount(n int) {
+	self.worker.SetMaxTxsPerAccount(n)
+}
+
+// SetWitnessing sets whether the state witnesses of new blocks are recorded.
+func (self *Miner) SetWitnessing(enable bool) {
+	self.worker.SetWitnessing(enable)
+}
+
+// SetFatalTxErrorClassifier extends the transaction errors aborting the block
+// being assembled with the ones fn reports true for. A nil fn restores the
+// default classification.
+func (self *Miner) SetFatalTxErrorClassifier(fn func(error) bool) {
+	self.worker.SetFatalTxErrorClassifier(fn)
+}
+
+// SetCoinbaseHook sets a callback providing the coinbase of each new block. An
+// empty address returned by the hook falls back to the etherbase.
+func (self *Miner) SetCoinbaseHook(fn func() common.Address) {
+	self.worker.setCoinbaseHook(fn)
+}
+
+// SetPostWriteHook sets a callback run synchronously after each sealed block is
+// written to the chain and before its chain events are posted. A slow hook
+// delays event propagation, so it should return quickly.
+func (self *Miner) SetPostWriteHook(fn func(block *types.Block, receipts []*types.Receipt)) {
+	self.worker.setPostWriteHook(fn)
+}
+
+// OnBlockSealed registers a callback run asynchronously after each sealed block
+// is written to the chain.
+func (self *Miner) OnBlockSealed(fn func(*types.Block)) {
+	self.worker.OnBlockSealed(fn)
+}
+
+// InclusionLatencyStats returns percentiles of the time recent transactions
+// spent in the pool before being included in a block.
+func (self *Miner) InclusionLatencyStats() (p50, p95, p99 time.Duration) {
+	return self.worker.InclusionLatencyStats()
+}
+
+// GasGriefingSenders returns the senders whose transactions were skipped in
+// the last assembled block for repeatedly exceeding the gas left in it.
+func (self *Miner) GasGriefingSenders() []common.Address {
+	return self.worker.GasGriefingSenders()
+}
+
+// PendingTxOrder returns the hashes of the pending transactions in the order the
+// next block would attempt them, without executing any.
+func (self *Miner) PendingTxOrder() ([]common.Hash, error) {
+	return self.worker.PendingTxOrder()
+}
+
+// LastBlockDropped returns the transactions left out of the last assembled
+// block and the reasons why.
+func (self *Miner) LastBlockDropped() []DroppedTx {
+	return self.worker.LastBlockDropped()
+}
+
+// SealingTaskStats returns the number of sealing tasks received, those skipped
+// as duplicates of the work in progress, and the sealing operations interrupted.
+func (self *Miner) SealingTaskStats() (tasks, duplicates, interrupted uint64) {
+	return self.worker.TaskCount(), self.worker.DuplicateTaskCount(), self.worker.InterruptedSealCount()
+}
+
+// AverageBlockFullness returns the average ratio of gas used to gas limit over
+// the recent blocks.
+func (self *Miner) AverageBlockFullness() float64 {
+	return self.worker.AverageBlockFullness()
+}
+
+// HealthCheck reports whether each goroutine of the worker is alive.
+func (self *Miner) HealthCheck() map[string]bool {
+	return self.worker.HealthCheck()
+}
+
+// SubscribeSealedBlock registers a subscription of the locally sealed blocks
+// written as the canonical head.
+func (self *Miner) SubscribeSealedBlock(ch chan<- *types.Block) event.Subscription {
+	return self.worker.SubscribeSealedBlock(ch)
+}
+
+// SubscribeMinerEvents registers a subscription of the miner starting and
+// stopping to seal blocks.
+func (self *Miner) SubscribeMinerEvents(ch chan<- MinerStatusEvent) event.Subscription {
+	return self.worker.SubscribeMinerEvents(ch)
+}
+
+// UnconfirmedCount returns the number of locally mined blocks awaiting confirmation.
+func (self *Miner) UnconfirmedCount() int {
+	return self.worker.UnconfirmedCount()
+}
+
+// GetBlockTemplate builds an unsealed block holding txs in the given order.
+func (self *Miner) GetBlockTemplate(txs []*types.Transaction, coinbase common.Address) (*types.Block, []*types.Receipt, error) {
+	return self.worker.GetBlockTemplate(txs, coinbase)
+}
+
+// SimulateBlock executes txs in a block on top of parent without sealing it or
+// writing anything to disk, giving up once ctx is done.
+func (self *Miner) SimulateBlock(ctx context.Context, txs []*types.Transaction, parent *types.Block) (*types.Block, []*types.Receipt, error) {
+	return self.worker.SimulateBlock(ctx, txs, parent)
+}
+
+// ReplayBlock re-executes block on top of the state rooted at stateRoot without
+// writing anything to disk.
+func (self *Miner) ReplayBlock(block *types.Block, stateRoot common.Hash) ([]*types.Receipt, *state.StateDB, error) {
+	return self.worker.ReplayBlock(block, stateRoot)
+}
+
+// SimulateTx applies tx on top of the pending state without changing it and
+// returns its receipt and return data.
+func (self *Miner) SimulateTx(tx *types.Transaction) (*types.Receipt, []byte, error) {
+	return self.worker.SimulateTx(tx)
+}
+
+// SetCommitRatio sets the share of the recommit interval spent assembling a block.
+func (self *Miner) SetCommitRatio(ratio float64) {
+	self.worker.SetCommitRatio(ratio)
+}
+
+// PendingGasRemaining returns the gas still available in the pending block.
+func (self *Miner) PendingGasRemaining() uint64 {
+	return self.worker.PendingGasRemaining()
+}
+
+// PendingGasLimit returns the gas limit of the pending block.
+func (self *Miner) PendingGasLimit() uint64 {
+	return self.worker.PendingGasLimit()
+}
+
 func (self *Miner) SetEtherbase(addr common.Address) {
 	self.coinbase = addr
 	self.worker.setEtherbase(addr)
 }
+
+// SetCoinbaseRotation spreads block rewards across addrs by block number.
+func (self *Miner) SetCoinbaseRotation(addrs []common.Address) {
+	self.worker.SetCoinbaseRotation(addrs)
+}
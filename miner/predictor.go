@@ -0,0 +1,68 @@
+package miner
+
+import "sync"
+
+// gasPredictorSamples is the number of recent blocks with transactions the gas
+// usage per transaction is predicted from.
+const gasPredictorSamples = 16
+
+// blockGasUsage is the gas used by the transactions of a block.
+type blockGasUsage struct {
+	gasUsed uint64
+	txs     int
+}
+
+// GasUsagePredictor estimates how many transactions fit into a block from the
+// average gas used per transaction by the most recent blocks, so the worker
+// fetches about as many pending transactions as a block takes. It is a hint
+// only, blocks are filled until their gas runs out.
+type GasUsagePredictor struct {
+	blocks []blockGasUsage // Gas usage of the recent blocks, used as a ring once full
+	next   int             // Slot overwritten by the next block once full
+	lock   sync.Mutex
+}
+
+// NewGasUsagePredictor creates a predictor taking the last size blocks into
+// account.
+func NewGasUsagePredictor(size int) *GasUsagePredictor {
+	return &GasUsagePredictor{blocks: make([]blockGasUsage, 0, size)}
+}
+
+// Add records the gas used by the transactions of a block, evicting the oldest
+// block if the window is full. Blocks without transactions tell nothing about
+// their gas usage and are ignored.
+func (p *GasUsagePredictor) Add(gasUsed uint64, txs int) {
+	if txs == 0 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	usage := blockGasUsage{gasUsed: gasUsed, txs: txs}
+	if len(p.blocks) < cap(p.blocks) {
+		p.blocks = append(p.blocks, usage)
+		return
+	}
+	p.blocks[p.next] = usage
+	p.next = (p.next + 1) % len(p.blocks)
+}
+
+// Predict returns the number of transactions expected to fit into the given
+// amount of gas, 0 if no block with transactions was recorded yet.
+func (p *GasUsagePredictor) Predict(gas uint64) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var (
+		gasUsed uint64
+		txs     int
+	)
+	for _, block := range p.blocks {
+		gasUsed += block.gasUsed
+		txs += block.txs
+	}
+	if gasUsed == 0 {
+		return 0
+	}
+	return int(float64(gas) / (float64(gasUsed) / float64(txs)))
+}
@@ -0,0 +1,33 @@
+package miner
+
+import (
+	"math"
+	"testing"
+
+	"github.com/Venachain/Venachain/params"
+)
+
+func TestGasUsagePredictor(t *testing.T) {
+	p := NewGasUsagePredictor(4)
+	if have := p.Predict(params.GenesisGasLimit); have != 0 {
+		t.Errorf("prediction without blocks mismatch: have %d, want 0", have)
+	}
+	// Blocks of identical transactions, some of them cut short, predict the
+	// number of those transactions filling a block
+	const txGas = 52000
+	p.Add(100*txGas, 100)
+	p.Add(0, 0) // ignored, no transactions
+	p.Add(3*txGas, 3)
+	gasLimit := uint64(10000000)
+	want := float64(gasLimit) / txGas
+	if have := p.Predict(gasLimit); math.Abs(float64(have)-want) > want*0.05 {
+		t.Errorf("prediction mismatch: have %d, want %v within 5%%", have, want)
+	}
+	// Once full, new blocks replace the oldest ones
+	for i := 0; i < 4; i++ {
+		p.Add(10*2*txGas, 10)
+	}
+	if have, want := p.Predict(gasLimit), int(gasLimit/(2*txGas)); have != want {
+		t.Errorf("window prediction mismatch: have %d, want %d", have, want)
+	}
+}
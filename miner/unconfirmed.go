@@ -85,6 +85,17 @@ func (set *unconfirmedBlocks) Insert(index uint64, hash common.Hash) {
 	log.Info("🔨 mined potential block", "number", index, "hash", hash)
 }
 
+// Len returns the number of blocks still waiting for confirmation.
+func (set *unconfirmedBlocks) Len() int {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+
+	if set.blocks == nil {
+		return 0
+	}
+	return set.blocks.Len()
+}
+
 // Shift drops all unconfirmed blocks from the set which exceed the unconfirmed sets depth
 // allowance, checking them against the canonical chain for inclusion or staleness
 // report.
@@ -86,3 +86,24 @@ func TestUnconfirmedShifts(t *testing.T) {
 		t.Errorf("unconfirmed count mismatch: have %d, want %d", n, 0)
 	}
 }
+
+// Tests that the unconfirmed count follows inserts and shifts.
+func TestUnconfirmedLen(t *testing.T) {
+	limit := uint(10)
+
+	w := &worker{unconfirmed: newUnconfirmedBlocks(new(noopChainRetriever), limit)}
+	if n := w.UnconfirmedCount(); n != 0 {
+		t.Errorf("unconfirmed count mismatch: have %d, want %d", n, 0)
+	}
+	for number := uint64(1); number <= 3; number++ {
+		w.unconfirmed.Insert(number, common.Hash([32]byte{byte(number)}))
+	}
+	if n := w.UnconfirmedCount(); n != 3 {
+		t.Errorf("unconfirmed count mismatch: have %d, want %d", n, 3)
+	}
+	// A new head at the depth of the first block confirms it
+	w.unconfirmed.Shift(1 + uint64(limit))
+	if n := w.UnconfirmedCount(); n != 2 {
+		t.Errorf("unconfirmed count mismatch: have %d, want %d", n, 2)
+	}
+}
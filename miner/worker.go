@@ -17,6 +17,8 @@
 package miner
 
 import (
+	"context"
+	"errors"
 	"math/big"
 	"sync"
 
@@ -36,6 +38,7 @@ import (
 	"github.com/Venachain/Venachain/log"
 	"github.com/Venachain/Venachain/params"
 	"github.com/Venachain/Venachain/rpc"
+	"github.com/Venachain/Venachain/trie"
 )
 
 const (
@@ -52,9 +55,30 @@ const (
 	// resubmitAdjustChanSize is the size of resubmitting interval adjustment channel.
 	resubmitAdjustChanSize = 10
 
+	// statusChanSize is the size of channel listening to the transitions between
+	// sealing and not sealing.
+	statusChanSize = 16
+
 	// miningLogAtDepth is the number of confirmations before logging successful mining.
 	miningLogAtDepth = 7
 
+	// sealedCallbackTimeout is the time a sealed block callback may run before
+	// the next one is started.
+	sealedCallbackTimeout = 500 * time.Millisecond
+
+	// stateFlushTimeout is the longest time the state of a sealed block is kept
+	// in memory when flushing the states in batches.
+	stateFlushTimeout = 5 * time.Second
+
+	// pendingFetchMargin is the percentage of extra transactions fetched from the
+	// pool on top of what the gas limit of a block can hold, making up for the
+	// ones dropped during execution.
+	pendingFetchMargin = 25
+
+	// maxTxBatchSize is the largest number of transactions whose accounts are
+	// loaded together ahead of their execution.
+	maxTxBatchSize = 256
+
 	// minRecommitInterval is the minimal time interval to recreate the mining block with
 	// any newly arrived transactions.
 	minRecommitInterval = 1 * time.Second
@@ -74,6 +98,8 @@ const (
 	// staleThreshold is the maximum depth of the acceptable stale block.
 	staleThreshold = 7
 
+	// defaultCommitRatio is the share of the recommit interval spent assembling a
+	// block before committing it.
 	defaultCommitRatio = 0.95
 )
 
@@ -85,6 +111,8 @@ type environment struct {
 	tcount  int            // tx count in cycle
 	gasPool *core.GasPool  // available gas used to pack transactions
 
+	accountTxs map[common.Address]int // transactions included per sender
+
 	header   *types.Header
 	txs      []*types.Transaction
 	receipts []*types.Receipt
@@ -98,6 +126,43 @@ type task struct {
 	createdAt time.Time
 }
 
+var (
+	// errNoPendingState is returned when simulating a transaction before any
+	// pending block was assembled.
+	errNoPendingState = errors.New("no pending state")
+
+	// errExtraTooLong is returned when setting extra data that does not fit the
+	// vanity prefix of the istanbul extra-data, which would be overwritten.
+	errExtraTooLong = errors.New("extra data too long")
+)
+
+// Reasons a transaction is dropped during block assembly.
+const (
+	DropGasLimit     = "gas limit reached"
+	DropNonceTooLow  = "nonce too low"
+	DropNonceTooHigh = "nonce too high"
+	DropGasGriefing  = "sender griefing the gas limit"
+	DropAccountLimit = "sender transaction limit reached"
+)
+
+// DroppedTx is a transaction left out of the block being assembled.
+type DroppedTx struct {
+	Hash   common.Hash `json:"hash"`
+	Reason string      `json:"reason"`
+}
+
+// MinerStartedEvent is posted on the event mux when the worker starts sealing.
+type MinerStartedEvent struct{}
+
+// MinerStoppedEvent is posted on the event mux when the worker stops sealing.
+type MinerStoppedEvent struct{}
+
+// MinerStatusEvent is delivered to the subscribers of the miner events when the
+// worker starts or stops sealing.
+type MinerStatusEvent struct {
+	Running bool
+}
+
 const (
 	commitInterruptNone int32 = iota
 	commitInterruptNewHead
@@ -154,19 +219,51 @@ type worker struct {
 	newWorkCh             chan *newWorkReq
 	taskCh                chan *task
 	resultCh              chan *types.Block
+	sealDoneCh            chan chan struct{} // Returns the stop channel of a finished sealing operation
+	sealFailedCh          chan struct{}      // Notifies the work loop of a block the engine gave up sealing
 	prepareResultCh       chan *types.Block
 	highestLogicalBlockCh chan *types.Block
 	startCh               chan struct{}
+	newTxsCh              chan struct{} // Notifies the arrival of new transactions to the work loop
 	exitCh                chan struct{}
+	statusCh              chan bool
 	resubmitIntervalCh    chan time.Duration
 	resubmitAdjustCh      chan *intervalAdjust
 
 	current     *environment       // An environment for current running cycle.
 	unconfirmed *unconfirmedBlocks // A set of locally mined blocks pending canonicalness confirmations.
 
-	mu       sync.RWMutex // The lock used to protect the coinbase and extra fields
-	coinbase common.Address
-	extra    []byte
+	mu           sync.RWMutex // The lock used to protect the coinbase and extra fields
+	coinbase     common.Address
+	coinbases    []common.Address      // Reward addresses rotated through by block number, if set
+	coinbaseHook func() common.Address // Callback providing the coinbase of each new block, if set
+	extra        []byte
+
+	gasLimitOverride  uint64           // Fixed gas limit of new blocks, 0 for the dynamic calculation
+	minGasPrice       *big.Int         // Lowest gas price of the transactions included in new blocks, nil for any
+	emptyBlockDelay   time.Duration    // Time without transaction arrivals before an empty block, 0 for none
+	stateBatchSize    int              // Number of sealed blocks whose states are flushed together, 1 for each
+	pendingFetchLimit int              // Maximum number of pending transactions fetched per block, 0 for the pool's limit
+	txBatchSize       int              // Number of transactions whose accounts are loaded together, 1 for none
+	fatalTxError      func(error) bool // Extra classifier of transaction errors aborting the block, if set
+	maxTxsPerAccount  int              // Maximum number of transactions of a sender per block, 0 for no limit
+	witness           bool             // Whether the state witnesses of new blocks are recorded
+
+	postWriteHook   func(block *types.Block, receipts []*types.Receipt) // Callback run after a sealed block is written, if set
+	sealedCallbacks []func(*types.Block)                                // Callbacks run asynchronously after a sealed block is written
+
+	inclusionLatency *latencySample     // Time from entering the pool to inclusion of recent transactions
+	blockFullness    *fullnessSample    // Ratio of gas used to gas limit of recent blocks
+	gasGriefing      *gasGriefing       // Senders repeatedly exceeding the gas left in the block
+	gasPredictor     *GasUsagePredictor // Gas used per transaction by recent blocks
+	heartbeats       *heartbeats        // Liveness of the worker goroutines
+
+	dropped   []DroppedTx  // Transactions left out of the last assembled block
+	droppedMu sync.RWMutex // The lock used to protect the dropped transactions
+
+	sealedBlockFeed event.Feed              // Feed of the sealed blocks written as canonical head
+	statusFeed      event.Feed              // Feed of the transitions between sealing and not sealing
+	scope           event.SubscriptionScope // Subscriptions closed along with the worker
 
 	pendingMu    sync.RWMutex
 	pendingTasks map[common.Hash]*task
@@ -177,14 +274,22 @@ type worker struct {
 
 	// atomic status counters
 	running int32 // The indicator whether the consensus engine is running or not.
+	paused  int32 // The indicator whether proposing own blocks is paused.
 	newTxs  int32 // New arrival transaction count since last sealing work submitting.
 
+	lastTxArrival int64 // Unix nanoseconds of the last transaction arrival.
+
+	taskCount        uint64 // Sealing tasks received by the task loop.
+	duplicateTasks   uint64 // Sealing tasks skipped for repeating the previous seal hash.
+	interruptedSeals uint64 // In-flight sealing operations aborted by a newer task.
+
 	// External functions
 	isLocalBlock func(block *types.Block) bool // Function used to determine whether the specified block is mined by local miner.
 
 	blockChainCache *core.BlockChainCache
 	commitWorkEnv   *commitWorkEnv
 	recommit        time.Duration
+	commitRatio     float64
 	commitDuration  int64 //in Millisecond
 
 	// Test hooks
@@ -194,7 +299,7 @@ type worker struct {
 	resubmitHook func(time.Duration, time.Duration) // Method to call upon updating resubmitting interval.
 }
 
-func newWorker(config *params.ChainConfig, engine consensus.Engine, eth Backend, mux *event.TypeMux, recommit time.Duration, gasFloor, gasCeil uint64, isLocalBlock func(*types.Block) bool,
+func newWorker(config *params.ChainConfig, engine consensus.Engine, eth Backend, mux *event.TypeMux, recommit time.Duration, commitRatio float64, gasFloor, gasCeil uint64, isLocalBlock func(*types.Block) bool,
 	highestLogicalBlockCh chan *types.Block, blockChainCache *core.BlockChainCache) *worker {
 
 	worker := &worker{
@@ -214,15 +319,27 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, eth Backend,
 		newWorkCh:             make(chan *newWorkReq),
 		taskCh:                make(chan *task),
 		resultCh:              make(chan *types.Block, resultQueueSize),
+		sealDoneCh:            make(chan chan struct{}),
 		prepareResultCh:       make(chan *types.Block, resultQueueSize),
 		exitCh:                make(chan struct{}),
+		statusCh:              make(chan bool, statusChanSize),
 		startCh:               make(chan struct{}, 1),
+		newTxsCh:              make(chan struct{}, 1),
+		sealFailedCh:          make(chan struct{}, 1),
 		resubmitIntervalCh:    make(chan time.Duration),
 		resubmitAdjustCh:      make(chan *intervalAdjust, resubmitAdjustChanSize),
 		highestLogicalBlockCh: highestLogicalBlockCh,
 		blockChainCache:       blockChainCache,
 		commitWorkEnv:         &commitWorkEnv{},
+		inclusionLatency:      newLatencySample(inclusionLatencySamples),
+		blockFullness:         newFullnessSample(fullnessSamples),
+		gasGriefing:           newGasGriefing(),
+		gasPredictor:          NewGasUsagePredictor(gasPredictorSamples),
+		txBatchSize:           1,
+		heartbeats:            newHeartbeats(mainLoopName, newWorkLoopName, resultLoopName, taskLoopName),
 	}
+	// Subscribe NewTxsEvent for tx pool
+	worker.txsSub = eth.TxPool().SubscribeNewTxsEvent(worker.txsCh)
 	// Subscribe events for blockchain
 	worker.chainHeadSub = eth.BlockChain().SubscribeChainHeadEvent(worker.chainHeadCh)
 
@@ -232,14 +349,24 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, eth Backend,
 		recommit = minRecommitInterval
 	}
 
+	// Sanitize commit ratio if the user-specified one is out of (0, 1].
+	if commitRatio <= 0 || commitRatio > 1 {
+		if commitRatio != 0 {
+			log.Warn("Sanitizing miner commit ratio", "provided", commitRatio, "updated", defaultCommitRatio)
+		}
+		commitRatio = defaultCommitRatio
+	}
+
 	worker.recommit = recommit
-	worker.commitDuration = int64((float64)(recommit.Nanoseconds()/1e6) * defaultCommitRatio)
-	log.Info("commitDuration in Millisecond", "commitDuration", worker.commitDuration)
+	worker.commitRatio = commitRatio
+	worker.commitDuration = calcCommitDuration(recommit, commitRatio)
+	log.Info("commitDuration in Millisecond", "commitDuration", worker.commitDuration, "commitRatio", commitRatio)
 
 	go worker.mainLoop()
 	go worker.newWorkLoop(recommit)
 	go worker.resultLoop()
 	go worker.taskLoop()
+	go worker.statusLoop()
 
 	// Submit first work to initialize pending state.
 	worker.startCh <- struct{}{}
@@ -254,11 +381,223 @@ func (w *worker) setEtherbase(addr common.Address) {
 	w.coinbase = addr
 }
 
-// setExtra sets the content used to initialize the block extra field.
-func (w *worker) setExtra(extra []byte) {
+// SetCoinbaseRotation sets the reward addresses the block coinbase rotates
+// through by block number. An empty list falls back to the single etherbase.
+func (w *worker) SetCoinbaseRotation(addrs []common.Address) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.coinbases = append([]common.Address(nil), addrs...)
+}
+
+// setCoinbaseHook sets the callback queried for the coinbase of each new block.
+// A nil hook restores the configured coinbase.
+func (w *worker) setCoinbaseHook(fn func() common.Address) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.coinbaseHook = fn
+}
+
+// setPostWriteHook sets the callback run after each sealed block is written to
+// the chain. A nil hook disables it.
+//
+// The hook runs synchronously in the result loop, before the chain events of the
+// block are posted, so it is ordered before any event subscriber sees the block.
+// A slow hook delays event propagation and the handling of further sealed
+// blocks; keep it fast or dispatch the work asynchronously.
+func (w *worker) setPostWriteHook(fn func(block *types.Block, receipts []*types.Receipt)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.postWriteHook = fn
+}
+
+// OnBlockSealed registers a callback run after each sealed block is written to
+// the chain. Unlike the post-write hook, callbacks run outside the result loop:
+// those of a block run one after the other in registration order, each given
+// sealedCallbackTimeout before the next one is started.
+func (w *worker) OnBlockSealed(fn func(*types.Block)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sealedCallbacks = append(w.sealedCallbacks, fn)
+}
+
+// runSealedCallbacks runs callbacks for block in order, moving on from any of
+// them taking longer than sealedCallbackTimeout.
+func runSealedCallbacks(callbacks []func(*types.Block), block *types.Block) {
+	for i, fn := range callbacks {
+		done := make(chan struct{})
+		go func(fn func(*types.Block)) {
+			defer close(done)
+			fn(block)
+		}(fn)
+
+		timer := time.NewTimer(sealedCallbackTimeout)
+		select {
+		case <-done:
+		case <-timer.C:
+			log.Warn("Sealed block callback timed out", "index", i, "number", block.Number(), "hash", block.Hash(), "timeout", sealedCallbackTimeout)
+		}
+		timer.Stop()
+	}
+}
+
+// coinbaseAt returns the coinbase to use for the block with the given number.
+// The caller must hold w.mu.
+func (w *worker) coinbaseAt(number uint64) common.Address {
+	if w.coinbaseHook != nil {
+		if addr := w.coinbaseHook(); addr != (common.Address{}) {
+			return addr
+		}
+	}
+	if len(w.coinbases) == 0 {
+		return w.coinbase
+	}
+	return w.coinbases[number%uint64(len(w.coinbases))]
+}
+
+// SetGasLimitOverride pins the gas limit of new blocks to limit, clamped to the
+// gas floor and ceiling. A zero limit restores the dynamic calculation.
+func (w *worker) SetGasLimitOverride(limit uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gasLimitOverride = limit
+}
+
+// SetMinGasPrice sets the lowest gas price of the transactions included in new
+// blocks. A nil price includes transactions of any price.
+func (w *worker) SetMinGasPrice(price *big.Int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if price != nil {
+		price = new(big.Int).Set(price)
+	}
+	w.minGasPrice = price
+}
+
+// SetEmptyBlockDelay sets how long no transaction must have arrived before an
+// empty block is committed, if empty blocks are produced at all. A zero delay
+// commits them right away.
+func (w *worker) SetEmptyBlockDelay(delay time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.emptyBlockDelay = delay
+}
+
+// SetStateBatchSize sets the number of sealed blocks whose states are flushed to
+// disk together. A size of 1 or less flushes the state of every block.
+func (w *worker) SetStateBatchSize(size int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stateBatchSize = size
+}
+
+// SetPendingFetchLimit sets the maximum number of pending transactions fetched
+// from the pool for each new block. The fetch is further bounded to what the
+// gas limit of the block can hold, judging by the gas used per transaction in
+// the recent blocks, plus pendingFetchMargin, sparing the sorting
+// of transactions that could never make it in, which takes roughly a quarter of
+// the CPU time with 50000 pending transactions (see BenchmarkPendingLimitedN).
+// A limit of 0 fetches as many as the pool hands out.
+func (w *worker) SetPendingFetchLimit(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pendingFetchLimit = n
+}
+
+// SetTxBatchSize sets the number of transactions whose sender and recipient
+// accounts are loaded concurrently before the transactions are executed one by
+// one. The size is capped at maxTxBatchSize, 1 or less loads each account when
+// its transaction is executed.
+func (w *worker) SetTxBatchSize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	if size > maxTxBatchSize {
+		size = maxTxBatchSize
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.txBatchSize = size
+}
+
+// prefetchTxBatch loads the sender and recipient accounts of up to n of the
+// next transactions into the pending state and returns the number of
+// transactions covered.
+func (w *worker) prefetchTxBatch(txs *types.TransactionsByPriceAndNonce, n int) int {
+	heads := txs.Heads(n)
+	addrs := make([]common.Address, 0, 2*len(heads))
+	for _, tx := range heads {
+		from, _ := types.Sender(w.current.signer, tx)
+		addrs = append(addrs, from)
+		if to := tx.To(); to != nil {
+			addrs = append(addrs, *to)
+		}
+	}
+	w.current.state.PrefetchAccounts(addrs)
+	return len(heads)
+}
+
+// pendingFetchCount returns the number of pending transactions to fetch for a
+// block with the given gas limit, 0 for no limit. The caller must hold w.mu.
+func (w *worker) pendingFetchCount(gasLimit uint64) int {
+	if w.pendingFetchLimit <= 0 {
+		return 0
+	}
+	count := w.pendingFetchLimit
+	// Every transaction takes at least the intrinsic gas of a plain transfer,
+	// and likely about as much gas as those of the recent blocks
+	fit := gasLimit / params.TxGas
+	if predicted := w.gasPredictor.Predict(gasLimit); predicted > 0 && uint64(predicted) < fit {
+		fit = uint64(predicted)
+	}
+	if fit < uint64(count) {
+		fit += fit * pendingFetchMargin / 100
+		if fit < uint64(count) {
+			count = int(fit)
+		}
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+// emptyBlockDue reports whether the empty block delay has passed at now since
+// the last transaction arrival. The caller must hold w.mu.
+func (w *worker) emptyBlockDue(now time.Time) bool {
+	if w.emptyBlockDelay == 0 || !common.SysCfg.IsProduceEmptyBlock() {
+		return true
+	}
+	lastTxArrival := time.Unix(0, atomic.LoadInt64(&w.lastTxArrival))
+	return now.Sub(lastTxArrival) >= w.emptyBlockDelay
+}
+
+// gasLimit returns the gas limit of the block on top of parent. The caller must
+// hold w.mu.
+func (w *worker) gasLimit(parent *types.Block) uint64 {
+	if w.gasLimitOverride == 0 {
+		return core.CalcGasLimit(parent, w.gasFloor, w.gasCeil)
+	}
+	limit := w.gasLimitOverride
+	if limit < w.gasFloor {
+		limit = w.gasFloor
+	}
+	if limit > w.gasCeil {
+		limit = w.gasCeil
+	}
+	return limit
+}
+
+// setExtra sets the content used to initialize the block extra field. Only
+// the vanity prefix of the extra field is free, the rest holds the istanbul
+// extra-data, so longer content is rejected.
+func (w *worker) setExtra(extra []byte) error {
+	if len(extra) > types.IstanbulExtraVanity {
+		return fmt.Errorf("%w: %d > %d bytes", errExtraTooLong, len(extra), types.IstanbulExtraVanity)
+	}
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	w.extra = extra
+	return nil
 }
 
 // setRecommitInterval updates the interval for miner sealing work recommitting.
@@ -285,23 +624,173 @@ func (w *worker) pendingBlock() *types.Block {
 	return w.snapshotBlock
 }
 
+// InclusionLatencyStats returns the 50th, 95th and 99th percentile of the time
+// recent transactions spent in the pool before being included in a block.
+func (w *worker) InclusionLatencyStats() (p50, p95, p99 time.Duration) {
+	ps := w.inclusionLatency.Percentiles(0.5, 0.95, 0.99)
+	return ps[0], ps[1], ps[2]
+}
+
+// AverageBlockFullness returns the average ratio of gas used to gas limit over
+// the recent blocks, which biases the recommit interval.
+func (w *worker) AverageBlockFullness() float64 {
+	fullness, _ := w.blockFullness.Average()
+	return fullness
+}
+
+// TaskCount returns the number of sealing tasks received by the task loop.
+func (w *worker) TaskCount() uint64 {
+	return atomic.LoadUint64(&w.taskCount)
+}
+
+// DuplicateTaskCount returns the number of sealing tasks skipped because they
+// repeated the work already being sealed. A high share of TaskCount suggests
+// the recommit interval is too aggressive.
+func (w *worker) DuplicateTaskCount() uint64 {
+	return atomic.LoadUint64(&w.duplicateTasks)
+}
+
+// InterruptedSealCount returns the number of in-flight sealing operations that
+// were aborted, by newer work or by the worker shutting down.
+func (w *worker) InterruptedSealCount() uint64 {
+	return atomic.LoadUint64(&w.interruptedSeals)
+}
+
+// tracksUnconfirmed reports whether locally mined blocks are tracked until they
+// reach miningLogAtDepth confirmations. Istanbul blocks are final once written,
+// so there is nothing to track.
+func (w *worker) tracksUnconfirmed() bool {
+	_, ok := w.engine.(consensus.Istanbul)
+	return !ok
+}
+
+// UnconfirmedCount returns the number of locally mined blocks that have not
+// reached miningLogAtDepth confirmations yet.
+func (w *worker) UnconfirmedCount() int {
+	return w.unconfirmed.Len()
+}
+
+// SetCommitRatio updates the share of the recommit interval spent assembling a
+// block and recomputes the commit duration. Ratios out of (0, 1] are ignored.
+func (w *worker) SetCommitRatio(r float64) {
+	if r <= 0 || r > 1 {
+		log.Warn("Ignoring invalid miner commit ratio", "ratio", r)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.commitRatio = r
+	w.commitDuration = calcCommitDuration(w.recommit, r)
+	log.Info("Miner commit ratio update", "commitRatio", r, "commitDuration", w.commitDuration)
+}
+
+// calcCommitDuration derives the commit duration in milliseconds from the
+// recommit interval.
+func calcCommitDuration(recommit time.Duration, ratio float64) int64 {
+	return int64((float64)(recommit.Nanoseconds()/1e6) * ratio)
+}
+
+// PendingGasRemaining returns the gas still available in the block being
+// built, or the gas ceiling if no block is being built.
+func (w *worker) PendingGasRemaining() uint64 {
+	w.snapshotMu.RLock()
+	defer w.snapshotMu.RUnlock()
+	if w.current == nil || w.current.gasPool == nil {
+		return w.gasCeil
+	}
+	return w.current.gasPool.Gas()
+}
+
+// PendingGasLimit returns the gas limit of the block being built, or the gas
+// ceiling if no block is being built.
+func (w *worker) PendingGasLimit() uint64 {
+	w.snapshotMu.RLock()
+	defer w.snapshotMu.RUnlock()
+	if w.current == nil || w.current.header == nil {
+		return w.gasCeil
+	}
+	return w.current.header.GasLimit
+}
+
 // start sets the running status as 1 and triggers new work submitting.
 func (w *worker) start() {
 
-	atomic.StoreInt32(&w.running, 1)
+	started := atomic.SwapInt32(&w.running, 1) == 0
 	w.startCh <- struct{}{}
 	if eng, ok := w.engine.(consensus.Istanbul); ok {
 		eng.Start(w.chain, w.chain.CurrentBlock)
 	}
+	if started {
+		w.postStatus(true)
+	}
 }
 
 // stop sets the running status as 0.
 func (w *worker) stop() {
-	atomic.StoreInt32(&w.running, 0)
+	stopped := atomic.SwapInt32(&w.running, 0) == 1
 
 	if eng, ok := w.engine.(consensus.Istanbul); ok {
 		eng.Stop()
 	}
+	if stopped {
+		w.postStatus(false)
+	}
+}
+
+// postStatus queues the announcement that the worker started or stopped
+// sealing. It never blocks, the announcement is dropped if the earlier ones
+// are still being delivered.
+func (w *worker) postStatus(running bool) {
+	select {
+	case w.statusCh <- running:
+	default:
+		log.Warn("Dropping miner status event, delivery is lagging", "running", running)
+	}
+}
+
+// statusLoop is a standalone goroutine to announce the transitions between
+// sealing and not sealing, both on the event mux and to the subscribers of the
+// miner events.
+func (w *worker) statusLoop() {
+	for {
+		select {
+		case running := <-w.statusCh:
+			if running {
+				w.mux.Post(MinerStartedEvent{})
+			} else {
+				w.mux.Post(MinerStoppedEvent{})
+			}
+			w.statusFeed.Send(MinerStatusEvent{Running: running})
+
+		case <-w.exitCh:
+			return
+		}
+	}
+}
+
+// SubscribeMinerEvents registers a subscription of the transitions of the worker
+// between sealing and not sealing. The events are delivered in order by a
+// separate goroutine, a slow subscriber only delays the later events.
+func (w *worker) SubscribeMinerEvents(ch chan<- MinerStatusEvent) event.Subscription {
+	return w.scope.Track(w.statusFeed.Subscribe(ch))
+}
+
+// PauseProposing stops building and proposing new blocks while the consensus
+// engine keeps running, so the node still verifies and commits the blocks of
+// the other validators. Unlike stop, the Istanbul engine is left untouched.
+func (w *worker) PauseProposing() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+// ResumeProposing restarts building and proposing new blocks after
+// PauseProposing.
+func (w *worker) ResumeProposing() {
+	atomic.StoreInt32(&w.paused, 0)
+}
+
+// isPaused returns an indicator whether proposing new blocks is paused.
+func (w *worker) isPaused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
 }
 
 // isRunning returns an indicator whether worker is running or not.
@@ -309,10 +798,52 @@ func (w *worker) isRunning() bool {
 	return atomic.LoadInt32(&w.running) == 1
 }
 
+// WaitForBlock waits until the chain head reaches block n and returns the first
+// such head, or context.DeadlineExceeded if it does not within timeout.
+func (w *worker) WaitForBlock(n uint64, timeout time.Duration) (*types.Block, error) {
+	headCh := make(chan core.ChainHeadEvent, chainHeadChanSize)
+	sub := w.chain.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+
+	// Subscribed first so no head is missed in between
+	if head := w.chain.CurrentBlock(); head.NumberU64() >= n {
+		return head, nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case head := <-headCh:
+			if head.Block.NumberU64() >= n {
+				return head.Block, nil
+			}
+		case err := <-sub.Err():
+			return nil, err
+		case <-timer.C:
+			return nil, context.DeadlineExceeded
+		}
+	}
+}
+
+// HealthCheck reports for each worker goroutine whether it is alive, that is it
+// did not miss the last three heartbeats it sends every second.
+func (w *worker) HealthCheck() map[string]bool {
+	return w.heartbeats.check(time.Now())
+}
+
 // close terminates all background threads maintained by the worker.
 // Note the worker does not support being closed multiple times.
 func (w *worker) close() {
 	close(w.exitCh)
+	w.scope.Close()
+}
+
+// SubscribeSealedBlock registers a subscription of the blocks sealed by the
+// worker, delivered once written as the canonical head. Delivery blocks the
+// worker until the block is received, so ch should be buffered.
+func (w *worker) SubscribeSealedBlock(ch chan<- *types.Block) event.Subscription {
+	return w.scope.Track(w.sealedBlockFeed.Subscribe(ch))
 }
 
 // newWorkLoop is a standalone goroutine to submit new mining work upon received events.
@@ -321,11 +852,15 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 		interrupt   *int32
 		minRecommit = recommit // minimal resubmit interval specified by user.
 		timestamp   int64      // timestamp for each round of mining in Millisecond.
+		lastCommit  time.Time  // time of the last submitted work.
 	)
 
 	timer := time.NewTimer(0)
 	<-timer.C // discard the initial tick
 
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
 	// commit aborts in-flight transaction execution with given signal and resubmits a new one.
 	commit := func(s int32, baseBlock *types.Block) {
 		if interrupt != nil {
@@ -333,8 +868,12 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 		}
 		interrupt = new(int32)
 		w.newWorkCh <- &newWorkReq{interrupt: interrupt, timestamp: timestamp, commitBlock: baseBlock}
-		timer.Reset(recommit)
+		// The interrupt feedback adjusts recommit, the fullness of the recent
+		// blocks biases it on top
+		fullness, samples := w.blockFullness.Average()
+		timer.Reset(biasRecommit(recommit, fullness, samples))
 		atomic.StoreInt32(&w.newTxs, 0)
+		lastCommit = time.Now()
 	}
 	// recalcRecommit recalculates the resubmitting interval upon feedback.
 	recalcRecommit := func(target float64, inc bool) {
@@ -377,11 +916,16 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 
 		case head := <-w.chainHeadCh:
 			clearPending(head.Block.NumberU64())
+			w.blockFullness.Add(head.Block.GasUsed(), head.Block.GasLimit())
+			w.gasPredictor.Add(head.Block.GasUsed(), len(head.Block.Transactions()))
 			timestamp = time.Now().UnixNano() / 1e6
 			//commit(false, commitInterruptNewHead)
 			// clear consensus cache
 			log.Info("received a event of ChainHeadEvent", "hash", head.Block.Hash(), "number", head.Block.NumberU64(), "parentHash", head.Block.ParentHash())
 			w.blockChainCache.ClearCache(head.Block)
+			if w.tracksUnconfirmed() {
+				w.unconfirmed.Shift(head.Block.NumberU64())
+			}
 
 			if h, ok := w.engine.(consensus.Handler); ok {
 				h.NewChainHead()
@@ -394,7 +938,8 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 			}
 
 			if eng, ok := w.engine.(consensus.Istanbul); ok {
-				if eng.ShouldSeal() {
+				// A paused validator keeps polling to propose again once resumed
+				if !w.isPaused() && eng.ShouldSeal() {
 					log.Debug("ShouldSeal() -> true")
 					commit(commitInterruptResubmit, nil)
 					timer.Reset(500 * time.Millisecond)
@@ -403,6 +948,31 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 				}
 			}
 
+		case <-w.newTxsCh:
+			// Pull in a burst of transactions without waiting for the timer, but
+			// no more often than the minimal recommit interval.
+			if !w.isRunning() || w.isPaused() || atomic.LoadInt32(&w.newTxs) == 0 {
+				continue
+			}
+			if time.Since(lastCommit) < minRecommitInterval {
+				continue
+			}
+			if eng, ok := w.engine.(consensus.Istanbul); ok && !eng.ShouldSeal() {
+				continue
+			}
+			commit(commitInterruptResubmit, nil)
+
+		case <-w.sealFailedCh:
+			// Resubmit the work the engine gave up sealing without waiting for
+			// the timer
+			if !w.isRunning() || w.isPaused() {
+				continue
+			}
+			if eng, ok := w.engine.(consensus.Istanbul); ok && !eng.ShouldSeal() {
+				continue
+			}
+			commit(commitInterruptResubmit, nil)
+
 		case interval := <-w.resubmitIntervalCh:
 			// Adjust resubmit interval explicitly by user.
 			if interval < minRecommitInterval {
@@ -430,6 +1000,8 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 			if w.resubmitHook != nil {
 				w.resubmitHook(minRecommit, recommit)
 			}
+		case now := <-heartbeat.C:
+			w.heartbeats.beat(newWorkLoopName, now)
 		case <-w.exitCh:
 			return
 		}
@@ -438,19 +1010,34 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 
 // mainLoop is a standalone goroutine to regenerate the sealing task based on the received event.
 func (w *worker) mainLoop() {
-	// defer w.txsSub.Unsubscribe()
+	defer w.txsSub.Unsubscribe()
 	defer w.chainHeadSub.Unsubscribe()
 	//defer w.chainSideSub.Unsubscribe()
 
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
 	for {
 		select {
 		case req := <-w.newWorkCh:
 			w.commitNewWork(req.interrupt, req.timestamp, req.commitBlock)
+		case now := <-heartbeat.C:
+			w.heartbeats.beat(mainLoopName, now)
+		case ev := <-w.txsCh:
+			atomic.AddInt32(&w.newTxs, int32(len(ev.Txs)))
+			atomic.StoreInt64(&w.lastTxArrival, time.Now().UnixNano())
+			// Wake up the work loop unless a notification is already pending
+			select {
+			case w.newTxsCh <- struct{}{}:
+			default:
+			}
 		// System stopped
 		case <-w.exitCh:
 			return
 		case <-w.chainHeadSub.Err():
 			return
+		case <-w.txsSub.Err():
+			return
 
 		case block := <-w.prepareResultCh:
 			// Short circuit when receiving empty result.
@@ -489,22 +1076,30 @@ func (w *worker) taskLoop() {
 		if stopCh != nil {
 			close(stopCh)
 			stopCh = nil
+			atomic.AddUint64(&w.interruptedSeals, 1)
 		}
 	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
 	for {
 		select {
+		case now := <-heartbeat.C:
+			w.heartbeats.beat(taskLoopName, now)
 		case task := <-w.taskCh:
 			if w.newTaskHook != nil {
 				w.newTaskHook(task)
 			}
+			atomic.AddUint64(&w.taskCount, 1)
 			// Reject duplicate sealing work due to resubmitting.
 			sealHash := w.engine.SealHash(task.block.Header())
 			if sealHash == prev {
+				atomic.AddUint64(&w.duplicateTasks, 1)
 				continue
 			}
 			// Interrupt previous sealing operation
 			interrupt()
-			stopCh, prev = make(chan struct{}), sealHash
+			prev = sealHash
 
 			if w.skipSealHook != nil && w.skipSealHook(task) {
 				continue
@@ -518,17 +1113,19 @@ func (w *worker) taskLoop() {
 				w.pendingTasks[sealHash] = task
 				w.pendingMu.Unlock()
 			}
-
-			if _, ok := w.engine.(consensus.Istanbul); ok {
-				// todo: shouldSeal()
-				if _, err := w.engine.Seal(w.chain, task.block, w.resultCh, stopCh); err != nil {
-					log.Warn("Block sealing failed", "err", err)
-				}
+			stopCh = make(chan struct{})
+			results := make(chan *types.Block, 1)
+			if _, err := w.engine.Seal(w.chain, task.block, results, stopCh); err != nil {
+				log.Warn("Block sealing failed", "err", err)
+				stopCh = nil
 				continue
 			}
+			go w.forwardSeal(results, stopCh)
 
-			if _, err := w.engine.Seal(w.chain, task.block, w.resultCh, stopCh); err != nil {
-				log.Warn("Block sealing failed", "err", err)
+		case done := <-w.sealDoneCh:
+			// The sealing operation is over, there is nothing left to interrupt
+			if done == stopCh {
+				stopCh = nil
 			}
 
 		case <-w.exitCh:
@@ -538,15 +1135,63 @@ func (w *worker) taskLoop() {
 	}
 }
 
+// forwardSeal relays the outcome of a sealing operation to the result loop and
+// reports the operation finished to the task loop. Engines deliver at most one
+// result per seal, and none if the operation is stopped.
+func (w *worker) forwardSeal(results <-chan *types.Block, stop chan struct{}) {
+	select {
+	case block := <-results:
+		select {
+		case w.resultCh <- block:
+		case <-w.exitCh:
+			return
+		}
+	case <-stop:
+	}
+	select {
+	case w.sealDoneCh <- stop:
+	case <-w.exitCh:
+	}
+}
+
 // resultLoop is a standalone goroutine to handle sealing result submitting
 // and flush relative data to the database.
 func (w *worker) resultLoop() {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	var (
+		deferred     int              // Sealed blocks whose state is not flushed yet
+		flushTimeout <-chan time.Time // Fires when the oldest deferred state is due
+	)
+	flush := func() {
+		if deferred == 0 {
+			return
+		}
+		if err := w.chain.FlushDeferredState(); err != nil {
+			log.Error("Failed flushing sealed block states", "blocks", deferred, "err", err)
+			// Retry later, the timer that fired would never fire again
+			flushTimeout = time.After(stateFlushTimeout)
+			return
+		}
+		deferred, flushTimeout = 0, nil
+	}
+
 	for {
 		select {
+		case now := <-heartbeat.C:
+			w.heartbeats.beat(resultLoopName, now)
+		case <-flushTimeout:
+			flush()
 		case block := <-w.resultCh:
 			now := time.Now()
-			// Short circuit when receiving empty result.
+			// Short circuit when receiving empty result, the engine gave up on
+			// the block and a new one is due.
 			if block == nil {
+				select {
+				case w.sealFailedCh <- struct{}{}:
+				default:
+				}
 				continue
 			}
 			// Short circuit when receiving duplicate result caused by resubmitting.
@@ -580,12 +1225,45 @@ func (w *worker) resultLoop() {
 				}
 				logs = append(logs, receipt.Logs...)
 			}
-			// Commit block and state to database.
-			stat, err := w.chain.WriteBlockWithState(block, task.receipts, task.state, false)
+			// Commit block and state to database, the state possibly batched with
+			// the next blocks.
+			w.mu.RLock()
+			batchSize := w.stateBatchSize
+			w.mu.RUnlock()
+
+			var (
+				stat core.WriteStatus
+				err  error
+			)
+			if batchSize > 1 {
+				stat, err = w.chain.WriteBlockWithDeferredState(block, task.receipts, task.state)
+			} else {
+				stat, err = w.chain.WriteBlockWithState(block, task.receipts, task.state, false)
+			}
 			if err != nil {
 				log.Error("Failed writing block to chain", "err", err)
 				continue
 			}
+			if batchSize <= 1 {
+				// Writing the state of a block flushes all earlier ones
+				deferred, flushTimeout = 0, nil
+			} else {
+				deferred++
+				if deferred >= batchSize {
+					flush()
+				} else if flushTimeout == nil {
+					flushTimeout = time.After(stateFlushTimeout)
+				}
+			}
+			w.mu.RLock()
+			hook, callbacks := w.postWriteHook, w.sealedCallbacks
+			w.mu.RUnlock()
+			if hook != nil {
+				hook(block, task.receipts)
+			}
+			if len(callbacks) > 0 {
+				go runSealedCallbacks(callbacks, block)
+			}
 			log.Info("Successfully sealed new block", "number", block.Number(), "sealhash", sealhash, "hash", hash,
 				"elapsed", common.PrettyDuration(time.Since(task.createdAt)))
 			// Broadcast the block and announce chain insertion event
@@ -603,11 +1281,17 @@ func (w *worker) resultLoop() {
 			}
 			w.chain.PostChainEvents(events, logs)
 
+			if stat == core.CanonStatTy {
+				w.sealedBlockFeed.Send(block)
+			}
 			// Insert the block into the set of pending ones to resultLoop for confirmations
-			//w.unconfirmed.Insert(block.NumberU64(), block.Hash())
+			if stat == core.CanonStatTy && w.tracksUnconfirmed() {
+				w.unconfirmed.Insert(block.NumberU64(), block.Hash())
+			}
 
 			log.Info("result block ---------------------------", "duration", time.Since(now))
 		case <-w.exitCh:
+			flush()
 			return
 		}
 	}
@@ -625,11 +1309,15 @@ func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
 	if err != nil {
 		return err
 	}
+	if w.witness {
+		state.EnableWitnessing()
+	}
 
 	env := &environment{
-		signer: types.NewEIP155Signer(w.config.ChainID),
-		state:  state,
-		header: header,
+		signer:     types.NewEIP155Signer(w.config.ChainID),
+		state:      state,
+		header:     header,
+		accountTxs: make(map[common.Address]int),
 	}
 
 	// Keep track of transactions which return errors so they can be removed
@@ -655,16 +1343,16 @@ func (w *worker) updateSnapshot(block *types.Block) {
 	w.snapshotState = w.current.state.Copy()
 }
 
-func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
-	snap := w.current.state.Snapshot()
+func (w *worker) commitTransaction(env *environment, tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
+	snap := env.state.Snapshot()
 
-	receipt, _, err := core.ApplyTransaction(w.config, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, vm.Config{})
+	receipt, _, err := core.ApplyTransaction(w.config, w.chain, &coinbase, env.gasPool, env.state, env.header, tx, &env.header.GasUsed, vm.Config{})
 	if err != nil {
-		w.current.state.RevertToSnapshot(snap)
+		env.state.RevertToSnapshot(snap)
 		return nil, err
 	}
-	w.current.txs = append(w.current.txs, tx)
-	w.current.receipts = append(w.current.receipts, receipt)
+	env.txs = append(env.txs, tx)
+	env.receipts = append(env.receipts, receipt)
 
 	return receipt.Logs, nil
 }
@@ -681,8 +1369,10 @@ func (w *worker) commitTransactionsWithHeader(header *types.Header, txs *types.T
 		w.current.gasPool = new(core.GasPool).AddGas(w.current.header.GasLimit)
 	}
 
-	var coalescedLogs []*types.Log
-
+	var (
+		coalescedLogs []*types.Log
+		prefetched    int // Transactions left of the last prefetched batch
+	)
 	for {
 		// In the following three cases, we will interrupt the execution of the transaction.
 		// (1) new head block event arrival, the interrupt signal is 1
@@ -714,45 +1404,101 @@ func (w *worker) commitTransactionsWithHeader(header *types.Header, txs *types.T
 		if tx == nil {
 			break
 		}
+		// Load the accounts of the next batch at once, then execute it serially
+		if w.txBatchSize > 1 {
+			if prefetched == 0 {
+				prefetched = w.prefetchTxBatch(txs, w.txBatchSize)
+			}
+			prefetched--
+		}
 		// Error may be ignored here. The error has already been checked
 		// during transaction acceptance is the transaction pool.
 		//
 		// We use the eip155 signer regardless of the current hf.
 		from, _ := types.Sender(w.current.signer, tx)
 
+		// Skip the senders whose transactions keep exceeding the remaining gas
+		if w.gasGriefing.griefing(from) {
+			log.Debug("Skipping account griefing the gas limit", "blockNumber", header.Number, "tx.hash", tx.Hash(), "sender", from)
+			w.recordDropped(tx.Hash(), DropGasGriefing)
+			txs.Pop()
+			continue
+		}
+		// Leave the rest of the block to others once a sender had its share
+		if w.maxTxsPerAccount > 0 && w.current.accountTxs[from] >= w.maxTxsPerAccount {
+			log.Trace("Skipping account at its transaction limit", "blockNumber", header.Number, "tx.hash", tx.Hash(), "sender", from, "limit", w.maxTxsPerAccount)
+			w.recordDropped(tx.Hash(), DropAccountLimit)
+			txs.Pop()
+			continue
+		}
 		// Start executing the transaction
 		rpc.MonitorWriteData(rpc.TransactionExecuteStartTime, tx.Hash().String(), "", w.extdb)
 		w.current.state.Prepare(tx.Hash(), common.Hash{}, w.current.tcount)
 		txHash := tx.Hash()
 		log.Trace("Start executing the transaction", "txHash", fmt.Sprintf("%x", txHash[:log.LogHashLen]), "blockNumber", header.Number)
-		logs, err := w.commitTransaction(tx, coinbase)
+		var (
+			logs []*types.Log
+			err  error
+		)
+		// The state transition reserves the system gas limit of a transaction
+		// instead of its own, so a transaction exceeding the gas left is caught here
+		if tx.Gas() > w.current.gasPool.Gas() {
+			err = core.ErrGasLimitReached
+		} else {
+			logs, err = w.commitTransaction(w.current, tx, coinbase)
+		}
 		rpc.MonitorWriteData(rpc.TransactionExecuteEndTime, tx.Hash().String(), "", w.extdb)
+		// A failed database read is kept by the state instead of failing the
+		// transaction, only consulted to abandon the block
+		fatal := err
+		if fatal == nil {
+			fatal = w.current.state.Error()
+		}
+		if fatal != nil && w.isFatalTxError(fatal) {
+			// Continuing on a broken state would produce a bad block, abandon it
+			log.Output("Fatal transaction error, block assembly aborted", log.LvlCrit, 0, "blockNumber", header.Number, "blockParentHash", header.ParentHash, "hash", tx.Hash(), "sender", from, "err", fatal)
+			rpc.MonitorWriteData(rpc.TransactionExecuteStatus, tx.Hash().String(), "false", w.extdb)
+			return true
+		}
 		switch err {
 		case core.ErrGasLimitReached:
 			// Pop the current out-of-gas transaction without shifting in the next from the account
 			log.Warn("Gas limit exceeded for current block", "blockNumber", header.Number, "blockParentHash", header.ParentHash, "tx.hash", tx.Hash(), "sender", from, "senderCurNonce", w.current.state.GetNonce(from), "tx.nonce", tx.Nonce())
+			w.recordDropped(txHash, DropGasLimit)
+			if w.gasGriefing.hit(from) {
+				log.Warn("Account griefing the gas limit, skipped for the block", "blockNumber", header.Number, "sender", from)
+			}
 			txs.Pop()
 			rpc.MonitorWriteData(rpc.TransactionExecuteStatus, tx.Hash().String(), "false", w.extdb)
 		case core.ErrNonceTooLow:
 			// New head notification data race between the transaction pool and miner, shift
 			log.Warn("Skipping transaction with low nonce", "blockNumber", header.Number, "blockParentHash", header.ParentHash, "tx.hash", tx.Hash(), "sender", from, "senderCurNonce", w.current.state.GetNonce(from), "tx.nonce", tx.Nonce())
+			w.recordDropped(txHash, DropNonceTooLow)
 			txs.Shift()
 			rpc.MonitorWriteData(rpc.TransactionExecuteStatus, tx.Hash().String(), "false", w.extdb)
 		case core.ErrNonceTooHigh:
 			// Reorg notification data race between the transaction pool and miner, skip account =
 			log.Warn("Skipping account with hight nonce", "blockNumber", header.Number, "blockParentHash", header.ParentHash, "tx.hash", tx.Hash(), "sender", from, "senderCurNonce", w.current.state.GetNonce(from), "tx.nonce", tx.Nonce())
+			w.recordDropped(txHash, DropNonceTooHigh)
 			txs.Pop()
 			rpc.MonitorWriteData(rpc.TransactionExecuteStatus, tx.Hash().String(), "false", w.extdb)
 		case nil:
 			// Everything ok, collect the logs and shift in the next transaction from the same account
 			coalescedLogs = append(coalescedLogs, logs...)
 			w.current.tcount++
+			if w.maxTxsPerAccount > 0 {
+				w.current.accountTxs[from]++
+			}
+			if seen, ok := w.eth.TxPool().FirstSeen(tx.Hash()); ok {
+				w.inclusionLatency.Add(time.Since(seen))
+			}
 			txs.Shift()
 			rpc.MonitorWriteData(rpc.TransactionExecuteStatus, tx.Hash().String(), "true", w.extdb)
 		default:
 			// Strange error, discard the transaction and get the next in line (note, the
 			// nonce-too-high clause will prevent us from executing in vain).
 			log.Warn("Transaction failed, account skipped", "blockNumber", header.Number, "blockParentHash", header.ParentHash, "hash", tx.Hash(), "hash", tx.Hash(), "err", err)
+			w.recordDropped(txHash, "execution failed: "+err.Error())
 			txs.Shift()
 			rpc.MonitorWriteData(rpc.TransactionExecuteStatus, tx.Hash().String(), "false", w.extdb)
 		}
@@ -781,11 +1527,131 @@ func (w *worker) commitTransactionsWithHeader(header *types.Header, txs *types.T
 	return false
 }
 
+// isFatalTxError reports whether a transaction error means the block being
+// assembled cannot be completed: state database failures, and any error
+// classified as fatal by the operator. The caller must hold w.mu.
+func (w *worker) isFatalTxError(err error) bool {
+	if _, ok := err.(*trie.MissingNodeError); ok {
+		return true
+	}
+	return w.fatalTxError != nil && w.fatalTxError(err)
+}
+
+// SetMaxTxsPerAccount sets the maximum number of transactions of a single
+// sender included in a block, the rest of its transactions waiting for later
+// blocks. A limit of 0 or less includes any number of them.
+func (w *worker) SetMaxTxsPerAccount(n int) {
+	if n < 0 {
+		n = 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.maxTxsPerAccount = n
+}
+
+// SetWitnessing sets whether the state witnesses of new blocks are recorded, the
+// engine storing their hashes in the blocks.
+func (w *worker) SetWitnessing(enable bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.witness = enable
+}
+
+// SetFatalTxErrorClassifier extends the transaction errors aborting the block
+// being assembled, instead of only skipping the failing transaction, with the
+// errors fn reports true for. A nil fn restores the default classification.
+func (w *worker) SetFatalTxErrorClassifier(fn func(error) bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fatalTxError = fn
+}
+
+// recordDropped notes a transaction left out of the block being assembled.
+func (w *worker) recordDropped(hash common.Hash, reason string) {
+	w.droppedMu.Lock()
+	defer w.droppedMu.Unlock()
+	w.dropped = append(w.dropped, DroppedTx{Hash: hash, Reason: reason})
+}
+
+// LastBlockDropped returns the transactions left out of the last assembled
+// block and the reasons why.
+func (w *worker) LastBlockDropped() []DroppedTx {
+	w.droppedMu.RLock()
+	defer w.droppedMu.RUnlock()
+	return append([]DroppedTx(nil), w.dropped...)
+}
+
+// GasGriefingSenders returns the senders whose transactions were skipped in
+// the last assembled block for repeatedly exceeding the gas left in it.
+func (w *worker) GasGriefingSenders() []common.Address {
+	return w.gasGriefing.Senders()
+}
+
+// fetchPending retrieves the pending transactions to fill a block with the
+// given gas limit from the pool. The caller must hold w.mu.
+func (w *worker) fetchPending(gasLimit uint64) (map[common.Address]types.Transactions, error) {
+	if w.minGasPrice != nil {
+		return w.eth.TxPool().PendingByGasPrice(w.minGasPrice, w.pendingFetchCount(gasLimit))
+	}
+	return w.eth.TxPool().PendingLimitedN(w.pendingFetchCount(gasLimit))
+}
+
+// splitLocals splits pending transactions into the ones of local accounts,
+// which are committed first, and the remote ones. The pending set is reused
+// for the remote transactions.
+func (w *worker) splitLocals(pending map[common.Address]types.Transactions) (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
+	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
+	for _, account := range w.eth.TxPool().Locals() {
+		if txs := remoteTxs[account]; len(txs) > 0 {
+			delete(remoteTxs, account)
+			localTxs[account] = txs
+		}
+	}
+	return localTxs, remoteTxs
+}
+
+// PendingTxOrder returns the hashes of the pending transactions in the order
+// the next block would attempt them: the ones of local accounts first, each
+// group sorted by price and nonce. The pool hands out a copy of its pending
+// transactions, so nothing is shared with the block being assembled and no
+// transaction is executed. Transactions failing during execution would skip
+// the rest of their account, which the order does not account for.
+func (w *worker) PendingTxOrder() ([]common.Hash, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	pending, err := w.fetchPending(w.gasLimit(w.chain.CurrentBlock()))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		signer          = types.NewEIP155Signer(w.config.ChainID)
+		locals, remotes = w.splitLocals(pending)
+		order           []common.Hash
+	)
+	for _, group := range []map[common.Address]types.Transactions{locals, remotes} {
+		if len(group) == 0 {
+			continue
+		}
+		txs := types.NewTransactionsByPriceAndNonce(signer, group)
+		for tx := txs.Peek(); tx != nil; tx = txs.Peek() {
+			order = append(order, tx.Hash())
+			txs.Shift()
+		}
+	}
+	return order, nil
+}
+
 // commitNewWork generates several new sealing tasks based on the parent block.
 func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *types.Block) {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 
+	// A paused validator only takes part in the consensus on others' blocks
+	if w.isRunning() && w.isPaused() {
+		return
+	}
+
 	tstart := time.Now()
 
 	var parent *types.Block
@@ -809,11 +1675,12 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 	header := &types.Header{
 		ParentHash: parent.Hash(),
 		Number:     num.Add(num, common.Big1),
-		GasLimit:   core.CalcGasLimit(parent, w.gasFloor, w.gasCeil),
+		GasLimit:   w.gasLimit(parent),
 		Extra:      w.extra,
 		Time:       big.NewInt(timestamp),
 	}
 	// Only set the coinbase if our consensus engine is running (avoid spurious block rewards)
+	coinbase := w.coinbase
 	if w.isRunning() {
 		/*
 			if w.coinbase == (common.Address{}) {
@@ -821,7 +1688,8 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 				return
 			}
 		*/
-		header.Coinbase = w.coinbase
+		coinbase = w.coinbaseAt(header.Number.Uint64())
+		header.Coinbase = coinbase
 	}
 
 	log.Debug("Begin consensus for new block", "number", header.Number, "gasLimit", header.GasLimit, "parentHash", parent.Hash(), "parentNumber", parent.NumberU64(), "parentStateRoot", parent.Root(), "timestamp", time.Now().UnixNano()/1e6)
@@ -830,7 +1698,7 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 		return
 	}
 
-	header.Coinbase = w.coinbase
+	header.Coinbase = coinbase
 
 	// Could potentially happen if starting to mine in an odd state.
 	err := w.makeCurrent(parent, header)
@@ -838,11 +1706,11 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 		log.Error("Failed to create mining context", "err", err)
 		return
 	}
+	w.gasGriefing.reset(header.Number.Uint64())
 
 	// Fill the block with all available pending transactions.
 	startTime := time.Now()
-	pending, err := w.eth.TxPool().PendingLimited()
-
+	pending, err := w.fetchPending(header.GasLimit)
 	if err != nil {
 		log.Error("Failed to fetch pending transactions", "time", common.PrettyDuration(time.Since(startTime)), "err", err)
 		return
@@ -850,6 +1718,15 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 
 	//log.Info("Fetch pending transactions success", "pendingLength", len(pending), "time", common.PrettyDuration(time.Since(startTime)))
 
+	if len(pending) == 0 && !w.emptyBlockDue(time.Now()) {
+		log.Debug("Delay empty block, transactions arrived recently", "number", header.Number)
+		return
+	}
+	// A new block is assembled, forget the transactions dropped from the last one
+	w.droppedMu.Lock()
+	w.dropped = nil
+	w.droppedMu.Unlock()
+
 	// Short circuit if there is no available pending transactions
 	if len(pending) == 0 {
 		if _, ok := w.engine.(consensus.Istanbul); ok {
@@ -865,25 +1742,19 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 		txsCount = txsCount + len(accTxs)
 	}
 	// Split the pending transactions into locals and remotes
-	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
-	for _, account := range w.eth.TxPool().Locals() {
-		if txs := remoteTxs[account]; len(txs) > 0 {
-			delete(remoteTxs, account)
-			localTxs[account] = txs
-		}
-	}
+	localTxs, remoteTxs := w.splitLocals(pending)
 	log.Debug("execute pending transactions", "localTxCount", len(localTxs), "remoteTxCount", len(remoteTxs), "txsCount", txsCount)
 
 	startTime = time.Now()
 	if len(localTxs) > 0 {
 		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, localTxs)
-		if ok := w.commitTransactionsWithHeader(header, txs, w.coinbase, interrupt); ok {
+		if ok := w.commitTransactionsWithHeader(header, txs, coinbase, interrupt); ok {
 			return
 		}
 	}
 	if len(remoteTxs) > 0 {
 		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, remoteTxs)
-		if ok := w.commitTransactionsWithHeader(header, txs, w.coinbase, interrupt); ok {
+		if ok := w.commitTransactionsWithHeader(header, txs, coinbase, interrupt); ok {
 			return
 		}
 	}
@@ -892,6 +1763,151 @@ func (w *worker) commitNewWork(interrupt *int32, timestamp int64, commitBlock *t
 	w.commit(w.fullTaskHook, true, tstart)
 }
 
+// GetBlockTemplate builds an unsealed block on top of the current head holding
+// txs in the given order, for external block builders. The block is assembled
+// in its own environment, the sealing work of the worker is left untouched. A
+// transaction failing to apply aborts the template.
+func (w *worker) GetBlockTemplate(txs []*types.Transaction, coinbase common.Address) (*types.Block, []*types.Receipt, error) {
+	return w.buildBlock(context.Background(), w.chain.CurrentBlock(), txs, coinbase)
+}
+
+// SimulateBlock executes txs in the given order in a block on top of parent and
+// returns the finalized, unsealed block along with the receipts. Its header root
+// is the resulting state root. The state is kept in memory only, nothing is
+// written to the database. The simulation stops before the next transaction
+// once ctx is done.
+func (w *worker) SimulateBlock(ctx context.Context, txs []*types.Transaction, parent *types.Block) (*types.Block, []*types.Receipt, error) {
+	w.mu.RLock()
+	coinbase := w.coinbase
+	w.mu.RUnlock()
+
+	return w.buildBlock(ctx, parent, txs, coinbase)
+}
+
+// buildBlock executes txs in the given order in an unsealed block on top of
+// parent, paying the fees to coinbase. It gives up with the error of ctx once
+// ctx is done.
+func (w *worker) buildBlock(ctx context.Context, parent *types.Block, txs []*types.Transaction, coinbase common.Address) (*types.Block, []*types.Receipt, error) {
+	w.mu.RLock()
+	extra, gasLimit := w.extra, w.gasLimit(parent)
+	w.mu.RUnlock()
+
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   gasLimit,
+		Extra:      extra,
+		Time:       big.NewInt(time.Now().UnixNano() / 1e6),
+	}
+	if err := w.engine.Prepare(w.chain, header); err != nil {
+		return nil, nil, err
+	}
+	header.Coinbase = coinbase
+
+	state, err := w.chain.StateAt(parent.Root())
+	if err != nil {
+		return nil, nil, err
+	}
+	env := &environment{
+		signer:  types.NewEIP155Signer(w.config.ChainID),
+		state:   state,
+		header:  header,
+		gasPool: new(core.GasPool).AddGas(header.GasLimit),
+	}
+	for i, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		// The state transition checks neither nonces nor the gas of the
+		// transaction, the pool does it for the sealed blocks
+		if err := checkTemplateTx(env, tx); err != nil {
+			return nil, nil, fmt.Errorf("transaction %d (%x) failed: %w", i, tx.Hash(), err)
+		}
+		env.state.Prepare(tx.Hash(), common.Hash{}, env.tcount)
+		if _, err := w.commitTransaction(env, tx, coinbase); err != nil {
+			return nil, nil, fmt.Errorf("transaction %d (%x) failed: %w", i, tx.Hash(), err)
+		}
+		env.tcount++
+	}
+	block, err := w.engine.Finalize(w.chain, header, env.state, env.txs, env.receipts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, env.receipts, nil
+}
+
+// checkTemplateTx checks that tx is the next transaction of its sender and fits
+// in the gas left in the block of env.
+func checkTemplateTx(env *environment, tx *types.Transaction) error {
+	from, err := types.Sender(env.signer, tx)
+	if err != nil {
+		return err
+	}
+	if nonce := env.state.GetNonce(from); tx.Nonce() < nonce {
+		return core.ErrNonceTooLow
+	} else if tx.Nonce() > nonce {
+		return core.ErrNonceTooHigh
+	}
+	if tx.Gas() > env.gasPool.Gas() {
+		return core.ErrGasLimitReached
+	}
+	return nil
+}
+
+// ReplayBlock re-executes the transactions of block on top of the state rooted
+// at stateRoot and returns the resulting receipts and state, for debugging. The
+// state is kept in memory only, nothing is written to the database.
+func (w *worker) ReplayBlock(block *types.Block, stateRoot common.Hash) ([]*types.Receipt, *state.StateDB, error) {
+	statedb, err := w.chain.StateAt(stateRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		header   = block.Header()
+		usedGas  = new(uint64)
+		gp       = new(core.GasPool).AddGas(block.GasLimit())
+		receipts = make([]*types.Receipt, 0, len(block.Transactions()))
+	)
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		receipt, _, err := core.ApplyTransaction(w.config, w.chain, nil, gp, statedb, header, tx, usedGas, vm.Config{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %d (%x) failed: %w", i, tx.Hash(), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, statedb, nil
+}
+
+// SimulateTx applies tx on top of the pending state, like the next block would,
+// and returns its receipt and the data returned by the call. The transaction
+// runs against a copy of the pending environment published by the last commit,
+// which is dropped afterwards, so the block being built is never touched.
+func (w *worker) SimulateTx(tx *types.Transaction) (*types.Receipt, []byte, error) {
+	w.snapshotMu.RLock()
+	if w.snapshotState == nil {
+		w.snapshotMu.RUnlock()
+		return nil, nil, errNoPendingState
+	}
+	var (
+		header  = types.CopyHeader(w.snapshotBlock.Header())
+		statedb = w.snapshotState.Copy()
+		txIndex = len(w.snapshotBlock.Transactions())
+	)
+	w.snapshotMu.RUnlock()
+
+	var (
+		usedGas = header.GasUsed
+		gp      = new(core.GasPool).AddGas(header.GasLimit - header.GasUsed)
+	)
+	statedb.Prepare(tx.Hash(), common.Hash{}, txIndex)
+	receipt, ret, _, err := core.ApplyTransactionWithResult(w.config, w.chain, &header.Coinbase, gp, statedb, header, tx, &usedGas, vm.Config{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return receipt, ret, nil
+}
+
 // commit runs any post-transaction state modifications, assembles the final block
 // and commits new work if consensus engine is running.
 func (w *worker) commit(interval func(), update bool, start time.Time) error {
@@ -17,20 +17,27 @@
 package miner
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/big"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Venachain/Venachain/common"
 	"github.com/Venachain/Venachain/consensus"
 	"github.com/Venachain/Venachain/core"
+	"github.com/Venachain/Venachain/core/rawdb"
+	"github.com/Venachain/Venachain/core/state"
 	"github.com/Venachain/Venachain/core/types"
 	"github.com/Venachain/Venachain/core/vm"
 	"github.com/Venachain/Venachain/crypto"
 	"github.com/Venachain/Venachain/ethdb"
 	"github.com/Venachain/Venachain/event"
 	"github.com/Venachain/Venachain/params"
-	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/Venachain/Venachain/rpc"
 )
 
 var (
@@ -53,17 +60,102 @@ var (
 func init() {
 	testTxPoolConfig = core.DefaultTxPoolConfig
 	testTxPoolConfig.Journal = ""
+	common.SysCfg.ReplayParam = &common.ReplayParam{
+		Pivot:           0,
+		OldSysContracts: make(map[common.Address]string),
+		OldSuperAdmin:   common.NullAddress,
+	}
 	tx1, _ := types.SignTx(types.NewTransaction(0, testUserAddress, big.NewInt(1000), params.TxGas, nil, nil), types.HomesteadSigner{}, testBankKey)
 	pendingTxs = append(pendingTxs, tx1)
 	tx2, _ := types.SignTx(types.NewTransaction(1, testUserAddress, big.NewInt(1000), params.TxGas, nil, nil), types.HomesteadSigner{}, testBankKey)
 	newTxs = append(newTxs, tx2)
 }
 
+// testEngine is an Istanbul consensus engine accepting any block, sealing each
+// block as soon as it is handed over.
+type testEngine struct {
+	paused int32 // Whether ShouldSeal holds back new blocks (atomic)
+
+	reorgFeed  event.Feed
+	valSetFeed event.Feed
+}
+
+func newTestEngine() *testEngine { return new(testEngine) }
+
+func (e *testEngine) ShouldSeal() bool { return atomic.LoadInt32(&e.paused) == 0 }
+
+func (e *testEngine) Start(chain consensus.ChainReader, currentBlock func() *types.Block) error {
+	return nil
+}
+
+func (e *testEngine) Stop() error { return nil }
+
+func (e *testEngine) SubscribeReorgEvents(ch chan<- consensus.ReorgEvent) event.Subscription {
+	return e.reorgFeed.Subscribe(ch)
+}
+
+func (e *testEngine) SubscribeValidatorSetEvents(ch chan<- consensus.ValidatorSetEvent) event.Subscription {
+	return e.valSetFeed.Subscribe(ch)
+}
+
+func (e *testEngine) ValidatorsAt(chain consensus.ChainReader, number uint64) ([]common.Address, error) {
+	return []common.Address{testBankAddress}, nil
+}
+
+func (e *testEngine) GetPendingRound() (uint64, error) { return 0, nil }
+
+func (e *testEngine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+func (e *testEngine) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return nil
+}
+
+func (e *testEngine) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+	for range headers {
+		results <- nil
+	}
+	return abort, results
+}
+
+func (e *testEngine) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return nil
+}
+
+func (e *testEngine) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	return nil
+}
+
+func (e *testEngine) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = state.IntermediateRoot(true)
+	return types.NewBlock(header, txs, receipts), nil
+}
+
+func (e *testEngine) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	go func() {
+		select {
+		case results <- block:
+		case <-stop:
+		}
+	}()
+	return nil, nil
+}
+
+func (e *testEngine) SealHash(header *types.Header) common.Hash {
+	return header.Hash()
+}
+
+func (e *testEngine) APIs(chain consensus.ChainReader) []rpc.API { return nil }
+func (e *testEngine) Close() error                               { return nil }
+
 // testWorkerBackend implements worker.Backend interfaces and wraps all information needed during the testing.
 type testWorkerBackend struct {
 	db         ethdb.Database
 	txPool     *core.TxPool
 	chain      *core.BlockChain
+	cache      *core.BlockChainCache
 	testTxFeed event.Feed
 }
 
@@ -71,24 +163,23 @@ func newTestWorkerBackend(t *testing.T, chainConfig *params.ChainConfig, engine
 	var (
 		db    = ethdb.NewMemDatabase()
 		gspec = core.Genesis{
-			Config: chainConfig,
-			Alloc:  core.GenesisAlloc{testBankAddress: {Balance: testBankFunds}},
+			Config:   chainConfig,
+			GasLimit: params.GenesisGasLimit,
+			Alloc:    core.GenesisAlloc{testBankAddress: {Balance: testBankFunds}},
 		}
 	)
-
-	switch engine.(type) {
-	case *clique.Clique:
-		gspec.ExtraData = make([]byte, 32+common.AddressLength+65)
-		copy(gspec.ExtraData[32:], testBankAddress[:])
-	default:
-		t.Fatalf("unexpected consensus engine type: %T", engine)
-	}
 	genesis := gspec.MustCommit(db)
+	// Forget the sealed transactions of the chains of earlier tests
+	rawdb.SetTxLookupEntryCache(genesis)
 
-	chain, _ := core.NewBlockChain(db, nil, gspec.Config, engine, vm.Config{}, nil)
-	txpool := core.NewTxPool(testTxPoolConfig, chainConfig, chain)
+	chain, _, err := core.NewBlockChain(db, nil, nil, gspec.Config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create the chain: %v", err)
+	}
+	cache := core.NewBlockChainCache(chain)
+	txpool := core.NewTxPool(testTxPoolConfig, chainConfig, cache, db, nil, testBankKey)
 
-	// Generate a small n-block chain and an uncle block for it
+	// Generate a small n-block chain
 	if n > 0 {
 		blocks, _ := core.GenerateChain(chainConfig, genesis, engine, db, n, func(i int, gen *core.BlockGen) {
 			gen.SetCoinbase(testBankAddress)
@@ -101,12 +192,14 @@ func newTestWorkerBackend(t *testing.T, chainConfig *params.ChainConfig, engine
 	return &testWorkerBackend{
 		db:     db,
 		chain:  chain,
+		cache:  cache,
 		txPool: txpool,
 	}
 }
 
 func (b *testWorkerBackend) BlockChain() *core.BlockChain { return b.chain }
 func (b *testWorkerBackend) TxPool() *core.TxPool         { return b.txPool }
+func (b *testWorkerBackend) ExtendedDb() ethdb.Database   { return nil }
 func (b *testWorkerBackend) PostChainEvents(events []interface{}) {
 	b.chain.PostChainEvents(events, nil)
 }
@@ -114,11 +207,24 @@ func (b *testWorkerBackend) PostChainEvents(events []interface{}) {
 func newTestWorker(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine, blocks int) (*worker, *testWorkerBackend) {
 	backend := newTestWorkerBackend(t, chainConfig, engine, blocks)
 	backend.txPool.AddLocals(pendingTxs)
-	w := newWorker(chainConfig, engine, backend, new(event.TypeMux), time.Second, params.GenesisGasLimit, params.GenesisGasLimit, nil)
+	w := newWorker(chainConfig, engine, backend, new(event.TypeMux), time.Second, defaultCommitRatio, params.GenesisGasLimit, params.GenesisGasLimit, nil, make(chan *types.Block), backend.cache)
 	w.setEtherbase(testBankAddress)
 	return w, backend
 }
 
+// waitInitialWork blocks until the work submitted on the creation of the worker
+// is assembled, so tests assembling blocks by hand do not race with it.
+func waitInitialWork(t *testing.T, w *worker) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if block, _ := w.pending(); block != nil {
+			w.mu.Lock()
+			w.mu.Unlock()
+			return
+		}
+	}
+	t.Fatalf("initial work not assembled")
+}
+
 func testPendingStateAndBlock(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
 	defer engine.Close()
 
@@ -347,3 +453,1294 @@ func testAdjustInterval(t *testing.T, chainConfig *params.ChainConfig, engine co
 		t.Error("interval reset timeout")
 	}
 }
+
+func TestSetExtra(t *testing.T) {
+	w := &worker{}
+	extra := make([]byte, types.IstanbulExtraVanity)
+	if err := w.setExtra(extra); err != nil {
+		t.Fatalf("failed to set extra of %d bytes: %v", len(extra), err)
+	}
+	if err := w.setExtra(append(extra, 0x01)); !errors.Is(err, errExtraTooLong) {
+		t.Fatalf("error mismatch: have %v, want %v", err, errExtraTooLong)
+	}
+	if len(w.extra) != types.IstanbulExtraVanity {
+		t.Fatalf("rejected extra was set: have %d bytes, want %d", len(w.extra), types.IstanbulExtraVanity)
+	}
+}
+
+func TestSetTxBatchSize(t *testing.T) {
+	w := new(worker)
+	for _, tt := range []struct{ size, want int }{{0, 1}, {1, 1}, {32, 32}, {maxTxBatchSize + 1, maxTxBatchSize}} {
+		w.SetTxBatchSize(tt.size)
+		if w.txBatchSize != tt.want {
+			t.Errorf("batch size mismatch for %d: have %d, want %d", tt.size, w.txBatchSize, tt.want)
+		}
+	}
+}
+
+func TestCoinbaseRotation(t *testing.T) {
+	w := &worker{coinbase: testBankAddress}
+	if have := w.coinbaseAt(7); have != testBankAddress {
+		t.Errorf("coinbase mismatch without rotation: have %x, want %x", have, testBankAddress)
+	}
+
+	addrs := []common.Address{{0x01}, {0x02}, {0x03}}
+	w.SetCoinbaseRotation(addrs)
+	for number := uint64(0); number < 2*uint64(len(addrs)); number++ {
+		if have, want := w.coinbaseAt(number), addrs[number%uint64(len(addrs))]; have != want {
+			t.Errorf("block %d: coinbase mismatch: have %x, want %x", number, have, want)
+		}
+	}
+
+	w.SetCoinbaseRotation(nil)
+	if have := w.coinbaseAt(7); have != testBankAddress {
+		t.Errorf("coinbase mismatch after clearing rotation: have %x, want %x", have, testBankAddress)
+	}
+}
+
+func TestSetCommitRatio(t *testing.T) {
+	w := &worker{recommit: 3 * time.Second, commitRatio: 1}
+	w.commitDuration = calcCommitDuration(w.recommit, w.commitRatio)
+	full := w.commitDuration
+
+	w.SetCommitRatio(0.5)
+	if w.commitDuration != full/2 {
+		t.Errorf("commit duration mismatch: have %d, want %d", w.commitDuration, full/2)
+	}
+	// Ratios out of (0, 1] are ignored
+	for _, r := range []float64{0, -0.5, 1.5} {
+		w.SetCommitRatio(r)
+		if w.commitRatio != 0.5 || w.commitDuration != full/2 {
+			t.Errorf("ratio %v: commit ratio changed to %v (%d ms)", r, w.commitRatio, w.commitDuration)
+		}
+	}
+}
+
+func TestPendingGas(t *testing.T) {
+	w := &worker{gasCeil: 8000000}
+	if have := w.PendingGasRemaining(); have != w.gasCeil {
+		t.Errorf("remaining gas mismatch without block: have %d, want %d", have, w.gasCeil)
+	}
+	if have := w.PendingGasLimit(); have != w.gasCeil {
+		t.Errorf("gas limit mismatch without block: have %d, want %d", have, w.gasCeil)
+	}
+
+	w.current = &environment{header: &types.Header{GasLimit: 5000000}}
+	if have := w.PendingGasRemaining(); have != w.gasCeil {
+		t.Errorf("remaining gas mismatch without gas pool: have %d, want %d", have, w.gasCeil)
+	}
+	if have := w.PendingGasLimit(); have != 5000000 {
+		t.Errorf("gas limit mismatch: have %d, want %d", have, 5000000)
+	}
+
+	w.current.gasPool = new(core.GasPool).AddGas(1200000)
+	if have := w.PendingGasRemaining(); have != 1200000 {
+		t.Errorf("remaining gas mismatch: have %d, want %d", have, 1200000)
+	}
+}
+
+func TestEmptyBlockDelay(t *testing.T) {
+	produceEmptyBlock := common.SysCfg.SysParam.IsProduceEmptyBlock
+	defer func() { common.SysCfg.SysParam.IsProduceEmptyBlock = produceEmptyBlock }()
+	common.SysCfg.SysParam.IsProduceEmptyBlock = true
+
+	var (
+		w     = new(worker)
+		start = time.Now()
+	)
+	if !w.emptyBlockDue(start) {
+		t.Fatalf("empty block delayed without a delay set")
+	}
+	w.SetEmptyBlockDelay(5 * time.Second)
+	if !w.emptyBlockDue(start) {
+		t.Fatalf("empty block delayed without any transaction arrival")
+	}
+
+	tests := []struct {
+		arrival time.Duration // Offset of a transaction arrival, negative for none
+		now     time.Duration // Offset of the check
+		due     bool
+	}{
+		{arrival: 0, now: 0, due: false},
+		{arrival: -1, now: 4 * time.Second, due: false},
+		{arrival: -1, now: 5 * time.Second, due: true},
+		// A new arrival restarts the delay window
+		{arrival: 6 * time.Second, now: 7 * time.Second, due: false},
+		{arrival: -1, now: 10 * time.Second, due: false},
+		{arrival: -1, now: 11 * time.Second, due: true},
+	}
+	for i, tt := range tests {
+		if tt.arrival >= 0 {
+			atomic.StoreInt64(&w.lastTxArrival, start.Add(tt.arrival).UnixNano())
+		}
+		if have := w.emptyBlockDue(start.Add(tt.now)); have != tt.due {
+			t.Errorf("test %d: empty block due mismatch: have %v, want %v", i, have, tt.due)
+		}
+	}
+
+	// The delay only applies if empty blocks are produced at all
+	common.SysCfg.SysParam.IsProduceEmptyBlock = false
+	if !w.emptyBlockDue(start) {
+		t.Errorf("empty block delayed while empty blocks are disabled")
+	}
+}
+
+func TestPendingFetchCount(t *testing.T) {
+	tests := []struct {
+		limit    int
+		gasLimit uint64
+		count    int
+	}{
+		{0, 100 * params.TxGas, 0},                     // no limit set
+		{50, 100 * params.TxGas, 50},                   // the block holds more than the limit
+		{1000, 100 * params.TxGas, 125},                // bounded by the block plus the margin
+		{1000, 100*params.TxGas + params.TxGas/2, 125}, // partial transactions don't count
+		{120, 100 * params.TxGas, 120},                 // the margin doesn't raise the limit
+		{10, 0, 1},                                     // at least one transaction is fetched
+	}
+	for i, tt := range tests {
+		w := &worker{gasPredictor: NewGasUsagePredictor(gasPredictorSamples)}
+		w.SetPendingFetchLimit(tt.limit)
+		if have := w.pendingFetchCount(tt.gasLimit); have != tt.count {
+			t.Errorf("test %d: fetch count mismatch: have %d, want %d", i, have, tt.count)
+		}
+	}
+	// Recent blocks of transactions using twice the intrinsic gas halve the fetch
+	w := &worker{gasPredictor: NewGasUsagePredictor(gasPredictorSamples)}
+	w.gasPredictor.Add(10*2*params.TxGas, 10)
+	w.SetPendingFetchLimit(1000)
+	if have := w.pendingFetchCount(100 * params.TxGas); have != 62 {
+		t.Errorf("predicted fetch count mismatch: have %d, want %d", have, 62)
+	}
+}
+
+// Tests that the pending fetch limit still applies with a minimum gas price set.
+func TestPendingFetchLimitMinGasPrice(t *testing.T) {
+	w, b := newTestWorker(t, params.TestChainConfig, newTestEngine(), 0)
+	defer w.close()
+
+	// The backend already holds a free transaction of the bank at nonce 0
+	var txs []*types.Transaction
+	for nonce := uint64(1); nonce <= 10; nonce++ {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, testUserAddress, big.NewInt(1000), params.TxGas, big.NewInt(2), nil), types.HomesteadSigner{}, testBankKey)
+		txs = append(txs, tx)
+	}
+	b.txPool.AddLocals(txs)
+
+	w.SetPendingFetchLimit(3)
+	w.SetMinGasPrice(new(big.Int))
+	count := func() int {
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+
+		pending, err := w.fetchPending(100 * params.TxGas)
+		if err != nil {
+			t.Fatalf("failed to fetch pending transactions: %v", err)
+		}
+		count := 0
+		for _, txs := range pending {
+			count += len(txs)
+		}
+		return count
+	}
+	if have := count(); have != 3 {
+		t.Errorf("fetched transaction count mismatch: have %d, want %d", have, 3)
+	}
+	// The minimum gas price still filters along with the limit
+	w.SetMinGasPrice(big.NewInt(1))
+	if have := count(); have != 0 {
+		t.Errorf("underpriced transaction count mismatch: have %d, want %d", have, 0)
+	}
+}
+
+// Tests that the predicted number of transactions fitting a block does not cut
+// the block short of its gas.
+func TestGasPredictionHint(t *testing.T) {
+	w, _ := newTestWorker(t, params.TestChainConfig, newTestEngine(), 0)
+	defer w.close()
+	waitInitialWork(t, w)
+
+	// The recent blocks held a single transaction each
+	parent := w.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   10 * params.TxGas,
+		Time:       big.NewInt(time.Now().Unix()),
+	}
+	w.gasPredictor.Add(header.GasLimit, 1)
+
+	var transfers types.Transactions
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, testUserAddress, big.NewInt(1000), params.TxGas, nil, nil), types.HomesteadSigner{}, testBankKey)
+		transfers = append(transfers, tx)
+	}
+	if err := w.makeCurrent(parent, header); err != nil {
+		t.Fatalf("failed to create mining context: %v", err)
+	}
+	txs := types.NewTransactionsByPriceAndNonce(w.current.signer, map[common.Address]types.Transactions{testBankAddress: transfers})
+	w.commitTransactionsWithHeader(header, txs, testBankAddress, nil)
+	if len(w.current.txs) != len(transfers) {
+		t.Errorf("included transaction count mismatch: have %d, want %d", len(w.current.txs), len(transfers))
+	}
+}
+
+func TestSimulateTx(t *testing.T) {
+	// The contract below is EVM bytecode
+	w := &worker{config: &params.ChainConfig{ChainID: big.NewInt(1), VMInterpreter: "evm"}}
+	tx, _ := types.SignTx(types.NewTransaction(0, common.Address{0xcc}, new(big.Int), 100000, new(big.Int), nil), types.MakeSigner(w.config), testBankKey)
+
+	if _, _, err := w.SimulateTx(tx); err != errNoPendingState {
+		t.Fatalf("error mismatch without pending state: have %v, want %v", err, errNoPendingState)
+	}
+	// Publish a pending state holding a contract storing 1 at slot 0 and
+	// returning 42, in a block fitting the gas every transaction reserves
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	statedb.SetCode(common.Address{0xcc}, common.Hex2Bytes("6001600055602a60005260206000f3"))
+	w.snapshotBlock = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), GasLimit: uint64(common.SysCfg.GetBlockGasLimit())})
+	w.snapshotState = statedb
+
+	_, pending := w.pending()
+	root := pending.IntermediateRoot(true)
+
+	receipt, ret, err := w.SimulateTx(tx)
+	if err != nil {
+		t.Fatalf("failed to simulate transaction: %v", err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful || receipt.GasUsed <= params.TxGas {
+		t.Errorf("receipt mismatch: status %d, gas used %d", receipt.Status, receipt.GasUsed)
+	}
+	if want := common.LeftPadBytes([]byte{42}, 32); !reflect.DeepEqual(ret, want) {
+		t.Errorf("return data mismatch: have %x, want %x", ret, want)
+	}
+	// The pending state is left untouched
+	_, pending = w.pending()
+	if nonce := pending.GetNonce(testBankAddress); nonce != 0 {
+		t.Errorf("pending nonce changed: have %d, want 0", nonce)
+	}
+	if have := pending.IntermediateRoot(true); have != root {
+		t.Errorf("pending state changed: have root %x, want %x", have, root)
+	}
+}
+
+func TestLastBlockDropped(t *testing.T) {
+	w := new(worker)
+	w.recordDropped(common.Hash{0x01}, DropNonceTooLow)
+	w.recordDropped(common.Hash{0x02}, DropGasLimit)
+
+	dropped := w.LastBlockDropped()
+	want := []DroppedTx{{common.Hash{0x01}, DropNonceTooLow}, {common.Hash{0x02}, DropGasLimit}}
+	if !reflect.DeepEqual(dropped, want) {
+		t.Fatalf("dropped transactions mismatch: have %v, want %v", dropped, want)
+	}
+	// The returned slice is a copy
+	dropped[0].Reason = DropNonceTooHigh
+	if have := w.LastBlockDropped()[0].Reason; have != DropNonceTooLow {
+		t.Errorf("dropped reason changed through the returned slice: have %q, want %q", have, DropNonceTooLow)
+	}
+}
+
+func TestCoinbaseRotationSealing(t *testing.T) {
+	testCoinbaseRotationSealing(t, params.TestChainConfig, newTestEngine())
+}
+
+func testCoinbaseRotationSealing(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	addrs := []common.Address{{0x01}, {0x02}, {0x03}}
+	w.SetCoinbaseRotation(addrs)
+
+	w.start()
+	for n := uint64(1); n <= uint64(2*len(addrs)); n++ {
+		b.txPool.AddLocals(newTxs)
+		if _, err := w.WaitForBlock(n, 3*time.Second); err != nil {
+			t.Fatalf("failed waiting for block %d: %v", n, err)
+		}
+		block := b.chain.GetBlockByNumber(n)
+		if want := addrs[n%uint64(len(addrs))]; block.Coinbase() != want {
+			t.Errorf("block %d: coinbase mismatch: have %x, want %x", n, block.Coinbase(), want)
+		}
+	}
+}
+
+func TestCoinbaseHook(t *testing.T) {
+	w := &worker{coinbase: testBankAddress}
+
+	addrs := []common.Address{{0x01}, {0x02}, {0x03}}
+	next := 0
+	w.setCoinbaseHook(func() common.Address {
+		addr := addrs[next%len(addrs)]
+		next++
+		return addr
+	})
+	for number := uint64(0); number < 2*uint64(len(addrs)); number++ {
+		if have, want := w.coinbaseAt(number), addrs[number%uint64(len(addrs))]; have != want {
+			t.Errorf("block %d: coinbase mismatch: have %x, want %x", number, have, want)
+		}
+	}
+
+	// An empty address falls back to the etherbase
+	w.setCoinbaseHook(func() common.Address { return common.Address{} })
+	if have := w.coinbaseAt(7); have != testBankAddress {
+		t.Errorf("coinbase mismatch on empty hook result: have %x, want %x", have, testBankAddress)
+	}
+}
+
+func TestGasLimitOverride(t *testing.T) {
+	w := &worker{gasFloor: 1000000, gasCeil: 8000000}
+	parent := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), GasLimit: 4000000})
+
+	for _, tt := range []struct {
+		override, want uint64
+	}{
+		{5000000, 5000000},
+		{500000, 1000000},  // clamped to the floor
+		{9000000, 8000000}, // clamped to the ceiling
+		{0, core.CalcGasLimit(parent, w.gasFloor, w.gasCeil)},
+	} {
+		w.SetGasLimitOverride(tt.override)
+		if have := w.gasLimit(parent); have != tt.want {
+			t.Errorf("override %d: gas limit mismatch: have %d, want %d", tt.override, have, tt.want)
+		}
+	}
+}
+
+func TestGasLimitOverrideSealing(t *testing.T) {
+	testGasLimitOverrideSealing(t, params.TestChainConfig, newTestEngine())
+}
+
+func testGasLimitOverrideSealing(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	// Lower the floor so the override below the genesis gas limit is kept
+	override := params.GenesisGasLimit - 1000
+	w.mu.Lock()
+	w.gasFloor = 0
+	w.mu.Unlock()
+	w.SetGasLimitOverride(override)
+
+	w.start()
+	b.txPool.AddLocals(newTxs)
+	block, err := w.WaitForBlock(1, 3*time.Second)
+	if err != nil {
+		t.Fatalf("failed waiting for a sealed block: %v", err)
+	}
+	if block.GasLimit() != override {
+		t.Errorf("gas limit mismatch: have %d, want %d", block.GasLimit(), override)
+	}
+}
+
+func TestGetBlockTemplate(t *testing.T) {
+	testGetBlockTemplate(t, params.TestChainConfig, newTestEngine())
+}
+
+func testGetBlockTemplate(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+	waitInitialWork(t, w)
+
+	signTx := func(nonce uint64, gas uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, testUserAddress, big.NewInt(1000), gas, nil, nil), types.HomesteadSigner{}, testBankKey)
+		return tx
+	}
+	// The sealing environment is replaced under the worker lock
+	currentEnv := func() *environment {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.current
+	}
+	current := currentEnv()
+	coinbase := common.Address{0x42}
+
+	// A valid pre-ordered list is included as is
+	txs := []*types.Transaction{signTx(0, params.TxGas), signTx(1, params.TxGas)}
+	block, receipts, err := w.GetBlockTemplate(txs, coinbase)
+	if err != nil {
+		t.Fatalf("failed to build block template: %v", err)
+	}
+	if len(block.Transactions()) != len(txs) || len(receipts) != len(txs) {
+		t.Fatalf("transaction count mismatch: have %d txs, %d receipts, want %d", len(block.Transactions()), len(receipts), len(txs))
+	}
+	for i, tx := range block.Transactions() {
+		if tx.Hash() != txs[i].Hash() {
+			t.Errorf("transaction %d: hash mismatch: have %x, want %x", i, tx.Hash(), txs[i].Hash())
+		}
+	}
+	if block.Coinbase() != coinbase {
+		t.Errorf("coinbase mismatch: have %x, want %x", block.Coinbase(), coinbase)
+	}
+	if block.NumberU64() != w.chain.CurrentBlock().NumberU64()+1 {
+		t.Errorf("block number mismatch: have %d, want %d", block.NumberU64(), w.chain.CurrentBlock().NumberU64()+1)
+	}
+
+	// A nonce gap aborts the template
+	_, _, err = w.GetBlockTemplate([]*types.Transaction{signTx(0, params.TxGas), signTx(2, params.TxGas)}, coinbase)
+	if !errors.Is(err, core.ErrNonceTooHigh) {
+		t.Errorf("nonce gap error mismatch: have %v, want %v", err, core.ErrNonceTooHigh)
+	}
+
+	// So does a transaction exceeding the block gas limit
+	_, _, err = w.GetBlockTemplate([]*types.Transaction{signTx(0, block.GasLimit()+1)}, coinbase)
+	if !errors.Is(err, core.ErrGasLimitReached) {
+		t.Errorf("gas limit error mismatch: have %v, want %v", err, core.ErrGasLimitReached)
+	}
+
+	if currentEnv() != current {
+		t.Errorf("block template modified the current sealing environment")
+	}
+}
+
+func TestReplayBlock(t *testing.T) {
+	testReplayBlock(t, params.TestChainConfig, newTestEngine())
+}
+
+func testReplayBlock(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	genesis := b.chain.CurrentBlock()
+	blocks, _ := core.GenerateChain(chainConfig, genesis, engine, b.db, 1, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(testBankAddress)
+		gen.AddTx(pendingTxs[0])
+	})
+	if _, err := b.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	block := b.chain.GetBlockByNumber(1)
+
+	receipts, statedb, err := w.ReplayBlock(block, genesis.Root())
+	if err != nil {
+		t.Fatalf("failed to replay block: %v", err)
+	}
+	want := b.chain.GetReceiptsByHash(block.Hash())
+	if len(receipts) != len(want) {
+		t.Fatalf("receipt count mismatch: have %d, want %d", len(receipts), len(want))
+	}
+	for i, receipt := range receipts {
+		if receipt.TxHash != want[i].TxHash {
+			t.Errorf("receipt %d: tx hash mismatch: have %x, want %x", i, receipt.TxHash, want[i].TxHash)
+		}
+		if receipt.Status != want[i].Status {
+			t.Errorf("receipt %d: status mismatch: have %d, want %d", i, receipt.Status, want[i].Status)
+		}
+		if receipt.CumulativeGasUsed != want[i].CumulativeGasUsed {
+			t.Errorf("receipt %d: cumulative gas mismatch: have %d, want %d", i, receipt.CumulativeGasUsed, want[i].CumulativeGasUsed)
+		}
+		if len(receipt.Logs) != len(want[i].Logs) {
+			t.Errorf("receipt %d: log count mismatch: have %d, want %d", i, len(receipt.Logs), len(want[i].Logs))
+		}
+	}
+	if have := statedb.GetBalance(testUserAddress); have.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("replayed balance mismatch: have %v, want %v", have, 1000)
+	}
+	// The replay must not touch the canonical chain
+	if head := b.chain.CurrentBlock(); head.Hash() != block.Hash() {
+		t.Errorf("chain head changed: have %x, want %x", head.Hash(), block.Hash())
+	}
+}
+
+func TestSimulateBlock(t *testing.T) {
+	testSimulateBlock(t, params.TestChainConfig, newTestEngine())
+}
+
+func testSimulateBlock(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	genesis := b.chain.CurrentBlock()
+	blocks, _ := core.GenerateChain(chainConfig, genesis, engine, b.db, 1, func(i int, gen *core.BlockGen) {
+		gen.AddTx(pendingTxs[0])
+	})
+	if _, err := b.chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	block := b.chain.GetBlockByNumber(1)
+
+	// Simulating the transactions of the canonical block yields its root
+	w.setEtherbase(block.Coinbase())
+	simulated, receipts, err := w.SimulateBlock(context.Background(), block.Transactions(), genesis)
+	if err != nil {
+		t.Fatalf("failed to simulate block: %v", err)
+	}
+	if simulated.Root() != block.Root() {
+		t.Errorf("state root mismatch: have %x, want %x", simulated.Root(), block.Root())
+	}
+	if len(receipts) != len(block.Transactions()) {
+		t.Errorf("receipt count mismatch: have %d, want %d", len(receipts), len(block.Transactions()))
+	}
+	// Nothing may be written, neither the chain nor the resulting state
+	if head := b.chain.CurrentBlock(); head.Hash() != block.Hash() {
+		t.Errorf("chain head changed: have %x, want %x", head.Hash(), block.Hash())
+	}
+	if has := b.chain.GetBlockByHash(simulated.Hash()); has != nil {
+		t.Errorf("simulated block was written")
+	}
+	// A cancelled simulation gives up before executing anything
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := w.SimulateBlock(ctx, block.Transactions(), genesis); err != context.Canceled {
+		t.Errorf("cancelled simulation error mismatch: have %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPostWriteHook(t *testing.T) {
+	testPostWriteHook(t, params.TestChainConfig, newTestEngine())
+}
+
+func testPostWriteHook(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	headCh := make(chan core.ChainHeadEvent, 1)
+	sub := b.chain.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+
+	written := make(chan common.Hash, 1)
+	w.setPostWriteHook(func(block *types.Block, receipts []*types.Receipt) {
+		if !b.chain.HasBlock(block.Hash(), block.NumberU64()) {
+			t.Errorf("hook ran before block %x was written", block.Hash())
+		}
+		if len(receipts) != len(block.Transactions()) {
+			t.Errorf("receipt count mismatch: have %d, want %d", len(receipts), len(block.Transactions()))
+		}
+		select {
+		case written <- block.Hash():
+		default:
+		}
+	})
+	w.start()
+
+	select {
+	case ev := <-headCh:
+		// The hook runs synchronously before the chain events are posted
+		select {
+		case hash := <-written:
+			if hash != ev.Block.Hash() {
+				t.Errorf("hooked block mismatch: have %x, want %x", hash, ev.Block.Hash())
+			}
+		default:
+			t.Errorf("chain head event posted before the hook ran")
+		}
+	case <-time.NewTimer(3 * time.Second).C:
+		t.Fatal("timeout waiting for sealed block")
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	testHealthCheck(t, params.TestChainConfig, newTestEngine())
+}
+
+func testHealthCheck(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, chainConfig, engine, 0)
+
+	time.Sleep(2 * heartbeatInterval)
+	for name, alive := range w.HealthCheck() {
+		if !alive {
+			t.Errorf("goroutine %s reported dead while running", name)
+		}
+	}
+	// Stopping all goroutines must be noticed within 4 seconds
+	w.close()
+	deadline := time.Now().Add(4*heartbeatInterval + heartbeatInterval/2)
+	for {
+		health := w.HealthCheck()
+		dead := 0
+		for _, alive := range health {
+			if !alive {
+				dead++
+			}
+		}
+		if dead == len(health) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("exited goroutines not detected: %v", health)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestSubscribeSealedBlock(t *testing.T) {
+	testSubscribeSealedBlock(t, params.TestChainConfig, newTestEngine())
+}
+
+func testSubscribeSealedBlock(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	sealedCh := make(chan *types.Block, 1)
+	sub := w.SubscribeSealedBlock(sealedCh)
+	w.start()
+
+	select {
+	case block := <-sealedCh:
+		if canon := b.chain.GetBlockByNumber(block.NumberU64()); canon == nil || canon.Hash() != block.Hash() {
+			t.Errorf("sealed block %x delivered before becoming canonical", block.Hash())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting for a sealed block")
+	}
+	w.stop()
+
+	// Unsubscribing must end the delivery
+	sub.Unsubscribe()
+	if _, ok := <-sub.Err(); ok {
+		t.Errorf("subscription error channel not closed")
+	}
+	if nsent := w.sealedBlockFeed.Send(b.chain.CurrentBlock()); nsent != 0 {
+		t.Errorf("block delivered to %d subscribers after unsubscribing", nsent)
+	}
+}
+
+func TestWaitForBlock(t *testing.T) {
+	testWaitForBlock(t, params.TestChainConfig, newTestEngine())
+}
+
+func testWaitForBlock(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	// Nothing is sealed while the worker is stopped
+	if _, err := w.WaitForBlock(1, 100*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("error mismatch: have %v, want %v", err, context.DeadlineExceeded)
+	}
+	w.start()
+	b.txPool.AddLocals(newTxs)
+	block, err := w.WaitForBlock(1, 3*time.Second)
+	if err != nil {
+		t.Fatalf("failed waiting for block 1: %v", err)
+	}
+	if block.NumberU64() < 1 {
+		t.Errorf("block number mismatch: have %d, want at least 1", block.NumberU64())
+	}
+	// A block already reached is returned right away
+	if _, err := w.WaitForBlock(1, 0); err != nil {
+		t.Errorf("failed waiting for a past block: %v", err)
+	}
+}
+
+func TestPauseProposing(t *testing.T) {
+	testPauseProposing(t, params.TestChainConfig, newTestEngine())
+}
+
+func testPauseProposing(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	taskCh := make(chan struct{}, 10)
+	w.newTaskHook = func(task *task) {
+		taskCh <- struct{}{}
+	}
+	w.PauseProposing()
+	w.start()
+	if !w.isRunning() {
+		t.Fatalf("paused worker not running")
+	}
+	// No sealing task is produced while paused, not even on resubmitting
+	w.commitNewWork(nil, time.Now().Unix(), nil)
+	select {
+	case <-taskCh:
+		t.Fatalf("sealing task pushed while paused")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	// Resuming restores the block production on the next turn of the validator
+	w.ResumeProposing()
+	select {
+	case <-taskCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no sealing task pushed after resuming")
+	}
+}
+
+// giveUpEngine is a test engine giving up on sealing the first block.
+type giveUpEngine struct {
+	*testEngine
+	gaveUp int32 // Whether the first block was given up already (atomic)
+}
+
+func (e *giveUpEngine) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	if atomic.CompareAndSwapInt32(&e.gaveUp, 0, 1) {
+		go func() {
+			select {
+			case results <- nil:
+			case <-stop:
+			}
+		}()
+		return nil, nil
+	}
+	return e.testEngine.Seal(chain, block, results, stop)
+}
+
+// Tests that a block the engine gave up sealing is resubmitted right away
+// instead of on the next turn of the recommit timer.
+func TestResubmitGivenUpSeal(t *testing.T) {
+	engine := &giveUpEngine{testEngine: newTestEngine()}
+	w, _ := newTestWorker(t, params.TestChainConfig, engine, 0)
+	defer w.close()
+
+	taskCh := make(chan time.Time, 10)
+	w.newTaskHook = func(task *task) {
+		taskCh <- time.Now()
+	}
+	w.start()
+
+	var first time.Time
+	select {
+	case first = <-taskCh:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no sealing task pushed")
+	}
+	select {
+	case second := <-taskCh:
+		if elapsed := second.Sub(first); elapsed > 300*time.Millisecond {
+			t.Errorf("given up block resubmitted late: after %v", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("given up block not resubmitted")
+	}
+}
+
+func TestMinGasPrice(t *testing.T) {
+	testMinGasPrice(t, params.TestChainConfig, newTestEngine())
+}
+
+func testMinGasPrice(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	// The test transactions are free, so none of them may be included
+	floor := big.NewInt(1)
+	w.SetMinGasPrice(floor)
+	w.start()
+	b.txPool.AddLocals(newTxs)
+
+	check := func(block *types.Block) {
+		for _, tx := range block.Transactions() {
+			if tx.GasPrice().Cmp(floor) < 0 {
+				t.Errorf("block %d includes transaction %x priced %v below %v", block.NumberU64(), tx.Hash(), tx.GasPrice(), floor)
+			}
+		}
+	}
+	// Empty blocks may not be sealed at all
+	if block, err := w.WaitForBlock(1, time.Second); err == nil {
+		check(block)
+	} else if err != context.DeadlineExceeded {
+		t.Fatalf("failed waiting for a sealed block: %v", err)
+	}
+	if block := w.pendingBlock(); block != nil {
+		check(block)
+	}
+}
+
+func TestSealedCallbacksOrder(t *testing.T) {
+	w := &worker{}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)})
+
+	var order []int
+	for i := 0; i < 3; i++ {
+		i := i
+		w.OnBlockSealed(func(b *types.Block) {
+			if b != block {
+				t.Errorf("callback %d: block mismatch", i)
+			}
+			order = append(order, i)
+		})
+	}
+	runSealedCallbacks(w.sealedCallbacks, block)
+
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("callback order mismatch: have %v, want [0 1 2]", order)
+	}
+}
+
+func TestSealedCallbacksTimeout(t *testing.T) {
+	w := &worker{}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)})
+
+	release := make(chan struct{})
+	defer close(release)
+	w.OnBlockSealed(func(*types.Block) { <-release })
+
+	ran := make(chan struct{})
+	w.OnBlockSealed(func(*types.Block) { close(ran) })
+
+	start := time.Now()
+	go runSealedCallbacks(w.sealedCallbacks, block)
+	select {
+	case <-ran:
+		if elapsed := time.Since(start); elapsed < sealedCallbackTimeout {
+			t.Errorf("next callback started before the timeout: after %v", elapsed)
+		}
+	case <-time.After(3 * sealedCallbackTimeout):
+		t.Fatal("stuck callback blocked the next one")
+	}
+}
+
+func TestOnBlockSealed(t *testing.T) {
+	testOnBlockSealed(t, params.TestChainConfig, newTestEngine())
+}
+
+func testOnBlockSealed(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	sealed := make(chan *types.Block, 1)
+	w.OnBlockSealed(func(block *types.Block) {
+		if !b.chain.HasBlock(block.Hash(), block.NumberU64()) {
+			t.Errorf("callback ran before block %x was written", block.Hash())
+		}
+		select {
+		case sealed <- block:
+		default:
+		}
+	})
+	w.start()
+	b.txPool.AddLocals(newTxs)
+
+	select {
+	case block := <-sealed:
+		if block.NumberU64() != 1 {
+			t.Errorf("sealed block number mismatch: have %d, want %d", block.NumberU64(), 1)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for the sealed block callback")
+	}
+}
+
+// sealHashEngine is a consensus engine that only implements SealHash and Seal.
+// It seals the blocks in instant right away and holds the others until they
+// are stopped.
+type sealHashEngine struct {
+	consensus.Engine
+	instant map[uint64]bool
+}
+
+func (sealHashEngine) SealHash(header *types.Header) common.Hash {
+	return header.Hash()
+}
+
+func (e sealHashEngine) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	if e.instant[block.NumberU64()] {
+		results <- block
+	}
+	return nil, nil
+}
+
+func TestSealingTaskStats(t *testing.T) {
+	w := &worker{
+		engine:       sealHashEngine{instant: map[uint64]bool{3: true}},
+		taskCh:       make(chan *task),
+		resultCh:     make(chan *types.Block, resultQueueSize),
+		sealDoneCh:   make(chan chan struct{}),
+		exitCh:       make(chan struct{}),
+		heartbeats:   newHeartbeats(taskLoopName),
+		pendingTasks: make(map[common.Hash]*task),
+	}
+	done := make(chan struct{})
+	go func() {
+		w.taskLoop()
+		close(done)
+	}()
+
+	newTask := func(number int64) *task {
+		return &task{block: types.NewBlockWithHeader(&types.Header{Number: big.NewInt(number)})}
+	}
+	// The resubmitted first block is a duplicate, the second block interrupts it
+	w.taskCh <- newTask(1)
+	w.taskCh <- newTask(1)
+	w.taskCh <- newTask(2)
+	// The third block interrupts the second one and is sealed right away
+	w.taskCh <- newTask(3)
+	select {
+	case block := <-w.resultCh:
+		if block.NumberU64() != 3 {
+			t.Errorf("sealed block number mismatch: have %d, want %d", block.NumberU64(), 3)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the sealed block")
+	}
+	// Shutting down has no sealing operation left to interrupt
+	time.Sleep(100 * time.Millisecond)
+	close(w.exitCh)
+	<-done
+
+	if have := w.TaskCount(); have != 4 {
+		t.Errorf("task count mismatch: have %d, want %d", have, 4)
+	}
+	if have := w.DuplicateTaskCount(); have != 1 {
+		t.Errorf("duplicate task count mismatch: have %d, want %d", have, 1)
+	}
+	if have := w.InterruptedSealCount(); have != 2 {
+		t.Errorf("interrupted seal count mismatch: have %d, want %d", have, 2)
+	}
+}
+
+func TestFatalTxError(t *testing.T) {
+	testFatalTxError(t, params.TestChainConfig, newTestEngine())
+}
+
+func testFatalTxError(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+	waitInitialWork(t, w)
+
+	parent := w.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   1000000,
+		Time:       big.NewInt(time.Now().Unix()),
+	}
+	signer := types.NewEIP155Signer(chainConfig.ChainID)
+	// An account without funds fails its transfer, ahead of the user's by price
+	poorKey, _ := crypto.GenerateKey()
+	failing, _ := types.SignTx(types.NewTransaction(0, testUserAddress, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, poorKey)
+	transfer, _ := types.SignTx(types.NewTransaction(0, testBankAddress, new(big.Int), params.TxGas, new(big.Int), nil), signer, testUserKey)
+	pending := func() *types.TransactionsByPriceAndNonce {
+		return types.NewTransactionsByPriceAndNonce(w.current.signer, map[common.Address]types.Transactions{
+			crypto.PubkeyToAddress(poorKey.PublicKey): {failing},
+			testUserAddress: {transfer},
+		})
+	}
+
+	// By default the failing transaction is dropped and assembly goes on
+	if err := w.makeCurrent(parent, header); err != nil {
+		t.Fatalf("failed to create mining context: %v", err)
+	}
+	if aborted := w.commitTransactionsWithHeader(header, pending(), testBankAddress, nil); aborted {
+		t.Fatalf("block assembly aborted on a non-fatal error")
+	}
+	if len(w.current.txs) != 1 || w.current.txs[0].Hash() != transfer.Hash() {
+		t.Errorf("included transactions mismatch: have %v, want [%x]", w.current.txs, transfer.Hash())
+	}
+	if dropped := w.LastBlockDropped(); len(dropped) != 1 || dropped[0].Hash != failing.Hash() {
+		t.Errorf("dropped transactions mismatch: have %v, want [%x]", dropped, failing.Hash())
+	}
+
+	// Classified as fatal, the same error abandons the block
+	w.SetFatalTxErrorClassifier(func(err error) bool { return err == vm.ErrInsufficientBalance })
+	w.droppedMu.Lock()
+	w.dropped = nil
+	w.droppedMu.Unlock()
+	if err := w.makeCurrent(parent, header); err != nil {
+		t.Fatalf("failed to create mining context: %v", err)
+	}
+	if aborted := w.commitTransactionsWithHeader(header, pending(), testBankAddress, nil); !aborted {
+		t.Fatalf("block assembly not aborted on a fatal error")
+	}
+	if len(w.current.txs) != 0 {
+		t.Errorf("transactions included after a fatal error: %v", w.current.txs)
+	}
+	if dropped := w.LastBlockDropped(); len(dropped) != 0 {
+		t.Errorf("transactions dropped on a fatal error: %v", dropped)
+	}
+}
+
+func TestMaxTxsPerAccount(t *testing.T) {
+	testMaxTxsPerAccount(t, params.TestChainConfig, newTestEngine())
+}
+
+func testMaxTxsPerAccount(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+	waitInitialWork(t, w)
+	w.SetMaxTxsPerAccount(3)
+
+	parent := w.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   1000000,
+		Time:       big.NewInt(time.Now().Unix()),
+	}
+	if err := w.makeCurrent(parent, header); err != nil {
+		t.Fatalf("failed to create mining context: %v", err)
+	}
+	// The bank floods the block, the user sends a single transfer
+	signer := types.NewEIP155Signer(chainConfig.ChainID)
+	var flood types.Transactions
+	for i := 0; i < 10; i++ {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), testUserAddress, big.NewInt(1), params.TxGas, new(big.Int), nil), signer, testBankKey)
+		flood = append(flood, tx)
+	}
+	transfer, _ := types.SignTx(types.NewTransaction(0, testBankAddress, new(big.Int), params.TxGas, new(big.Int), nil), signer, testUserKey)
+
+	txs := types.NewTransactionsByPriceAndNonce(w.current.signer, map[common.Address]types.Transactions{
+		testBankAddress: flood,
+		testUserAddress: {transfer},
+	})
+	w.commitTransactionsWithHeader(header, txs, testBankAddress, nil)
+
+	included := make(map[common.Hash]bool)
+	for _, tx := range w.current.txs {
+		included[tx.Hash()] = true
+	}
+	if len(w.current.txs) != 4 || !included[transfer.Hash()] {
+		t.Fatalf("included transactions mismatch: have %d, want the user's and 3 of the bank's", len(w.current.txs))
+	}
+	for i, tx := range flood {
+		if included[tx.Hash()] != (i < 3) {
+			t.Errorf("bank transaction %d inclusion mismatch: have %v, want %v", i, included[tx.Hash()], i < 3)
+		}
+	}
+	want := []DroppedTx{{flood[3].Hash(), DropAccountLimit}}
+	if dropped := w.LastBlockDropped(); !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped transactions mismatch: have %v, want %v", dropped, want)
+	}
+}
+
+func TestGasGriefing(t *testing.T) {
+	testGasGriefing(t, params.TestChainConfig, newTestEngine())
+}
+
+func testGasGriefing(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, _ := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+	waitInitialWork(t, w)
+
+	parent := w.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   1000000,
+		Time:       big.NewInt(time.Now().Unix()),
+	}
+	signer := types.NewEIP155Signer(chainConfig.ChainID)
+	// The bank's transaction never fits the block, the user's transfer always does
+	grief, _ := types.SignTx(types.NewTransaction(0, common.Address{0xcc}, new(big.Int), 2*header.GasLimit, new(big.Int), []byte{0x01}), signer, testBankKey)
+	transfer, _ := types.SignTx(types.NewTransaction(0, testBankAddress, new(big.Int), params.TxGas, new(big.Int), nil), signer, testUserKey)
+
+	// Every recommit of the block runs into the griefing transaction until the
+	// threshold is reached, after which the bank is skipped without execution
+	for i := 0; i <= gasGriefThreshold; i++ {
+		if err := w.makeCurrent(parent, header); err != nil {
+			t.Fatalf("recommit %d: failed to create mining context: %v", i, err)
+		}
+		w.gasGriefing.reset(header.Number.Uint64())
+		w.droppedMu.Lock()
+		w.dropped = nil
+		w.droppedMu.Unlock()
+
+		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, map[common.Address]types.Transactions{
+			testBankAddress: {grief},
+			testUserAddress: {transfer},
+		})
+		w.commitTransactionsWithHeader(header, txs, testBankAddress, nil)
+
+		want := []DroppedTx{{grief.Hash(), DropGasLimit}}
+		if i == gasGriefThreshold {
+			want[0].Reason = DropGasGriefing
+		}
+		if dropped := w.LastBlockDropped(); !reflect.DeepEqual(dropped, want) {
+			t.Errorf("recommit %d: dropped transactions mismatch: have %v, want %v", i, dropped, want)
+		}
+		if len(w.current.txs) != 1 || w.current.txs[0].Hash() != transfer.Hash() {
+			t.Errorf("recommit %d: included transactions mismatch: have %v, want [%x]", i, w.current.txs, transfer.Hash())
+		}
+	}
+	if senders := w.GasGriefingSenders(); !reflect.DeepEqual(senders, []common.Address{testBankAddress}) {
+		t.Errorf("griefing senders mismatch: have %v, want %v", senders, []common.Address{testBankAddress})
+	}
+	// The next block starts afresh
+	w.gasGriefing.reset(header.Number.Uint64() + 1)
+	if senders := w.GasGriefingSenders(); len(senders) != 0 {
+		t.Errorf("griefing senders carried over to the next block: %v", senders)
+	}
+}
+
+// BenchmarkTxBatch measures executing transfers from 512 distinct senders with
+// their accounts loaded in batches of different sizes.
+func BenchmarkTxBatch(b *testing.B) {
+	var (
+		db      = state.NewDatabase(ethdb.NewMemDatabase())
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+		pending = make(map[common.Address]types.Transactions)
+	)
+	statedb, _ := state.New(common.Hash{}, db)
+	for i := 0; i < 512; i++ {
+		key, _ := crypto.GenerateKey()
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		statedb.SetBalance(addr, big.NewInt(1000))
+		tx, _ := types.SignTx(types.NewTransaction(0, common.BigToAddress(big.NewInt(int64(i+1))), big.NewInt(1), params.TxGas, new(big.Int), nil), signer, key)
+		pending[addr] = types.Transactions{tx}
+	}
+	root, _ := statedb.Commit(false)
+
+	for _, size := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				statedb, _ := state.New(root, db)
+				header := &types.Header{Number: big.NewInt(1), GasLimit: params.GenesisGasLimit}
+				w := &worker{
+					config:      params.TestChainConfig,
+					txBatchSize: size,
+					current: &environment{
+						signer:  signer,
+						state:   statedb,
+						header:  header,
+						gasPool: new(core.GasPool).AddGas(header.GasLimit),
+					},
+				}
+				queued := make(map[common.Address]types.Transactions, len(pending))
+				for addr, txs := range pending {
+					queued[addr] = txs
+				}
+				txs := types.NewTransactionsByPriceAndNonce(signer, queued)
+				b.StartTimer()
+
+				// Mirror the execution loop of commitTransactionsWithHeader
+				prefetched := 0
+				for tx := txs.Peek(); tx != nil; tx = txs.Peek() {
+					if w.txBatchSize > 1 {
+						if prefetched == 0 {
+							prefetched = w.prefetchTxBatch(txs, w.txBatchSize)
+						}
+						prefetched--
+					}
+					if _, err := w.commitTransaction(w.current, tx, common.Address{}); err != nil {
+						b.Fatalf("failed to execute transaction: %v", err)
+					}
+					txs.Shift()
+				}
+			}
+		})
+	}
+}
+
+func TestMinerEvents(t *testing.T) {
+	w := &worker{
+		engine:   sealHashEngine{},
+		mux:      new(event.TypeMux),
+		startCh:  make(chan struct{}, 1),
+		statusCh: make(chan bool, statusChanSize),
+		exitCh:   make(chan struct{}),
+	}
+	defer close(w.exitCh)
+	go w.statusLoop()
+	miner := &Miner{worker: w}
+
+	// An unbuffered subscriber nobody reads from must not block the worker
+	stalled := make(chan MinerStatusEvent)
+	stalledSub := miner.SubscribeMinerEvents(stalled)
+
+	statusCh := make(chan MinerStatusEvent, 4)
+	sub := miner.SubscribeMinerEvents(statusCh)
+	defer sub.Unsubscribe()
+
+	muxSub := w.mux.Subscribe(MinerStartedEvent{}, MinerStoppedEvent{})
+	defer muxSub.Unsubscribe()
+
+	// Only the transitions are announced, not repeated starts and stops
+	done := make(chan struct{})
+	go func() {
+		w.start()
+		<-w.startCh
+		w.start()
+		<-w.startCh
+		w.stop()
+		w.stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("starting and stopping blocked on the event delivery")
+	}
+	var posted []interface{}
+	for len(posted) < 2 {
+		select {
+		case ev := <-muxSub.Chan():
+			posted = append(posted, ev.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for posted events, have %v", posted)
+		}
+		// Let the status delivery continue past the stalled subscriber
+		if len(posted) == 1 {
+			stalledSub.Unsubscribe()
+		}
+	}
+	if !reflect.DeepEqual(posted, []interface{}{MinerStartedEvent{}, MinerStoppedEvent{}}) {
+		t.Errorf("posted events mismatch: have %v, want [started stopped]", posted)
+	}
+	var statuses []MinerStatusEvent
+	for len(statuses) < 2 {
+		select {
+		case ev := <-statusCh:
+			statuses = append(statuses, ev)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for status events, have %v", statuses)
+		}
+	}
+	if want := []MinerStatusEvent{{Running: true}, {Running: false}}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("status events mismatch: have %v, want %v", statuses, want)
+	}
+}
+
+func TestPendingTxOrder(t *testing.T) {
+	testPendingTxOrder(t, params.TestChainConfig, newTestEngine())
+}
+
+func testPendingTxOrder(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine) {
+	defer engine.Close()
+
+	w, b := newTestWorker(t, chainConfig, engine, 0)
+	defer w.close()
+
+	// Let the initial work finish and keep the worker stopped, so no pending
+	// block is assembled from the transactions below
+	waitInitialWork(t, w)
+	w.stop()
+
+	// The pool tracks the senders of all its transactions as local, so both
+	// accounts end up in one group: the better paying transaction comes first,
+	// the others keep their nonce order
+	remote, _ := types.SignTx(types.NewTransaction(0, testBankAddress, new(big.Int), params.TxGas, big.NewInt(1), nil), types.HomesteadSigner{}, testUserKey)
+	b.txPool.AddRemotes([]*types.Transaction{remote})
+	b.txPool.AddLocals(newTxs)
+
+	order, err := w.PendingTxOrder()
+	if err != nil {
+		t.Fatalf("failed to order pending transactions: %v", err)
+	}
+	want := []common.Hash{remote.Hash(), pendingTxs[0].Hash(), newTxs[0].Hash()}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("pending order mismatch: have %x, want %x", order, want)
+	}
+	// Nothing was executed
+	if _, state := w.pending(); state != nil && state.GetNonce(testUserAddress) != 0 {
+		t.Errorf("remote transaction executed while ordering")
+	}
+}
@@ -87,6 +87,14 @@ type MsgReadWriter interface {
 	MsgWriter
 }
 
+// MsgManyWriter is implemented by message writers able to send a payload
+// scattered over several buffers without joining them first.
+type MsgManyWriter interface {
+	// WriteMany sends a message whose payload is the concatenation of
+	// payloads. It will block until the payloads have been consumed.
+	WriteMany(code uint64, payloads [][]byte) error
+}
+
 // Send writes an RLP-encoded message with the given code.
 // data should encode as an RLP list.
 func Send(w MsgWriter, msgcode uint64, data interface{}) error {
@@ -110,6 +118,31 @@ func SendItems(w MsgWriter, msgcode uint64, elems ...interface{}) error {
 	return Send(w, msgcode, elems)
 }
 
+// SendMany writes a message with the given code whose payload is the
+// concatenation of payloads, which should together encode as an RLP list. The
+// payloads are handed over as they are to writers implementing MsgManyWriter
+// and read in turn by any other writer, so they are never joined into a single
+// buffer. The message on the wire is the same as sent by Send.
+func SendMany(w MsgWriter, msgcode uint64, payloads [][]byte) error {
+	if mw, ok := w.(MsgManyWriter); ok {
+		return mw.WriteMany(msgcode, payloads)
+	}
+	return w.WriteMsg(manyMsg(msgcode, payloads))
+}
+
+// manyMsg creates a message reading its payload from the given buffers in turn.
+func manyMsg(code uint64, payloads [][]byte) Msg {
+	var (
+		size    int
+		readers = make([]io.Reader, len(payloads))
+	)
+	for i, payload := range payloads {
+		size += len(payload)
+		readers[i] = bytes.NewReader(payload)
+	}
+	return Msg{Code: code, Size: uint32(size), Payload: io.MultiReader(readers...)}
+}
+
 // eofSignal wraps a reader with eof signaling. the eof channel is
 // closed when the wrapped reader returns an error or when count bytes
 // have been read.
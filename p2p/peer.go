@@ -430,6 +430,23 @@ func (rw *protoRW) WriteMsg(msg Msg) (err error) {
 	return err
 }
 
+// WriteMany implements MsgManyWriter, passing the payloads on to the underlying
+// writer without joining them.
+func (rw *protoRW) WriteMany(code uint64, payloads [][]byte) (err error) {
+	if code >= rw.Length {
+		return newPeerError(errInvalidMsgCode, "not handled")
+	}
+	select {
+	case <-rw.wstart:
+		err = SendMany(rw.w, code+rw.offset, payloads)
+		// Report write status back to Peer.run, see WriteMsg
+		rw.werr <- err
+	case <-rw.closed:
+		err = ErrShuttingDown
+	}
+	return err
+}
+
 func (rw *protoRW) ReadMsg() (Msg, error) {
 	select {
 	case msg := <-rw.in:
@@ -480,7 +497,9 @@ func (p *Peer) Info() *PeerInfo {
 	info.Network.Consensus = p.rw.is(consensusDialedConn)
 
 	// Gather all the running protocol infos
-
+	if p.running == nil {
+		return info
+	}
 	protoInfo := interface{}("unknown")
 	if query := p.running.Protocol.PeerInfo; query != nil {
 		if metadata := query(p.ID()); metadata != nil {
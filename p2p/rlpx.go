@@ -102,6 +102,14 @@ func (t *rlpx) WriteMsg(msg Msg) error {
 	return t.rw.WriteMsg(msg)
 }
 
+// WriteMany implements MsgManyWriter.
+func (t *rlpx) WriteMany(code uint64, payloads [][]byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	t.fd.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	return t.rw.WriteMany(code, payloads)
+}
+
 func (t *rlpx) close(err error) {
 	t.wmu.Lock()
 	defer t.wmu.Unlock()
@@ -597,8 +605,6 @@ func newRLPXFrameRW(conn io.ReadWriter, s secrets) *rlpxFrameRW {
 }
 
 func (rw *rlpxFrameRW) WriteMsg(msg Msg) error {
-	ptype, _ := rlp.EncodeToBytes(msg.Code)
-
 	// if snappy is enabled, compress message now
 	if rw.snappy {
 		if msg.Size > maxUint24 {
@@ -610,9 +616,44 @@ func (rw *rlpxFrameRW) WriteMsg(msg Msg) error {
 		msg.Payload = bytes.NewReader(payload)
 		msg.Size = uint32(len(payload))
 	}
+	return rw.writeFrame(msg.Code, msg.Size, func(w io.Writer) error {
+		_, err := io.Copy(w, msg.Payload)
+		return err
+	})
+}
+
+// WriteMany implements MsgManyWriter, encrypting the payloads one after the
+// other into the frame. Compressed messages need the whole payload at once
+// and are joined.
+func (rw *rlpxFrameRW) WriteMany(code uint64, payloads [][]byte) error {
+	if rw.snappy {
+		return rw.WriteMsg(manyMsg(code, payloads))
+	}
+	var size uint64
+	for _, payload := range payloads {
+		size += uint64(len(payload))
+	}
+	if size > uint64(maxUint24) {
+		return errors.New("message size overflows uint24")
+	}
+	return rw.writeFrame(code, uint32(size), func(w io.Writer) error {
+		for _, payload := range payloads {
+			if _, err := w.Write(payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeFrame writes a frame holding a message with the given code and a
+// payload of size bytes, written into the encrypted frame by writePayload.
+func (rw *rlpxFrameRW) writeFrame(code uint64, size uint32, writePayload func(w io.Writer) error) error {
+	ptype, _ := rlp.EncodeToBytes(code)
+
 	// write header
 	headbuf := make([]byte, 32)
-	fsize := uint32(len(ptype)) + msg.Size
+	fsize := uint32(len(ptype)) + size
 	if fsize > maxUint24 {
 		return errors.New("message size overflows uint24")
 	}
@@ -632,7 +673,7 @@ func (rw *rlpxFrameRW) WriteMsg(msg Msg) error {
 	if _, err := tee.Write(ptype); err != nil {
 		return err
 	}
-	if _, err := io.Copy(tee, msg.Payload); err != nil {
+	if err := writePayload(tee); err != nil {
 		return err
 	}
 	if padding := fsize % 16; padding > 0 {
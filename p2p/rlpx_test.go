@@ -313,6 +313,33 @@ ba628a4ba590cb43f7848f41c4382885
 	}
 }
 
+// Tests that a message written from scattered payloads is framed exactly like
+// the same message written in one piece.
+func TestRLPXFrameWriteMany(t *testing.T) {
+	buf := new(bytes.Buffer)
+	hash := fakeHash([]byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1})
+	rw := newRLPXFrameRW(buf, secrets{
+		AES:        crypto.Keccak256(),
+		MAC:        crypto.Keccak256(),
+		IngressMAC: hash,
+		EgressMAC:  hash,
+	})
+	// The golden frame of TestRLPXFrameFake, holding [1, 2, 3, 4]
+	golden := unhex(`
+00828ddae471818bb0bfa6b551d1cb42
+01010101010101010101010101010101
+ba628a4ba590cb43f7848f41c4382885
+01010101010101010101010101010101
+`)
+	payloads := [][]byte{rlp.ListHeader(4), {0x01}, {0x02, 0x03}, {0x04}}
+	if err := SendMany(rw, 8, payloads); err != nil {
+		t.Fatalf("WriteMany error: %v", err)
+	}
+	if written := buf.Bytes(); !bytes.Equal(written, golden) {
+		t.Fatalf("output mismatch:\n  got:  %x\n  want: %x", written, golden)
+	}
+}
+
 type fakeHash []byte
 
 func (fakeHash) Write(p []byte) (int, error) { return len(p), nil }
@@ -220,6 +220,12 @@ type conn struct {
 	name  string          // valid after the protocol handshake
 }
 
+// WriteMany implements MsgManyWriter, handing the payloads to the transport
+// without joining them if it supports it.
+func (c *conn) WriteMany(code uint64, payloads [][]byte) error {
+	return SendMany(c.transport, code, payloads)
+}
+
 type transport interface {
 	// The two handshakes.
 	doEncHandshake(prv *ecdsa.PrivateKey, dialDest *discover.Node) (discover.NodeID, error)
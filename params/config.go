@@ -84,6 +84,75 @@ type IstanbulConfig struct {
 	BlockPeriod        uint64         `json:"period,omitempty"`  // Default minimum difference between two consecutive block's timestamps in second
 	ProposerPolicy     ProposerPolicy `json:"policy,omitempty"`  // The policy for proposer selection
 	FirstValidatorNode discover.Node  `json:"firstValidatorNode,omitempty"`
+	CheckpointInterval uint64         `json:"checkpointInterval,omitempty"` // Number of blocks after which to save the vote snapshot to the database
+
+	// MaxStoredCommittedSeals caps the committed seals stored in block headers,
+	// never going below a quorum. 0 stores all of them.
+	MaxStoredCommittedSeals int `json:"maxStoredCommittedSeals,omitempty"`
+
+	// PersistProposedBlocks writes proposed blocks to disk until committed, so
+	// a proposer crashing mid-proposal proposes them again on restart.
+	PersistProposedBlocks bool `json:"persistProposedBlocks,omitempty"`
+
+	// MessageCacheSize is the number of recent consensus messages remembered to
+	// drop replays. 0 uses the default of 4096.
+	MessageCacheSize int `json:"messageCacheSize,omitempty"`
+
+	// RemovalGracePeriod is the number of blocks a validator dropped from the
+	// consensus node list at least stays in the validator set, still counting
+	// toward quorum but no longer proposing. 0 removes validators immediately.
+	RemovalGracePeriod uint64 `json:"removalGracePeriod,omitempty"`
+
+	// RemovalGraceBlock is the block the removal grace period applies from, nil
+	// never applies it.
+	RemovalGraceBlock *big.Int `json:"removalGraceBlock,omitempty"`
+
+	// FastSealSingleValidator lets the sole validator of a network seal its
+	// proposals right after verifying them, skipping PRE-PREPARE and PREPARE.
+	FastSealSingleValidator bool `json:"fastSealSingleValidator,omitempty"`
+
+	// SealTimeout is the time in milliseconds a proposed block waits to be
+	// committed before sealing gives up on it. 0 waits until a new sealing
+	// task stops it.
+	SealTimeout uint64 `json:"sealTimeout,omitempty"`
+
+	// MaxValidators caps the number of normal validator nodes in the node
+	// management contract, adding or updating nodes beyond it fails. 0 uses the
+	// default of 100.
+	MaxValidators uint64 `json:"maxValidators,omitempty"`
+
+	// MaxValidatorsBlock is the block the validator limit applies from, nil
+	// never applies it.
+	MaxValidatorsBlock *big.Int `json:"maxValidatorsBlock,omitempty"`
+
+	// ValidatorWeights are the stake weights of the validators under the
+	// weighted round robin proposer policy. Validators without an entry weigh 1.
+	ValidatorWeights map[common.Address]uint64 `json:"validatorWeights,omitempty"`
+}
+
+// IsRemovalGrace returns whether num is either equal to the removal grace block
+// or greater, with a grace period set.
+func (c *IstanbulConfig) IsRemovalGrace(num *big.Int) bool {
+	if c.RemovalGracePeriod == 0 || c.RemovalGraceBlock == nil || num == nil {
+		return false
+	}
+	return c.RemovalGraceBlock.Cmp(num) <= 0
+}
+
+// defaultMaxValidators is the maximum number of normal validator nodes once the
+// validator limit applies, if none is configured.
+const defaultMaxValidators = 100
+
+// ValidatorsLimit returns the maximum number of normal validator nodes at num,
+// 0 if the validator limit does not apply yet.
+func (c *IstanbulConfig) ValidatorsLimit(num *big.Int) uint64 {
+	if c.MaxValidatorsBlock == nil || num == nil || c.MaxValidatorsBlock.Cmp(num) > 0 {
+		return 0
+	}
+	if c.MaxValidators == 0 {
+		return defaultMaxValidators
+	}
+	return c.MaxValidators
 }
 
 // String implements the fmt.Stringer interface.
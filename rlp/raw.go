@@ -34,6 +34,13 @@ func ListSize(contentSize uint64) uint64 {
 	return uint64(headsize(contentSize)) + contentSize
 }
 
+// ListHeader returns the header of an RLP list with the given content
+// size. The encoded list is the header followed by the content.
+func ListHeader(contentSize uint64) []byte {
+	buf := make([]byte, 9)
+	return buf[:puthead(buf, 0xC0, 0xF7, contentSize)]
+}
+
 // Split returns the content of first RLP value and any
 // bytes after the value as subslices of b.
 func Split(b []byte) (k Kind, content, rest []byte, err error) {
@@ -71,6 +71,26 @@ func TestSplitTypes(t *testing.T) {
 	}
 }
 
+func TestListHeader(t *testing.T) {
+	for _, size := range []int{0, 1, 55, 56, 255, 256, 65536} {
+		content := make([]RawValue, size)
+		for i := range content {
+			content[i] = RawValue{0x01}
+		}
+		enc, err := EncodeToBytes(content)
+		if err != nil {
+			t.Fatalf("size %d: encoding error: %v", size, err)
+		}
+		header := ListHeader(uint64(size))
+		if !bytes.Equal(header, enc[:len(enc)-size]) {
+			t.Errorf("size %d: header mismatch: got %x, want %x", size, header, enc[:len(enc)-size])
+		}
+		if uint64(len(header)+size) != ListSize(uint64(size)) {
+			t.Errorf("size %d: encoded size mismatch: got %d, want %d", size, len(header)+size, ListSize(uint64(size)))
+		}
+	}
+}
+
 func TestSplit(t *testing.T) {
 	tests := []struct {
 		input     string
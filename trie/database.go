@@ -82,6 +82,9 @@ type Database struct {
 	nodesSize     common.StorageSize // Storage size of the nodes cache (exc. flushlist)
 	preimagesSize common.StorageSize // Storage size of the preimages cache
 
+	nodeHits   metrics.Counter // Node lookups served from the memory cache
+	nodeMisses metrics.Counter // Node lookups falling through to the persistent database
+
 	lock sync.RWMutex
 }
 
@@ -267,12 +270,23 @@ func expandNode(hash hashNode, n node, cachegen uint16) node {
 // its written out to disk or garbage collected.
 func NewDatabase(diskdb ethdb.Database) *Database {
 	return &Database{
-		diskdb:    diskdb,
-		nodes:     map[common.Hash]*cachedNode{{}: {}},
-		preimages: make(map[common.Hash][]byte),
+		diskdb:     diskdb,
+		nodes:      map[common.Hash]*cachedNode{{}: {}},
+		preimages:  make(map[common.Hash][]byte),
+		nodeHits:   metrics.NilCounter{},
+		nodeMisses: metrics.NilCounter{},
 	}
 }
 
+// MeterNodeCache reports every node lookup served from the memory cache to hits
+// and every lookup falling through to the persistent database to misses.
+func (db *Database) MeterNodeCache(hits, misses metrics.Counter) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.nodeHits, db.nodeMisses = hits, misses
+}
+
 // DiskDB retrieves the persistent storage backing the trie database.
 func (db *Database) DiskDB() DatabaseReader {
 	return db.diskdb
@@ -338,11 +352,15 @@ func (db *Database) node(hash common.Hash, cachegen uint16) node {
 	// Retrieve the node from cache if available
 	db.lock.RLock()
 	node := db.nodes[hash]
+	hits, misses := db.nodeHits, db.nodeMisses
 	db.lock.RUnlock()
 
 	if node != nil {
+		hits.Inc(1)
 		return node.obj(hash, cachegen)
 	}
+	misses.Inc(1)
+
 	// Content unavailable in memory, attempt to retrieve from disk
 	enc, err := db.diskdb.Get(hash[:])
 	if err != nil || enc == nil {
@@ -357,11 +375,15 @@ func (db *Database) Node(hash common.Hash) ([]byte, error) {
 	// Retrieve the node from cache if available
 	db.lock.RLock()
 	node := db.nodes[hash]
+	hits, misses := db.nodeHits, db.nodeMisses
 	db.lock.RUnlock()
 
 	if node != nil {
+		hits.Inc(1)
 		return node.rlp(), nil
 	}
+	misses.Inc(1)
+
 	// Content unavailable in memory, attempt to retrieve from disk
 	return db.diskdb.Get(hash[:])
 }
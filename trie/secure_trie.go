@@ -81,6 +81,12 @@ func (t *SecureTrie) TryGet(key []byte) ([]byte, error) {
 	return t.trie.TryGet(t.hashKey(key))
 }
 
+// TryHas reports whether the trie holds a value for key.
+// If a node was not found in the database, a MissingNodeError is returned.
+func (t *SecureTrie) TryHas(key []byte) (bool, error) {
+	return t.trie.TryHas(t.hashKey(key))
+}
+
 // Update associates key with value in the trie. Subsequent calls to
 // Get will return value. If value has length zero, any existing value
 // is deleted from the trie and calls to Get will return nil.
@@ -142,6 +142,14 @@ func (t *Trie) TryGet(key []byte) ([]byte, error) {
 	return value, err
 }
 
+// TryHas reports whether the trie holds a value for key. The value is neither
+// copied nor decoded. If a node was not found in the database, a
+// MissingNodeError is returned.
+func (t *Trie) TryHas(key []byte) (bool, error) {
+	value, err := t.TryGet(key)
+	return len(value) > 0, err
+}
+
 func (t *Trie) tryGet(origNode node, key []byte, pos int) (value []byte, newnode node, didResolve bool, err error) {
 	switch n := (origNode).(type) {
 	case nil:
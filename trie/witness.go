@@ -0,0 +1,126 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/Venachain/Venachain/common"
+	"github.com/Venachain/Venachain/crypto"
+	"github.com/Venachain/Venachain/rlp"
+)
+
+// errWitnessMissingNode is returned when a node is looked up that is not part
+// of the witness.
+var errWitnessMissingNode = errors.New("node not in witness")
+
+// Witness is a set of trie nodes proving a number of paths in the trie rooted at
+// Root. It holds everything a stateless client needs to resolve those paths
+// without access to the database.
+//
+// A Witness can be filled through Prove, as it is an ethdb.Putter, and read back
+// through VerifyProof, as it is a DatabaseReader.
+type Witness struct {
+	Root common.Hash
+
+	nodes map[common.Hash][]byte
+	lock  sync.RWMutex
+}
+
+// witnessRLP is the RLP encoding of a Witness. Nodes are sorted by hash so the
+// encoding of a node set is unique.
+type witnessRLP struct {
+	Root  common.Hash
+	Nodes [][]byte
+}
+
+// NewWitness creates an empty witness for the trie rooted at root.
+func NewWitness(root common.Hash) *Witness {
+	return &Witness{
+		Root:  root,
+		nodes: make(map[common.Hash][]byte),
+	}
+}
+
+// Put adds a node to the witness. The key is the hash of the node.
+func (w *Witness) Put(key []byte, value []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.nodes[common.BytesToHash(key)] = common.CopyBytes(value)
+	return nil
+}
+
+// Get retrieves the node with the given hash.
+func (w *Witness) Get(key []byte) ([]byte, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if node, ok := w.nodes[common.BytesToHash(key)]; ok {
+		return node, nil
+	}
+	return nil, errWitnessMissingNode
+}
+
+// Has reports whether the witness holds the node with the given hash.
+func (w *Witness) Has(key []byte) (bool, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	_, ok := w.nodes[common.BytesToHash(key)]
+	return ok, nil
+}
+
+// Len returns the number of nodes in the witness.
+func (w *Witness) Len() int {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	return len(w.nodes)
+}
+
+// Hash returns the keccak256 hash of the RLP encoding of the witness.
+func (w *Witness) Hash() common.Hash {
+	enc, err := rlp.EncodeToBytes(w)
+	if err != nil {
+		return common.Hash{}
+	}
+	return crypto.Keccak256Hash(enc)
+}
+
+// EncodeRLP implements rlp.Encoder.
+func (w *Witness) EncodeRLP(out io.Writer) error {
+	w.lock.RLock()
+	hashes := make([]common.Hash, 0, len(w.nodes))
+	for hash := range w.nodes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	enc := witnessRLP{Root: w.Root, Nodes: make([][]byte, len(hashes))}
+	for i, hash := range hashes {
+		enc.Nodes[i] = w.nodes[hash]
+	}
+	w.lock.RUnlock()
+
+	return rlp.Encode(out, enc)
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (w *Witness) DecodeRLP(s *rlp.Stream) error {
+	var dec witnessRLP
+	if err := s.Decode(&dec); err != nil {
+		return err
+	}
+	nodes := make(map[common.Hash][]byte, len(dec.Nodes))
+	for _, node := range dec.Nodes {
+		nodes[crypto.Keccak256Hash(node)] = node
+	}
+	w.lock.Lock()
+	w.Root, w.nodes = dec.Root, nodes
+	w.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,65 @@
+package trie
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Venachain/Venachain/rlp"
+)
+
+// Tests that a witness filled by Prove verifies all proven keys, also after an
+// RLP round trip, and that the encoding does not depend on insertion order.
+func TestWitness(t *testing.T) {
+	trie, vals := randomTrie(500)
+	root := trie.Hash()
+
+	var (
+		witness  = NewWitness(root)
+		reversed = NewWitness(root)
+		keys     [][]byte
+	)
+	for _, kv := range vals {
+		keys = append(keys, kv.k)
+		if len(keys) == 50 {
+			break
+		}
+	}
+	for i := range keys {
+		if err := trie.Prove(keys[i], 0, witness); err != nil {
+			t.Fatalf("failed to prove key %x: %v", keys[i], err)
+		}
+		if err := trie.Prove(keys[len(keys)-1-i], 0, reversed); err != nil {
+			t.Fatalf("failed to prove key %x: %v", keys[i], err)
+		}
+	}
+	if witness.Hash() != reversed.Hash() {
+		t.Fatalf("witness hash depends on insertion order")
+	}
+
+	enc, err := rlp.EncodeToBytes(witness)
+	if err != nil {
+		t.Fatalf("failed to encode witness: %v", err)
+	}
+	decoded := new(Witness)
+	if err := rlp.DecodeBytes(enc, decoded); err != nil {
+		t.Fatalf("failed to decode witness: %v", err)
+	}
+	if decoded.Root != root || decoded.Len() != witness.Len() {
+		t.Fatalf("decoded witness mismatch: have root %x, %d nodes, want root %x, %d nodes", decoded.Root, decoded.Len(), root, witness.Len())
+	}
+	for _, key := range keys {
+		val, _, err := VerifyProof(root, key, decoded)
+		if err != nil {
+			t.Fatalf("failed to verify key %x: %v", key, err)
+		}
+		if !bytes.Equal(val, vals[string(key)].v) {
+			t.Fatalf("verified value mismatch for key %x: have %x, want %x", key, val, vals[string(key)].v)
+		}
+	}
+	if ok, _ := decoded.Has(root[:]); !ok {
+		t.Errorf("witness misses the root node")
+	}
+	if _, err := NewWitness(root).Get(root[:]); err == nil {
+		t.Errorf("empty witness returned a node")
+	}
+}